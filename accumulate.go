@@ -0,0 +1,103 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Accumulate has an aggregate query fold each record into the Count of its group, and the Sum, Avg, Min and Max of the
+// passed in fields, as it's read, rather than keeping every record of the group in its reduction.  Memory then grows
+// with the number of groups instead of the number of records.  Only the functions on the accumulated fields can be
+// called on the results, Reduction, Sort and Reduce panic, as the records aren't kept
+func (q *Query) Accumulate(fields ...string) *Query {
+	q.accumulate = append([]string{}, fields...)
+	return q
+}
+
+// accumulator holds the running Sum, Min and Max of a field across the records of a group
+type accumulator struct {
+	sum        float64
+	numeric    bool
+	min, max   reflect.Value // the records, always pointers
+	minV, maxV reflect.Value // their field values
+}
+
+// accumulating returns whether the aggregate query folds its records into accumulators
+func (q *Query) accumulating() bool {
+	return q != nil && q.accumulate != nil
+}
+
+// newAccumulated returns a group with no records that accumulates the fields
+func newAccumulated(group []reflect.Value, groupBy []string, fields []string, nameTag string) *AggregateResult {
+	result := &AggregateResult{
+		group:        group,
+		groupBy:      groupBy,
+		fieldNameTag: nameTag,
+		accumulators: make(map[string]*accumulator, len(fields)),
+	}
+	for i := range fields {
+		result.accumulators[fields[i]] = &accumulator{}
+	}
+	return result
+}
+
+// fold adds the record to the count and accumulators of the group
+func (a *AggregateResult) fold(record reflect.Value) error {
+	a.count++
+
+	for field, acc := range a.accumulators {
+		fVal := fieldValueByName(record.Elem(), field, a.fieldNameTag)
+		if !fVal.IsValid() {
+			return fmt.Errorf("The field %s does not exist in the type %s", field, record.Type())
+		}
+
+		if a.count == 1 {
+			acc.numeric = isNumber(fVal.Kind())
+			acc.min, acc.minV = record, fVal
+			acc.max, acc.maxV = record, fVal
+		} else {
+			c, err := compare(fVal.Interface(), acc.minV.Interface())
+			if err != nil {
+				return err
+			}
+			if c < 0 {
+				acc.min, acc.minV = record, fVal
+			}
+
+			c, err = compare(fVal.Interface(), acc.maxV.Interface())
+			if err != nil {
+				return err
+			}
+			if c > 0 {
+				acc.max, acc.maxV = record, fVal
+			}
+		}
+
+		if acc.numeric {
+			acc.sum += tryFloat(fVal)
+		}
+	}
+
+	return nil
+}
+
+// accumulated returns the accumulator of the field
+// panics if the field wasn't accumulated
+func (a *AggregateResult) accumulated(field string) *accumulator {
+	acc, ok := a.accumulators[field]
+	if !ok {
+		panic(fmt.Sprintf("The field %s wasn't passed to Accumulate", field))
+	}
+	return acc
+}
+
+// keepsRecords panics if the records of the group weren't kept
+func (a *AggregateResult) keepsRecords() {
+	if a.accumulators != nil {
+		panic("The records of a group aggregated with Accumulate aren't kept")
+	}
+}
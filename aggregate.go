@@ -5,6 +5,7 @@
 package badgerhold
 
 import (
+	"bytes"
 	"fmt"
 	"reflect"
 	"sort"
@@ -16,7 +17,35 @@ import (
 type AggregateResult struct {
 	reduction []reflect.Value // always pointers
 	group     []reflect.Value
+	groupBy   []string
 	sortby    string
+
+	fieldNameTag string // the store's FieldNameTag, which the names of the fields passed in are matched against
+
+	// results aggregated from an index have their reduction loaded from keys only when it's needed
+	keys keyList
+	load func(keys keyList) ([]reflect.Value, error)
+
+	// results aggregated with Accumulate only keep the count and accumulated fields of their records
+	count        int
+	accumulators map[string]*accumulator
+}
+
+// loadReduction loads the records of the reduction if the result was aggregated from an index
+// panics if the records can't be loaded
+func (a *AggregateResult) loadReduction() {
+	if a.load == nil {
+		return
+	}
+
+	reduction, err := a.load(a.keys)
+	if err != nil {
+		panic(fmt.Sprintf("Error loading aggregate reduction: %s", err))
+	}
+
+	a.reduction = reduction
+	a.keys = nil
+	a.load = nil
 }
 
 // Group returns the field grouped by in the query
@@ -37,6 +66,8 @@ func (a *AggregateResult) Group(result ...interface{}) {
 
 // Reduction is the collection of records that are part of the AggregateResult Group
 func (a *AggregateResult) Reduction(result interface{}) {
+	a.keepsRecords()
+	a.loadReduction()
 	resultVal := reflect.ValueOf(result)
 
 	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
@@ -66,12 +97,12 @@ func (a *aggregateResultSort) Swap(i, j int) {
 }
 func (a *aggregateResultSort) Less(i, j int) bool {
 	//reduction values are always pointers
-	iVal := a.reduction[i].Elem().FieldByName(a.sortby)
+	iVal := fieldValueByName(a.reduction[i].Elem(), a.sortby, a.fieldNameTag)
 	if !iVal.IsValid() {
 		panic(fmt.Sprintf("The field %s does not exist in the type %s", a.sortby, a.reduction[i].Type()))
 	}
 
-	jVal := a.reduction[j].Elem().FieldByName(a.sortby)
+	jVal := fieldValueByName(a.reduction[j].Elem(), a.sortby, a.fieldNameTag)
 	if !jVal.IsValid() {
 		panic(fmt.Sprintf("The field %s does not exist in the type %s", a.sortby, a.reduction[j].Type()))
 	}
@@ -87,9 +118,11 @@ func (a *aggregateResultSort) Less(i, j int) bool {
 // Sort sorts the aggregate reduction by the passed in field in ascending order
 // Sort is called automatically by calls to Min / Max to get the min and max values
 func (a *AggregateResult) Sort(field string) {
-	if !startsUpper(field) {
+	if a.fieldNameTag == "" && !startsUpper(field) {
 		panic("The first letter of a field must be upper-case")
 	}
+	a.keepsRecords()
+	a.loadReduction()
 	if a.sortby == field {
 		// already sorted
 		return
@@ -101,8 +134,6 @@ func (a *AggregateResult) Sort(field string) {
 
 // Max Returns the maxiumum value of the Aggregate Grouping, uses the Comparer interface
 func (a *AggregateResult) Max(field string, result interface{}) {
-	a.Sort(field)
-
 	resultVal := reflect.ValueOf(result)
 	if resultVal.Kind() != reflect.Ptr {
 		panic("result argument must be an address")
@@ -112,13 +143,17 @@ func (a *AggregateResult) Max(field string, result interface{}) {
 		panic("result argument must not be nil")
 	}
 
+	if a.accumulators != nil {
+		resultVal.Elem().Set(a.accumulated(field).max.Elem())
+		return
+	}
+
+	a.Sort(field)
 	resultVal.Elem().Set(a.reduction[len(a.reduction)-1].Elem())
 }
 
 // Min returns the minimum value of the Aggregate Grouping, uses the Comparer interface
 func (a *AggregateResult) Min(field string, result interface{}) {
-	a.Sort(field)
-
 	resultVal := reflect.ValueOf(result)
 	if resultVal.Kind() != reflect.Ptr {
 		panic("result argument must be an address")
@@ -128,6 +163,12 @@ func (a *AggregateResult) Min(field string, result interface{}) {
 		panic("result argument must not be nil")
 	}
 
+	if a.accumulators != nil {
+		resultVal.Elem().Set(a.accumulated(field).min.Elem())
+		return
+	}
+
+	a.Sort(field)
 	resultVal.Elem().Set(a.reduction[0].Elem())
 }
 
@@ -135,16 +176,33 @@ func (a *AggregateResult) Min(field string, result interface{}) {
 // panics if the field cannot be converted to an float64
 func (a *AggregateResult) Avg(field string) float64 {
 	sum := a.Sum(field)
-	return sum / float64(len(a.reduction))
+	return sum / float64(a.Count())
 }
 
 // Sum returns the sum value of the aggregate grouping
 // panics if the field cannot be converted to an float64
 func (a *AggregateResult) Sum(field string) float64 {
+	for i := range a.groupBy {
+		if a.groupBy[i] == field {
+			// every record in the group has the same value
+			return tryFloat(a.group[i]) * float64(a.Count())
+		}
+	}
+
+	if a.accumulators != nil {
+		acc := a.accumulated(field)
+		if !acc.numeric && a.count != 0 {
+			// panics with the same error as an unaccumulated Sum
+			tryFloat(acc.minV)
+		}
+		return acc.sum
+	}
+
+	a.loadReduction()
 	var sum float64
 
 	for i := range a.reduction {
-		fVal := a.reduction[i].Elem().FieldByName(field)
+		fVal := fieldValueByName(a.reduction[i].Elem(), field, a.fieldNameTag)
 		if !fVal.IsValid() {
 			panic(fmt.Sprintf("The field %s does not exist in the type %s", field, a.reduction[i].Type()))
 		}
@@ -157,24 +215,129 @@ func (a *AggregateResult) Sum(field string) float64 {
 
 // Count returns the number of records in the aggregate grouping
 func (a *AggregateResult) Count() int {
+	if a.accumulators != nil {
+		return a.count
+	}
+	if a.load != nil {
+		return len(a.keys)
+	}
 	return len(a.reduction)
 }
 
+// Reduce folds every record in the aggregate grouping into a single value, by calling fn with the value returned so
+// far, starting at initial, and a pointer to each record, i.e. for a weighted average:
+//	total := result.Reduce(func(acc, record interface{}) interface{} {
+//		return acc.(float64) + record.(*Item).Price*float64(record.(*Item).Quantity)
+//	}, 0.0)
+// If the grouping was read from an index, every record in the group is loaded and decoded first, so Count and Sum of
+// the grouped field are cheaper where they're enough
+func (a *AggregateResult) Reduce(fn func(acc, record interface{}) interface{}, initial interface{}) interface{} {
+	a.keepsRecords()
+	a.loadReduction()
+	acc := initial
+	for i := range a.reduction {
+		acc = fn(acc, a.reduction[i].Interface())
+	}
+	return acc
+}
+
+// AggregateOrder reports whether the aggregate result a should be ordered before b
+type AggregateOrder func(a, b *AggregateResult) bool
+
+// OrderByCount orders aggregate results from the largest group to the smallest
+func OrderByCount() AggregateOrder {
+	return func(a, b *AggregateResult) bool {
+		return a.Count() > b.Count()
+	}
+}
+
+// OrderBySum orders aggregate results from the largest Sum of the passed in field to the smallest
+// panics if the field cannot be converted to an float64
+func OrderBySum(field string) AggregateOrder {
+	sums := make(map[*AggregateResult]float64)
+	sum := func(a *AggregateResult) float64 {
+		s, ok := sums[a]
+		if !ok {
+			s = a.Sum(field)
+			sums[a] = s
+		}
+		return s
+	}
+
+	return func(a, b *AggregateResult) bool {
+		return sum(a) > sum(b)
+	}
+}
+
+// SortAggregate sorts aggregate results by the passed in order, keeping the existing order of results that are equal
+func SortAggregate(results []*AggregateResult, order AggregateOrder) {
+	sort.SliceStable(results, func(i, j int) bool {
+		return order(results[i], results[j])
+	})
+}
+
+// TopAggregate sorts aggregate results by the passed in order, and returns only the first limit results, such as the
+// 10 groups with the highest total:
+//	top := badgerhold.TopAggregate(results, badgerhold.OrderBySum("Total"), 10)
+func TopAggregate(results []*AggregateResult, order AggregateOrder, limit int) []*AggregateResult {
+	SortAggregate(results, order)
+	if limit >= 0 && limit < len(results) {
+		results = results[:limit]
+	}
+	return results
+}
+
 // FindAggregate returns an aggregate grouping for the passed in query
 // groupBy is optional
+// If the query matches all records, and the records are grouped by a single indexed field, the groups are read
+// directly from the index, and the records in each group are only loaded if they are needed by the aggregate
+// function run on them, i.e. Count or Sum of the grouped field won't load any records
 func (s *Store) FindAggregate(dataType interface{}, query *Query, groupBy ...string) ([]*AggregateResult, error) {
 	var result []*AggregateResult
 	var err error
 	err = s.Badger().View(func(tx *badger.Txn) error {
+		if s.indexAggregatable(dataType, query, groupBy) &&
+			!indexBuilding(tx, newStorer(dataType, s.encodeValue).Type(), groupBy[0]) {
+			result, err = s.indexAggregate(tx, dataType, groupBy[0])
+			if err == nil && query.accumulating() {
+				for i := range result {
+					// only the count is accumulated
+					result[i].count = len(result[i].keys)
+					result[i].accumulators = make(map[string]*accumulator)
+					result[i].keys, result[i].load = nil, nil
+				}
+			}
+			return err
+		}
 		result, err = s.TxFindAggregate(tx, dataType, query, groupBy...)
 		return err
 	})
 
-	if err != nil {
-		return nil, err
-	}
+	// a query with SkipErrors returns its results along with the records it skipped
+	return result, err
+}
 
-	return result, nil
+// GroupFunc returns the value to group a record by in an aggregate query, such as a time truncated to the day.
+// The record passed in is always a pointer, and the values returned must be comparable with each other
+type GroupFunc func(record interface{}) interface{}
+
+// FindAggregateFunc returns an aggregate grouping for the passed in query, grouped by the values returned
+// from groupBy for each record.  The groups are sorted by the value returned from groupBy
+func (s *Store) FindAggregateFunc(dataType interface{}, query *Query, groupBy GroupFunc) ([]*AggregateResult, error) {
+	var result []*AggregateResult
+	var err error
+	err = s.Badger().View(func(tx *badger.Txn) error {
+		result, err = s.TxFindAggregateFunc(tx, dataType, query, groupBy)
+		return err
+	})
+
+	return result, err
+}
+
+// TxFindAggregateFunc is the same as FindAggregateFunc, but you specify your own transaction
+func (s *Store) TxFindAggregateFunc(tx *badger.Txn, dataType interface{}, query *Query,
+	groupBy GroupFunc) ([]*AggregateResult, error) {
+	return aggregateQueryFunc(tx, dataType, s.preloaded(query), groupBy)
 }
 
 // FindAggregatePRS returns an aggregate grouping for the passed in query
@@ -187,25 +350,164 @@ func (s *Store) FindAggregatePRS(dataType interface{}, query *Query, kuncian str
 		return err
 	})
 
-	if err != nil {
-		return nil, err
-	}
-
-	return result, nil
+	// a query with SkipErrors returns its results along with the records it skipped
+	return result, err
 }
 
 // TxFindAggregate is the same as FindAggregate, but you specify your own transaction
 // groupBy is optional
 func (s *Store) TxFindAggregate(tx *badger.Txn, dataType interface{}, query *Query,
 	groupBy ...string) ([]*AggregateResult, error) {
-	return aggregateQuery(tx, dataType, query, groupBy...)
+	return aggregateQuery(tx, dataType, s.preloaded(query), groupBy...)
 }
 
 // TxFindAggregatePRS is the same as FindAggregate, but you specify your own transaction
 // groupBy is optional
 func (s *Store) TxFindAggregatePRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian string,
 	groupBy ...string) ([]*AggregateResult, error) {
-	return aggregateQueryPRS(tx, dataType, query, kuncian, groupBy...)
+	return aggregateQueryPRS(tx, dataType, s.preloaded(query), kuncian, groupBy...)
+}
+
+// indexAggregatable returns whether or not the aggregate query can be grouped from an index rather than
+// from the records themselves
+func (s *Store) indexAggregatable(dataType interface{}, query *Query, groupBy []string) bool {
+	if len(groupBy) != 1 {
+		return false
+	}
+
+	if query != nil && (!query.IsEmpty() || query.skip != 0 || query.limit != 0 || query.boundType != nil) {
+		return false
+	}
+
+	if query.accumulating() && len(query.accumulate) != 0 {
+		// loading each group's records to accumulate them would keep them all in memory
+		return false
+	}
+
+	if (query == nil || !query.includeDeleted) && s.softDeletes(reflect.TypeOf(dataType)) {
+		// the index doesn't know which records are soft deleted
+		return false
+	}
+
+	if _, ok := dataType.(Storer); ok {
+		// custom indexes may skip records or not hold the field value
+		return false
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	structField, ok := fieldByName(tp, groupBy[0], s.fieldNameTag)
+	if !ok || isHashed(structField) {
+		// a hash index doesn't hold the field values to group by
+		return false
+	}
+
+	_, ok = newStorer(dataType, s.encodeValue).Indexes()[structField.Name]
+	return ok
+}
+
+// indexAggregate groups the records of dataType by the values in the index on field
+func (s *Store) indexAggregate(tx *badger.Txn, dataType interface{}, field string) ([]*AggregateResult, error) {
+	storer := newStorer(dataType, s.encodeValue)
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	structField, ok := fieldByName(tp, field, s.fieldNameTag)
+	if !ok {
+		return nil, fmt.Errorf("The field %s does not exist in the type %s", field, tp)
+	}
+
+	var result []*AggregateResult
+
+	prefix := indexKeyPrefix(storer.Type(), structField.Name)
+	iter := tx.NewIterator(badger.DefaultIteratorOptions)
+	defer iter.Close()
+
+	// the header of the index value being grouped
+	var current []byte
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		header, value, member, ok := splitIndexKey(prefix, iter.Item().KeyCopy(nil))
+		if !ok {
+			return nil, fmt.Errorf("The index entry %q is corrupt", iter.Item().Key())
+		}
+
+		if len(member) == 0 {
+			// the header of a unique value
+			continue
+		}
+		if bytes.Equal(header, current) {
+			result[len(result)-1].keys = append(result[len(result)-1].keys, member)
+			continue
+		}
+		current = header
+
+		group := reflect.New(structField.Type)
+		err := indexDecode(value, group.Interface(), s.decodeValue)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, &AggregateResult{
+			group:        []reflect.Value{group.Elem()},
+			groupBy:      []string{field},
+			fieldNameTag: s.fieldNameTag,
+			keys:         keyList{member},
+			load: func(keys keyList) ([]reflect.Value, error) {
+				return s.loadRecords(tp, keys)
+			},
+		})
+	}
+
+	// match the group order of an aggregate run against the records
+	var err error
+	sort.SliceStable(result, func(i, j int) bool {
+		c, cerr := compare(result[i].group[0].Interface(), result[j].group[0].Interface())
+		if cerr != nil {
+			err = cerr
+		}
+		return c < 0
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+// loadRecords reads the records for the passed in keys in a new transaction
+func (s *Store) loadRecords(dataType reflect.Type, keys keyList) ([]reflect.Value, error) {
+	records := make([]reflect.Value, 0, len(keys))
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		for i := range keys {
+			item, err := tx.Get(keys[i])
+			if err != nil {
+				return err
+			}
+
+			value := reflect.New(dataType)
+			err = item.Value(func(v []byte) error {
+				return s.decode(v, value.Interface())
+			})
+			if err != nil {
+				return err
+			}
+
+			records = append(records, value)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return records, nil
 }
 
 func tryFloat(val reflect.Value) float64 {
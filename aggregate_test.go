@@ -7,7 +7,9 @@ package badgerhold_test
 import (
 	"fmt"
 	"testing"
+	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/timshannon/badgerhold"
 )
 
@@ -520,3 +522,386 @@ func TestFindAggregateWithNoGroupBy(t *testing.T) {
 
 	})
 }
+
+func TestFindAggregateFromIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Order struct {
+			ID     int
+			Status int `badgerhold:"index"`
+		}
+
+		statuses := []int{3, 1, 2, 3, 3, 1}
+		for i := range statuses {
+			err := store.Insert(i, &Order{ID: i, Status: statuses[i]})
+			if err != nil {
+				t.Fatalf("Error inserting data for aggregate test: %s", err)
+			}
+		}
+
+		result, err := store.FindAggregate(&Order{}, nil, "Status")
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		expected := []struct {
+			status int
+			count  int
+		}{
+			{1, 2},
+			{2, 1},
+			{3, 3},
+		}
+
+		if len(result) != len(expected) {
+			t.Fatalf("Wrong number of groupings.  Wanted %d got %d", len(expected), len(result))
+		}
+
+		for i := range expected {
+			var status int
+			result[i].Group(&status)
+
+			if status != expected[i].status {
+				t.Fatalf("Expected group %d got %d", expected[i].status, status)
+			}
+
+			if result[i].Count() != expected[i].count {
+				t.Fatalf("Expected status %d count of %d got %d", status, expected[i].count, result[i].Count())
+			}
+
+			if result[i].Sum("Status") != float64(status*expected[i].count) {
+				t.Fatalf("Expected status %d sum of %d got %v", status, status*expected[i].count,
+					result[i].Sum("Status"))
+			}
+
+			var orders []Order
+			result[i].Reduction(&orders)
+
+			if len(orders) != expected[i].count {
+				t.Fatalf("Expected %d records in the reduction got %d", expected[i].count, len(orders))
+			}
+
+			for j := range orders {
+				if orders[j].Status != status {
+					t.Fatalf("Reduction item is not in the proper grouping.  Wanted %d, Got %d", status,
+						orders[j].Status)
+				}
+			}
+		}
+
+		max := &Order{}
+		result[2].Max("ID", max)
+		if max.ID != 4 {
+			t.Fatalf("Expected max ID of %d got %d", 4, max.ID)
+		}
+	})
+}
+
+func TestFindAggregateFunc(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Event struct {
+			Name    string
+			Created time.Time
+		}
+
+		day := time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)
+		events := []Event{
+			{"b", day.Add(26 * time.Hour)},
+			{"a", day.Add(3 * time.Hour)},
+			{"c", day.Add(50 * time.Hour)},
+			{"a", day.Add(25 * time.Hour)},
+			{"b", day.Add(time.Minute)},
+		}
+
+		for i := range events {
+			err := store.Insert(i, events[i])
+			if err != nil {
+				t.Fatalf("Error inserting data for aggregate test: %s", err)
+			}
+		}
+
+		result, err := store.FindAggregateFunc(&Event{}, nil, func(record interface{}) interface{} {
+			return record.(*Event).Created.Truncate(24 * time.Hour)
+		})
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		expected := []int{2, 2, 1}
+		if len(result) != len(expected) {
+			t.Fatalf("Wrong number of groupings.  Wanted %d got %d", len(expected), len(result))
+		}
+
+		for i := range expected {
+			var group time.Time
+			result[i].Group(&group)
+
+			if !group.Equal(day.AddDate(0, 0, i)) {
+				t.Fatalf("Expected group %s got %s", day.AddDate(0, 0, i), group)
+			}
+
+			if result[i].Count() != expected[i] {
+				t.Fatalf("Expected group %s count of %d got %d", group, expected[i], result[i].Count())
+			}
+
+			var reduction []Event
+			result[i].Reduction(&reduction)
+			for j := range reduction {
+				if !reduction[j].Created.Truncate(24 * time.Hour).Equal(group) {
+					t.Fatalf("Reduction item is not in the proper grouping.  Wanted %s, Got %s", group,
+						reduction[j].Created)
+				}
+			}
+		}
+
+		result, err = store.FindAggregateFunc(&Event{}, badgerhold.Where("Name").Ne("c"),
+			func(record interface{}) interface{} {
+				return record.(*Event).Name
+			})
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		if len(result) != 2 {
+			t.Fatalf("Wrong number of groupings.  Wanted %d got %d", 2, len(result))
+		}
+
+		var name string
+		result[0].Group(&name)
+		if name != "a" || result[0].Count() != 2 {
+			t.Fatalf("Expected group a with count 2, got group %s with count %d", name, result[0].Count())
+		}
+	})
+}
+
+func TestTopAggregate(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		result, err := store.FindAggregate(&ItemTest{}, nil, "Category")
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		groups := len(result)
+
+		badgerhold.SortAggregate(result, badgerhold.OrderByCount())
+		for i := 1; i < len(result); i++ {
+			if result[i-1].Count() < result[i].Count() {
+				t.Fatalf("Group %d has a count of %d, which is less than the next group's %d", i-1,
+					result[i-1].Count(), result[i].Count())
+			}
+		}
+
+		top := badgerhold.TopAggregate(result, badgerhold.OrderBySum("ID"), 2)
+		if len(top) != 2 {
+			t.Fatalf("TopAggregate returned %d groups wanted %d", len(top), 2)
+		}
+
+		for i := range result[2:] {
+			if result[2+i].Sum("ID") > top[1].Sum("ID") {
+				t.Fatalf("A group with a larger sum than %f was left out of the top groups", top[1].Sum("ID"))
+			}
+		}
+		if top[0].Sum("ID") < top[1].Sum("ID") {
+			t.Fatalf("Top groups aren't ordered by their sum")
+		}
+
+		all := badgerhold.TopAggregate(result, badgerhold.OrderByCount(), groups+10)
+		if len(all) != groups {
+			t.Fatalf("TopAggregate with a limit larger than the results returned %d groups wanted %d", len(all),
+				groups)
+		}
+	})
+}
+
+func TestFindAggregateReduce(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		result, err := store.FindAggregate(&ItemTest{}, nil, "Category")
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		for i := range result {
+			sum := result[i].Reduce(func(acc, record interface{}) interface{} {
+				return acc.(float64) + float64(record.(*ItemTest).ID)
+			}, 0.0).(float64)
+
+			if sum != result[i].Sum("ID") {
+				t.Fatalf("Reduce sum is %f wanted %f", sum, result[i].Sum("ID"))
+			}
+
+			names := result[i].Reduce(func(acc, record interface{}) interface{} {
+				return append(acc.([]string), record.(*ItemTest).Name)
+			}, []string(nil)).([]string)
+
+			if len(names) != result[i].Count() {
+				t.Fatalf("Reduce folded %d records wanted %d", len(names), result[i].Count())
+			}
+		}
+	})
+}
+
+func TestCountDistinct(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		categories := make(map[string]bool)
+		vehicleNames := make(map[string]bool)
+		for i := range testData {
+			categories[testData[i].Category] = true
+			if testData[i].Category == "vehicle" {
+				vehicleNames[testData[i].Name] = true
+			}
+		}
+
+		for _, approximate := range []bool{false, true} {
+			count, err := store.CountDistinct(&ItemTest{}, "Category", nil, approximate)
+			if err != nil {
+				t.Fatalf("Error counting distinct categories: %s", err)
+			}
+			if count != len(categories) {
+				t.Fatalf("Counted %d distinct categories wanted %d, approximate %t", count, len(categories),
+					approximate)
+			}
+
+			count, err = store.CountDistinct(&ItemTest{}, "Name", badgerhold.Where("Category").Eq("vehicle"),
+				approximate)
+			if err != nil {
+				t.Fatalf("Error counting distinct names: %s", err)
+			}
+			if count != len(vehicleNames) {
+				t.Fatalf("Counted %d distinct vehicle names wanted %d, approximate %t", count, len(vehicleNames),
+					approximate)
+			}
+		}
+
+		_, err := store.CountDistinct(&ItemTest{}, "BadField", nil, false)
+		if err == nil {
+			t.Fatalf("Counting a field that doesn't exist didn't fail")
+		}
+	})
+}
+
+type Visit struct {
+	Visitor int
+}
+
+func TestCountDistinctApproximate(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		const records = 15000
+		const distinct = 10000
+		const batch = 500
+
+		for start := 0; start < records; start += batch {
+			err := store.Badger().Update(func(tx *badger.Txn) error {
+				for i := start; i < start+batch; i++ {
+					err := store.TxInsert(tx, i, &Visit{Visitor: i % distinct})
+					if err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		exact, err := store.CountDistinct(&Visit{}, "Visitor", nil, false)
+		if err != nil {
+			t.Fatalf("Error counting distinct visitors: %s", err)
+		}
+		if exact != distinct {
+			t.Fatalf("Counted %d distinct visitors wanted %d", exact, distinct)
+		}
+
+		estimate, err := store.CountDistinct(&Visit{}, "Visitor", nil, true)
+		if err != nil {
+			t.Fatalf("Error estimating distinct visitors: %s", err)
+		}
+		if diff := float64(estimate-distinct) / distinct; diff > 0.03 || diff < -0.03 {
+			t.Fatalf("Estimated %d distinct visitors, more than 3%% off %d", estimate, distinct)
+		}
+	})
+}
+
+func TestFindAggregateAccumulate(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		buffered, err := store.FindAggregate(&ItemTest{}, nil, "Category")
+		if err != nil {
+			t.Fatalf("Error finding aggregate data from badgerhold: %s", err)
+		}
+
+		result, err := store.FindAggregate(&ItemTest{}, (&badgerhold.Query{}).Accumulate("ID", "Name"), "Category")
+		if err != nil {
+			t.Fatalf("Error finding accumulated aggregate data from badgerhold: %s", err)
+		}
+
+		if len(result) != len(buffered) {
+			t.Fatalf("Wrong number of groupings.  Wanted %d got %d", len(buffered), len(result))
+		}
+
+		for i := range result {
+			var group, want string
+			result[i].Group(&group)
+			buffered[i].Group(&want)
+			if group != want {
+				t.Fatalf("Accumulated group %d is %s wanted %s", i, group, want)
+			}
+
+			if result[i].Count() != buffered[i].Count() || result[i].Sum("ID") != buffered[i].Sum("ID") ||
+				result[i].Avg("ID") != buffered[i].Avg("ID") {
+				t.Fatalf("Accumulated %s group has count %d sum %v avg %v wanted %d %v %v", group,
+					result[i].Count(), result[i].Sum("ID"), result[i].Avg("ID"),
+					buffered[i].Count(), buffered[i].Sum("ID"), buffered[i].Avg("ID"))
+			}
+
+			min, max := &ItemTest{}, &ItemTest{}
+			wantMin, wantMax := &ItemTest{}, &ItemTest{}
+			result[i].Min("ID", min)
+			result[i].Max("ID", max)
+			buffered[i].Min("ID", wantMin)
+			buffered[i].Max("ID", wantMax)
+			if !min.equal(wantMin) || !max.equal(wantMax) {
+				t.Fatalf("Accumulated %s group has min %v max %v wanted %v %v", group, min, max, wantMin, wantMax)
+			}
+
+			result[i].Min("Name", min)
+			buffered[i].Min("Name", wantMin)
+			if min.Name != wantMin.Name {
+				t.Fatalf("Accumulated %s group has min name %s wanted %s", group, min.Name, wantMin.Name)
+			}
+		}
+
+		// counting from the index doesn't keep the keys of each group either
+		counted, err := store.FindAggregate(&ItemTest{}, (&badgerhold.Query{}).Accumulate(), "Category")
+		if err != nil {
+			t.Fatalf("Error finding accumulated aggregate data from badgerhold: %s", err)
+		}
+		for i := range counted {
+			if counted[i].Count() != buffered[i].Count() {
+				t.Fatalf("Accumulated count is %d wanted %d", counted[i].Count(), buffered[i].Count())
+			}
+		}
+
+		for _, fn := range []func(){
+			func() { result[0].Reduction(&[]ItemTest{}) },
+			func() { result[0].Sum("Key") },
+			func() { result[0].Sum("Name") },
+			func() { counted[0].Reduction(&[]ItemTest{}) },
+		} {
+			func() {
+				defer func() {
+					if recover() == nil {
+						t.Fatalf("Accumulated result didn't panic")
+					}
+				}()
+				fn()
+			}()
+		}
+	})
+}
@@ -0,0 +1,205 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"reflect"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/dgraph-io/badger/pb"
+)
+
+// maximum number of pending writes badger will buffer while loading a backup
+const restoreMaxPendingWrites = 256
+
+// Backup writes a snapshot of the entire store to w in badger's backup format, and returns a version timestamp
+// that can be passed in as since for a following incremental backup. Passing in 0 for since takes a full backup.
+// Indexes are stored alongside the records they refer to, so they are included in the backup and don't need to be
+// rebuilt after a Restore
+func (s *Store) Backup(w io.Writer, since uint64) (uint64, error) {
+	return s.Badger().Backup(w, since)
+}
+
+// Restore loads a backup written by Backup into the store.  Restore should be called on an empty store, or one
+// restored from an earlier backup in the same chain of incremental backups
+func (s *Store) Restore(r io.Reader) error {
+	err := s.Badger().Load(r, restoreMaxPendingWrites)
+	s.purgeCaches()
+	return err
+}
+
+// importBatchSize is the number of records Import resolves and writes in each transaction
+const importBatchSize = 1000
+
+// backupDeleted is the badger meta bit set on the deleted entries of an incremental backup
+const backupDeleted = 1 << 0
+
+// ImportResolver decides what's stored for a record being imported over the record already in the store.  existing is
+// nil if there's no record with the key.  The returned value is written, with its indexes, if the returned bool is
+// true, otherwise the existing record is left as it is
+type ImportResolver func(key, existing, incoming interface{}) (interface{}, bool)
+
+// Import loads the records of dataType from a backup written by Backup, and passes each to the resolver along with
+// the record already stored under its key, to decide what's kept.  Unlike Restore, the store doesn't need to be empty,
+// the indexes are updated to match the values the resolver chooses, and the backup's other types and index entries
+// are left out.  A nil resolver overwrites the existing records.  Existing and incoming records are passed to the
+// resolver as pointers, and the key is decoded into the type of dataType's key field, or left as its encoded []byte
+// if dataType doesn't have one.  Records are written in batches, each in its own transaction
+func (s *Store) Import(r io.Reader, dataType interface{}, resolver ImportResolver) error {
+	if resolver == nil {
+		resolver = func(key, existing, incoming interface{}) (interface{}, bool) {
+			return incoming, true
+		}
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	keyType := keyFieldType(tp)
+
+	typeName := s.storer(dataType).Type()
+	prefix := typePrefix(typeName)
+	var batch []*pb.KV
+	var last []byte
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := s.update(func(tx *badger.Txn) error {
+			for _, kv := range batch {
+				err := s.importRecord(tx, kv, typeName, tp, keyType, resolver)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	reader := bufio.NewReader(r)
+	for {
+		var size uint64
+		err := binary.Read(reader, binary.LittleEndian, &size)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		data := make([]byte, size)
+		_, err = io.ReadFull(reader, data)
+		if err != nil {
+			return err
+		}
+
+		list := &pb.KVList{}
+		err = list.Unmarshal(data)
+		if err != nil {
+			return err
+		}
+
+		for _, kv := range list.Kv {
+			if !bytes.HasPrefix(kv.Key, prefix) {
+				continue
+			}
+			if bytes.Equal(kv.Key, last) {
+				// the latest version of a key comes first, so skip the older ones
+				continue
+			}
+			last = kv.Key
+
+			if len(kv.Meta) > 0 && kv.Meta[0]&backupDeleted != 0 {
+				continue
+			}
+			if kv.ExpiresAt != 0 && kv.ExpiresAt <= uint64(time.Now().Unix()) {
+				continue
+			}
+
+			batch = append(batch, kv)
+			if len(batch) == importBatchSize {
+				err = flush()
+				if err != nil {
+					return err
+				}
+			}
+		}
+	}
+
+	return flush()
+}
+
+// importRecord resolves an imported record against the one stored under its key, and writes the resolver's choice
+func (s *Store) importRecord(tx *badger.Txn, kv *pb.KV, typeName string, tp, keyType reflect.Type,
+	resolver ImportResolver) error {
+	incoming := reflect.New(tp)
+	err := s.decode(kv.Value, incoming.Interface())
+	if err != nil {
+		return err
+	}
+
+	var existing interface{}
+	item, err := tx.Get(kv.Key)
+	if err == nil {
+		current := reflect.New(tp)
+		err = item.Value(func(value []byte) error {
+			return s.decode(value, current.Interface())
+		})
+		if err != nil {
+			return err
+		}
+		existing = current.Interface()
+	} else if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	var key interface{} = kv.Key[len(typePrefix(typeName)):]
+	if keyType != nil {
+		decoded := reflect.New(keyType)
+		err = decodeKey(kv.Key, decoded.Interface(), typeName, s.decodeValue)
+		if err != nil {
+			return err
+		}
+		key = decoded.Elem().Interface()
+	}
+
+	value, write := resolver(key, existing, incoming.Interface())
+	if !write {
+		return nil
+	}
+
+	vt := reflect.TypeOf(value)
+	for vt != nil && vt.Kind() == reflect.Ptr {
+		vt = vt.Elem()
+	}
+	if vt != tp {
+		return fmt.Errorf("The import resolver returned a %T for a %s record", value, tp)
+	}
+
+	return s.TxUpsert(tx, importedKey(kv.Key[len(typePrefix(typeName)):]), value)
+}
+
+// importedKey is a record key that's already encoded
+type importedKey []byte
+
+func (k importedKey) EncodeKey() ([]byte, error) {
+	return k, nil
+}
+
+func (k *importedKey) DecodeKey(data []byte) error {
+	*k = append(importedKey{}, data...)
+	return nil
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+
+	"github.com/timshannon/badgerhold"
+)
+
+func TestBackupRestore(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var buf bytes.Buffer
+		_, err := store.Backup(&buf, 0)
+		if err != nil {
+			t.Fatalf("Error backing up store: %s", err)
+		}
+
+		opt := testOptions()
+		restored, err := badgerhold.Open(opt)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", opt.Dir, err)
+		}
+		defer os.RemoveAll(opt.Dir)
+		defer restored.Close()
+
+		err = restored.Restore(&buf)
+		if err != nil {
+			t.Fatalf("Error restoring store: %s", err)
+		}
+
+		query := badgerhold.Where("Category").Eq("vehicle").Index("Category")
+
+		var expected []ItemTest
+		err = store.Find(&expected, query)
+		if err != nil {
+			t.Fatalf("Error finding data from original store: %s", err)
+		}
+
+		var result []ItemTest
+		err = restored.Find(&result, badgerhold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data from restored store: %s", err)
+		}
+
+		if len(result) == 0 || len(result) != len(expected) {
+			t.Fatalf("Restored index query returned %d records wanted %d", len(result), len(expected))
+		}
+
+		for i := range expected {
+			if !result[i].equal(&expected[i]) {
+				t.Fatalf("Restored record %v doesn't match original %v", result[i], expected[i])
+			}
+		}
+	})
+}
+
+func TestImport(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+		for _, ticket := range []SupportTicket{
+			{ID: "T-0", Queue: "support", Points: 5},
+			{ID: "T-1", Queue: "billing", Points: 1},
+			{ID: "T-2", Queue: "billing", Points: 2},
+		} {
+			err := store.Insert(ticket.ID, &ticket)
+			if err != nil {
+				t.Fatalf("Error inserting ticket: %s", err)
+			}
+		}
+
+		var buf bytes.Buffer
+		_, err := store.Backup(&buf, 0)
+		if err != nil {
+			t.Fatalf("Error backing up store: %s", err)
+		}
+
+		opt := testOptions()
+		imported, err := badgerhold.Open(opt)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", opt.Dir, err)
+		}
+		defer os.RemoveAll(opt.Dir)
+		defer imported.Close()
+
+		for _, ticket := range []SupportTicket{
+			{ID: "T-0", Queue: "sales", Points: 1},
+			{ID: "T-1", Queue: "sales", Points: 7},
+		} {
+			err = imported.Insert(ticket.ID, &ticket)
+			if err != nil {
+				t.Fatalf("Error inserting ticket: %s", err)
+			}
+		}
+
+		var resolved []string
+		err = imported.Import(&buf, &SupportTicket{}, func(key, existing, incoming interface{}) (interface{}, bool) {
+			resolved = append(resolved, fmt.Sprintf("%v:%v", key, existing != nil))
+			if key == "T-2" {
+				return nil, false
+			}
+			if existing != nil && existing.(*SupportTicket).Points > incoming.(*SupportTicket).Points {
+				return existing, false
+			}
+			return incoming, true
+		})
+		if err != nil {
+			t.Fatalf("Error importing tickets: %s", err)
+		}
+
+		if fmt.Sprint(resolved) != "[T-0:true T-1:true T-2:false]" {
+			t.Fatalf("Resolver was called for %v", resolved)
+		}
+
+		for queue, ids := range map[string]string{"support": "[T-0]", "sales": "[T-1]", "billing": "[]"} {
+			keys, err := imported.FindKeys(&SupportTicket{}, badgerhold.Where("Queue").Eq(queue).Index("Queue"))
+			if err != nil {
+				t.Fatalf("Error finding tickets: %s", err)
+			}
+			if fmt.Sprint(keys) != ids {
+				t.Fatalf("The %s index has %v wanted %s", queue, keys, ids)
+			}
+		}
+
+		types, err := imported.Types()
+		if err != nil {
+			t.Fatalf("Error getting types: %s", err)
+		}
+		if fmt.Sprint(types) != "[SupportTicket]" {
+			t.Fatalf("Import stored the types %v wanted only SupportTicket", types)
+		}
+
+		_, err = store.Backup(&buf, 0)
+		if err != nil {
+			t.Fatalf("Error backing up store: %s", err)
+		}
+		err = imported.Import(&buf, &SupportTicket{}, nil)
+		if err != nil {
+			t.Fatalf("Error importing tickets: %s", err)
+		}
+
+		var result SupportTicket
+		err = imported.Get("T-1", &result)
+		if err != nil {
+			t.Fatalf("Error getting ticket: %s", err)
+		}
+		if result.Queue != "billing" || result.Points != 1 {
+			t.Fatalf("Import without a resolver didn't overwrite the ticket: %+v", result)
+		}
+	})
+}
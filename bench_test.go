@@ -7,8 +7,11 @@ package badgerhold_test
 import (
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
+	"math"
 	"os"
+	"strconv"
 	"testing"
 
 	"github.com/dgraph-io/badger"
@@ -225,3 +228,211 @@ func BenchmarkFindIndexed(b *testing.B) {
 		}
 	})
 }
+
+func BenchmarkFindAll(b *testing.B) {
+	benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+		for k := 0; k < 300; k++ {
+			err := store.Insert(id(), benchItem)
+			if err != nil {
+				b.Fatalf("Error inserting benchmarking data: %s", err)
+			}
+		}
+
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			var result []BenchData
+
+			err := store.Find(&result, nil)
+			if err != nil {
+				b.Fatalf("Error finding data in store: %s", err)
+			}
+		}
+	})
+}
+
+// hotIndexSizes are the number of records sharing a single index value in the hot index benchmarks
+var hotIndexSizes = []int{1000, 10000}
+
+// fillHotIndex inserts count records that all share the same indexed Category, and returns their keys
+func fillHotIndex(b *testing.B, store *badgerhold.Store, count int) [][]byte {
+	keys := make([][]byte, 0, count)
+	for len(keys) < count {
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			for i := 0; i < 500 && len(keys) < count; i++ {
+				key := id()
+				err := store.TxInsert(tx, key, &BenchDataIndexed{ID: len(keys), Category: "hot"})
+				if err != nil {
+					return err
+				}
+				keys = append(keys, key)
+			}
+			return nil
+		})
+		if err != nil {
+			b.Fatalf("Error inserting benchmarking data: %s", err)
+		}
+	}
+	return keys
+}
+
+func BenchmarkHotIndexInsert(b *testing.B) {
+	for _, size := range hotIndexSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+				fillHotIndex(b, store, size)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					err := store.Insert(id(), &BenchDataIndexed{ID: i, Category: "hot"})
+					if err != nil {
+						b.Fatalf("Error inserting into store: %s", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchmarkHotIndexInsertParallel inserts from several goroutines at once, which only conflict if their writes to the
+// shared index value do
+func BenchmarkHotIndexInsertParallel(b *testing.B) {
+	for _, size := range hotIndexSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+				fillHotIndex(b, store, size)
+
+				b.ResetTimer()
+
+				b.RunParallel(func(pb *testing.PB) {
+					for pb.Next() {
+						err := store.Insert(badgerhold.NextSequence(), &BenchDataIndexed{Category: "hot"})
+						if err != nil {
+							b.Fatalf("Error inserting into store: %s", err)
+						}
+					}
+				})
+			})
+		})
+	}
+}
+
+func BenchmarkHotIndexDelete(b *testing.B) {
+	for _, size := range hotIndexSizes {
+		b.Run(strconv.Itoa(size), func(b *testing.B) {
+			benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+				keys := fillHotIndex(b, store, size+b.N)
+
+				b.ResetTimer()
+
+				for i := 0; i < b.N; i++ {
+					err := store.Delete(keys[i], &BenchDataIndexed{})
+					if err != nil {
+						b.Fatalf("Error deleting from store: %s", err)
+					}
+				}
+			})
+		})
+	}
+}
+
+// BenchRecord is encoded with gob, BenchBinaryRecord has the same fields and encodes itself
+type BenchRecord struct {
+	ID       int64
+	Category string
+	Score    float64
+	Count    uint32
+}
+
+type BenchBinaryRecord struct {
+	ID       int64
+	Category string
+	Score    float64
+	Count    uint32
+}
+
+func (r BenchBinaryRecord) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 20+len(r.Category))
+	binary.BigEndian.PutUint64(buf, uint64(r.ID))
+	binary.BigEndian.PutUint64(buf[8:], math.Float64bits(r.Score))
+	binary.BigEndian.PutUint32(buf[16:], r.Count)
+	copy(buf[20:], r.Category)
+	return buf, nil
+}
+
+func (r *BenchBinaryRecord) UnmarshalBinary(data []byte) error {
+	if len(data) < 20 {
+		return errors.New("Invalid BenchBinaryRecord")
+	}
+	r.ID = int64(binary.BigEndian.Uint64(data))
+	r.Score = math.Float64frombits(binary.BigEndian.Uint64(data[8:]))
+	r.Count = binary.BigEndian.Uint32(data[16:])
+	r.Category = string(data[20:])
+	return nil
+}
+
+func BenchmarkGobRecordInsert(b *testing.B) {
+	benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			err := store.Insert(id(), &BenchRecord{ID: int64(i), Category: "test category", Score: 1.5, Count: 3})
+			if err != nil {
+				b.Fatalf("Error inserting into store: %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkBinaryMarshalerRecordInsert(b *testing.B) {
+	benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			err := store.Insert(id(), &BenchBinaryRecord{ID: int64(i), Category: "test category", Score: 1.5,
+				Count: 3})
+			if err != nil {
+				b.Fatalf("Error inserting into store: %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkGobRecordGet(b *testing.B) {
+	benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+		key := id()
+		err := store.Insert(key, &BenchRecord{ID: 1, Category: "test category", Score: 1.5, Count: 3})
+		if err != nil {
+			b.Fatalf("Error inserting into store: %s", err)
+		}
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			var result BenchRecord
+			err = store.Get(key, &result)
+			if err != nil {
+				b.Fatalf("Error getting from store: %s", err)
+			}
+		}
+	})
+}
+
+func BenchmarkBinaryMarshalerRecordGet(b *testing.B) {
+	benchWrap(b, nil, func(store *badgerhold.Store, b *testing.B) {
+		key := id()
+		err := store.Insert(key, &BenchBinaryRecord{ID: 1, Category: "test category", Score: 1.5, Count: 3})
+		if err != nil {
+			b.Fatalf("Error inserting into store: %s", err)
+		}
+		b.ResetTimer()
+
+		for i := 0; i < b.N; i++ {
+			var result BenchBinaryRecord
+			err = store.Get(key, &result)
+			if err != nil {
+				b.Fatalf("Error getting from store: %s", err)
+			}
+		}
+	})
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// buildPrefix starts the badger key that marks an index as being built in the background, until it holds every record
+const buildPrefix = "_bhBuild:"
+
+func buildKey(typeName, indexName string) []byte {
+	return []byte(buildPrefix + typeName + ":" + indexName)
+}
+
+// indexBuilding returns whether the index is being built, so it doesn't hold every record yet
+func indexBuilding(tx *badger.Txn, typeName, indexName string) bool {
+	if indexName == "" {
+		return false
+	}
+	_, err := tx.Get(buildKey(typeName, indexName))
+	return err == nil
+}
+
+// BuildIndex builds the index of dataType from its existing records in the background, such as an index tag added to
+// a type that already has records, and returns a channel that receives the result once the build is done.  Records
+// are indexed in batches, each in its own transaction, and progress, if it's not nil, is called with the number of
+// records indexed so far after each batch.  Until the build is done, queries using the index scan every record of the
+// type instead, and records written in the meantime are indexed as usual.  Entries the index already holds are kept, so
+// an index with stale entries should be rebuilt with ReIndex instead.  The index is marked as being built in the store
+// itself, so if the build fails or the store is closed, queries keep scanning until the index is built again with
+// BuildIndex or ReIndex
+func (s *Store) BuildIndex(dataType interface{}, indexName string, progress func(indexed int)) <-chan error {
+	done := make(chan error, 1)
+
+	storer := s.storer(dataType)
+	index, ok := storer.Indexes()[indexName]
+	if !ok {
+		done <- fmt.Errorf("The index %s does not exist", indexName)
+		close(done)
+		return done
+	}
+	typeName := storer.Type()
+
+	err := s.update(func(tx *badger.Txn) error {
+		return tx.Set(buildKey(typeName, indexName), []byte{})
+	})
+	if err != nil {
+		done <- err
+		close(done)
+		return done
+	}
+
+	go func() {
+		defer close(done)
+		done <- s.backgroundBuild(dataType, typeName, indexName, index, progress)
+	}()
+
+	return done
+}
+
+// backgroundBuild adds every record of the type to the index, a batch of records at a time, then clears the mark that
+// it's being built
+func (s *Store) backgroundBuild(dataType interface{}, typeName, indexName string, index Index,
+	progress func(indexed int)) error {
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	tPrefix := typePrefix(typeName)
+	seek := tPrefix
+	indexed := 0
+
+	for {
+		var last []byte
+		var count int
+
+		// the records are read in the same transaction their entries are written in, so a batch that conflicts with
+		// a write to one of its records is retried, rather than indexing the record's old value
+		err := s.update(func(tx *badger.Txn) error {
+			last, count = nil, 0
+
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			for iter.Seek(seek); iter.ValidForPrefix(tPrefix) && count < migrateBatchSize; iter.Next() {
+				key := iter.Item().KeyCopy(nil)
+				value := reflect.New(tp)
+				err := iter.Item().Value(func(v []byte) error {
+					return s.decode(v, value.Interface())
+				})
+				if err != nil {
+					return err
+				}
+
+				err = s.indexUpdate(typeName, indexName, index, tx, key, value.Interface(), false)
+				if err != nil {
+					return err
+				}
+				last = key
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			break
+		}
+
+		indexed += count
+		// the smallest key after the last record of the batch
+		seek = append(last, 0)
+		if progress != nil {
+			progress(indexed)
+		}
+	}
+
+	s.preloads.purge()
+
+	return s.update(func(tx *badger.Txn) error {
+		return tx.Delete(buildKey(typeName, indexName))
+	})
+}
@@ -0,0 +1,237 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// bulkLoadBatchSize is the number of records BulkLoad inserts in each transaction
+const bulkLoadBatchSize = 1000
+
+// ErrIndexesStale is the error returned by a query on an index of a type whose indexes haven't been rebuilt since its
+// records were bulk loaded or migrated.  Store.ReIndex rebuilds them
+var ErrIndexesStale = errors.New("The indexes of this type are stale until they're rebuilt with Store.ReIndex")
+
+// BulkLoad inserts records of dataType without updating its indexes, which is much faster for large imports, and
+// rebuilds each of the type's indexes in a single pass once load returns.  load is passed an insert func that works
+// like Insert, except unique constraints aren't checked until the indexes are rebuilt, and the records are written in
+// batches.  From the time BulkLoad starts until the indexes are rebuilt, the type's indexes are marked stale, and any
+// query using one of them returns ErrIndexesStale, even after a restart.  If load, or rebuilding the indexes, fails,
+// the records inserted so far are kept, and the indexes stay stale until they're rebuilt with ReIndex. BulkLoad
+// shouldn't run alongside other writes to the type
+func (s *Store) BulkLoad(dataType interface{}, load func(insert func(key, data interface{}) error) error) error {
+	typeName := s.storer(dataType).Type()
+
+	err := s.update(func(tx *badger.Txn) error {
+		state, err := txSchemaState(tx, typeName)
+		if err != nil {
+			return err
+		}
+		state.flags |= schemaReindex
+		return setSchemaState(tx, typeName, state)
+	})
+	if err != nil {
+		return err
+	}
+
+	type pendingRecord struct {
+		key, data interface{}
+	}
+	var batch []pendingRecord
+
+	flush := func() error {
+		err := s.update(func(tx *badger.Txn) error {
+			for i := range batch {
+				err := s.insert(tx, batch[i].key, batch[i].data, false)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		batch = batch[:0]
+		return err
+	}
+
+	err = load(func(key, data interface{}) error {
+		if s.storer(data).Type() != typeName {
+			return &ErrTypeMismatch{dataType, data}
+		}
+
+		batch = append(batch, pendingRecord{key, data})
+		if len(batch) < bulkLoadBatchSize {
+			return nil
+		}
+		return flush()
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(batch) > 0 {
+		err = flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.ReIndex(dataType)
+}
+
+// ReIndex drops the named indexes of dataType and rebuilds them from its records in a single pass each, or every
+// index of the type if no names are passed in.  Once every index has been rebuilt, the type's indexes are no longer
+// stale.  ErrUniqueExists is returned if the records break the constraint of a unique index.  ReIndex shouldn't run
+// alongside other writes to the type
+func (s *Store) ReIndex(dataType interface{}, indexNames ...string) error {
+	storer := s.storer(dataType)
+	typeName := storer.Type()
+	indexes := storer.Indexes()
+
+	all := len(indexNames) == 0
+	if all {
+		for name := range indexes {
+			indexNames = append(indexNames, name)
+		}
+		sort.Strings(indexNames)
+	}
+
+	for _, name := range indexNames {
+		index, ok := indexes[name]
+		if !ok {
+			return fmt.Errorf("The index %s does not exist", name)
+		}
+
+		err := s.rebuildIndex(dataType, typeName, name, index)
+		if err != nil {
+			return err
+		}
+
+		// a rebuilt index is complete, even if it was being built in the background
+		err = s.update(func(tx *badger.Txn) error {
+			return tx.Delete(buildKey(typeName, name))
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if !all {
+		return nil
+	}
+
+	return s.update(func(tx *badger.Txn) error {
+		state, err := txSchemaState(tx, typeName)
+		if err != nil || state.flags&schemaReindex == 0 {
+			return err
+		}
+		state.flags &^= schemaReindex
+		return setSchemaState(tx, typeName, state)
+	})
+}
+
+// rebuildIndex replaces the entries of the index with ones built from the records of the type.  The records are read
+// in a single transaction, and the entries are written in batches, with the values of a unique index kept in memory to
+// check each one is only written once
+func (s *Store) rebuildIndex(dataType interface{}, typeName, indexName string, index Index) error {
+	prefix := indexKeyPrefix(typeName, indexName)
+	_, err := s.deletePrefix(prefix)
+	if err != nil {
+		return err
+	}
+
+	// the rebuilt index doesn't go through recordWritten
+	s.preloads.purge()
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	batch := s.Badger().NewWriteBatch()
+	defer batch.Cancel()
+
+	// the values of a unique index already written
+	written := make(map[string]bool)
+
+	err = s.Badger().View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		tPrefix := typePrefix(typeName)
+		for iter.Seek(tPrefix); iter.ValidForPrefix(tPrefix); iter.Next() {
+			value := reflect.New(tp)
+			err := iter.Item().Value(func(v []byte) error {
+				return s.decode(v, value.Interface())
+			})
+			if err != nil {
+				return err
+			}
+
+			values, err := index.values(indexName, value.Interface())
+			if err != nil {
+				return err
+			}
+			stored, err := index.coverValue(value.Interface(), s.encodeValue)
+			if err != nil {
+				return err
+			}
+			if stored == nil {
+				stored = []byte{}
+			}
+
+			key := iter.Item().KeyCopy(nil)
+			for i := range values {
+				if containsValue(values[:i], values[i]) {
+					continue
+				}
+
+				header := indexValueKey(prefix, values[i])
+				if index.Unique {
+					if written[string(header)] {
+						return ErrUniqueExists
+					}
+					written[string(header)] = true
+
+					// the header key of a unique value, as written by indexUpdateValue
+					err = batch.Set(header, []byte{})
+					if err != nil {
+						return err
+					}
+				}
+
+				err = batch.Set(append(header, key...), stored)
+				if err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return batch.Flush()
+}
+
+// indexesStale returns whether the indexes of the type are waiting to be rebuilt after a bulk load or migration
+func indexesStale(tx *badger.Txn, typeName string) bool {
+	state, err := txSchemaState(tx, typeName)
+	return err == nil && state.flags&schemaReindex != 0
+}
+
+// indexError returns the error for a query whose index can't be used
+func (q *Query) indexError() error {
+	if q.staleIndex {
+		return ErrIndexesStale
+	}
+	return fmt.Errorf("The index %s does not exist", q.index)
+}
@@ -0,0 +1,120 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/timshannon/badgerhold"
+)
+
+func TestBulkLoad(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		const count = 2500
+		err := store.BulkLoad(&Member{}, func(insert func(key, data interface{}) error) error {
+			for i := 0; i < count; i++ {
+				err := insert(i, &Member{FirstName: "Member", LastName: fmt.Sprintf("%d", i%10), Age: i})
+				if err != nil {
+					return err
+				}
+			}
+
+			// the indexes are stale until the load is done
+			var result []Member
+			err := store.Find(&result, badgerhold.Where("LastName").Eq("7").Index("LastName"))
+			if err != badgerhold.ErrIndexesStale {
+				t.Fatalf("Querying an index during a bulk load returned %v wanted ErrIndexesStale", err)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error bulk loading members: %s", err)
+		}
+
+		var result []Member
+		err = store.Find(&result, badgerhold.Where("LastName").Eq("7").Index("LastName"))
+		if err != nil {
+			t.Fatalf("Error finding members: %s", err)
+		}
+		if len(result) != count/10 {
+			t.Fatalf("Index found %d members wanted %d", len(result), count/10)
+		}
+
+		// later writes keep the rebuilt index up to date
+		err = store.Delete(7, &Member{})
+		if err != nil {
+			t.Fatalf("Error deleting member: %s", err)
+		}
+		result = nil
+		err = store.Find(&result, badgerhold.Where("LastName").Eq("7").Index("LastName"))
+		if err != nil {
+			t.Fatalf("Error finding members: %s", err)
+		}
+		if len(result) != count/10-1 {
+			t.Fatalf("Index found %d members after a delete wanted %d", len(result), count/10-1)
+		}
+
+		err = store.BulkLoad(&Member{}, func(insert func(key, data interface{}) error) error {
+			return insert(1, &ItemTest{})
+		})
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Bulk loading the wrong type returned %v wanted an *ErrTypeMismatch", err)
+		}
+		err = store.ReIndex(&Member{})
+		if err != nil {
+			t.Fatalf("Error rebuilding indexes: %s", err)
+		}
+	})
+}
+
+func TestBulkLoadUnique(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type TestUnique struct {
+			Key  uint64 `badgerhold:"key"`
+			Name string `badgerhold:"unique"`
+		}
+
+		err := store.BulkLoad(&TestUnique{}, func(insert func(key, data interface{}) error) error {
+			for i, name := range []string{"one", "two", "one"} {
+				err := insert(uint64(i+1), &TestUnique{Name: name})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Bulk loading duplicate unique values returned %v wanted ErrUniqueExists", err)
+		}
+
+		var result []TestUnique
+		err = store.Find(&result, badgerhold.Where("Name").Eq("two").Index("Name"))
+		if err != badgerhold.ErrIndexesStale {
+			t.Fatalf("Querying an index that failed to rebuild returned %v wanted ErrIndexesStale", err)
+		}
+
+		err = store.Delete(uint64(3), &TestUnique{})
+		if err != nil {
+			t.Fatalf("Error deleting the duplicate: %s", err)
+		}
+		err = store.ReIndex(&TestUnique{}, "Bad")
+		if err == nil {
+			t.Fatalf("Rebuilding an index that doesn't exist didn't fail")
+		}
+		err = store.ReIndex(&TestUnique{})
+		if err != nil {
+			t.Fatalf("Error rebuilding indexes: %s", err)
+		}
+
+		err = store.Find(&result, badgerhold.Where("Name").Eq("two").Index("Name"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 1 {
+			t.Fatalf("Found %d records wanted 1", len(result))
+		}
+	})
+}
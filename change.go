@@ -0,0 +1,152 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// ChangeOperation is the type of write that triggered a ChangeEvent
+type ChangeOperation int
+
+const (
+	// ChangeInsert is a new record being written to the store
+	ChangeInsert ChangeOperation = iota
+	// ChangeUpdate is an existing record being overwritten
+	ChangeUpdate
+	// ChangeDelete is an existing record being removed from the store
+	ChangeDelete
+)
+
+// ChangeEvent describes a single record change that has been committed to the store
+type ChangeEvent struct {
+	Type      string          // the storer type of the record
+	Key       []byte          // the encoded badger key of the record
+	Operation ChangeOperation // the kind of change made
+	Value     interface{}     // the new value of the record, nil on delete
+	Previous  interface{}     // the value of the record before the change, nil on insert
+
+	decode DecodeFunc // the store's Decoder, which DecodeKey uses
+}
+
+// DecodeKey decodes the key of the changed record into key, which must be a pointer
+func (e *ChangeEvent) DecodeKey(key interface{}) error {
+	return decodeKey(e.Key, key, e.Type, e.decode)
+}
+
+// changeHooks holds the registered change hooks for a store, and the events waiting on their transaction to commit
+type changeHooks struct {
+	sync.RWMutex
+	hooks   []func(ev ChangeEvent)
+	pending map[*badger.Txn][]ChangeEvent
+}
+
+// OnChange registers a hook that is called for every record inserted, updated or deleted by the store. Hooks are
+// only called after the transaction making the change has successfully committed, and are called in the order they
+// were registered.
+// Changes made in a transaction you manage yourself cannot be tracked to their commit, and don't trigger hooks, unless
+// the transaction was started by WithRetry
+func (s *Store) OnChange(hook func(ev ChangeEvent)) {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	s.changes.hooks = append(s.changes.hooks, hook)
+}
+
+// trackedUpdate runs fn in a new read-write transaction, and passes any changes made in it to the change hooks
+// once the transaction is committed
+func (s *Store) trackedUpdate(fn func(tx *badger.Txn) error) error {
+	// the preloaded indexes of the types written need to be dropped again once they're committed
+	fn, settlePreloads := s.preloads.tracked(fn)
+	settle := settlePreloads
+	if s.getCache != nil {
+		// the records written need to be removed from the Get cache again once they're committed
+		var settleCache func()
+		fn, settleCache = s.getCache.tracked(fn)
+		settle = func() {
+			settleCache()
+			settlePreloads()
+		}
+	}
+
+	s.changes.RLock()
+	hooks := s.changes.hooks
+	s.changes.RUnlock()
+
+	if len(hooks) == 0 {
+		err := s.Badger().Update(fn)
+		settle()
+		return err
+	}
+
+	var events []ChangeEvent
+
+	err := s.Badger().Update(func(tx *badger.Txn) error {
+		s.changes.Lock()
+		s.changes.pending[tx] = nil
+		s.changes.Unlock()
+
+		defer func() {
+			s.changes.Lock()
+			events = s.changes.pending[tx]
+			delete(s.changes.pending, tx)
+			s.changes.Unlock()
+		}()
+
+		return fn(tx)
+	})
+	settle()
+	if err != nil {
+		return err
+	}
+
+	for i := range events {
+		for _, hook := range hooks {
+			hook(events[i])
+		}
+	}
+
+	return nil
+}
+
+// tracksChanges returns whether or not changes made in the passed in transaction will be sent to the change hooks
+func (s *Store) tracksChanges(tx *badger.Txn) bool {
+	s.changes.RLock()
+	defer s.changes.RUnlock()
+
+	_, ok := s.changes.pending[tx]
+	return ok
+}
+
+// recordChange queues a change to be sent to the change hooks when tx commits
+func (s *Store) recordChange(tx *badger.Txn, ev ChangeEvent) {
+	s.changes.Lock()
+	defer s.changes.Unlock()
+
+	if _, ok := s.changes.pending[tx]; ok {
+		ev.decode = s.decodeValue
+		s.changes.pending[tx] = append(s.changes.pending[tx], ev)
+	}
+}
+
+// copyRecord returns a copy of the passed in record by encoding and decoding it, so that the copy isn't affected by any
+// changes made to the original
+func (s *Store) copyRecord(value reflect.Value) (interface{}, error) {
+	encoded, err := s.encode(value.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	cp := reflect.New(value.Type())
+	err = s.decode(encoded, cp.Interface())
+	if err != nil {
+		return nil, err
+	}
+
+	return cp.Elem().Interface(), nil
+}
@@ -0,0 +1,131 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/timshannon/badgerhold"
+)
+
+func TestOnChange(t *testing.T) {
+	opt := testOptions()
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	var events []badgerhold.ChangeEvent
+	var order []int
+
+	store.OnChange(func(ev badgerhold.ChangeEvent) {
+		events = append(events, ev)
+		order = append(order, 1)
+	})
+	store.OnChange(func(ev badgerhold.ChangeEvent) {
+		order = append(order, 2)
+	})
+
+	data := &ItemTest{
+		Name:     "Test Name",
+		Category: "Test Category",
+		Created:  time.Now(),
+	}
+
+	err = store.Insert("testKey", data)
+	if err != nil {
+		t.Fatalf("Error inserting data: %s", err)
+	}
+
+	if len(events) != 1 || events[0].Operation != badgerhold.ChangeInsert || events[0].Type != "ItemTest" {
+		t.Fatalf("Insert didn't fire an insert event: %v", events)
+	}
+
+	var key string
+	err = events[0].DecodeKey(&key)
+	if err != nil {
+		t.Fatalf("Error decoding event key: %s", err)
+	}
+	if key != "testKey" {
+		t.Fatalf("Event key is %s wanted %s", key, "testKey")
+	}
+
+	if len(order) != 2 || order[0] != 1 || order[1] != 2 {
+		t.Fatalf("Hooks weren't called in registration order: %v", order)
+	}
+
+	// failed writes don't fire events
+	err = store.Insert("testKey", data)
+	if err != badgerhold.ErrKeyExists {
+		t.Fatalf("Expected ErrKeyExists, got %v", err)
+	}
+
+	err = store.UpdateMatching(&ItemTest{}, nil, func(record interface{}) error {
+		return errors.New("rollback")
+	})
+	if err == nil {
+		t.Fatalf("UpdateMatching didn't return the update error")
+	}
+
+	if len(events) != 1 {
+		t.Fatalf("Failed transactions fired events: %v", events[1:])
+	}
+
+	err = store.Update("testKey", &ItemTest{
+		Name:     "Test Name Updated",
+		Category: "Test Category",
+		Created:  time.Now(),
+	})
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+
+	if len(events) != 2 || events[1].Operation != badgerhold.ChangeUpdate {
+		t.Fatalf("Update didn't fire an update event: %v", events)
+	}
+
+	previous, ok := events[1].Previous.(*ItemTest)
+	if !ok || !previous.equal(data) {
+		t.Fatalf("Update event previous value is %v wanted %v", events[1].Previous, data)
+	}
+
+	err = store.UpdateMatching(&ItemTest{}, badgerhold.Where("Name").Eq("Test Name Updated"),
+		func(record interface{}) error {
+			record.(*ItemTest).Name = "Test Name Matched"
+			return nil
+		})
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+
+	if len(events) != 3 || events[2].Operation != badgerhold.ChangeUpdate {
+		t.Fatalf("UpdateMatching didn't fire an update event: %v", events)
+	}
+
+	if events[2].Previous.(*ItemTest).Name != "Test Name Updated" ||
+		events[2].Value.(*ItemTest).Name != "Test Name Matched" {
+		t.Fatalf("UpdateMatching event has the wrong values.  Previous %v, Value %v", events[2].Previous,
+			events[2].Value)
+	}
+
+	err = store.Delete("testKey", &ItemTest{})
+	if err != nil {
+		t.Fatalf("Error deleting data: %s", err)
+	}
+
+	if len(events) != 4 || events[3].Operation != badgerhold.ChangeDelete || events[3].Value != nil {
+		t.Fatalf("Delete didn't fire a delete event: %v", events)
+	}
+
+	if events[3].Previous.(*ItemTest).Name != "Test Name Matched" {
+		t.Fatalf("Delete event previous value is %v", events[3].Previous)
+	}
+}
@@ -5,13 +5,15 @@
 package badgerhold
 
 import (
+	"encoding"
 	"fmt"
 	"math/big"
 	"reflect"
 	"time"
 )
 
-// ErrTypeMismatch is the error thrown when two types cannot be compared
+// ErrTypeMismatch is the error thrown when two types cannot be compared, or when a query bound to a type with
+// ForType is run against a different type
 type ErrTypeMismatch struct {
 	Value interface{}
 	Other interface{}
@@ -26,11 +28,66 @@ func (e *ErrTypeMismatch) Error() string {
 // If a field in a struct doesn't specify a comparer, then the default comparison is used (convert to string and compare)
 // this interface is already handled for standard Go Types as well as more complex ones such as those in time and big
 // an error is returned if the type cannot be compared
-// The concrete type will always be passedin, not a pointer
+// The concrete type will always be passedin, not a pointer, and Compare can be implemented on either the type or a
+// pointer to it
 type Comparer interface {
 	Compare(other interface{}) (int, error)
 }
 
+// keyComparer returns whether the KeyEncoder key implements Comparer, and can be decoded to compare it
+func keyComparer(key interface{}) bool {
+	tp := reflect.TypeOf(key)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	ptr := reflect.New(tp).Interface()
+	if _, ok := ptr.(KeyDecoder); !ok {
+		return false
+	}
+	_, ok := ptr.(Comparer)
+	return ok
+}
+
+// compareKey compares the encoded record key with the criterion's key using the key type's Compare, and returns an
+// error if it disagrees with encodedResult, the order of the encoded keys, as the keys are seeked in that order
+func (c *Criterion) compareKey(key []byte, keyType string, encodedResult int) (bool, error) {
+	tp := reflect.TypeOf(c.value)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	decoded := reflect.New(tp)
+	err := decodeKey(key, decoded.Interface(), keyType, c.query.store.decodeValue)
+	if err != nil {
+		return false, err
+	}
+
+	result, err := c.compare(decoded.Interface(), c.value, nil)
+	if err != nil {
+		return false, err
+	}
+
+	if sign(result) != sign(encodedResult) {
+		return false, fmt.Errorf("The Compare method of the key type %s disagrees with the order of its encoded "+
+			"keys, %v compares %d with %v, but its encoded key compares %d, so Key criteria can miss records",
+			tp, decoded.Elem().Interface(), sign(result), c.value, sign(encodedResult))
+	}
+
+	return compareResult(c.operator, result), nil
+}
+
+func sign(result int) int {
+	switch {
+	case result < 0:
+		return -1
+	case result > 0:
+		return 1
+	default:
+		return 0
+	}
+}
+
 func (c *Criterion) compare(rowValue, criterionValue interface{}, currentRow interface{}) (int, error) {
 	if rowValue == nil || criterionValue == nil {
 		if rowValue == criterionValue {
@@ -40,10 +97,9 @@ func (c *Criterion) compare(rowValue, criterionValue interface{}, currentRow int
 	}
 
 	if _, ok := criterionValue.(Field); ok {
-		fVal := reflect.ValueOf(currentRow).Elem().FieldByName(string(criterionValue.(Field)))
-		if !fVal.IsValid() {
-			return 0, fmt.Errorf("The field %s does not exist in the type %s", criterionValue,
-				reflect.TypeOf(currentRow))
+		fVal, err := fieldValue(reflect.ValueOf(currentRow), string(criterionValue.(Field)), c.query.store.fieldNameTag)
+		if err != nil {
+			return 0, err
 		}
 
 		criterionValue = fVal.Interface()
@@ -60,9 +116,44 @@ func (c *Criterion) compare(rowValue, criterionValue interface{}, currentRow int
 		other = reflect.ValueOf(other).Elem().Interface()
 	}
 
+	if s, ok := other.(string); ok {
+		if text, ok := textValue(value); ok {
+			// types such as enums are compared with strings by their text form
+			return compare(text, s)
+		}
+	}
+
 	return compare(value, other)
 }
 
+// textValue returns the text form of value if it's not a string, but implements encoding.TextMarshaler or
+// fmt.Stringer, on either the type or a pointer to it
+func textValue(value interface{}) (string, bool) {
+	if _, ok := value.(string); ok {
+		return "", false
+	}
+
+	ptr := reflect.New(reflect.TypeOf(value))
+	ptr.Elem().Set(reflect.ValueOf(value))
+
+	for _, v := range []interface{}{value, ptr.Interface()} {
+		if marshaler, ok := v.(encoding.TextMarshaler); ok {
+			text, err := marshaler.MarshalText()
+			if err == nil {
+				return string(text), true
+			}
+		}
+	}
+
+	for _, v := range []interface{}{value, ptr.Interface()} {
+		if stringer, ok := v.(fmt.Stringer); ok {
+			return stringer.String(), true
+		}
+	}
+
+	return "", false
+}
+
 func compare(value, other interface{}) (int, error) {
 	switch t := value.(type) {
 	case time.Time:
@@ -295,6 +386,13 @@ func compare(value, other interface{}) (int, error) {
 	case Comparer:
 		return value.(Comparer).Compare(other)
 	default:
+		// values are dereferenced before they're compared, so check for a Compare method with a pointer receiver
+		ptr := reflect.New(reflect.TypeOf(value))
+		ptr.Elem().Set(reflect.ValueOf(value))
+		if c, ok := ptr.Interface().(Comparer); ok {
+			return c.Compare(other)
+		}
+
 		valS := fmt.Sprintf("%s", value)
 		otherS := fmt.Sprintf("%s", other)
 		if valS == otherS {
@@ -303,3 +303,135 @@ func TestFindWithBuiltinTypes(t *testing.T) {
 		}
 	})
 }
+
+// Cents compares by its amount, which isn't the same order as its string form
+type Cents struct {
+	Amount int64
+}
+
+func (c *Cents) Compare(other interface{}) (int, error) {
+	o, ok := other.(Cents)
+	if !ok {
+		return 0, &badgerhold.ErrTypeMismatch{Value: c, Other: other}
+	}
+
+	switch {
+	case c.Amount < o.Amount:
+		return -1, nil
+	case c.Amount > o.Amount:
+		return 1, nil
+	}
+	return 0, nil
+}
+
+type LedgerEntry struct {
+	Key     int
+	Balance *big.Int `badgerhold:"index"`
+	Fee     Cents
+}
+
+func TestFindCustomComparable(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		amounts := []int64{5, 100, 9, 20, 1000}
+		for i := range amounts {
+			err := store.Insert(i, &LedgerEntry{
+				Key:     i,
+				Balance: new(big.Int).Mul(big.NewInt(amounts[i]), big.NewInt(1e18)),
+				Fee:     Cents{amounts[i]},
+			})
+			if err != nil {
+				t.Fatalf("Error inserting ledger entry: %s", err)
+			}
+		}
+
+		tests := []*badgerhold.Query{
+			badgerhold.Where("Balance").Gt(new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18))),
+			badgerhold.Where("Balance").Gt(new(big.Int).Mul(big.NewInt(10), big.NewInt(1e18))).Index("Balance"),
+			badgerhold.Where("Fee").Gt(Cents{10}),
+		}
+
+		for i := range tests {
+			var result []LedgerEntry
+			err := store.Find(&result, tests[i])
+			if err != nil {
+				t.Fatalf("Error finding ledger entries: %s", err)
+			}
+
+			if len(result) != 3 {
+				t.Fatalf("%s returned %d entries wanted 3", tests[i], len(result))
+			}
+		}
+
+		var result []LedgerEntry
+		err := store.Find(&result, badgerhold.Where("Fee").Ge(Cents{0}).SortBy("Fee"))
+		if err != nil {
+			t.Fatalf("Error finding ledger entries: %s", err)
+		}
+
+		for i := 1; i < len(result); i++ {
+			if result[i-1].Fee.Amount > result[i].Fee.Amount {
+				t.Fatalf("Entries aren't sorted by the Compare method of Fee: %v", result)
+			}
+		}
+	})
+}
+
+type TicketStatus int
+
+const (
+	TicketOpen TicketStatus = iota
+	TicketActive
+	TicketClosed
+)
+
+func (s TicketStatus) String() string {
+	switch s {
+	case TicketOpen:
+		return "open"
+	case TicketActive:
+		return "active"
+	case TicketClosed:
+		return "closed"
+	}
+	return "unknown"
+}
+
+type Ticket struct {
+	ID     int
+	Status TicketStatus `badgerhold:"index"`
+}
+
+func TestFindEnumByString(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		statuses := []TicketStatus{TicketOpen, TicketActive, TicketClosed, TicketActive, TicketOpen}
+		for i := range statuses {
+			err := store.Insert(i, &Ticket{ID: i, Status: statuses[i]})
+			if err != nil {
+				t.Fatalf("Error inserting ticket: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query *badgerhold.Query
+			count int
+		}{
+			{badgerhold.Where("Status").Eq("active"), 2},
+			{badgerhold.Where("Status").Eq("active").Index("Status"), 2},
+			{badgerhold.Where("Status").Ne("open"), 3},
+			{badgerhold.Where("Status").In("closed", "open").Index("Status"), 3},
+			{badgerhold.Where("Status").Eq(TicketClosed).Index("Status"), 1},
+		}
+
+		for _, tst := range tests {
+			var result []Ticket
+			err := store.Find(&result, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding tickets with %s: %s", tst.query, err)
+			}
+
+			if len(result) != tst.count {
+				t.Fatalf("%s returned %d tickets wanted %d", tst.query, len(result), tst.count)
+			}
+		}
+	})
+}
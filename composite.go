@@ -0,0 +1,267 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/dgraph-io/badger"
+)
+
+// compositeIndexSeparator separates the fields of a composite index in its badgerholdIndex tag, and in its name
+const compositeIndexSeparator = ","
+
+// compositeTag returns the name and fields of the composite index defined by the field's badgerholdIndex or
+// badgerholdUnique tag, such as `badgerholdIndex:"Category,Created"`, and whether it's unique.  The name is empty if
+// the tag doesn't define one
+func compositeTag(field reflect.StructField) (name string, fields []string, unique bool) {
+	tag := field.Tag.Get(BadgerHoldUniqueTag)
+	unique = tag != ""
+	if !unique {
+		tag = field.Tag.Get(BadgerHoldIndexTag)
+	}
+	if !strings.Contains(tag, compositeIndexSeparator) {
+		return "", nil, false
+	}
+
+	fields = strings.Split(tag, compositeIndexSeparator)
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return strings.Join(fields, compositeIndexSeparator), fields, unique
+}
+
+// compositeIndex returns the Index of the composite index on the fields of tp, whose values are encoded with encode
+// panics if a field doesn't exist, or can't be indexed
+func compositeIndex(tp reflect.Type, fields []string, unique bool, encode EncodeFunc) Index {
+	for _, name := range fields {
+		field, ok := tp.FieldByName(name)
+		if !ok {
+			panic(fmt.Sprintf("Invalid Type for Storer.  The composite index field %s does not exist", name))
+		}
+		if isSkipped(field) || isEncrypted(field) || field.Type.Kind() == reflect.Map {
+			panic("Invalid Type for Storer.  The field " + name + " can't be part of a composite index")
+		}
+	}
+
+	return Index{
+		IndexFunc: func(_ string, value interface{}) ([]byte, error) {
+			record := reflect.ValueOf(value)
+			for record.Kind() == reflect.Ptr {
+				record = record.Elem()
+			}
+
+			var encoded []byte
+			for _, name := range fields {
+				fVal := fieldValueByName(record, name, "")
+				if (fVal.Kind() == reflect.Ptr || fVal.Kind() == reflect.Interface) && fVal.IsNil() {
+					// nil values can't be encoded, and the record isn't indexed
+					return nil, nil
+				}
+
+				part, err := indexEncode(fVal.Interface(), encode)
+				if err != nil {
+					return nil, err
+				}
+				encoded = appendCompositePart(encoded, part)
+			}
+			return encoded, nil
+		},
+		Unique: unique,
+	}
+}
+
+// appendCompositePart appends an encoded field value to a composite index value.  Zero bytes are escaped, and the
+// value is terminated with a zero byte followed by a one, the same as indexValueKey, so composite values sort by
+// their first field, then by the next
+func appendCompositePart(encoded, part []byte) []byte {
+	for _, b := range part {
+		encoded = append(encoded, b)
+		if b == 0 {
+			encoded = append(encoded, 0xFF)
+		}
+	}
+	return append(encoded, 0, 1)
+}
+
+// splitCompositeValue splits a composite index value into the encoded values of its fields
+func splitCompositeValue(encoded []byte) ([][]byte, bool) {
+	var parts [][]byte
+	var part []byte
+	for i := 0; i < len(encoded); i++ {
+		if encoded[i] != 0 {
+			part = append(part, encoded[i])
+			continue
+		}
+
+		i++
+		if i == len(encoded) {
+			return nil, false
+		}
+		switch encoded[i] {
+		case 0xFF:
+			part = append(part, 0)
+		case 1:
+			parts = append(parts, part)
+			part = nil
+		default:
+			return nil, false
+		}
+	}
+	if part != nil {
+		return nil, false
+	}
+	return parts, true
+}
+
+// compositeIndexFields returns the fields of the composite index of the struct type tp, or nil if the index isn't a
+// composite index defined by a struct tag
+func compositeIndexFields(tp reflect.Type, indexName string) []reflect.StructField {
+	if tp == nil || !strings.Contains(indexName, compositeIndexSeparator) ||
+		reflect.PtrTo(tp).Implements(storerType) {
+		return nil
+	}
+
+	for _, field := range promotedFields(tp) {
+		name, names, _ := compositeTag(field)
+		if name != indexName {
+			continue
+		}
+
+		fields := make([]reflect.StructField, len(names))
+		for i := range names {
+			fields[i], _ = tp.FieldByName(names[i])
+		}
+		return fields
+	}
+	return nil
+}
+
+// coversComposite returns whether the query has criteria on the first field of the composite index that can be
+// tested against the index
+func (q *Query) coversComposite(fields []reflect.StructField) bool {
+	return len(q.fieldCriteria[fields[0].Name]) != 0 && !q.skipsIndex(fields[0].Name)
+}
+
+// compositePrefix returns the start of the index keys of every value matching the query's Eq criteria on the leading
+// fields of the composite index, so the iterator only needs to read those
+func compositePrefix(prefix []byte, query *Query, fields []reflect.StructField) []byte {
+	var encoded []byte
+	for _, field := range fields {
+		if query.skipsIndex(field.Name) {
+			break
+		}
+
+		var part []byte
+		for _, c := range query.fieldCriteria[field.Name] {
+			if c.operator != eq || c.value == nil || reflect.TypeOf(c.value) != field.Type {
+				continue
+			}
+
+			var err error
+			part, err = indexEncode(c.value, query.store.encodeValue)
+			if err != nil {
+				return prefix
+			}
+			break
+		}
+		if part == nil {
+			break
+		}
+		encoded = appendCompositePart(encoded, part)
+	}
+
+	// the header of the value without its terminator
+	header := indexValueKey(prefix, encoded)
+	return header[:len(header)-2]
+}
+
+// matchesComposite tests the query's criteria on the fields of the composite index against an index value
+func (q *Query) matchesComposite(encoded []byte, fields []reflect.StructField) (bool, error) {
+	parts, ok := splitCompositeValue(encoded)
+	if !ok || len(parts) != len(fields) {
+		return false, fmt.Errorf("The composite index value %x is corrupt", encoded)
+	}
+
+	for i, field := range fields {
+		criteria := q.fieldCriteria[field.Name]
+		if len(criteria) == 0 || q.skipsIndex(field.Name) {
+			continue
+		}
+
+		value := indexValue{data: parts[i], fieldType: field.Type, decodeValue: q.store.decodeValue}
+		ok, err := matchesAllCriteria(criteria, value, true, "", nil)
+		if err != nil || !ok {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// compositeKeys returns the nextKeys func of an iterator reading the record keys of a composite index
+func (i *iterator) compositeKeys(typeName string, query *Query, fields []reflect.StructField,
+	cacheSize int) func(*badger.Iterator) ([][]byte, [][]byte, error) {
+	query.stats.usedIndex(query.index)
+	indexPrefix := indexKeyPrefix(typeName, query.index)
+	prefix := compositePrefix(indexPrefix, query, fields)
+
+	var cursor keyCursor = iteratorCursor{i.iter}
+	if keys, ok := query.preloads.lookup(i.tx, indexPrefix); ok {
+		cursor = &sliceCursor{keys: keys}
+	}
+	cursor.Seek(prefix)
+
+	// the header of the index value being read, and whether it matched the criteria
+	var current []byte
+	var matched bool
+
+	return func(*badger.Iterator) ([][]byte, [][]byte, error) {
+		var nKeys [][]byte
+
+		for len(nKeys) < cacheSize {
+			if !cursor.ValidForPrefix(prefix) {
+				return nKeys, nil, nil
+			}
+			if query.expired() {
+				return nil, nil, ErrQueryTimeout
+			}
+
+			key := cursor.KeyCopy()
+			header, value, member, ok := splitIndexKey(indexPrefix, key)
+			if !ok {
+				return nil, nil, fmt.Errorf("The index entry %q is corrupt", key)
+			}
+
+			if !bytes.Equal(header, current) {
+				query.stats.scannedKey()
+
+				var err error
+				matched, err = query.matchesComposite(value, fields)
+				if err != nil {
+					return nil, nil, err
+				}
+				current = header
+			}
+
+			i.lastSeek = key
+			if !matched {
+				// skip the records of this index value
+				cursor.Seek(skipIndexValue(header))
+				continue
+			}
+
+			if len(member) != 0 {
+				nKeys = append(nKeys, member)
+			}
+			cursor.Next()
+		}
+
+		// the index only holds the record keys, their values have to be retrieved separately
+		return nKeys, nil, nil
+	}
+}
@@ -0,0 +1,66 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"reflect"
+	"strings"
+)
+
+// withStore sets the store the query, and its ors and groups, run against, whose Storers include the indexes added
+// to it.  It panics if the store can't match the names of the query's fields, see checkFieldNames
+func (q *Query) withStore(store *Store) {
+	if store.fieldNameTag == "" {
+		q.checkFieldNames()
+	}
+	q.store = store
+	for i := range q.ors {
+		q.ors[i].withStore(store)
+	}
+	for i := range q.groups {
+		q.groups[i].withStore(store)
+	}
+}
+
+// computedIndex returns the index of dataType named after the field, if dataType has no such field itself, such as an
+// index whose values are computed from several fields of the record.  Criteria on the field are then tested against
+// the values the index computes for each record, whenever the index itself isn't used
+func (q *Query) computedIndex(dataType reflect.Type, field string) (Index, bool) {
+	if strings.Contains(field, ".") {
+		return Index{}, false
+	}
+
+	storer := q.store.storer(reflect.New(dataType).Interface())
+
+	index, ok := storer.Indexes()[field]
+	if !ok || (index.IndexFunc == nil && index.MultiValueFunc == nil) {
+		return Index{}, false
+	}
+	return index, true
+}
+
+// matchesComputed returns whether any of the values the index computes for the record matches all of the criteria,
+// the same way the criteria are tested against the entries of the index
+func matchesComputed(criteria []*Criterion, index Index, name string, value reflect.Value,
+	currentRow interface{}) (bool, error) {
+	record := value
+	if record.Kind() != reflect.Ptr {
+		record = reflect.New(value.Type())
+		record.Elem().Set(value)
+	}
+
+	values, err := index.values(name, record.Interface())
+	if err != nil {
+		return false, err
+	}
+
+	for i := range values {
+		ok, err := matchesAllCriteria(criteria, values[i], true, "", currentRow)
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,182 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/timshannon/badgerhold"
+)
+
+type StressItem struct {
+	ID       uint64
+	Category string `badgerhold:"index"`
+	Value    int
+}
+
+func TestConcurrentUse(t *testing.T) {
+	opt := badgerhold.DefaultOptions
+	opt.InMemory = true
+	opt.Logger = emptyLogger{}
+	// every writer updates the same few index entries, so conflicts are expected and have to be retried
+	opt.ConflictRetries = 20
+	opt.ConflictBackoff = time.Millisecond
+
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	defer store.Close()
+
+	const workers = 8
+	const iterations = 30
+
+	var wg sync.WaitGroup
+	errs := make(chan error, workers*iterations)
+
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			category := fmt.Sprintf("category %d", w%4)
+
+			for i := 0; i < iterations; i++ {
+				key, err := store.NextSequence(&StressItem{})
+				if err != nil {
+					errs <- err
+					return
+				}
+
+				err = store.Insert(key, &StressItem{ID: key, Category: category, Value: i})
+				if err != nil {
+					errs <- fmt.Errorf("Error inserting: %s", err)
+					return
+				}
+
+				var result []StressItem
+				err = store.Find(&result, badgerhold.Where("Category").Eq(category).Index("Category"))
+				if err != nil {
+					errs <- fmt.Errorf("Error finding: %s", err)
+					return
+				}
+
+				for k := range result {
+					if result[k].Category != category {
+						errs <- fmt.Errorf("Found %v in the index for %s", result[k], category)
+						return
+					}
+				}
+
+				switch i % 3 {
+				case 0:
+					err = store.Delete(key, &StressItem{})
+				case 1:
+					err = store.UpdateMatching(&StressItem{}, badgerhold.Where("ID").Eq(key),
+						func(record interface{}) error {
+							record.(*StressItem).Value++
+							return nil
+						})
+				default:
+					_, err = store.FindAggregate(&StressItem{}, nil, "Category")
+				}
+				if err != nil {
+					errs <- fmt.Errorf("Error on mixed operation: %s", err)
+					return
+				}
+
+				if i == iterations/2 {
+					store.OnChange(func(ev badgerhold.ChangeEvent) {})
+				}
+			}
+		}(w)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	// every record has to still be reachable through its index
+	var all []StressItem
+	err = store.Find(&all, nil)
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+
+	indexed := 0
+	for c := 0; c < 4; c++ {
+		var result []StressItem
+		err = store.Find(&result, badgerhold.Where("Category").Eq(fmt.Sprintf("category %d", c)).Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		indexed += len(result)
+	}
+
+	expected := workers * (iterations - (iterations+2)/3)
+	if len(all) != expected || indexed != expected {
+		t.Fatalf("Found %d records and %d through the index, wanted %d", len(all), indexed, expected)
+	}
+}
+
+type SequenceStressItem struct {
+	ID       uint64 `badgerhold:"key"`
+	Category string `badgerhold:"index"`
+}
+
+func TestConcurrentSequenceInsert(t *testing.T) {
+	opt := badgerhold.DefaultOptions
+	opt.InMemory = true
+	opt.Logger = emptyLogger{}
+
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	defer store.Close()
+
+	const inserts = 200
+
+	var wg sync.WaitGroup
+	errs := make(chan error, inserts)
+
+	for i := 0; i < inserts; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			// every insert adds to the same index value, which only writes each record's own index key, so the
+			// inserts don't conflict
+			item := &SequenceStressItem{Category: "category"}
+			err := store.Insert(badgerhold.NextSequence(), item)
+			if err != nil {
+				errs <- fmt.Errorf("Error inserting: %s", err)
+				return
+			}
+
+			var result SequenceStressItem
+			err = store.Get(item.ID, &result)
+			if err != nil {
+				errs <- fmt.Errorf("Error getting the inserted key %d: %s", item.ID, err)
+				return
+			}
+			if result.Category != item.Category {
+				errs <- fmt.Errorf("Got the record %v under the key %d", result, item.ID)
+			}
+		}()
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Fatal(err)
+	}
+}
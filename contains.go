@@ -0,0 +1,138 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// Contains tests if the current slice or array field has an element equal to the passed in value
+// 	Where("Tags").Contains("go")
+func (c *Criterion) Contains(value interface{}) *Query {
+	return c.op(ct, value)
+}
+
+// ContainsAny tests if the current slice or array field has an element equal to any of the passed in values
+func (c *Criterion) ContainsAny(values ...interface{}) *Query {
+	c.operator = ca
+	c.inValues = values
+
+	q := c.query
+	q.fieldCriteria[q.currentField] = append(q.fieldCriteria[q.currentField], c)
+
+	return q
+}
+
+// testContains tests the Contains or ContainsAny criterion against the elements of the field, or against a single
+// element read from an element index
+func (c *Criterion) testContains(value interface{}, element bool, currentRow interface{}) (bool, error) {
+	values := c.inValues
+	if c.operator == ct {
+		values = []interface{}{c.value}
+	}
+
+	matches := func(elem interface{}) (bool, error) {
+		for i := range values {
+			result, err := c.compare(elem, values[i], currentRow)
+			if err != nil {
+				return false, err
+			}
+			if result == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	if element {
+		return matches(value)
+	}
+
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return false, fmt.Errorf("Contains can only be used on slice and array fields, not %T", value)
+	}
+
+	for i := 0; i < v.Len(); i++ {
+		ok, err := matches(v.Index(i).Interface())
+		if err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// elementKind returns whether each element of an indexed field of the type is indexed separately, which is the case
+// for every slice other than []byte
+func elementKind(tp reflect.Type) bool {
+	return tp.Kind() == reflect.Slice && tp.Elem().Kind() != reflect.Uint8
+}
+
+// elementOperators returns the operators of the criteria that can be tested against the entries of the index, if it's
+// the struct tag index of a slice field of tp, with an entry for each of the field's elements, of a full text field,
+// with an entry for each of its terms, of a geo field, of a map field, with an entry for each key and value, or of a
+// hashed field, whose entries only tell equal values apart.  It returns nil for any other index
+func elementOperators(tp reflect.Type, indexName string) []int {
+	if indexName == "" {
+		return nil
+	}
+
+	field, ok := tagIndexField(tp, indexName)
+	switch {
+	case !ok:
+		return nil
+	case isFullText(field):
+		return []int{mt}
+	case isGeo(field):
+		return []int{geo}
+	case isHashed(field):
+		return []int{eq, in}
+	case elementKind(field.Type):
+		return []int{ct, ca}
+	case field.Type.Kind() == reflect.Map:
+		return []int{hk}
+	}
+	return nil
+}
+
+// elementValues returns the elements of the slice field encoded with encode, the values it's indexed under
+func elementValues(fVal reflect.Value, encode EncodeFunc) ([][]byte, error) {
+	values := make([][]byte, 0, fVal.Len())
+	for i := 0; i < fVal.Len(); i++ {
+		elem := fVal.Index(i)
+		if (elem.Kind() == reflect.Ptr || elem.Kind() == reflect.Interface) && elem.IsNil() {
+			// nil values can't be encoded, and aren't indexed
+			continue
+		}
+
+		encoded, err := indexEncode(elem.Interface(), encode)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encoded)
+	}
+
+	return values, nil
+}
+
+// elementCriteria returns the first of the criteria with one of the operators, the only ones that can be tested
+// against the entries of an element or full text index.  The rest are tested against the records
+func elementCriteria(criteria []*Criterion, operators []int) []*Criterion {
+	for _, c := range criteria {
+		if c.mapped || c.json != nil {
+			continue
+		}
+		if _, ok := c.value.(Field); ok {
+			continue
+		}
+		for _, operator := range operators {
+			if c.operator == operator {
+				return []*Criterion{c}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,129 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+// BadgerHoldCoverTag is the struct tag used to list the fields stored along with the entries of an indexed field, so
+// queries that Select only those fields can be answered from the index alone, `badgerholdCover:"Name, Email"`
+const BadgerHoldCoverTag = "badgerholdCover"
+
+// Select limits the records returned by Find and FindOne to the passed in fields, along with the key field, leaving
+// the rest of their fields as zero values.  If the query's index covers every selected field, and every criterion is
+// tested against the index, the records are read from the index entries instead of being retrieved one by one
+// 	Where("Category").Eq("food").Index("Category").Select("Name")
+func (q *Query) Select(fields ...string) *Query {
+	q.selected = fields
+	return q
+}
+
+// coverTag returns the fields of tp the field's cover tag lists, or nil if it doesn't have one
+// panics if any of them can't be covered
+func coverTag(tp reflect.Type, field reflect.StructField) []string {
+	tag := field.Tag.Get(BadgerHoldCoverTag)
+	if tag == "" {
+		return nil
+	}
+
+	covers := strings.Split(tag, compositeIndexSeparator)
+	for i := range covers {
+		covers[i] = strings.TrimSpace(covers[i])
+		coveredField(tp, covers[i])
+	}
+	return covers
+}
+
+// coveredField returns the field of tp an index covers
+// panics if it isn't one of tp's own fields, or its value isn't stored
+func coveredField(tp reflect.Type, name string) reflect.StructField {
+	field, ok := tp.FieldByName(name)
+	if !ok || len(field.Index) != 1 {
+		panic("Invalid Type for Storer.  The covered field " + name + " isn't a field of " + tp.Name())
+	}
+	if isSkipped(field) || isEncrypted(field) {
+		panic("Invalid Type for Storer.  The field " + name + " is skipped or encrypted, so it can't be covered")
+	}
+	return field
+}
+
+// coverValue returns the copy of the record with only the fields an index covers, encoded with encode, which is
+// stored with each of its entries, or nil if it doesn't cover any
+func (i Index) coverValue(value interface{}, encode EncodeFunc) ([]byte, error) {
+	if len(i.Covers) == 0 {
+		return nil, nil
+	}
+
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil, fmt.Errorf("The index covers fields of %T, which isn't a struct", value)
+	}
+
+	covered := reflect.New(v.Type())
+	for _, name := range i.Covers {
+		field := coveredField(v.Type(), name)
+		covered.Elem().Field(field.Index[0]).Set(v.Field(field.Index[0]))
+	}
+
+	// the covered fields are never encrypted, so the copy doesn't need the store's EncryptionKey
+	return binaryEncoder(encode)(covered.Interface())
+}
+
+// coveredBy returns whether the query's records can be read from the entries of its index, which happens when it
+// selects only fields the index covers, and the index handles all of its criteria
+func (q *Query) coveredBy(tp reflect.Type, index Index) bool {
+	if len(q.selected) == 0 || len(index.Covers) == 0 || q.index == "" || !q.keysOnly(tp) {
+		return false
+	}
+
+	for _, name := range q.selected {
+		found := false
+		for _, covered := range index.Covers {
+			if name == covered {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// selectFields returns a copy of the record with only the fields the query selects
+func (q *Query) selectFields(value reflect.Value) (reflect.Value, error) {
+	selected := reflect.New(value.Type().Elem())
+	for _, name := range q.selected {
+		field, ok := value.Type().Elem().FieldByName(name)
+		if !ok || len(field.Index) != 1 {
+			return reflect.Value{}, fmt.Errorf("The field %s does not exist in the type %s", name,
+				value.Type().Elem())
+		}
+		selected.Elem().Field(field.Index[0]).Set(value.Elem().Field(field.Index[0]))
+	}
+	return selected, nil
+}
+
+// coveredValue returns the covered fields stored with the index entry at the cursor, or nil if they weren't stored
+// with it, or the cursor is over preloaded keys, so the record has to be retrieved instead
+func coveredValue(cursor keyCursor) ([]byte, error) {
+	c, ok := cursor.(iteratorCursor)
+	if !ok {
+		return nil, nil
+	}
+
+	value, err := c.Item().ValueCopy(nil)
+	if err != nil || len(value) == 0 {
+		return nil, err
+	}
+	return value, nil
+}
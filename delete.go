@@ -13,58 +13,174 @@ import (
 // Delete deletes a record from the bolthold, datatype just needs to be an example of the type stored so that
 // the proper bucket and indexes are updated
 func (s *Store) Delete(key, dataType interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxDelete(tx, key, dataType)
 	})
 }
 
 // TxDelete is the same as Delete except it allows you specify your own transaction
 func (s *Store) TxDelete(tx *badger.Txn, key, dataType interface{}) error {
-	storer := newStorer(dataType)
-	gk, err := encodeKey(key, storer.Type())
+	_, err := s.deleteIf(tx, key, dataType, nil)
+	return err
+}
 
-	if err != nil {
+// DeleteIf deletes a record only if the predicate returns true for the record as it's currently stored, such as
+// when it still has the version it was last read with.  The record is read, tested and deleted in one transaction,
+// so it can't be changed in between.  Whether or not the record was deleted is returned, and ErrNotFound if there
+// is no record with the key
+func (s *Store) DeleteIf(key, dataType interface{}, predicate func(current interface{}) bool) (bool, error) {
+	var deleted bool
+	err := s.update(func(tx *badger.Txn) error {
+		var err error
+		deleted, err = s.TxDeleteIf(tx, key, dataType, predicate)
 		return err
+	})
+	return deleted, err
+}
+
+// TxDeleteIf is the same as DeleteIf except it allows you specify your own transaction
+func (s *Store) TxDeleteIf(tx *badger.Txn, key, dataType interface{},
+	predicate func(current interface{}) bool) (bool, error) {
+	if predicate == nil {
+		panic("DeleteIf predicate cannot be nil")
+	}
+	return s.deleteIf(tx, key, dataType, predicate)
+}
+
+// deleteIf deletes the record if predicate is nil or returns true for it
+func (s *Store) deleteIf(tx *badger.Txn, key, dataType interface{},
+	predicate func(current interface{}) bool) (bool, error) {
+	storer := s.storer(dataType)
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
+
+	if err != nil {
+		return false, err
 	}
 
 	value := reflect.New(reflect.TypeOf(dataType)).Interface()
 
 	item, err := tx.Get(gk)
 	if err == badger.ErrKeyNotFound {
-		return ErrNotFound
+		return false, ErrNotFound
 	}
 	if err != nil {
-		return err
+		return false, err
 	}
 
-	item.Value(func(bVal []byte) error {
-		return decode(bVal, value)
+	err = item.Value(func(bVal []byte) error {
+		return s.decode(bVal, value)
 	})
 	if err != nil {
-		return err
+		return false, err
+	}
+
+	if predicate != nil && !predicate(reflect.ValueOf(value).Elem().Interface()) {
+		return false, nil
 	}
 
 	// delete data
 	err = tx.Delete(gk)
 
 	if err != nil {
-		return err
+		return false, err
 	}
 
+	s.recordWritten(tx, gk)
+
 	// remove any indexes
-	return indexDelete(storer, tx, gk, value)
+	err = s.indexDelete(storer, tx, gk, reflect.ValueOf(value).Elem().Interface())
+	if err != nil {
+		return false, err
+	}
+
+	s.recordChange(tx, ChangeEvent{
+		Type:      storer.Type(),
+		Key:       gk,
+		Operation: ChangeDelete,
+		Previous:  reflect.ValueOf(value).Elem().Interface(),
+	})
+
+	return true, nil
 }
 
 // DeleteMatching deletes all of the records that match the passed in query
+// If more records are deleted than the store's GCDeleteThreshold, the value log garbage collection is run afterwards
 func (s *Store) DeleteMatching(dataType interface{}, query *Query) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
-		return s.TxDeleteMatching(tx, dataType, query)
+	var deleted int
+	err := s.update(func(tx *badger.Txn) error {
+		var err error
+		deleted, err = s.deleteQuery(tx, dataType, query)
+		return err
 	})
+	if err != nil {
+		return err
+	}
+
+	if s.gcDeleteThreshold > 0 && deleted >= s.gcDeleteThreshold {
+		s.collectGarbage()
+	}
+
+	return nil
 }
 
 // TxDeleteMatching does the same as DeleteMatching, but allows you to specify your own transaction
 func (s *Store) TxDeleteMatching(tx *badger.Txn, dataType interface{}, query *Query) error {
-	return deleteQuery(tx, dataType, query)
+	_, err := s.deleteQuery(tx, dataType, query)
+	return err
+}
+
+// Clear deletes every record of dataType along with its indexes, in batches, and resets the sequence used when
+// inserting it with badgerhold.NextSequence().  It's much faster than DeleteMatching, as the records aren't read, but
+// no change events are sent for them, and it shouldn't run alongside other writes to the type.  The number of records
+// deleted is returned.  Like DeleteMatching, the value log garbage collection is run afterwards if more records are
+// deleted than the store's GCDeleteThreshold
+func (s *Store) Clear(dataType interface{}) (int, error) {
+	typeName := s.storer(dataType).Type()
+
+	deleted, err := s.deletePrefix(typePrefix(typeName))
+	s.purgeCaches()
+	if err != nil {
+		return deleted, err
+	}
+
+	_, err = s.deletePrefix(typeIndexPrefix(typeName))
+	if err != nil {
+		return deleted, err
+	}
+
+	err = s.resetSequence(typeName)
+	if err != nil {
+		return deleted, err
+	}
+
+	if s.gcDeleteThreshold > 0 && deleted >= s.gcDeleteThreshold {
+		s.collectGarbage()
+	}
+
+	return deleted, nil
+}
+
+// RunValueLogGC runs badger's value log garbage collection, which rewrites a value log file if at least
+// discardRatio of it can be discarded, reclaiming the space of deleted and overwritten records.
+// badger.ErrNoRewrite is returned if there was nothing to rewrite
+func (s *Store) RunValueLogGC(discardRatio float64) error {
+	return s.Badger().RunValueLogGC(discardRatio)
+}
+
+// collectGarbage runs the value log garbage collection until there is nothing left to rewrite
+func (s *Store) collectGarbage() {
+	for {
+		err := s.RunValueLogGC(s.gcDiscardRatio)
+		if err == badger.ErrNoRewrite {
+			return
+		}
+		if err != nil {
+			if s.logger != nil {
+				s.logger.Warningf("Error running value log GC after deleting records: %s", err)
+			}
+			return
+		}
+	}
 }
 
 // DeleteMatching deletes all of the records that match the passed in query
@@ -76,5 +192,5 @@ func (s *Store) DeleteMatchingPRS(dataType interface{}, query *Query, kuncian st
 
 // TxDeleteMatching does the same as DeleteMatching, but allows you to specify your own transaction
 func (s *Store) TxDeleteMatchingPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian string) error {
-	return deleteQueryPRS(tx, dataType, query, kuncian)
+	return s.deleteQueryPRS(tx, dataType, query, kuncian)
 }
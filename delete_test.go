@@ -168,3 +168,214 @@ func TestDeleteNotFound(t *testing.T) {
 
 	})
 }
+
+type BulkDeleteItem struct {
+	ID    int
+	Group string `badgerhold:"index"`
+}
+
+func TestDeleteMatchingBulk(t *testing.T) {
+	opt := badgerhold.DefaultOptions
+	opt.InMemory = true
+	opt.Logger = emptyLogger{}
+	opt.GCDeleteThreshold = 100
+
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening store: %s", err)
+	}
+	defer store.Close()
+
+	for i := 0; i < 500; i++ {
+		group := "keep"
+		if i%5 != 0 {
+			group = "delete"
+		}
+		err = store.Insert(i, &BulkDeleteItem{ID: i, Group: group})
+		if err != nil {
+			t.Fatalf("Error inserting data for bulk delete test: %s", err)
+		}
+	}
+
+	err = store.DeleteMatching(&BulkDeleteItem{}, badgerhold.Where("Group").Eq("delete").Index("Group"))
+	if err != nil {
+		t.Fatalf("Error bulk deleting data from badgerhold: %s", err)
+	}
+
+	var result []BulkDeleteItem
+	err = store.Find(&result, badgerhold.Where("Group").Eq("delete").Index("Group"))
+	if err != nil {
+		t.Fatalf("Error finding deleted records: %s", err)
+	}
+	if len(result) != 0 {
+		t.Fatalf("Found %d records through the index after they were deleted", len(result))
+	}
+
+	// the index entry for the deleted group should be removed entirely, and the remaining entry should only
+	// hold the kept records
+	entries, err := store.DumpIndex(&BulkDeleteItem{}, "Group")
+	if err != nil {
+		t.Fatalf("Error reading index entries: %s", err)
+	}
+	if len(entries) != 1 {
+		t.Fatalf("Found %d index entries wanted %d", len(entries), 1)
+	}
+	if len(entries[0].Keys) != 100 {
+		t.Fatalf("Index entry %s holds %d keys wanted %d", entries[0].Encoded, len(entries[0].Keys), 100)
+	}
+
+	// along with the keys of the deleted records
+	prefix := []byte("_bhIdx:BulkDeleteItem:Group:")
+	err = store.Badger().View(func(tx *badger.Txn) error {
+		it := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		keys := 0
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			keys++
+		}
+
+		if keys != 100 {
+			t.Fatalf("Found %d index keys wanted the kept entry's %d records", keys, 100)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading index entries: %s", err)
+	}
+
+	err = store.RunValueLogGC(0.5)
+	if err != nil && err != badger.ErrNoRewrite {
+		t.Fatalf("Error running value log GC: %s", err)
+	}
+}
+
+func TestDeleteIf(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		key := "testKey"
+		err := store.Insert(key, &ItemTest{
+			Name:     "Test Name",
+			Category: "vehicle",
+			Created:  time.Now(),
+		})
+		if err != nil {
+			t.Fatalf("Error inserting data for delete test: %s", err)
+		}
+
+		deleted, err := store.DeleteIf(key, &ItemTest{}, func(current interface{}) bool {
+			return current.(*ItemTest).Name == "Changed Name"
+		})
+		if err != nil {
+			t.Fatalf("Error deleting data from badgerhold: %s", err)
+		}
+		if deleted {
+			t.Fatalf("DeleteIf deleted a record the predicate didn't match")
+		}
+
+		result := &ItemTest{}
+		err = store.Get(key, result)
+		if err != nil {
+			t.Fatalf("Record was deleted when the predicate didn't match: %s", err)
+		}
+
+		deleted, err = store.DeleteIf(key, &ItemTest{}, func(current interface{}) bool {
+			return current.(*ItemTest).Name == "Test Name"
+		})
+		if err != nil {
+			t.Fatalf("Error deleting data from badgerhold: %s", err)
+		}
+		if !deleted {
+			t.Fatalf("DeleteIf didn't delete a record the predicate matched")
+		}
+
+		err = store.Get(key, result)
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Data was not deleted from badgerhold")
+		}
+
+		var indexed []ItemTest
+		err = store.Find(&indexed, badgerhold.Where("Category").Eq("vehicle").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(indexed) != 0 {
+			t.Fatalf("DeleteIf didn't remove the record from its indexes")
+		}
+
+		deleted, err = store.DeleteIf(key, &ItemTest{}, func(current interface{}) bool {
+			return true
+		})
+		if err != badgerhold.ErrNotFound || deleted {
+			t.Fatalf("DeleteIf of a missing record returned %t, %v instead of ErrNotFound", deleted, err)
+		}
+	})
+}
+
+func TestClear(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		type ClearSequence struct {
+			Key uint64 `badgerholdKey:"Key"`
+		}
+
+		for i := 0; i < 5; i++ {
+			err := store.Insert(badgerhold.NextSequence(), &ClearSequence{})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		deleted, err := store.Clear(&ItemTest{})
+		if err != nil {
+			t.Fatalf("Error clearing data: %s", err)
+		}
+		if deleted != len(testData) {
+			t.Fatalf("Clear deleted %d records wanted %d", deleted, len(testData))
+		}
+
+		var result []ItemTest
+		err = store.Find(&result, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 0 {
+			t.Fatalf("Found %d records after Clear", len(result))
+		}
+
+		entries, err := store.DumpIndex(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		if len(entries) != 0 {
+			t.Fatalf("Index has %d entries after Clear", len(entries))
+		}
+
+		// other types are left alone
+		var others []ClearSequence
+		err = store.Find(&others, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(others) != 5 {
+			t.Fatalf("Clear deleted the records of another type, %d are left", len(others))
+		}
+
+		deleted, err = store.Clear(&ClearSequence{})
+		if err != nil {
+			t.Fatalf("Error clearing data: %s", err)
+		}
+		if deleted != 5 {
+			t.Fatalf("Clear deleted %d records wanted 5", deleted)
+		}
+
+		seq := &ClearSequence{}
+		err = store.Insert(badgerhold.NextSequence(), seq)
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		if seq.Key != 0 {
+			t.Fatalf("The sequence wasn't reset, the next key is %d", seq.Key)
+		}
+	})
+}
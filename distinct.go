@@ -0,0 +1,159 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"hash/fnv"
+	"math"
+	"math/bits"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// hllPrecision is the number of hash bits used to pick a HyperLogLog register, 2^14 registers have a standard error
+// of 1.04 / sqrt(2^14), about 0.81%
+const hllPrecision = 14
+
+// CountDistinct returns the number of distinct values of the field in the records of dataType that match the query.
+// Nil values aren't counted.  If approximate is true, the values are counted with a HyperLogLog estimate that uses
+// 16KB of memory no matter how many distinct values there are, and is usually within 2% of the exact count, with a
+// standard error of about 0.81%.  Otherwise every distinct value is kept in memory until the count is done
+func (s *Store) CountDistinct(dataType interface{}, field string, query *Query, approximate bool) (int, error) {
+	var count int
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		var err error
+		count, err = s.TxCountDistinct(tx, dataType, field, query, approximate)
+		return err
+	})
+	return count, err
+}
+
+// TxCountDistinct is the same as CountDistinct, but you specify your own transaction
+func (s *Store) TxCountDistinct(tx *badger.Txn, dataType interface{}, field string, query *Query,
+	approximate bool) (int, error) {
+	return countDistinct(tx, dataType, field, s.preloaded(query), approximate)
+}
+
+func countDistinct(tx *badger.Txn, dataType interface{}, field string, query *Query, approximate bool) (int, error) {
+	if query == nil {
+		query = &Query{}
+	}
+	query.begin()
+	defer query.end()
+
+	query.writable = false
+
+	var counter distinctCounter = make(distinctSet)
+	if approximate {
+		counter = newHyperLogLog(hllPrecision)
+	}
+
+	err := runQuery(tx, dataType, query, nil, query.skip,
+		func(r *record) error {
+			fVal, err := fieldValue(r.value, field, query.store.fieldNameTag)
+			if err != nil {
+				return err
+			}
+			if isNillable(fVal.Kind()) && fVal.IsNil() {
+				return nil
+			}
+
+			// the index encoding is used, as it's the same for equal numbers, strings and times
+			encoded, err := indexEncode(fVal.Interface(), query.store.encodeValue)
+			if err != nil {
+				return err
+			}
+			counter.add(encoded)
+			return nil
+		})
+	if err != nil {
+		return 0, err
+	}
+
+	return counter.count(), query.skippedError()
+}
+
+type distinctCounter interface {
+	add(value []byte)
+	count() int
+}
+
+// distinctSet counts distinct values exactly
+type distinctSet map[string]struct{}
+
+func (d distinctSet) add(value []byte) {
+	d[string(value)] = struct{}{}
+}
+
+func (d distinctSet) count() int {
+	return len(d)
+}
+
+// hyperLogLog estimates the number of distinct values from the longest run of leading zeros in their hashes
+type hyperLogLog struct {
+	precision uint
+	registers []uint8
+}
+
+func newHyperLogLog(precision uint) *hyperLogLog {
+	return &hyperLogLog{
+		precision: precision,
+		registers: make([]uint8, 1<<precision),
+	}
+}
+
+func (h *hyperLogLog) add(value []byte) {
+	hash := fnv.New64a()
+	hash.Write(value)
+	x := mix64(hash.Sum64())
+
+	register := x >> (64 - h.precision)
+	// the guard bit caps the run of zeros when the rest of the hash is empty
+	rank := uint8(bits.LeadingZeros64(x<<h.precision|1<<(h.precision-1))) + 1
+	if rank > h.registers[register] {
+		h.registers[register] = rank
+	}
+}
+
+func (h *hyperLogLog) count() int {
+	m := float64(len(h.registers))
+
+	sum := 0.0
+	zeros := 0
+	for _, r := range h.registers {
+		sum += math.Ldexp(1, -int(r))
+		if r == 0 {
+			zeros++
+		}
+	}
+
+	estimate := 0.7213 / (1 + 1.079/m) * m * m / sum
+	if estimate <= 2.5*m && zeros != 0 {
+		// small counts are more accurately estimated from the number of empty registers
+		estimate = m * math.Log(m/float64(zeros))
+	}
+
+	return int(estimate + 0.5)
+}
+
+// mix64 spreads the bits of an FNV hash, whose high bits barely change between values that only differ in their
+// last bytes, such as consecutive integers
+func mix64(x uint64) uint64 {
+	x ^= x >> 33
+	x *= 0xff51afd7ed558ccd
+	x ^= x >> 33
+	x *= 0xc4ceb9fe1a85ec53
+	x ^= x >> 33
+	return x
+}
+
+func isNillable(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Ptr, reflect.Map, reflect.Slice, reflect.Interface:
+		return true
+	}
+	return false
+}
@@ -6,7 +6,13 @@ package badgerhold
 
 import (
 	"bytes"
+	"crypto/cipher"
+	"encoding"
 	"encoding/gob"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
 )
 
 // EncodeFunc is a function for encoding a value into bytes
@@ -15,8 +21,18 @@ type EncodeFunc func(value interface{}) ([]byte, error)
 // DecodeFunc is a function for decoding a value from bytes
 type DecodeFunc func(data []byte, value interface{}) error
 
-var encode EncodeFunc
-var decode DecodeFunc
+// KeyEncoder is the interface to implement on a key type to control how it is encoded into the badger key.
+// Badger stores keys in byte order, so a KeyEncoder allows you to define the order in which records are
+// iterated when no index is used.  Key types that don't implement KeyEncoder use the store's default encoding
+type KeyEncoder interface {
+	EncodeKey() ([]byte, error)
+}
+
+// KeyDecoder is the interface to implement on a pointer to a key type to decode a key previously encoded with
+// KeyEncoder.  Any type that implements KeyEncoder must also implement KeyDecoder
+type KeyDecoder interface {
+	DecodeKey(data []byte) error
+}
 
 // DefaultEncode is the default encoding func for badgerhold (Gob)
 func DefaultEncode(value interface{}) ([]byte, error) {
@@ -26,7 +42,7 @@ func DefaultEncode(value interface{}) ([]byte, error) {
 
 	err := en.Encode(value)
 	if err != nil {
-		return nil, err
+		return nil, gobError(err)
 	}
 
 	return buff.Bytes(), nil
@@ -42,13 +58,205 @@ func DefaultDecode(data []byte, value interface{}) error {
 		return err
 	}
 
-	return de.Decode(value)
+	return gobError(de.Decode(value))
+}
+
+// gobError points gob's errors about unregistered interface values at Options.RegisterTypes
+func gobError(err error) error {
+	if err != nil && strings.Contains(err.Error(), "not registered for interface") {
+		return fmt.Errorf("%w, the types stored in interface fields need to be passed to Options.RegisterTypes", err)
+	}
+	return err
+}
+
+// registerTypes registers the RegisterTypes with gob, so values of them can be stored in interface fields.  It does nothing
+// if the store doesn't use the default gob encoding
+func registerTypes(options Options) {
+	if !isFunc(options.Encoder, DefaultEncode) && !isFunc(options.Decoder, DefaultDecode) {
+		return
+	}
+
+	for i := range options.RegisterTypes {
+		gob.Register(options.RegisterTypes[i])
+	}
+}
+
+// isFunc returns whether fn is the function other
+func isFunc(fn, other interface{}) bool {
+	v := reflect.ValueOf(fn)
+	return v.Kind() == reflect.Func && !v.IsNil() && v.Pointer() == reflect.ValueOf(other).Pointer()
+}
+
+// codecFields are the index paths of the fields of a struct type that are changed when it's encoded
+type codecFields struct {
+	skipped   [][]int
+	encrypted [][]int
+}
+
+// fieldsCache caches the codecFields by struct type
+var fieldsCache sync.Map
+
+// taggedFields returns the exported fields in tp, including those in embedded structs, that are tagged
+// `badgerhold:"-"` to keep them out of storage, or `badgerhold:"encrypt"` to encrypt them
+func taggedFields(tp reflect.Type) *codecFields {
+	if fields, ok := fieldsCache.Load(tp); ok {
+		return fields.(*codecFields)
+	}
+
+	fields := &codecFields{}
+	var walk func(current reflect.Type, path []int)
+	walk = func(current reflect.Type, path []int) {
+		for i := 0; i < current.NumField(); i++ {
+			field := current.Field(i)
+			fieldPath := append(append([]int{}, path...), i)
+			if isSkipped(field) {
+				if field.PkgPath == "" {
+					fields.skipped = append(fields.skipped, fieldPath)
+				}
+				continue
+			}
+			if isEncrypted(field) {
+				if field.PkgPath == "" {
+					fields.encrypted = append(fields.encrypted, fieldPath)
+				}
+				continue
+			}
+			if field.Anonymous && field.Type.Kind() == reflect.Struct {
+				walk(field.Type, fieldPath)
+			}
+		}
+	}
+	walk(tp, nil)
+
+	fieldsCache.Store(tp, fields)
+	return fields
+}
+
+func isSkipped(field reflect.StructField) bool {
+	return field.Tag.Get(badgerholdPrefixTag) == badgerholdPrefixSkipValue
+}
+
+// zeroSkipped sets the skipped fields of the struct value to their zero values
+func zeroSkipped(value reflect.Value, paths [][]int) {
+	for i := range paths {
+		field := value.FieldByIndex(paths[i])
+		if field.CanSet() {
+			field.Set(reflect.Zero(field.Type()))
+		}
+	}
+}
+
+// fieldEncoder wraps encoder so that the skipped fields of structs aren't encoded, and their encrypted fields are
+// encrypted with fieldCipher.  The struct is copied with the fields changed rather than changing the caller's value
+func fieldEncoder(encoder EncodeFunc, fieldCipher cipher.AEAD) EncodeFunc {
+	return func(value interface{}) ([]byte, error) {
+		v := reflect.ValueOf(value)
+		ptr := v.Kind() == reflect.Ptr
+		if ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if v.Kind() != reflect.Struct {
+			return encoder(value)
+		}
+
+		fields := taggedFields(v.Type())
+		if len(fields.skipped) == 0 && len(fields.encrypted) == 0 {
+			return encoder(value)
+		}
+
+		stored := reflect.New(v.Type()).Elem()
+		stored.Set(v)
+		zeroSkipped(stored, fields.skipped)
+		err := encryptFields(stored, fields.encrypted, fieldCipher)
+		if err != nil {
+			return nil, err
+		}
+
+		if ptr {
+			return encoder(stored.Addr().Interface())
+		}
+		return encoder(stored.Interface())
+	}
+}
+
+// fieldDecoder wraps decoder so that the skipped fields of structs are zeroed after decoding, rather than keeping
+// whatever the value they're decoded into already held, and their encrypted fields are decrypted with fieldCipher
+func fieldDecoder(decoder DecodeFunc, fieldCipher cipher.AEAD) DecodeFunc {
+	return func(data []byte, value interface{}) error {
+		err := decoder(data, value)
+		if err != nil {
+			return err
+		}
+
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Ptr || v.IsNil() || v.Elem().Kind() != reflect.Struct {
+			return nil
+		}
+
+		fields := taggedFields(v.Elem().Type())
+		zeroSkipped(v.Elem(), fields.skipped)
+		return decryptFields(v.Elem(), fields.encrypted, fieldCipher)
+	}
+}
+
+var (
+	binaryMarshalerType   = reflect.TypeOf((*encoding.BinaryMarshaler)(nil)).Elem()
+	binaryUnmarshalerType = reflect.TypeOf((*encoding.BinaryUnmarshaler)(nil)).Elem()
+)
+
+// binaryCodec returns whether the struct type implements encoding.BinaryMarshaler, on either the type or a pointer
+// to it, and encoding.BinaryUnmarshaler on a pointer to it
+func binaryCodec(tp reflect.Type) bool {
+	return tp.Kind() == reflect.Struct && reflect.PtrTo(tp).Implements(binaryUnmarshalerType) &&
+		(tp.Implements(binaryMarshalerType) || reflect.PtrTo(tp).Implements(binaryMarshalerType))
+}
+
+// binaryEncoder wraps encoder so that records whose type implements encoding.BinaryMarshaler encode themselves
+func binaryEncoder(encoder EncodeFunc) EncodeFunc {
+	return func(value interface{}) ([]byte, error) {
+		v := reflect.ValueOf(value)
+		for v.Kind() == reflect.Ptr && !v.IsNil() {
+			v = v.Elem()
+		}
+		if !v.IsValid() || !binaryCodec(v.Type()) {
+			return encoder(value)
+		}
+
+		if !v.CanAddr() {
+			ptr := reflect.New(v.Type())
+			ptr.Elem().Set(v)
+			v = ptr.Elem()
+		}
+		return v.Addr().Interface().(encoding.BinaryMarshaler).MarshalBinary()
+	}
+}
+
+// binaryDecoder wraps decoder so that records whose type implements encoding.BinaryUnmarshaler decode themselves
+func binaryDecoder(decoder DecodeFunc) DecodeFunc {
+	return func(data []byte, value interface{}) error {
+		v := reflect.ValueOf(value)
+		if v.Kind() != reflect.Ptr || v.IsNil() || !binaryCodec(v.Elem().Type()) {
+			return decoder(data, value)
+		}
+
+		return v.Interface().(encoding.BinaryUnmarshaler).UnmarshalBinary(data)
+	}
 }
 
 // encodeKey encodes key values with a type prefix which allows multiple different types
 // to exist in the badger DB
-func encodeKey(key interface{}, typeName string) ([]byte, error) {
-	encoded, err := encode(key)
+func encodeKey(key interface{}, typeName string, encode EncodeFunc) ([]byte, error) {
+	var encoded []byte
+	var err error
+
+	if ke, ok := key.(KeyEncoder); ok {
+		encoded, err = ke.EncodeKey()
+		if err == nil {
+			err = checkKeyDecodes(key, encoded)
+		}
+	} else {
+		encoded, err = encode(key)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -56,7 +264,55 @@ func encodeKey(key interface{}, typeName string) ([]byte, error) {
 	return append(typePrefix(typeName), encoded...), nil
 }
 
+// checkKeyDecodes returns an error if the key encoded by its KeyEncoder doesn't decode back to the same key with its
+// KeyDecoder, so a record isn't written under a key that can't be read back
+func checkKeyDecodes(key interface{}, encoded []byte) error {
+	want := reflect.Indirect(reflect.ValueOf(key))
+	decoded := reflect.New(want.Type())
+
+	kd, ok := decoded.Interface().(KeyDecoder)
+	if !ok {
+		return fmt.Errorf("The key type %T implements KeyEncoder but not KeyDecoder", key)
+	}
+
+	err := kd.DecodeKey(encoded)
+	if err != nil {
+		return fmt.Errorf("The key %v doesn't decode with DecodeKey: %w", key, err)
+	}
+	if !reflect.DeepEqual(decoded.Elem().Interface(), want.Interface()) {
+		return fmt.Errorf("The key %v decodes to %v with DecodeKey", key, decoded.Elem().Interface())
+	}
+	return nil
+}
+
+// keyFieldType returns the type of the struct's key field, tagged `badgerhold:"key"` or `badgerholdKey`, or nil if it
+// doesn't have one
+func keyFieldType(tp reflect.Type) reflect.Type {
+	if tp.Kind() != reflect.Struct {
+		return nil
+	}
+
+	for i := 0; i < tp.NumField(); i++ {
+		if strings.Contains(string(tp.Field(i).Tag), BadgerholdKeyTag) ||
+			tp.Field(i).Tag.Get(badgerholdPrefixTag) == badgerholdPrefixKeyValue {
+			return tp.Field(i).Type
+		}
+	}
+
+	return nil
+}
+
 // decodeKey decodes the key value and removes the type prefix
-func decodeKey(data []byte, key interface{}, typeName string) error {
-	return decode(data[len(typePrefix(typeName)):], key)
+func decodeKey(data []byte, key interface{}, typeName string, decode DecodeFunc) error {
+	data = data[len(typePrefix(typeName)):]
+
+	if kd, ok := key.(KeyDecoder); ok {
+		return kd.DecodeKey(data)
+	}
+
+	if _, ok := reflect.Indirect(reflect.ValueOf(key)).Interface().(KeyEncoder); ok {
+		return fmt.Errorf("The key type %T implements KeyEncoder but not KeyDecoder", key)
+	}
+
+	return decode(data, key)
 }
@@ -0,0 +1,693 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/timshannon/badgerhold"
+)
+
+// regionKey is a composite key that sorts by Region, then by the numeric value of ID
+type regionKey struct {
+	Region string
+	ID     uint32
+}
+
+func (k regionKey) EncodeKey() ([]byte, error) {
+	if bytes.IndexByte([]byte(k.Region), 0) != -1 {
+		return nil, errors.New("Region cannot contain a null byte")
+	}
+	buf := make([]byte, len(k.Region)+5)
+	copy(buf, k.Region)
+	binary.BigEndian.PutUint32(buf[len(k.Region)+1:], k.ID)
+	return buf, nil
+}
+
+func (k *regionKey) DecodeKey(data []byte) error {
+	i := bytes.IndexByte(data, 0)
+	if i == -1 || len(data) != i+5 {
+		return errors.New("Invalid regionKey")
+	}
+	k.Region = string(data[:i])
+	k.ID = binary.BigEndian.Uint32(data[i+1:])
+	return nil
+}
+
+type RegionItem struct {
+	Key  regionKey `badgerhold:"key"`
+	Name string
+}
+
+func TestKeyEncoder(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		keys := []regionKey{
+			{"west", 300},
+			{"east", 2},
+			{"west", 1},
+			{"east", 1000},
+		}
+
+		for i := range keys {
+			err := store.Insert(keys[i], &RegionItem{Name: keys[i].Region})
+			if err != nil {
+				t.Fatalf("Error inserting data for key encoder test: %s", err)
+			}
+		}
+
+		result := &RegionItem{}
+		err := store.Get(regionKey{"west", 300}, result)
+		if err != nil {
+			t.Fatalf("Error getting data from badgerhold: %s", err)
+		}
+		if result.Name != "west" {
+			t.Fatalf("Got %s wanted %s", result.Name, "west")
+		}
+
+		var found []RegionItem
+		err = store.Find(&found, nil)
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+
+		expected := []regionKey{
+			{"east", 2},
+			{"east", 1000},
+			{"west", 1},
+			{"west", 300},
+		}
+
+		if len(found) != len(expected) {
+			t.Fatalf("Find result count is %d wanted %d", len(found), len(expected))
+		}
+
+		for i := range expected {
+			if found[i].Key != expected[i] {
+				t.Fatalf("Result %d has key %v wanted %v", i, found[i].Key, expected[i])
+			}
+		}
+
+		found = nil
+		err = store.Find(&found, badgerhold.Where(badgerhold.Key).Eq(regionKey{"east", 1000}))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+
+		if len(found) != 1 || found[0].Key != (regionKey{"east", 1000}) {
+			t.Fatalf("Key criteria on a KeyEncoder key returned %v", found)
+		}
+
+		err = store.Insert(regionKey{"bad\x00region", 1}, &RegionItem{})
+		if err == nil {
+			t.Fatalf("Inserting with a key that failed to encode didn't return an error")
+		}
+	})
+}
+
+type encodeOnlyKey string
+
+func (k encodeOnlyKey) EncodeKey() ([]byte, error) {
+	return []byte(k), nil
+}
+
+type EncodeOnlyItem struct {
+	Key  encodeOnlyKey `badgerhold:"key"`
+	Name string
+}
+
+func TestKeyEncoderWithoutDecoder(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Insert(encodeOnlyKey("test"), &EncodeOnlyItem{Name: "test"})
+		if err == nil {
+			t.Fatalf("Inserting a key without a KeyDecoder didn't fail")
+		}
+
+		var result []EncodeOnlyItem
+		err = store.Find(&result, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 0 {
+			t.Fatalf("Found %d records stored under a key without a KeyDecoder", len(result))
+		}
+	})
+}
+
+// lossyKey drops everything after the first 4 bytes when it's encoded, so longer keys don't decode back to themselves
+type lossyKey string
+
+func (k lossyKey) EncodeKey() ([]byte, error) {
+	if len(k) > 4 {
+		k = k[:4]
+	}
+	return []byte(k), nil
+}
+
+func (k *lossyKey) DecodeKey(data []byte) error {
+	*k = lossyKey(data)
+	return nil
+}
+
+type LossyItem struct {
+	Key  lossyKey `badgerhold:"key"`
+	Name string
+}
+
+func TestKeyEncoderRoundTrip(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Insert(lossyKey("test"), &LossyItem{Name: "test"})
+		if err != nil {
+			t.Fatalf("Error inserting a key that decodes: %s", err)
+		}
+
+		err = store.Insert(lossyKey("testing"), &LossyItem{Name: "testing"})
+		if err == nil {
+			t.Fatalf("Inserting a key that doesn't decode back to itself didn't fail")
+		}
+
+		var result []LossyItem
+		err = store.Find(&result, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 1 || result[0].Name != "test" {
+			t.Fatalf("Found %v wanted only the record whose key decodes", result)
+		}
+	})
+}
+
+// timeKey is a big endian timestamp, so keys are stored in time order
+type timeKey uint64
+
+func (k timeKey) EncodeKey() ([]byte, error) {
+	buf := make([]byte, 8)
+	binary.BigEndian.PutUint64(buf, uint64(k))
+	return buf, nil
+}
+
+func (k *timeKey) DecodeKey(data []byte) error {
+	if len(data) != 8 {
+		return errors.New("Invalid timeKey")
+	}
+	*k = timeKey(binary.BigEndian.Uint64(data))
+	return nil
+}
+
+type Reading struct {
+	Time  timeKey `badgerhold:"key"`
+	Value int
+}
+
+func TestKeyRange(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for i := 0; i < 300; i++ {
+			err := store.Insert(timeKey(i*10), &Reading{Value: i % 2})
+			if err != nil {
+				t.Fatalf("Error inserting data for key range test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query *badgerhold.Query
+			first timeKey
+			count int
+		}{
+			{badgerhold.Where(badgerhold.Key).Ge(timeKey(200)).And(badgerhold.Key).Lt(timeKey(300)), 200, 10},
+			{badgerhold.Where(badgerhold.Key).Gt(timeKey(200)).And(badgerhold.Key).Le(timeKey(300)), 210, 10},
+			{badgerhold.Where(badgerhold.Key).Ge(timeKey(205)).And(badgerhold.Key).Lt(timeKey(305)), 210, 10},
+			{badgerhold.Where(badgerhold.Key).Eq(timeKey(1500)), 1500, 1},
+			{badgerhold.Where(badgerhold.Key).Eq(timeKey(1505)), 0, 0},
+			{badgerhold.Where(badgerhold.Key).Ge(timeKey(2900)), 2900, 10},
+			{badgerhold.Where(badgerhold.Key).Lt(timeKey(100)), 0, 10},
+			{badgerhold.Where(badgerhold.Key).Ge(timeKey(200)).And(badgerhold.Key).Lt(timeKey(300)).And("Value").Eq(1), 210, 5},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []Reading
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != tests[i].count {
+					t.Fatalf("Find result count is %d wanted %d.", len(result), tests[i].count)
+				}
+
+				for k := range result {
+					if k > 0 && result[k].Time <= result[k-1].Time {
+						t.Fatalf("Results aren't in key order: %v", result)
+					}
+				}
+
+				if len(result) > 0 && result[0].Time != tests[i].first {
+					t.Fatalf("First result has key %d wanted %d", result[0].Time, tests[i].first)
+				}
+			})
+		}
+	})
+}
+
+// versionKey is a KeyEncoder key with a Compare that agrees with the order of its encoding
+type versionKey struct {
+	Major uint16
+	Minor uint16
+}
+
+func (k versionKey) EncodeKey() ([]byte, error) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint16(buf, k.Major)
+	binary.BigEndian.PutUint16(buf[2:], k.Minor)
+	return buf, nil
+}
+
+func (k *versionKey) DecodeKey(data []byte) error {
+	if len(data) != 4 {
+		return errors.New("Invalid versionKey")
+	}
+	k.Major = binary.BigEndian.Uint16(data)
+	k.Minor = binary.BigEndian.Uint16(data[2:])
+	return nil
+}
+
+func (k versionKey) Compare(other interface{}) (int, error) {
+	o, ok := other.(versionKey)
+	if !ok {
+		return 0, &badgerhold.ErrTypeMismatch{Value: k, Other: other}
+	}
+	if k.Major != o.Major {
+		return int(k.Major) - int(o.Major), nil
+	}
+	return int(k.Minor) - int(o.Minor), nil
+}
+
+type Release struct {
+	Version versionKey `badgerhold:"key"`
+	Name    string
+}
+
+// descendingKey is a KeyEncoder key whose Compare orders it the opposite way to its encoding
+type descendingKey uint32
+
+func (k descendingKey) EncodeKey() ([]byte, error) {
+	buf := make([]byte, 4)
+	binary.BigEndian.PutUint32(buf, uint32(k))
+	return buf, nil
+}
+
+func (k *descendingKey) DecodeKey(data []byte) error {
+	if len(data) != 4 {
+		return errors.New("Invalid descendingKey")
+	}
+	*k = descendingKey(binary.BigEndian.Uint32(data))
+	return nil
+}
+
+func (k descendingKey) Compare(other interface{}) (int, error) {
+	o, ok := other.(descendingKey)
+	if !ok {
+		return 0, &badgerhold.ErrTypeMismatch{Value: k, Other: other}
+	}
+	return int(o) - int(k), nil
+}
+
+type Countdown struct {
+	Key   descendingKey `badgerhold:"key"`
+	Value int
+}
+
+func TestKeyComparer(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for major := uint16(1); major <= 3; major++ {
+			for minor := uint16(0); minor < 300; minor += 100 {
+				err := store.Insert(versionKey{major, minor}, &Release{Name: "release"})
+				if err != nil {
+					t.Fatalf("Error inserting release: %s", err)
+				}
+			}
+		}
+
+		var releases []Release
+		err := store.Find(&releases, badgerhold.Where(badgerhold.Key).Gt(versionKey{1, 200}).
+			And(badgerhold.Key).Le(versionKey{2, 100}))
+		if err != nil {
+			t.Fatalf("Error finding releases: %s", err)
+		}
+		if len(releases) != 2 || releases[0].Version != (versionKey{2, 0}) ||
+			releases[1].Version != (versionKey{2, 100}) {
+			t.Fatalf("Unexpected releases: %v", releases)
+		}
+
+		for i := 0; i < 10; i++ {
+			err := store.Insert(descendingKey(i), &Countdown{Value: i})
+			if err != nil {
+				t.Fatalf("Error inserting countdown: %s", err)
+			}
+		}
+
+		var countdowns []Countdown
+		err = store.Find(&countdowns, badgerhold.Where(badgerhold.Key).Gt(descendingKey(5)))
+		if err == nil {
+			t.Fatalf("A key Compare that disagrees with the key encoding didn't return an error")
+		}
+	})
+}
+
+type CachedItem struct {
+	Name  string
+	Cache []byte `badgerhold:"-"`
+}
+
+type SkippedIndexItem struct {
+	Name  string
+	Cache string `badgerhold:"-" badgerholdIndex:"Cache"`
+}
+
+func TestSkippedField(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		data := &CachedItem{Name: "test", Cache: []byte("derived data")}
+		err := store.Insert("testKey", data)
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		if string(data.Cache) != "derived data" {
+			t.Fatalf("Insert changed the skipped field of the passed in data to %s", data.Cache)
+		}
+
+		result := &CachedItem{Cache: []byte("previous")}
+		err = store.Get("testKey", result)
+		if err != nil {
+			t.Fatalf("Error getting data: %s", err)
+		}
+
+		if result.Name != "test" {
+			t.Fatalf("Got name %s wanted test", result.Name)
+		}
+		if result.Cache != nil {
+			t.Fatalf("Skipped field was decoded as %s wanted nil", result.Cache)
+		}
+
+		var found []CachedItem
+		err = store.Find(&found, badgerhold.Where("Name").Eq("test"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(found) != 1 || found[0].Cache != nil {
+			t.Fatalf("Find returned %v wanted one record without its skipped field", found)
+		}
+	})
+}
+
+func TestSkippedFieldIndexPanic(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Inserting a type with an indexed skipped field did not panic!")
+			}
+		}()
+
+		_ = store.Insert("testKey", &SkippedIndexItem{Name: "test", Cache: "value"})
+	})
+}
+
+type Patient struct {
+	Name  string
+	SSN   string `badgerhold:"encrypt"`
+	Notes []byte `badgerhold:"encrypt"`
+}
+
+type IndexedPatient struct {
+	SSN string `badgerhold:"encrypt" badgerholdIndex:"SSN"`
+}
+
+func TestEncryptedFields(t *testing.T) {
+	opt := testOptions()
+	opt.EncryptionKey = []byte("0123456789abcdef0123456789abcdef")
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+
+	data := &Patient{Name: "Tim", SSN: "123-45-6789", Notes: []byte("allergic to badgers")}
+	err = store.Insert("tim", data)
+	if err != nil {
+		t.Fatalf("Error inserting encrypted data: %s", err)
+	}
+	err = store.Insert("empty", &Patient{Name: "Empty"})
+	if err != nil {
+		t.Fatalf("Error inserting empty encrypted fields: %s", err)
+	}
+
+	if data.SSN != "123-45-6789" {
+		t.Fatalf("Insert changed the encrypted field of the passed in data to %s", data.SSN)
+	}
+
+	err = store.Badger().View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Rewind(); iter.Valid(); iter.Next() {
+			value, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+			if bytes.Contains(value, []byte("123-45-6789")) || bytes.Contains(value, []byte("badgers")) {
+				t.Fatalf("Encrypted fields were stored in plain text")
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading raw values: %s", err)
+	}
+
+	// the key belongs to the store, so opening another store without one doesn't affect it
+	other := testOptions()
+	otherStore, err := badgerhold.Open(other)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", other.Dir, err)
+	}
+	defer os.RemoveAll(other.Dir)
+	defer otherStore.Close()
+
+	err = otherStore.Insert("tim", data)
+	if err != badgerhold.ErrNoEncryptionKey {
+		t.Fatalf("Inserting encrypted data into a store without a key returned %v wanted %s", err,
+			badgerhold.ErrNoEncryptionKey)
+	}
+
+	result := &Patient{}
+	err = store.Get("tim", result)
+	if err != nil {
+		t.Fatalf("Error getting encrypted data: %s", err)
+	}
+	if result.SSN != data.SSN || string(result.Notes) != string(data.Notes) {
+		t.Fatalf("Decrypted %v wanted %v", result, data)
+	}
+
+	var found []Patient
+	err = store.Find(&found, badgerhold.Where("SSN").Eq("123-45-6789"))
+	if err != nil {
+		t.Fatalf("Error querying an encrypted field: %s", err)
+	}
+	if len(found) != 1 || found[0].Name != "Tim" {
+		t.Fatalf("Query on an encrypted field returned %v", found)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Inserting a type with an indexed encrypted field did not panic!")
+			}
+		}()
+		_ = store.Insert("indexed", &IndexedPatient{SSN: "123-45-6789"})
+	}()
+
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("Error closing store: %s", err)
+	}
+
+	// reading encrypted fields without the key fails
+	noKey := testOptions()
+	os.RemoveAll(noKey.Dir)
+	noKey.Dir = opt.Dir
+	noKey.ValueDir = opt.Dir
+	store, err = badgerhold.Open(noKey)
+	if err != nil {
+		t.Fatalf("Error reopening %s: %s", opt.Dir, err)
+	}
+
+	err = store.Get("tim", &Patient{})
+	if err != badgerhold.ErrNoEncryptionKey {
+		t.Fatalf("Getting encrypted data without a key returned %v wanted %s", err, badgerhold.ErrNoEncryptionKey)
+	}
+
+	err = store.Get("empty", &Patient{})
+	if err != nil {
+		t.Fatalf("Getting a record with empty encrypted fields without a key failed: %s", err)
+	}
+	store.Close()
+
+	// and with the wrong key
+	wrongKey := noKey
+	wrongKey.EncryptionKey = []byte("fedcba9876543210fedcba9876543210")
+	store, err = badgerhold.Open(wrongKey)
+	if err != nil {
+		t.Fatalf("Error reopening %s: %s", opt.Dir, err)
+	}
+	defer store.Close()
+
+	err = store.Get("tim", &Patient{})
+	if err == nil {
+		t.Fatalf("Getting encrypted data with the wrong key didn't fail")
+	}
+
+	badKey := testOptions()
+	defer os.RemoveAll(badKey.Dir)
+	badKey.EncryptionKey = []byte("short")
+	_, err = badgerhold.Open(badKey)
+	if err == nil {
+		t.Fatalf("Opening a store with an invalid encryption key didn't fail")
+	}
+}
+
+type Shape interface {
+	Area() int
+}
+
+type Square struct {
+	Side int
+}
+
+func (s Square) Area() int { return s.Side * s.Side }
+
+type Drawing struct {
+	Name  string
+	Shape Shape
+}
+
+func TestRegisterTypes(t *testing.T) {
+	opt := testOptions()
+	opt.RegisterTypes = []interface{}{Square{}}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	err = store.Insert("square", &Drawing{Name: "square", Shape: Square{Side: 3}})
+	if err != nil {
+		t.Fatalf("Error inserting a registered interface value: %s", err)
+	}
+
+	var result Drawing
+	err = store.Get("square", &result)
+	if err != nil {
+		t.Fatalf("Error getting a registered interface value: %s", err)
+	}
+	if result.Shape == nil || result.Shape.Area() != 9 {
+		t.Fatalf("Got %+v wanted a Square with an area of 9", result)
+	}
+
+	var found []Drawing
+	err = store.Find(&found, badgerhold.Where("Name").Eq("square"))
+	if err != nil {
+		t.Fatalf("Error finding a registered interface value: %s", err)
+	}
+	if len(found) != 1 || found[0].Shape.Area() != 9 {
+		t.Fatalf("Found %+v wanted a Square with an area of 9", found)
+	}
+}
+
+// Sensor encodes itself with encoding.BinaryMarshaler instead of the store's Encoder
+type Sensor struct {
+	ID    uint32
+	Zone  string `badgerholdIndex:"Zone"`
+	Level int32
+}
+
+func (s Sensor) MarshalBinary() ([]byte, error) {
+	buf := make([]byte, 8+len(s.Zone))
+	binary.BigEndian.PutUint32(buf, s.ID)
+	binary.BigEndian.PutUint32(buf[4:], uint32(s.Level))
+	copy(buf[8:], s.Zone)
+	return buf, nil
+}
+
+func (s *Sensor) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 {
+		return errors.New("Invalid Sensor")
+	}
+	s.ID = binary.BigEndian.Uint32(data)
+	s.Level = int32(binary.BigEndian.Uint32(data[4:]))
+	s.Zone = string(data[8:])
+	return nil
+}
+
+func TestBinaryMarshaler(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		sensors := []Sensor{
+			{ID: 1, Zone: "north", Level: -3},
+			{ID: 2, Zone: "south", Level: 10},
+			{ID: 3, Zone: "north", Level: 7},
+		}
+		for i := range sensors {
+			err := store.Insert(sensors[i].ID, sensors[i])
+			if err != nil {
+				t.Fatalf("Error inserting sensor: %s", err)
+			}
+		}
+
+		err := store.Badger().View(func(tx *badger.Txn) error {
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			prefix := []byte("bh:Sensor:")
+			count := 0
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				value, err := iter.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				var sensor Sensor
+				if sensor.UnmarshalBinary(value) != nil || sensor != sensors[count] {
+					t.Fatalf("Record %d wasn't stored with its MarshalBinary: %x", count, value)
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading raw records: %s", err)
+		}
+
+		var sensor Sensor
+		err = store.Get(uint32(2), &sensor)
+		if err != nil {
+			t.Fatalf("Error getting sensor: %s", err)
+		}
+		if sensor != sensors[1] {
+			t.Fatalf("Got %+v wanted %+v", sensor, sensors[1])
+		}
+
+		var result []Sensor
+		err = store.Find(&result, badgerhold.Where("Zone").Eq("north").Index("Zone").And("Level").Gt(int32(0)))
+		if err != nil {
+			t.Fatalf("Error finding sensors: %s", err)
+		}
+		if len(result) != 1 || result[0] != sensors[2] {
+			t.Fatalf("Unexpected sensors: %+v", result)
+		}
+	})
+}
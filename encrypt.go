@@ -0,0 +1,152 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+)
+
+// ErrNoEncryptionKey is returned when a record with fields tagged `badgerhold:"encrypt"` is written or read without
+// the EncryptionKey option set
+var ErrNoEncryptionKey = errors.New("Encrypted fields require the EncryptionKey option to be set")
+
+// newFieldCipher returns the AES-GCM cipher a store encrypts and decrypts the fields tagged `badgerhold:"encrypt"`
+// with, or nil if the key is empty
+func newFieldCipher(key []byte) (cipher.AEAD, error) {
+	if len(key) == 0 {
+		return nil, nil
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+func isEncrypted(field reflect.StructField) bool {
+	return field.Tag.Get(badgerholdPrefixTag) == badgerholdPrefixEncryptValue
+}
+
+// encryptFields replaces the encrypted fields of the struct value with their cipher text.  Each value is sealed with
+// a random nonce, so equal values are stored differently.  Empty fields are left empty
+func encryptFields(value reflect.Value, paths [][]int, fieldCipher cipher.AEAD) error {
+	for i := range paths {
+		field, err := encryptedField(value, paths[i], fieldCipher)
+		if err != nil {
+			return err
+		}
+		if !field.IsValid() {
+			continue
+		}
+
+		if field.Kind() == reflect.String {
+			sealed, err := seal(fieldCipher, []byte(field.String()))
+			if err != nil {
+				return err
+			}
+			// strings are kept valid, so that encoders such as JSON can store them
+			field.SetString(base64.StdEncoding.EncodeToString(sealed))
+			continue
+		}
+
+		sealed, err := seal(fieldCipher, field.Bytes())
+		if err != nil {
+			return err
+		}
+		field.SetBytes(sealed)
+	}
+
+	return nil
+}
+
+// decryptFields replaces the cipher text in the encrypted fields of the struct value with their original values
+func decryptFields(value reflect.Value, paths [][]int, fieldCipher cipher.AEAD) error {
+	for i := range paths {
+		field, err := encryptedField(value, paths[i], fieldCipher)
+		if err != nil {
+			return err
+		}
+		if !field.IsValid() {
+			continue
+		}
+
+		var sealed []byte
+		if field.Kind() == reflect.String {
+			sealed, err = base64.StdEncoding.DecodeString(field.String())
+		} else {
+			sealed = field.Bytes()
+		}
+
+		var opened []byte
+		if err == nil {
+			opened, err = open(fieldCipher, sealed)
+		}
+		if err != nil {
+			return fmt.Errorf("Error decrypting the field %s: %w", value.Type().FieldByIndex(paths[i]).Name, err)
+		}
+
+		if field.Kind() == reflect.String {
+			field.SetString(string(opened))
+		} else {
+			field.SetBytes(opened)
+		}
+	}
+
+	return nil
+}
+
+// encryptedField returns the field at path if it needs to be encrypted or decrypted, or an invalid value if it's
+// empty or can't be set, and ErrNoEncryptionKey if it needs to be but there is no cipher
+func encryptedField(value reflect.Value, path []int, fieldCipher cipher.AEAD) (reflect.Value, error) {
+	field := value.FieldByIndex(path)
+	if field.Kind() != reflect.String && !isByteSlice(field.Type()) {
+		return reflect.Value{}, fmt.Errorf("The field %s is a %s, only string and []byte fields can be encrypted",
+			value.Type().FieldByIndex(path).Name, field.Type())
+	}
+
+	if !field.CanSet() || field.Len() == 0 {
+		return reflect.Value{}, nil
+	}
+
+	if fieldCipher == nil {
+		return reflect.Value{}, ErrNoEncryptionKey
+	}
+
+	return field, nil
+}
+
+func isByteSlice(tp reflect.Type) bool {
+	return tp.Kind() == reflect.Slice && tp.Elem().Kind() == reflect.Uint8
+}
+
+// seal encrypts the plain text, and prefixes it with the nonce used
+func seal(fieldCipher cipher.AEAD, plain []byte) ([]byte, error) {
+	nonce := make([]byte, fieldCipher.NonceSize())
+	_, err := io.ReadFull(rand.Reader, nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return fieldCipher.Seal(nonce, nonce, plain, nil), nil
+}
+
+// open decrypts cipher text written by seal
+func open(fieldCipher cipher.AEAD, sealed []byte) ([]byte, error) {
+	size := fieldCipher.NonceSize()
+	if len(sealed) < size {
+		return nil, errors.New("The cipher text is too short")
+	}
+
+	return fieldCipher.Open(nil, sealed[:size], sealed[size:], nil)
+}
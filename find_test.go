@@ -5,12 +5,16 @@
 package badgerhold_test
 
 import (
+	"bytes"
 	"fmt"
+	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"testing"
 	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/timshannon/badgerhold"
 )
 
@@ -394,6 +398,11 @@ var testResults = []test{
 		query:  badgerhold.Where("Tags").IsNil(),
 		result: []int{0, 1, 2, 3, 5, 6, 8, 9, 11, 13, 14, 16},
 	},
+	test{
+		name:   "Not Nil Comparison",
+		query:  badgerhold.Where("Tags").IsNotNil(),
+		result: []int{4, 7, 10, 12, 15},
+	},
 	test{
 		name:   "String starts with",
 		query:  badgerhold.Where("Name").HasPrefix("golf"),
@@ -501,6 +510,26 @@ var testResults = []test{
 		query:  badgerhold.Where("Category").Eq("food").Index("Category").And(badgerhold.Key).Gt(testData[10].Key),
 		result: []int{12, 15},
 	},
+	test{
+		name:   "Slice Length Greater Than",
+		query:  badgerhold.Where("Tags").LenGt(0),
+		result: []int{4, 7, 10, 12, 15},
+	},
+	test{
+		name:   "Slice Length Equal",
+		query:  badgerhold.Where("Tags").LenEq(0),
+		result: []int{0, 1, 2, 3, 5, 6, 8, 9, 11, 13, 14, 16},
+	},
+	test{
+		name:   "String Length Less Than",
+		query:  badgerhold.Where("Name").LenLt(4),
+		result: []int{0, 3, 6},
+	},
+	test{
+		name:   "String Length Greater Than on an Index",
+		query:  badgerhold.Where("Category").LenGt(4).Index("Category").And("Name").LenGt(5),
+		result: []int{11},
+	},
 }
 
 func insertTestData(t *testing.T, store *badgerhold.Store) {
@@ -597,23 +626,29 @@ func TestFindWithNonSlicePtr(t *testing.T) {
 }
 
 func TestQueryWhereNamePanic(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("Querying with a lower case field did not cause a panic!")
-		}
-	}()
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Querying with a lower case field did not cause a panic!")
+			}
+		}()
 
-	_ = badgerhold.Where("lower").Eq("test")
+		var result []ItemTest
+		_ = store.Find(&result, badgerhold.Where("lower").Eq("test"))
+	})
 }
 
 func TestQueryAndNamePanic(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("Querying with a lower case field did not cause a panic!")
-		}
-	}()
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Querying with a lower case field did not cause a panic!")
+			}
+		}()
 
-	_ = badgerhold.Where("Upper").Eq("test").And("lower").Eq("test")
+		var result []ItemTest
+		_ = store.Find(&result, badgerhold.Where("Upper").Eq("test").And("lower").Eq("test"))
+	})
 }
 
 func TestFindOnInvalidFieldName(t *testing.T) {
@@ -944,16 +979,6 @@ func TestKeyStructTagIntoPtr(t *testing.T) {
 	})
 }
 
-func TestQueryNestedIndex(t *testing.T) {
-	defer func() {
-		if r := recover(); r == nil {
-			t.Fatalf("Querying with a nested index field did not panic!")
-		}
-	}()
-
-	_ = badgerhold.Where("Test").Eq("test").Index("Nested.Name")
-}
-
 // TestQueryIterKeyCacheOverflow tests to make sure that a query can goe past the current hardcoded key cache in the
 // iterator (currently 100 keys)
 func TestQueryIterKeyCacheOverflow(t *testing.T) {
@@ -1062,3 +1087,1709 @@ func TestNestedStructPointer(t *testing.T) {
 		}
 	})
 }
+
+func TestFindParallel(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type ParallelTest struct {
+			Key  int
+			Name string
+		}
+
+		size := 1000
+
+		for i := 0; i < size; i++ {
+			err := store.Insert(i, &ParallelTest{
+				Key:  i,
+				Name: fmt.Sprintf("name-%d", i),
+			})
+			if err != nil {
+				t.Fatalf("Error inserting data for parallel test: %s", err)
+			}
+		}
+
+		tests := []func() *badgerhold.Query{
+			func() *badgerhold.Query {
+				return badgerhold.Where("Name").RegExp(regexp.MustCompile("7"))
+			},
+			func() *badgerhold.Query {
+				return badgerhold.Where("Name").RegExp(regexp.MustCompile("7")).Skip(10).Limit(250)
+			},
+			func() *badgerhold.Query {
+				return badgerhold.Where("Name").MatchFunc(func(ra *badgerhold.RecordAccess) (bool, error) {
+					return ra.Record().(*ParallelTest).Key%3 == 0, nil
+				}).SortBy("Name").Reverse()
+			},
+		}
+
+		for i := range tests {
+			t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+				var expected []ParallelTest
+				err := store.Find(&expected, tests[i]())
+				if err != nil {
+					t.Fatalf("Error getting data from badgerhold: %s", err)
+				}
+
+				var result []ParallelTest
+				err = store.Find(&result, tests[i]().Parallel(4))
+				if err != nil {
+					t.Fatalf("Error getting data from badgerhold: %s", err)
+				}
+
+				if len(result) == 0 || len(result) != len(expected) {
+					t.Fatalf("Parallel result count is %d wanted %d", len(result), len(expected))
+				}
+
+				for k := range expected {
+					if result[k] != expected[k] {
+						t.Fatalf("Parallel result %d is %v wanted %v", k, result[k], expected[k])
+					}
+				}
+			})
+		}
+
+		var result []ParallelTest
+		err := store.Find(&result, badgerhold.Where("Key").MatchFunc(func(ra *badgerhold.RecordAccess) (bool, error) {
+			var sub []ParallelTest
+			err := ra.SubQuery(&sub, badgerhold.Where("Key").Eq(ra.Field()))
+			return len(sub) == 1, err
+		}).Parallel(2))
+		if err == nil {
+			t.Fatalf("Running a SubQuery from a parallel query didn't fail")
+		}
+	})
+}
+
+func TestFindOne(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		tests := []*badgerhold.Query{
+			badgerhold.Where("Category").Eq("vehicle").Index("Category"),
+			badgerhold.Where("Category").Eq("food"),
+			badgerhold.Where("Name").Eq("lion").And("Category").Eq("animal").Index("Category"),
+			badgerhold.Where("ID").Gt(5).SortBy("ID").Reverse(),
+		}
+
+		for i := range tests {
+			t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+				var expected []ItemTest
+				err := store.Find(&expected, tests[i])
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				result := &ItemTest{}
+				err = store.FindOne(result, tests[i])
+				if err != nil {
+					t.Fatalf("Error finding one record from badgerhold: %s", err)
+				}
+
+				if !result.equal(&expected[0]) {
+					t.Fatalf("Got %v wanted %v", result, expected[0])
+				}
+			})
+		}
+
+		matched := 0
+		result := &ItemTest{}
+		err := store.FindOne(result, badgerhold.Where("Name").MatchFunc(func(ra *badgerhold.RecordAccess) (bool, error) {
+			matched++
+			return true, nil
+		}))
+		if err != nil {
+			t.Fatalf("Error finding one record from badgerhold: %s", err)
+		}
+
+		if matched != 1 {
+			t.Fatalf("FindOne tested %d records, wanted it to stop after the first match", matched)
+		}
+
+		err = store.FindOne(result, badgerhold.Where("Category").Eq("unknown").Index("Category"))
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+
+		err = store.FindOne(result, badgerhold.Where("Name").Eq("unknown"))
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Expected ErrNotFound, got %v", err)
+		}
+	})
+}
+
+func TestFindForType(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		type OtherItem struct {
+			Key      int
+			Category string
+		}
+
+		var result []ItemTest
+		err := store.Find(&result, badgerhold.Where("Category").Eq("vehicle").ForType(&ItemTest{}))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+		if len(result) != 5 {
+			t.Fatalf("Find result count is %d wanted %d.", len(result), 5)
+		}
+
+		var other []OtherItem
+		err = store.Find(&other, badgerhold.Where("Category").Eq("vehicle").ForType([]ItemTest{}))
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Expected ErrTypeMismatch, got %v", err)
+		}
+
+		err = store.FindOne(&OtherItem{}, badgerhold.Where("Category").Eq("vehicle").ForType(&ItemTest{}))
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Expected ErrTypeMismatch from FindOne, got %v", err)
+		}
+
+		_, err = store.FindAggregate(&OtherItem{}, (&badgerhold.Query{}).ForType(&ItemTest{}), "Category")
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Expected ErrTypeMismatch from FindAggregate, got %v", err)
+		}
+
+		err = store.DeleteMatching(&OtherItem{}, badgerhold.Where("Category").Eq("vehicle").ForType(&ItemTest{}))
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Expected ErrTypeMismatch from DeleteMatching, got %v", err)
+		}
+	})
+}
+
+func TestFindNilPointerIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type SoftDelete struct {
+			Key       int
+			DeletedAt *time.Time `badgerhold:"index"`
+			Extra     interface{}
+		}
+
+		now := time.Now()
+
+		for i := 0; i < 10; i++ {
+			item := &SoftDelete{Key: i}
+			if i%3 == 0 {
+				item.DeletedAt = &now
+				item.Extra = "extra"
+			}
+			err := store.Insert(i, item)
+			if err != nil {
+				t.Fatalf("Error inserting data for nil index test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query *badgerhold.Query
+			count int
+		}{
+			{badgerhold.Where("DeletedAt").IsNil(), 6},
+			{badgerhold.Where("DeletedAt").IsNil().Index("DeletedAt"), 6},
+			{badgerhold.Where("DeletedAt").IsNotNil(), 4},
+			{badgerhold.Where("DeletedAt").IsNotNil().Index("DeletedAt"), 4},
+			{badgerhold.Where("Extra").IsNil(), 6},
+			{badgerhold.Where("Key").IsNil(), 0},
+			{badgerhold.Where("Key").IsNotNil(), 10},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []SoftDelete
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != tests[i].count {
+					t.Fatalf("Find result count is %d wanted %d.", len(result), tests[i].count)
+				}
+			})
+		}
+
+		err := store.Delete(0, &SoftDelete{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+
+		err = store.Delete(1, &SoftDelete{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+	})
+}
+
+func TestFindMapKey(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Product struct {
+			Key        int
+			Attributes map[string]string `badgerhold:"index"`
+			Sizes      map[string]int
+		}
+
+		products := []Product{
+			{Key: 0, Attributes: map[string]string{"color": "red", "size": "L"}, Sizes: map[string]int{"L": 3}},
+			{Key: 1, Attributes: map[string]string{"color": "blue", "size": "L"}, Sizes: map[string]int{"L": 1}},
+			{Key: 2, Attributes: map[string]string{"color": "red"}, Sizes: map[string]int{"S": 5}},
+			{Key: 3, Attributes: map[string]string{"size": "S"}},
+			{Key: 4},
+		}
+
+		for i := range products {
+			err := store.Insert(products[i].Key, &products[i])
+			if err != nil {
+				t.Fatalf("Error inserting data for map key test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query  *badgerhold.Query
+			result []int
+		}{
+			{badgerhold.Where("Attributes").MapKey("color").Eq("red"), []int{0, 2}},
+			{badgerhold.Where("Attributes").MapKey("color").Eq("red").Index("Attributes"), []int{0, 2}},
+			{badgerhold.Where("Attributes").MapKey("color").Eq("red").And("Attributes").MapKey("size").Eq("L").
+				Index("Attributes"), []int{0}},
+			{badgerhold.Where("Attributes").MapKey("color").Ne("red"), []int{1}},
+			{badgerhold.Where("Attributes").MapKey("color").Ne("red").Index("Attributes"), []int{1}},
+			{badgerhold.Where("Attributes").MapKey("shape").Eq("round").Index("Attributes"), []int{}},
+			{badgerhold.Where("Sizes").MapKey("L").Gt(2), []int{0}},
+			{badgerhold.Where("Sizes").MapKey("S").IsNotNil(), []int{2}},
+			{badgerhold.Where("Attributes").MapHasKey("color"), []int{0, 1, 2}},
+			{badgerhold.Where("Attributes").MapHasKey("color").Index("Attributes"), []int{1, 0, 2}}, // in index order
+			{badgerhold.Where("Attributes").MapHasKey("size").And("Attributes").MapKey("color").Eq("red").
+				Index("Attributes"), []int{0}},
+			{badgerhold.Where("Attributes").MapHasKey("shape").Index("Attributes"), []int{}},
+			{badgerhold.Where("Attributes").MapHasKeyValue("color", "red").Index("Attributes"), []int{0, 2}},
+			{badgerhold.Where("Sizes").MapHasKey("S"), []int{2}},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []Product
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d. Results: %v", len(result), len(tests[i].result),
+						result)
+				}
+
+				for k := range result {
+					if result[k].Key != tests[i].result[k] {
+						t.Fatalf("Result %d has key %d wanted %d", k, result[k].Key, tests[i].result[k])
+					}
+				}
+			})
+		}
+
+		index, fullScan, err := store.WillUseIndex(&Product{}, badgerhold.Where("Attributes").MapHasKey("size").
+			Index("Attributes"))
+		if err != nil || index != "Attributes" || fullScan {
+			t.Fatalf("MapHasKey will use the index %q with a full scan %t and error %v, wanted the Attributes index",
+				index, fullScan, err)
+		}
+
+		// updates move the record to its new index entries
+		products[2].Attributes["color"] = "green"
+		err = store.Update(products[2].Key, &products[2])
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Product
+		err = store.Find(&result, badgerhold.Where("Attributes").MapKey("color").Eq("red").Index("Attributes"))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+		if len(result) != 1 || result[0].Key != 0 {
+			t.Fatalf("Found %v after update, wanted only the record with key 0", result)
+		}
+
+		err = store.Find(&result, badgerhold.Where("Attributes").MapKey(1).Eq("red"))
+		if err == nil {
+			t.Fatalf("Using a map key of the wrong type didn't return an error")
+		}
+
+		err = store.Find(&result, badgerhold.Where("Key").MapKey("color").Eq("red"))
+		if err == nil {
+			t.Fatalf("Using MapKey on a field that isn't a map didn't return an error")
+		}
+
+		err = store.Find(&result, badgerhold.Where("Attributes").MapHasKey(1))
+		if err == nil {
+			t.Fatalf("Using MapHasKey with a key of the wrong type didn't return an error")
+		}
+	})
+}
+
+func TestFindContains(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Article struct {
+			Key   int
+			Tags  []string `badgerhold:"index"`
+			Votes []int
+		}
+
+		articles := []Article{
+			{Key: 0, Tags: []string{"go", "databases"}, Votes: []int{1, 5}},
+			{Key: 1, Tags: []string{"rust"}, Votes: []int{3}},
+			{Key: 2, Tags: []string{"go", "go", "testing"}},
+			{Key: 3},
+		}
+
+		for i := range articles {
+			err := store.Insert(articles[i].Key, &articles[i])
+			if err != nil {
+				t.Fatalf("Error inserting data for contains test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query  *badgerhold.Query
+			result []int
+			index  string
+		}{
+			{badgerhold.Where("Tags").Contains("go"), []int{0, 2}, ""},
+			{badgerhold.Where("Tags").Contains("go").Index("Tags"), []int{0, 2}, "Tags"},
+			{badgerhold.Where("Tags").ContainsAny("rust", "testing").Index("Tags"), []int{1, 2}, "Tags"},
+			{badgerhold.Where("Tags").Contains("go").And("Tags").Contains("testing").Index("Tags"), []int{2}, "Tags"},
+			{badgerhold.Where("Tags").Contains("java").Index("Tags"), []int{}, "Tags"},
+			{badgerhold.Where("Tags").IsNil().Index("Tags"), []int{3}, ""},
+			{badgerhold.Where("Tags").Eq([]string{"rust"}).Index("Tags"), []int{1}, ""},
+			{badgerhold.Where("Votes").Contains(5), []int{0}, ""},
+			{badgerhold.Where("Votes").ContainsAny(3, 4), []int{1}, ""},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				index, _, err := store.WillUseIndex(&Article{}, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error checking the query's index: %s", err)
+				}
+				if index != tests[i].index {
+					t.Fatalf("WillUseIndex returned %q wanted %q", index, tests[i].index)
+				}
+
+				var result []Article
+				err = store.Find(&result, tests[i].query.SortBy("Key"))
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d. Results: %v", len(result), len(tests[i].result),
+						result)
+				}
+
+				for k := range result {
+					if result[k].Key != tests[i].result[k] {
+						t.Fatalf("Result %d has key %d wanted %d", k, result[k].Key, tests[i].result[k])
+					}
+				}
+			})
+		}
+
+		// updates move the record to the index entries of its new elements
+		articles[0].Tags = []string{"databases"}
+		err := store.Update(articles[0].Key, &articles[0])
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Article
+		err = store.Find(&result, badgerhold.Where("Tags").Contains("go").Index("Tags"))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+		if len(result) != 1 || result[0].Key != 2 {
+			t.Fatalf("Found %v after update, wanted only the record with key 2", result)
+		}
+
+		err = store.Find(&result, badgerhold.Where("Key").Contains(1))
+		if err == nil {
+			t.Fatalf("Using Contains on a field that isn't a slice didn't return an error")
+		}
+	})
+}
+
+func TestFindMatch(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Document struct {
+			Key   int
+			Title string
+			Body  string `badgerholdFullText:"Body"`
+		}
+
+		documents := []Document{
+			{Key: 0, Title: "bleve", Body: "Full text search for Go, with ranking and search facets."},
+			{Key: 1, Title: "badger", Body: "An embedded key-value store in Go."},
+			{Key: 2, Title: "badgerhold", Body: "Embedded queries and search on top of badger, in Go!"},
+			{Key: 3, Title: "empty"},
+		}
+
+		for i := range documents {
+			err := store.Insert(documents[i].Key, &documents[i])
+			if err != nil {
+				t.Fatalf("Error inserting data for match test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query  *badgerhold.Query
+			result []int
+			index  string
+		}{
+			{badgerhold.Where("Body").Match("SEARCH"), []int{0, 2}, ""},
+			{badgerhold.Where("Body").Match("search").Index("Body"), []int{0, 2}, "Body"},
+			{badgerhold.Where("Body").Match("embedded search").Index("Body"), []int{2, 0, 1}, "Body"},
+			{badgerhold.Where("Body").Match("embedded search").And("Title").Ne("badgerhold").Index("Body"),
+				[]int{0, 1}, "Body"},
+			{badgerhold.Where("Body").Match("embedded search").Index("Body").SortBy("Key"), []int{0, 1, 2}, "Body"},
+			{badgerhold.Where("Body").Match("embedded search").Index("Body").Limit(1), []int{2}, "Body"},
+			{badgerhold.Where("Body").Match("java").Index("Body"), []int{}, "Body"},
+			{badgerhold.Where("Body").Eq("").Index("Body"), []int{3}, ""},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				index, _, err := store.WillUseIndex(&Document{}, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error checking the query's index: %s", err)
+				}
+				if index != tests[i].index {
+					t.Fatalf("WillUseIndex returned %q wanted %q", index, tests[i].index)
+				}
+
+				var result []Document
+				err = store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d. Results: %v", len(result), len(tests[i].result),
+						result)
+				}
+
+				for k := range result {
+					if result[k].Key != tests[i].result[k] {
+						t.Fatalf("Result %d has key %d wanted %d", k, result[k].Key, tests[i].result[k])
+					}
+				}
+			})
+		}
+
+		// updates move the record to the index entries of its new terms
+		documents[1].Body = "A key-value store"
+		err := store.Update(documents[1].Key, &documents[1])
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Document
+		err = store.Find(&result, badgerhold.Where("Body").Match("embedded").Index("Body"))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+		if len(result) != 1 || result[0].Key != 2 {
+			t.Fatalf("Found %v after update, wanted only the record with key 2", result)
+		}
+
+		err = store.Find(&result, badgerhold.Where("Key").Match("1"))
+		if err == nil {
+			t.Fatalf("Using Match on a field that isn't a string didn't return an error")
+		}
+	})
+}
+
+func TestFindJSON(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Event struct {
+			Key     int
+			Payload []byte `badgerhold:"index"`
+		}
+
+		events := []Event{
+			{Key: 0, Payload: []byte(`{"user": {"role": "admin", "age": 40}, "tags": ["a", "b"]}`)},
+			{Key: 1, Payload: []byte(`{"user": {"role": "guest", "age": 20}, "tags": ["b"]}`)},
+			{Key: 2, Payload: []byte(`{"user": {"role": "admin", "age": "unknown"}}`)},
+			{Key: 3, Payload: []byte(`{"user": null, "a/b": 1}`)},
+			{Key: 4, Payload: []byte(`not json`)},
+		}
+
+		for i := range events {
+			err := store.Insert(events[i].Key, &events[i])
+			if err != nil {
+				t.Fatalf("Error inserting data for JSON test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query  *badgerhold.Query
+			result []int
+		}{
+			{badgerhold.WhereJSON("Payload", "$.user.role").Eq("admin"), []int{0, 2}},
+			{badgerhold.WhereJSON("Payload", "$.user.role").Eq("admin").Index("Payload"), []int{0, 2}},
+			{badgerhold.WhereJSON("Payload", "/user/role").Ne("admin"), []int{1}},
+			{badgerhold.WhereJSON("Payload", "$.user.age").Gt(30), []int{0}},
+			{badgerhold.WhereJSON("Payload", "$['user'].age").In(20, 40.0), []int{0, 1}},
+			{badgerhold.WhereJSON("Payload", "$.tags[1]").Eq("b"), []int{0}},
+			{badgerhold.WhereJSON("Payload", "/tags/0").Eq("b"), []int{1}},
+			{badgerhold.WhereJSON("Payload", "$.user").IsNil(), []int{3}},
+			{badgerhold.WhereJSON("Payload", "/a~1b").Eq(1), []int{3}},
+			{badgerhold.WhereJSON("Payload", "$.user.role").Eq("admin").AndJSON("Payload", "$.tags[0]").Eq("a"),
+				[]int{0}},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []Event
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d. Results: %v", len(result), len(tests[i].result),
+						result)
+				}
+
+				for k := range result {
+					if result[k].Key != tests[i].result[k] {
+						t.Fatalf("Result %d has key %d wanted %d", k, result[k].Key, tests[i].result[k])
+					}
+				}
+			})
+		}
+
+		var result []Event
+		err := store.Find(&result, badgerhold.Where("Payload").JSONPath("$.user.role").StrictJSON().Eq("admin"))
+		if err == nil {
+			t.Fatalf("A strict JSON query didn't return an error for malformed JSON")
+		}
+
+		err = store.Find(&result, badgerhold.WhereJSON("Key", "$.user").Eq("x"))
+		if err == nil {
+			t.Fatalf("Using JSONPath on a field that isn't JSON didn't return an error")
+		}
+
+		defer func() {
+			if recover() == nil {
+				t.Fatalf("An invalid JSON path didn't panic")
+			}
+		}()
+		badgerhold.WhereJSON("Payload", "$.tags[x]")
+	})
+}
+
+type EmbeddedBase struct {
+	CreatedAt time.Time `badgerhold:"index"`
+	Name      string    `badgerhold:"index"`
+}
+
+type EmbeddedItem struct {
+	EmbeddedBase
+	Name string // shadows EmbeddedBase.Name
+}
+
+type EmbeddedPtrItem struct {
+	*EmbeddedBase
+	Key int
+}
+
+func TestFindEmbedded(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+
+		for i := 0; i < 10; i++ {
+			err := store.Insert(i, &EmbeddedItem{
+				EmbeddedBase: EmbeddedBase{
+					CreatedAt: start.AddDate(0, 0, i),
+					Name:      "base",
+				},
+				Name: fmt.Sprintf("item %d", i),
+			})
+			if err != nil {
+				t.Fatalf("Error inserting data for embedded test: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query *badgerhold.Query
+			count int
+		}{
+			{badgerhold.Where("CreatedAt").Ge(start.AddDate(0, 0, 5)), 5},
+			{badgerhold.Where("CreatedAt").Ge(start.AddDate(0, 0, 5)).Index("CreatedAt"), 5},
+			{badgerhold.Where("CreatedAt").Eq(start.AddDate(0, 0, 3)).Index("CreatedAt"), 1},
+			{badgerhold.Where("Name").Eq("item 3"), 1},
+			{badgerhold.Where("Name").Eq("base"), 0},
+			{badgerhold.Where("EmbeddedBase.Name").Eq("base"), 10},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []EmbeddedItem
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+
+				if len(result) != tests[i].count {
+					t.Fatalf("Find result count is %d wanted %d.", len(result), tests[i].count)
+				}
+			})
+		}
+
+		// the shadowed field's index tag doesn't apply
+		var result []EmbeddedItem
+		err := store.Find(&result, badgerhold.Where("Name").Eq("item 3").Index("Name"))
+		if err == nil {
+			t.Fatalf("Querying the index of a shadowed field didn't return an error")
+		}
+
+		err = store.Insert(0, &EmbeddedPtrItem{Key: 0})
+		if err != nil {
+			t.Fatalf("Error inserting data with a nil embedded pointer: %s", err)
+		}
+		err = store.Insert(1, &EmbeddedPtrItem{Key: 1, EmbeddedBase: &EmbeddedBase{CreatedAt: start}})
+		if err != nil {
+			t.Fatalf("Error inserting data with an embedded pointer: %s", err)
+		}
+
+		var ptrResult []EmbeddedPtrItem
+		err = store.Find(&ptrResult, badgerhold.Where("CreatedAt").Eq(start))
+		if err != nil {
+			t.Fatalf("Error finding data through a nil embedded pointer: %s", err)
+		}
+
+		if len(ptrResult) != 1 || ptrResult[0].Key != 1 {
+			t.Fatalf("Got %v wanted only the record with key 1", ptrResult)
+		}
+	})
+}
+
+// decodeFailer fails to decode when its value is "fail", to simulate a corrupt record
+type decodeFailer string
+
+func (d decodeFailer) GobEncode() ([]byte, error) {
+	return []byte(d), nil
+}
+
+func (d *decodeFailer) GobDecode(data []byte) error {
+	if string(data) == "fail" {
+		return fmt.Errorf("Corrupt value")
+	}
+	*d = decodeFailer(data)
+	return nil
+}
+
+func TestFindDecodeErrorMidStream(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type CorruptItem struct {
+			Key      int
+			Category string `badgerhold:"index"`
+			Value    decodeFailer
+		}
+
+		size := 300
+		for i := 0; i < size; i++ {
+			item := &CorruptItem{
+				Key:      i,
+				Category: "category",
+				Value:    "ok",
+			}
+			if i == size/2 {
+				item.Value = "fail"
+			}
+
+			err := store.Insert(i, item)
+			if err != nil {
+				t.Fatalf("Error inserting data for decode error test: %s", err)
+			}
+		}
+
+		tests := []*badgerhold.Query{
+			nil,
+			badgerhold.Where("Category").Eq("category").Index("Category"),
+			badgerhold.Where("Key").Ge(0),
+			badgerhold.Where("Key").Ge(0).SortBy("Key"),
+			badgerhold.Where("Key").Ge(0).Parallel(2),
+			badgerhold.Where("Key").Eq(size - 1).Or(badgerhold.Where("Key").Lt(size)),
+		}
+
+		for i := range tests {
+			t.Run(fmt.Sprintf("Test %d", i), func(t *testing.T) {
+				var result []CorruptItem
+				err := store.Find(&result, tests[i])
+				if err == nil {
+					t.Fatalf("Find returned %d records without an error", len(result))
+				}
+			})
+		}
+
+		_, err := store.FindAggregate(&CorruptItem{}, badgerhold.Where("Key").Ge(0), "Category")
+		if err == nil {
+			t.Fatalf("FindAggregate didn't return the decode error")
+		}
+
+		err = store.UpdateMatching(&CorruptItem{}, nil, func(record interface{}) error {
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("UpdateMatching didn't return the decode error")
+		}
+
+		err = store.DeleteMatching(&CorruptItem{}, nil)
+		if err == nil {
+			t.Fatalf("DeleteMatching didn't return the decode error")
+		}
+
+		var result []CorruptItem
+		err = store.Find(&result, nil)
+		if err == nil {
+			t.Fatalf("DeleteMatching deleted records despite the decode error")
+		}
+	})
+}
+
+func TestFindFieldComparison(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+		err := store.Insert(100, &ItemTest{Key: 100, Name: "food", Category: "food"})
+		if err != nil {
+			t.Fatalf("Error inserting data for test: %s", err)
+		}
+
+		var result []ItemTest
+		err = store.Find(&result, badgerhold.Where("Category").Eq(badgerhold.Field("Name")).Index("Category"))
+		if err != nil {
+			t.Fatalf("Error comparing an indexed field to another field: %s", err)
+		}
+		if len(result) != 1 || result[0].Key != 100 {
+			t.Fatalf("Indexed field comparison returned %v wanted the record with key 100", result)
+		}
+
+		result = nil
+		err = store.Find(&result, badgerhold.Where("Name").Gt(badgerhold.Field("ID")))
+		if _, ok := err.(*badgerhold.ErrTypeMismatch); !ok {
+			t.Fatalf("Comparing fields of different types did NOT return the correct error.  Got %v", err)
+		}
+
+		err = store.Find(&result, badgerhold.Where("Name").Eq(badgerhold.Field("BadName")))
+		if err == nil {
+			t.Fatalf("Comparing to a field that doesn't exist did NOT return an error")
+		}
+	})
+}
+
+func TestFindQueryTimeout(t *testing.T) {
+	opt := testOptions()
+	opt.QueryTimeout = time.Nanosecond
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	// the timeout belongs to the store, so opening another store without one doesn't change it
+	other := testOptions()
+	otherStore, err := badgerhold.Open(other)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", other.Dir, err)
+	}
+	defer os.RemoveAll(other.Dir)
+	defer otherStore.Close()
+
+	insertTestData(t, store)
+
+	queries := []*badgerhold.Query{
+		nil,
+		badgerhold.Where("Name").Eq("car"),
+		badgerhold.Where("Category").Eq("food").Index("Category"),
+		badgerhold.Where("Name").Eq("car").Or(badgerhold.Where("Name").Eq("truck")),
+	}
+
+	for i := range queries {
+		var result []ItemTest
+		err = store.Find(&result, queries[i])
+		if err != badgerhold.ErrQueryTimeout {
+			t.Fatalf("Find with %s returned %v wanted %s", queries[i], err, badgerhold.ErrQueryTimeout)
+		}
+	}
+
+	err = store.DeleteMatching(&ItemTest{}, badgerhold.Where("Name").Eq("car"))
+	if err != badgerhold.ErrQueryTimeout {
+		t.Fatalf("DeleteMatching returned %v wanted %s", err, badgerhold.ErrQueryTimeout)
+	}
+
+	// gets aren't queries, and aren't limited
+	result := &ItemTest{}
+	err = store.Get(testData[0].Key, result)
+	if err != nil {
+		t.Fatalf("Error getting data with a query timeout set: %s", err)
+	}
+}
+
+type slowQueryLogger struct {
+	emptyLogger
+	warnings []string
+}
+
+func (l *slowQueryLogger) Warningf(msg string, data ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(msg, data...))
+}
+
+func TestFindQueryObserver(t *testing.T) {
+	var stats []badgerhold.QueryStats
+	logger := &slowQueryLogger{}
+
+	opt := testOptions()
+	opt.QueryObserver = func(s badgerhold.QueryStats) {
+		stats = append(stats, s)
+	}
+	opt.SlowQueryThreshold = time.Nanosecond
+	opt.Logger = logger
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+	logger.warnings = nil
+
+	var result []ItemTest
+	err = store.Find(&result, badgerhold.Where("Category").Eq("food"))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+
+	result = nil
+	err = store.Find(&result, badgerhold.Where("Category").Eq("food").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+
+	if len(stats) != 2 {
+		t.Fatalf("QueryObserver was called %d times wanted 2", len(stats))
+	}
+
+	scan := stats[0]
+	if scan.Type != "ItemTest" || scan.Index != "" || scan.Scanned != len(testData) ||
+		scan.Decoded != len(testData) || scan.Matched != len(result) {
+		t.Fatalf("Unexpected stats for a full scan: %+v", scan)
+	}
+
+	indexed := stats[1]
+	if indexed.Index != "Category" || indexed.Matched != len(result) || indexed.Decoded != len(result) ||
+		indexed.Scanned >= len(testData) {
+		t.Fatalf("Unexpected stats for an indexed query: %+v", indexed)
+	}
+
+	if indexed.Query != badgerhold.Where("Category").Eq("food").Index("Category").String() {
+		t.Fatalf("Stats have the query %s", indexed.Query)
+	}
+
+	if len(logger.warnings) != 2 || !strings.Contains(logger.warnings[0], "Slow badgerhold query on ItemTest") {
+		t.Fatalf("Slow queries weren't logged: %v", logger.warnings)
+	}
+
+	// the observer belongs to the store, so the queries of another store aren't observed
+	other := testOptions()
+	otherStore, err := badgerhold.Open(other)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", other.Dir, err)
+	}
+	defer os.RemoveAll(other.Dir)
+	defer otherStore.Close()
+
+	err = otherStore.Find(&result, badgerhold.Where("Category").Eq("food"))
+	if err != nil {
+		t.Fatalf("Error finding data in another store: %s", err)
+	}
+
+	err = store.Find(&result, badgerhold.Where("Category").Eq("food"))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(stats) != 3 || len(logger.warnings) != 3 {
+		t.Fatalf("QueryObserver was called %d times and %d slow queries were logged, wanted 3 of each", len(stats),
+			len(logger.warnings))
+	}
+}
+
+func TestFindGroups(t *testing.T) {
+	tests := []struct {
+		name  string
+		query *badgerhold.Query
+		match func(i ItemTest) bool
+	}{
+		{
+			name: "And with an Or group",
+			query: badgerhold.Where("Category").Eq("vehicle").
+				AndGroup(badgerhold.Where("Name").Eq("car").Or(badgerhold.Where("Name").Eq("van"))),
+			match: func(i ItemTest) bool {
+				return i.Category == "vehicle" && (i.Name == "car" || i.Name == "van")
+			},
+		},
+		{
+			name: "Or of an And group",
+			query: badgerhold.Group(badgerhold.Where("Category").Eq("vehicle").And("ID").Gt(1)).
+				Or(badgerhold.Where("Name").Eq("seal")),
+			match: func(i ItemTest) bool {
+				return (i.Category == "vehicle" && i.ID > 1) || i.Name == "seal"
+			},
+		},
+		{
+			name: "Two Or groups",
+			query: badgerhold.Group(badgerhold.Where("Category").Eq("animal").Or(badgerhold.Where("Category").Eq("food"))).
+				AndGroup(badgerhold.Where("ID").Gt(10).Or(badgerhold.Where("Name").Eq("seal"))),
+			match: func(i ItemTest) bool {
+				return (i.Category == "animal" || i.Category == "food") && (i.ID > 10 || i.Name == "seal")
+			},
+		},
+		{
+			name: "Deeply nested",
+			query: badgerhold.Where("Category").Eq("vehicle").AndGroup(
+				badgerhold.Where("Name").Eq("car").Or(
+					badgerhold.Where("ID").Ge(3).AndGroup(
+						badgerhold.Where("Name").Eq("van").Or(badgerhold.Where("ID").Gt(10)),
+					),
+				),
+			),
+			match: func(i ItemTest) bool {
+				return i.Category == "vehicle" && (i.Name == "car" || (i.ID >= 3 && (i.Name == "van" || i.ID > 10)))
+			},
+		},
+		{
+			name: "Indexed with a group",
+			query: badgerhold.Where("Category").Eq("vehicle").Index("Category").
+				AndGroup(badgerhold.Where("ID").Eq(1).Or(badgerhold.Where("ID").Eq(3))),
+			match: func(i ItemTest) bool {
+				return i.Category == "vehicle" && (i.ID == 1 || i.ID == 3)
+			},
+		},
+		{
+			name: "Key in a group",
+			query: badgerhold.Group(badgerhold.Where(badgerhold.Key).Eq(0).Or(badgerhold.Where(badgerhold.Key).Eq(2))).
+				AndGroup(badgerhold.Where("Category").Ne("animal")),
+			match: func(i ItemTest) bool {
+				return (i.Key == 0 || i.Key == 2) && i.Category != "animal"
+			},
+		},
+		{
+			name:  "Not of an And group",
+			query: badgerhold.Not(badgerhold.Where("Category").Eq("vehicle").And("ID").Gt(1)),
+			match: func(i ItemTest) bool {
+				return !(i.Category == "vehicle" && i.ID > 1)
+			},
+		},
+		{
+			name:  "Not of an Or group",
+			query: badgerhold.Not(badgerhold.Where("Category").Eq("vehicle").Or(badgerhold.Where("ID").Gt(10))),
+			match: func(i ItemTest) bool {
+				return !(i.Category == "vehicle" || i.ID > 10)
+			},
+		},
+		{
+			name:  "And with a Not",
+			query: badgerhold.Where("Category").Eq("animal").Not(badgerhold.Where("Name").Eq("fish")),
+			match: func(i ItemTest) bool {
+				return i.Category == "animal" && !(i.Name == "fish")
+			},
+		},
+		{
+			name: "Or of a Not",
+			query: badgerhold.Not(badgerhold.Where("Category").Ne("food")).
+				Or(badgerhold.Where("Name").Eq("seal")),
+			match: func(i ItemTest) bool {
+				return !(i.Category != "food") || i.Name == "seal"
+			},
+		},
+		{
+			name:  "Not of a Not",
+			query: badgerhold.Not(badgerhold.Not(badgerhold.Where("Category").Eq("food"))),
+			match: func(i ItemTest) bool {
+				return i.Category == "food"
+			},
+		},
+		{
+			name: "Not in a group",
+			query: badgerhold.Where("ID").Gt(2).AndGroup(badgerhold.Not(badgerhold.Where("Category").Eq("vehicle")).
+				Or(badgerhold.Where("Name").Eq("car"))),
+			match: func(i ItemTest) bool {
+				return i.ID > 2 && (!(i.Category == "vehicle") || i.Name == "car")
+			},
+		},
+		{
+			name: "Indexed with a Not on the index",
+			query: badgerhold.Where("Category").Ne("food").Index("Category").
+				Not(badgerhold.Where("Category").Eq("animal").Index("Category")),
+			match: func(i ItemTest) bool {
+				return i.Category != "food" && !(i.Category == "animal")
+			},
+		},
+		{
+			name: "Group that can't match",
+			query: badgerhold.Where("Category").Eq("vehicle").
+				AndGroup(badgerhold.Where("Category").Eq("animal").Or(badgerhold.Where("Category").Eq("food"))),
+			match: func(i ItemTest) bool {
+				return false
+			},
+		},
+	}
+
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				var result []ItemTest
+				err := store.Find(&result, tc.query)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+
+				found := make(map[int]bool, len(result))
+				for i := range result {
+					found[result[i].Key] = true
+				}
+
+				wanted := 0
+				for i := range testData {
+					if !tc.match(testData[i]) {
+						continue
+					}
+					wanted++
+					if !found[testData[i].Key] {
+						t.Fatalf("Record %d wasn't returned by %s", testData[i].Key, tc.query)
+					}
+				}
+
+				if len(result) != wanted {
+					t.Fatalf("Returned %d records wanted %d for %s", len(result), wanted, tc.query)
+				}
+			})
+		}
+	})
+}
+
+func TestFindNoIndex(t *testing.T) {
+	var stats []badgerhold.QueryStats
+
+	opt := testOptions()
+	opt.QueryObserver = func(s badgerhold.QueryStats) {
+		stats = append(stats, s)
+	}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+
+	find := func(result interface{}, query *badgerhold.Query) badgerhold.QueryStats {
+		stats = nil
+		err := store.Find(result, query)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(stats) != 1 {
+			t.Fatalf("Observed %d queries wanted 1", len(stats))
+		}
+		return stats[0]
+	}
+
+	var indexed, scanned []ItemTest
+	indexStats := find(&indexed, badgerhold.Where("Category").Eq("vehicle").Index("Category"))
+	scanStats := find(&scanned, badgerhold.Where("Category").Eq("vehicle").Index("Category").NoIndex())
+	if len(indexed) != len(scanned) {
+		t.Fatalf("NoIndex found %d records, the index found %d", len(scanned), len(indexed))
+	}
+	if indexStats.Index != "Category" || scanStats.Index != "" {
+		t.Fatalf("Queries used the indexes %q and %q wanted Category and none", indexStats.Index, scanStats.Index)
+	}
+	if scanStats.Scanned != len(testData) {
+		t.Fatalf("NoIndex scanned %d keys wanted %d", scanStats.Scanned, len(testData))
+	}
+
+	for i := 0; i < 20; i++ {
+		err = store.Insert(timeKey(i*10), &Reading{Value: i})
+		if err != nil {
+			t.Fatalf("Error inserting reading: %s", err)
+		}
+	}
+
+	var seeked, all []Reading
+	seekStats := find(&seeked, badgerhold.Where(badgerhold.Key).Ge(timeKey(150)))
+	scanStats = find(&all, badgerhold.Where(badgerhold.Key).Ge(timeKey(150)).NoIndex())
+	if len(seeked) != 5 || len(all) != 5 {
+		t.Fatalf("Key range queries found %d and %d records wanted 5", len(seeked), len(all))
+	}
+	if seekStats.Scanned != 5 || scanStats.Scanned != 20 {
+		t.Fatalf("Key seek scanned %d keys and NoIndex scanned %d wanted 5 and 20", seekStats.Scanned,
+			scanStats.Scanned)
+	}
+
+	find(&scanned, badgerhold.Where("Name").Eq("test").Index("BadIndex").NoIndex())
+}
+
+type Shift struct {
+	ID    int
+	Start time.Time `badgerholdIndex:"Start"`
+	End   *time.Time
+}
+
+func TestFindTimeComponents(t *testing.T) {
+	est := time.FixedZone("EST", -5*60*60)
+	date := func(month time.Month, day, hour int) time.Time {
+		return time.Date(2019, month, day, hour, 30, 0, 0, time.UTC)
+	}
+	end := date(time.December, 2, 23)
+
+	shifts := []Shift{
+		{ID: 0, Start: date(time.December, 2, 9), End: &end}, // Monday
+		{ID: 1, Start: date(time.December, 3, 2)},            // Tuesday, but Monday in EST
+		{ID: 2, Start: date(time.November, 30, 16)},          // Saturday
+		{ID: 3, Start: date(time.January, 1, 17)},            // Tuesday, 12:30 in EST
+		{ID: 4, Start: date(time.December, 31, 23).In(est)},  // Tuesday, 18:30 in EST
+		{ID: 5, Start: date(time.January, 1, 3)},             // Tuesday, but Monday in December in EST
+	}
+
+	tests := []struct {
+		name   string
+		query  *badgerhold.Query
+		result []int
+	}{
+		{
+			name:   "Weekday",
+			query:  badgerhold.Where("Start").Weekday(time.Monday),
+			result: []int{0},
+		},
+		{
+			name:   "Weekday in Location",
+			query:  badgerhold.Where("Start").Location(est).Weekday(time.Monday),
+			result: []int{0, 1, 5},
+		},
+		{
+			name:   "Multiple Weekdays",
+			query:  badgerhold.Where("Start").Weekday(time.Saturday, time.Sunday),
+			result: []int{2},
+		},
+		{
+			name:   "Month",
+			query:  badgerhold.Where("Start").Month(time.December),
+			result: []int{0, 1, 4},
+		},
+		{
+			name:   "Month in Location",
+			query:  badgerhold.Where("Start").Location(est).Month(time.December),
+			result: []int{0, 1, 4, 5},
+		},
+		{
+			name:   "HourRange",
+			query:  badgerhold.Where("Start").HourRange(9, 17),
+			result: []int{0, 2},
+		},
+		{
+			name:   "HourRange in Location",
+			query:  badgerhold.Where("Start").Location(est).HourRange(9, 17),
+			result: []int{2, 3},
+		},
+		{
+			name:   "HourRange past midnight",
+			query:  badgerhold.Where("Start").HourRange(22, 3),
+			result: []int{1, 4},
+		},
+		{
+			name:   "Pointer field",
+			query:  badgerhold.Where("End").HourRange(20, 24),
+			result: []int{0},
+		},
+		{
+			name:   "Combined with other criteria",
+			query:  badgerhold.Where("Start").Month(time.December).And("Start").Weekday(time.Tuesday).Index("Start"),
+			result: []int{1, 4},
+		},
+	}
+
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for i := range shifts {
+			err := store.Insert(shifts[i].ID, shifts[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				var result []Shift
+				err := store.Find(&result, tc.query.SortBy("ID"))
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+
+				ids := make([]int, len(result))
+				for i := range result {
+					ids[i] = result[i].ID
+				}
+				if !reflect.DeepEqual(ids, tc.result) {
+					t.Fatalf("Expected %v, got %v", tc.result, ids)
+				}
+			})
+		}
+
+		t.Run("Not a Time", func(t *testing.T) {
+			var result []Shift
+			err := store.Find(&result, badgerhold.Where("ID").Weekday(time.Monday))
+			if err == nil {
+				t.Fatalf("Weekday didn't fail on an int field")
+			}
+		})
+
+		t.Run("Invalid HourRange", func(t *testing.T) {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatalf("HourRange didn't panic on an hour out of range")
+				}
+			}()
+			badgerhold.Where("Start").HourRange(9, 25)
+		})
+	})
+}
+
+func TestLengthCriteria(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var result []ItemTest
+		err := store.Find(&result, badgerhold.Where("ID").LenEq(1))
+		if err == nil {
+			t.Fatalf("LenEq didn't fail on an int field")
+		}
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("LenEq didn't panic on the Key")
+			}
+		}()
+		badgerhold.Where(badgerhold.Key).LenEq(1)
+	})
+}
+
+func TestFindSkipErrors(t *testing.T) {
+	type Note struct {
+		ID   int
+		Text string
+	}
+
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for i := 0; i < 20; i++ {
+			err := store.Insert(i, &Note{ID: i, Text: fmt.Sprintf("note %d", i)})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		var corrupt [][]byte
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			prefix := []byte("bh:Note:")
+			i := 0
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				if i == 3 || i == 7 {
+					corrupt = append(corrupt, iter.Item().KeyCopy(nil))
+				}
+				i++
+			}
+
+			for _, key := range corrupt {
+				err := tx.Set(key, []byte("not a record"))
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error corrupting records: %s", err)
+		}
+
+		checkSkipped := func(t *testing.T, err error) {
+			skipped, ok := err.(*badgerhold.ErrSkippedRecords)
+			if !ok {
+				t.Fatalf("Expected an ErrSkippedRecords, got %v", err)
+			}
+			if len(skipped.Errors) != len(corrupt) {
+				t.Fatalf("Expected %d skipped records, got %d", len(corrupt), len(skipped.Errors))
+			}
+			for i := range corrupt {
+				if !bytes.Equal(skipped.Errors[i].Key, corrupt[i]) {
+					t.Fatalf("Expected the skipped key %q, got %q", corrupt[i], skipped.Errors[i].Key)
+				}
+				if skipped.Errors[i].Err == nil {
+					t.Fatalf("Skipped record %q has no error", skipped.Errors[i].Key)
+				}
+			}
+		}
+
+		t.Run("Strict", func(t *testing.T) {
+			var result []Note
+			err := store.Find(&result, nil)
+			if err == nil {
+				t.Fatalf("Find didn't fail on a corrupt record")
+			}
+			if _, ok := err.(*badgerhold.ErrSkippedRecords); ok {
+				t.Fatalf("Find skipped records without SkipErrors")
+			}
+		})
+
+		t.Run("Find", func(t *testing.T) {
+			var result []Note
+			err := store.Find(&result, badgerhold.Where("ID").Ge(0).SkipErrors())
+			checkSkipped(t, err)
+			if len(result) != 20-len(corrupt) {
+				t.Fatalf("Expected %d results, got %d", 20-len(corrupt), len(result))
+			}
+		})
+
+		t.Run("Or", func(t *testing.T) {
+			var result []Note
+			err := store.Find(&result, badgerhold.Where("ID").Lt(0).
+				Or(badgerhold.Where("Text").HasPrefix("note")).SkipErrors())
+			checkSkipped(t, err)
+			if len(result) != 20-len(corrupt) {
+				t.Fatalf("Expected %d results, got %d", 20-len(corrupt), len(result))
+			}
+		})
+
+		t.Run("Parallel", func(t *testing.T) {
+			var result []Note
+			err := store.Find(&result, badgerhold.Where("ID").Ge(0).Parallel(4).SkipErrors())
+			checkSkipped(t, err)
+			if len(result) != 20-len(corrupt) {
+				t.Fatalf("Expected %d results, got %d", 20-len(corrupt), len(result))
+			}
+		})
+
+		t.Run("FindOne", func(t *testing.T) {
+			var result Note
+			err := store.FindOne(&result, badgerhold.Where("ID").Eq(15).SkipErrors())
+			checkSkipped(t, err)
+			if result.ID != 15 {
+				t.Fatalf("Expected the note 15, got %d", result.ID)
+			}
+		})
+
+		t.Run("Aggregate", func(t *testing.T) {
+			result, err := store.FindAggregate(&Note{}, (&badgerhold.Query{}).SkipErrors())
+			checkSkipped(t, err)
+			if len(result) != 1 || result[0].Count() != 20-len(corrupt) {
+				t.Fatalf("Expected %d records in the aggregate", 20-len(corrupt))
+			}
+		})
+
+		t.Run("Delete", func(t *testing.T) {
+			err := store.DeleteMatching(&Note{}, badgerhold.Where("ID").Ge(0).SkipErrors())
+			if err == nil {
+				t.Fatalf("DeleteMatching didn't fail on a corrupt record")
+			}
+			if _, ok := err.(*badgerhold.ErrSkippedRecords); ok {
+				t.Fatalf("DeleteMatching skipped records")
+			}
+
+			var result []Note
+			err = store.Find(&result, badgerhold.Where("ID").Ge(0).SkipErrors())
+			checkSkipped(t, err)
+			if len(result) != 20-len(corrupt) {
+				t.Fatalf("DeleteMatching deleted records")
+			}
+		})
+	})
+}
+
+func TestFindPRSSkipErrors(t *testing.T) {
+	type Note struct {
+		ID   int
+		Text string
+	}
+
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		kuncian := "tenant:"
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			for i := 0; i < 20; i++ {
+				err := store.TxInsertPRS(tx, i, &Note{ID: i, Text: fmt.Sprintf("note %d", i)}, kuncian)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		corrupt := 0
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			var keys [][]byte
+			prefix := []byte("bh:" + kuncian + "Note:")
+			i := 0
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				if i == 3 || i == 7 {
+					keys = append(keys, iter.Item().KeyCopy(nil))
+				}
+				i++
+			}
+
+			for _, key := range keys {
+				err := tx.Set(key, []byte("not a record"))
+				if err != nil {
+					return err
+				}
+				corrupt++
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error corrupting records: %s", err)
+		}
+		if corrupt != 2 {
+			t.Fatalf("Corrupted %d records wanted 2", corrupt)
+		}
+
+		checkSkipped := func(t *testing.T, err error) {
+			skipped, ok := err.(*badgerhold.ErrSkippedRecords)
+			if !ok {
+				t.Fatalf("Expected an ErrSkippedRecords, got %v", err)
+			}
+			if len(skipped.Errors) != corrupt {
+				t.Fatalf("Expected %d skipped records, got %d", corrupt, len(skipped.Errors))
+			}
+		}
+
+		t.Run("Find", func(t *testing.T) {
+			var result []Note
+			err := store.FindPRS(&result, badgerhold.Where("ID").Ge(0).SkipErrors(), kuncian)
+			checkSkipped(t, err)
+			if len(result) != 20-corrupt {
+				t.Fatalf("Expected %d results, got %d", 20-corrupt, len(result))
+			}
+		})
+
+		t.Run("Parallel", func(t *testing.T) {
+			var expected []Note
+			err := store.FindPRS(&expected, badgerhold.Where("ID").Ge(0).SkipErrors(), kuncian)
+			checkSkipped(t, err)
+
+			var result []Note
+			err = store.FindPRS(&result, badgerhold.Where("ID").Ge(0).Parallel(4).SkipErrors(), kuncian)
+			checkSkipped(t, err)
+			if len(result) != len(expected) {
+				t.Fatalf("Parallel result count is %d wanted %d", len(result), len(expected))
+			}
+			for i := range result {
+				if result[i] != expected[i] {
+					t.Fatalf("Parallel result %d is %v wanted %v", i, result[i], expected[i])
+				}
+			}
+		})
+
+		t.Run("Aggregate", func(t *testing.T) {
+			result, err := store.FindAggregatePRS(&Note{}, (&badgerhold.Query{}).SkipErrors(), kuncian)
+			checkSkipped(t, err)
+			if len(result) != 1 || result[0].Count() != 20-corrupt {
+				t.Fatalf("Expected %d records in the aggregate", 20-corrupt)
+			}
+		})
+	})
+}
+
+type Order struct {
+	ID         int
+	CustomerID string `badgerholdIndex:"CustomerID"`
+	Total      int
+}
+
+func TestFindJoin(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		customers := map[string]*Customer{
+			"alice": {Name: "Alice", Region: "north"},
+			"bob":   {Name: "Bob", Region: "south"},
+			"carol": {Name: "Carol", Region: "north"},
+		}
+		for key, customer := range customers {
+			err := store.Insert(key, customer)
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		orders := []Order{
+			{ID: 0, CustomerID: "alice", Total: 10},
+			{ID: 1, CustomerID: "bob", Total: 20},
+			{ID: 2, CustomerID: "carol", Total: 30},
+			{ID: 3, CustomerID: "alice", Total: 40},
+			{ID: 4, CustomerID: "dave", Total: 50},
+		}
+		for i := range orders {
+			err := store.Insert(orders[i].ID, orders[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			name         string
+			query        *badgerhold.Query
+			foreignQuery *badgerhold.Query
+			result       []int
+		}{
+			{
+				name:         "Join",
+				foreignQuery: badgerhold.Where("Region").Eq("north"),
+				result:       []int{0, 2, 3},
+			},
+			{
+				name:         "Join with a query",
+				query:        badgerhold.Where("Total").Gt(10),
+				foreignQuery: badgerhold.Where("Region").Eq("north"),
+				result:       []int{2, 3},
+			},
+			{
+				name:         "Join with an Or",
+				query:        badgerhold.Where("Total").Eq(10).Or(badgerhold.Where("Total").Ge(20)),
+				foreignQuery: badgerhold.Where("Region").Eq("south"),
+				result:       []int{1},
+			},
+			{
+				name:         "Join with an index",
+				query:        badgerhold.Where("CustomerID").Ne("carol").Index("CustomerID"),
+				foreignQuery: badgerhold.Where("Region").Eq("north"),
+				result:       []int{0, 3},
+			},
+			{
+				name:   "Join all",
+				result: []int{0, 1, 2, 3},
+			},
+			{
+				name:         "No foreign matches",
+				foreignQuery: badgerhold.Where("Region").Eq("west"),
+				result:       []int{},
+			},
+		}
+
+		for _, tc := range tests {
+			t.Run(tc.name, func(t *testing.T) {
+				query := tc.query
+				if query == nil {
+					query = &badgerhold.Query{}
+				}
+
+				var result []Order
+				err := store.FindJoin(&result, query.SortBy("ID"), "CustomerID", &Customer{}, tc.foreignQuery)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+
+				ids := make([]int, len(result))
+				for i := range result {
+					ids[i] = result[i].ID
+				}
+				if !reflect.DeepEqual(ids, tc.result) {
+					t.Fatalf("Expected %v, got %v", tc.result, ids)
+				}
+			})
+		}
+
+		t.Run("Missing field", func(t *testing.T) {
+			var result []Order
+			err := store.FindJoin(&result, nil, "Customer", &Customer{}, nil)
+			if err == nil {
+				t.Fatalf("FindJoin didn't fail on a missing field")
+			}
+		})
+	})
+}
+
+type SupportTicket struct {
+	ID     string `badgerhold:"key"`
+	Queue  string `badgerholdIndex:"Queue"`
+	Points int
+}
+
+func TestFindKeys(t *testing.T) {
+	var stats []badgerhold.QueryStats
+	opt := testOptions()
+	opt.QueryObserver = func(s badgerhold.QueryStats) {
+		stats = append(stats, s)
+	}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	for i, queue := range []string{"support", "billing", "support", "sales", "support"} {
+		id := fmt.Sprintf("T-%d", i)
+		err = store.Insert(id, &SupportTicket{ID: id, Queue: queue, Points: i})
+		if err != nil {
+			t.Fatalf("Error inserting ticket: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name    string
+		query   *badgerhold.Query
+		keys    string
+		decoded bool
+	}{
+		{"All", nil, "[T-0 T-1 T-2 T-3 T-4]", false},
+		{"Index", badgerhold.Where("Queue").Eq("support").Index("Queue"), "[T-0 T-2 T-4]", false},
+		{"Key", badgerhold.Where(badgerhold.Key).Gt("T-2"), "[T-3 T-4]", true},
+		{"Limit", badgerhold.Where("Queue").Eq("support").Index("Queue").Skip(1).Limit(1), "[T-2]", false},
+		{"Unindexed", badgerhold.Where("Points").Ge(3), "[T-3 T-4]", true},
+		{"Or", badgerhold.Where("Queue").Eq("sales").Index("Queue").
+			Or(badgerhold.Where("Queue").Eq("billing").Index("Queue")), "[T-3 T-1]", true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			stats = nil
+			keys, err := store.FindKeys(&SupportTicket{}, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding keys: %s", err)
+			}
+			if fmt.Sprint(keys) != tst.keys {
+				t.Fatalf("FindKeys returned %v wanted %s", keys, tst.keys)
+			}
+			for i := range keys {
+				if _, ok := keys[i].(string); !ok {
+					t.Fatalf("FindKeys returned a %T key wanted a string", keys[i])
+				}
+			}
+			if len(stats) != 1 || (stats[0].Decoded != 0) != tst.decoded {
+				t.Fatalf("FindKeys stats are %+v", stats)
+			}
+		})
+	}
+
+	_, err = store.FindKeys(&ItemTest{}, nil)
+	if err == nil {
+		t.Fatalf("FindKeys on a type without a key field didn't fail")
+	}
+}
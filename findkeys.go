@@ -0,0 +1,134 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// FindKeys returns the keys of the records of dataType that match the query, decoded into the type of dataType's key
+// field, the one tagged `badgerhold:"key"`.  When the query's only criteria are on its index, or on the Key, the
+// matching keys are read straight from the index, without retrieving or decoding the records
+func (s *Store) FindKeys(dataType interface{}, query *Query) ([]interface{}, error) {
+	var keys []interface{}
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		var err error
+		keys, err = s.TxFindKeys(tx, dataType, query)
+		return err
+	})
+	return keys, err
+}
+
+// TxFindKeys is the same as FindKeys, but you specify your own transaction
+func (s *Store) TxFindKeys(tx *badger.Txn, dataType interface{}, query *Query) ([]interface{}, error) {
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	keyType := keyFieldType(tp)
+	if keyType == nil {
+		return nil, fmt.Errorf("The type %s has no key field to decode its keys into", tp)
+	}
+
+	query = s.preloaded(query)
+	query.begin()
+	defer query.end()
+
+	query.writable = false
+
+	typeName := newStorer(dataType, s.encodeValue).Type()
+	var keys []interface{}
+
+	err := runKeyQuery(tx, dataType, query, func(k []byte) error {
+		key := reflect.New(keyType)
+		err := decodeKey(k, key.Interface(), typeName, s.decodeValue)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, key.Elem().Interface())
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, query.skippedError()
+}
+
+// runKeyQuery calls action with the encoded key of every record that matches the query.  If the query's iterator
+// handles all of its criteria, only the keys are read, otherwise the query is run as usual
+func runKeyQuery(tx *badger.Txn, dataType interface{}, query *Query, action func(key []byte) error) error {
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	if !query.keysOnly(tp) || indexBuilding(tx, newStorer(dataType, query.store.encodeValue).Type(), query.index) {
+		// an index being built doesn't hold every record yet, so they're read and tested instead
+		return runQuery(tx, dataType, query, nil, query.skip, func(r *record) error {
+			return action(r.key)
+		})
+	}
+
+	query.dataType = tp
+	if query.boundType != nil && query.boundType != query.dataType {
+		return &ErrTypeMismatch{reflect.Zero(query.boundType).Interface(), reflect.Zero(tp).Interface()}
+	}
+
+	iter := newIterator(tx, newStorer(dataType, query.store.encodeValue).Type(), query, nil)
+	defer iter.Close()
+
+	if query.index != "" && query.badIndex {
+		return query.indexError()
+	}
+
+	skip := query.skip
+	limit := query.limit
+	for k, _ := iter.NextCounter(); k != nil; k, _ = iter.NextCounter() {
+		query.stats.matchedRecord()
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		err := action(k)
+		if err != nil {
+			return err
+		}
+
+		if query.limit != 0 {
+			limit--
+			if limit == 0 {
+				break
+			}
+		}
+	}
+
+	return iter.Error()
+}
+
+// keysOnly returns whether the query's matching keys can be found without reading the records of the type, which is
+// when the iterator for its index handles all of its criteria
+func (q *Query) keysOnly(tp reflect.Type) bool {
+	if len(q.sort) > 0 || q.ors != nil || q.groups != nil {
+		return false
+	}
+	if q.store.softDeletes(tp) && !q.includeDeleted {
+		return false
+	}
+
+	for field := range q.fieldCriteria {
+		if field != q.index || q.skipsIndex(field) || elementOperators(tp, field) != nil {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,165 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// BadgerHoldFullTextTag is the struct tag used to define a string field as searchable with Match, with an index entry
+// for each of the terms in the field
+const BadgerHoldFullTextTag = "badgerholdFullText"
+
+// Match tests if the current string field contains any of the terms in the passed in text.  Terms are the runs of
+// letters and numbers in the text, compared regardless of case.  Unless the query is sorted, the records it returns
+// are ranked by how many of the terms they contain, then by how often they contain them.  A field tagged with
+// badgerholdFullText has an index entry for each of its terms, so a Match query using its index only reads the records
+// that contain the terms
+// 	Where("Body").Match("embedded search").Index("Body")
+func (c *Criterion) Match(text string) *Query {
+	return c.op(mt, text)
+}
+
+// isFullText returns whether the field is tagged to be indexed by its terms
+func isFullText(field reflect.StructField) bool {
+	return field.Tag.Get(BadgerHoldFullTextTag) != ""
+}
+
+// terms splits the text into its lower cased runs of letters and numbers
+func terms(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// distinctTerms returns the terms of the text, each only once
+func distinctTerms(text string) []string {
+	all := terms(text)
+	seen := make(map[string]bool, len(all))
+	distinct := all[:0]
+	for _, term := range all {
+		if !seen[term] {
+			seen[term] = true
+			distinct = append(distinct, term)
+		}
+	}
+	return distinct
+}
+
+// fullTextValues returns the terms of the string field encoded with encode, the values it's indexed under
+func fullTextValues(fVal reflect.Value, encode EncodeFunc) ([][]byte, error) {
+	found := distinctTerms(fVal.String())
+	values := make([][]byte, 0, len(found))
+	for _, term := range found {
+		encoded, err := indexEncode(term, encode)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encoded)
+	}
+
+	return values, nil
+}
+
+// testMatch tests the Match criterion against the terms of the field, or against a single term read from a full text
+// index
+func (c *Criterion) testMatch(value interface{}, term bool) (bool, error) {
+	wanted := distinctTerms(c.value.(string))
+	if term {
+		for i := range wanted {
+			if wanted[i] == value {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+
+	score, err := matchScore(value, wanted)
+	return score.terms > 0, err
+}
+
+// relevance is how well a record matches the terms of a Match criterion
+type relevance struct {
+	terms       int // distinct terms found
+	occurrences int // times they were found
+}
+
+// matchScore returns the relevance of the string field to the wanted terms
+func matchScore(value interface{}, wanted []string) (relevance, error) {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.String {
+		return relevance{}, fmt.Errorf("Match can only be used on string fields, not %T", value)
+	}
+
+	counts := make(map[string]int, len(wanted))
+	for _, term := range wanted {
+		counts[term] = 0
+	}
+	for _, term := range terms(v.String()) {
+		if _, ok := counts[term]; ok {
+			counts[term]++
+		}
+	}
+
+	var score relevance
+	for _, count := range counts {
+		if count > 0 {
+			score.terms++
+			score.occurrences += count
+		}
+	}
+	return score, nil
+}
+
+// ranked returns whether the query's records are ordered by their relevance to its Match criteria
+func (q *Query) ranked() bool {
+	if len(q.sort) > 0 || q.unranked {
+		return false
+	}
+	for _, criteria := range q.fieldCriteria {
+		for _, c := range criteria {
+			if c.operator == mt {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// recordRelevance returns the relevance of the record to all of the query's Match criteria
+func (q *Query) recordRelevance(r *record) relevance {
+	var total relevance
+	for field, criteria := range q.fieldCriteria {
+		for _, c := range criteria {
+			if c.operator != mt {
+				continue
+			}
+
+			fVal, err := fieldValue(r.value.Elem(), field, q.store.fieldNameTag)
+			if err != nil {
+				continue
+			}
+			score, err := matchScore(fVal.Interface(), distinctTerms(c.value.(string)))
+			if err != nil {
+				continue
+			}
+			total.terms += score.terms
+			total.occurrences += score.occurrences
+		}
+	}
+	return total
+}
+
+// moreRelevant returns whether the record a is more relevant to the query's Match criteria than b
+func moreRelevant(query *Query, a, b *record) bool {
+	ra, rb := query.recordRelevance(a), query.recordRelevance(b)
+	if ra.terms != rb.terms {
+		return ra.terms > rb.terms
+	}
+	return ra.occurrences > rb.occurrences
+}
@@ -0,0 +1,324 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// BadgerHoldGeoTag is the struct tag used to define a GeoPoint field as indexed by its geohash, so WithinRadius and
+// WithinBox queries using the index only read the records in and around the area
+const BadgerHoldGeoTag = "badgerholdGeo"
+
+// GeoPoint is a location in degrees of latitude and longitude
+type GeoPoint struct {
+	Lat float64
+	Lon float64
+}
+
+var geoPointType = reflect.TypeOf(GeoPoint{})
+
+const (
+	// geohashPrecision is the number of geohash characters the points of a geo index are stored with, about 4cm
+	geohashPrecision = 12
+	// geoMaxCells is the most geohash cells a query searches the index for, larger areas use shorter cells
+	geoMaxCells = 32
+	// earthRadius is the mean radius of the earth in meters
+	earthRadius = 6371008.8
+	// metersPerDegree is the length of a degree of latitude in meters
+	metersPerDegree = earthRadius * math.Pi / 180
+)
+
+const geohashAlphabet = "0123456789bcdefghjkmnpqrstuvwxyz"
+
+// geoArea is the area a geo criterion matches, either the points within radius meters of center, or within the box
+// between min and max.  The box of a circle is the circle's bounding box
+type geoArea struct {
+	circle bool
+	center GeoPoint
+	radius float64
+	min    GeoPoint
+	max    GeoPoint
+}
+
+// WithinRadius tests if the current GeoPoint field is within the passed in distance, in meters, of the location.
+// Distances are great circle distances on a spherical earth
+// 	Where("Location").WithinRadius(48.8566, 2.3522, 5000).Index("Location")
+func (c *Criterion) WithinRadius(lat, lon, meters float64) *Query {
+	if meters < 0 {
+		panic("The radius of WithinRadius can't be negative")
+	}
+
+	// the bounding box of the circle
+	latDelta := meters / metersPerDegree
+	area := &geoArea{
+		circle: true,
+		center: GeoPoint{Lat: lat, Lon: lon},
+		radius: meters,
+		min:    GeoPoint{Lat: math.Max(lat-latDelta, -90), Lon: -180},
+		max:    GeoPoint{Lat: math.Min(lat+latDelta, 90), Lon: 180},
+	}
+	if cos := math.Cos(lat * math.Pi / 180); area.min.Lat > -90 && area.max.Lat < 90 && cos > 0 {
+		if lonDelta := latDelta / cos; lonDelta < 180 {
+			area.min.Lon, area.max.Lon = lon-lonDelta, lon+lonDelta
+		}
+	}
+	return c.op(geo, area)
+}
+
+// WithinBox tests if the current GeoPoint field is within the box between the passed in corners, inclusive.  If minLon
+// is greater than maxLon, the box crosses the antimeridian
+func (c *Criterion) WithinBox(minLat, minLon, maxLat, maxLon float64) *Query {
+	if minLat > maxLat {
+		panic("The minimum latitude of WithinBox can't be greater than its maximum")
+	}
+	if maxLon < minLon {
+		maxLon += 360
+	}
+	return c.op(geo, &geoArea{
+		min: GeoPoint{Lat: minLat, Lon: minLon},
+		max: GeoPoint{Lat: maxLat, Lon: maxLon},
+	})
+}
+
+func (a *geoArea) String() string {
+	if a.circle {
+		return fmt.Sprintf("within %gm of %v", a.radius, a.center)
+	}
+	return fmt.Sprintf("within %v and %v", a.min, a.max)
+}
+
+// contains returns whether the point is in the area
+func (a *geoArea) contains(p GeoPoint) bool {
+	if a.circle {
+		return distance(a.center, p) <= a.radius
+	}
+
+	if p.Lat < a.min.Lat || p.Lat > a.max.Lat {
+		return false
+	}
+	// compare the longitude in the range of the box, which may run past 180
+	lon := p.Lon
+	for lon < a.min.Lon {
+		lon += 360
+	}
+	return lon <= a.max.Lon
+}
+
+// distance returns the great circle distance between the points in meters
+func distance(a, b GeoPoint) float64 {
+	lat1, lat2 := a.Lat*math.Pi/180, b.Lat*math.Pi/180
+	dLat := lat2 - lat1
+	dLon := (b.Lon - a.Lon) * math.Pi / 180
+
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(h)))
+}
+
+// testGeo tests the geo criterion against the GeoPoint field
+func (c *Criterion) testGeo(value interface{}) (bool, error) {
+	switch p := value.(type) {
+	case GeoPoint:
+		return c.value.(*geoArea).contains(p), nil
+	case *GeoPoint:
+		if p == nil {
+			return false, nil
+		}
+		return c.value.(*geoArea).contains(*p), nil
+	default:
+		return false, fmt.Errorf("WithinRadius and WithinBox can only be used on GeoPoint fields, not %T", value)
+	}
+}
+
+// isGeo returns whether the field is tagged to be indexed by its geohash
+// panics if the field isn't a GeoPoint
+func isGeo(field reflect.StructField) bool {
+	if field.Tag.Get(BadgerHoldGeoTag) == "" {
+		return false
+	}
+	if field.Type != geoPointType && field.Type != reflect.PtrTo(geoPointType) {
+		panic("Invalid Type for Storer.  The field " + field.Name + " isn't a GeoPoint, so it can't be geo indexed")
+	}
+	return true
+}
+
+// geohashBits returns the number of bits of longitude and latitude in a geohash of the precision
+func geohashBits(precision int) (lonBits, latBits uint) {
+	bits := uint(precision * 5)
+	return (bits + 1) / 2, bits / 2
+}
+
+// geohashCell returns the cell of the geohash grid of the precision the point is in, counted from -180 and -90
+func geohashCell(p GeoPoint, precision int) (lonCell, latCell uint64) {
+	lonBits, latBits := geohashBits(precision)
+	return gridCell(p.Lon, -180, 360, lonBits), gridCell(p.Lat, -90, 180, latBits)
+}
+
+func gridCell(value, start, span float64, bits uint) uint64 {
+	cells := uint64(1) << bits
+	cell := math.Floor((value - start) / span * float64(cells))
+	switch {
+	case cell < 0:
+		return 0
+	case cell >= float64(cells):
+		return cells - 1
+	}
+	return uint64(cell)
+}
+
+// geohash returns the geohash of the cell of the grid of the precision
+func geohash(lonCell, latCell uint64, precision int) string {
+	lonBits, latBits := geohashBits(precision)
+
+	hash := make([]byte, precision)
+	var char, bit uint
+	for i, bits := uint(0), lonBits+latBits; i < bits; i++ {
+		// bits alternate between longitude and latitude, starting with longitude
+		var set uint64
+		if i%2 == 0 {
+			lonBits--
+			set = (lonCell >> lonBits) & 1
+		} else {
+			latBits--
+			set = (latCell >> latBits) & 1
+		}
+
+		char = char<<1 | uint(set)
+		bit++
+		if bit == 5 {
+			hash[i/5] = geohashAlphabet[char]
+			char, bit = 0, 0
+		}
+	}
+	return string(hash)
+}
+
+// pointGeohash returns the geohash a point is indexed under
+func pointGeohash(p GeoPoint) string {
+	lonCell, latCell := geohashCell(p, geohashPrecision)
+	return geohash(lonCell, latCell, geohashPrecision)
+}
+
+// geoValue returns the geohash of the GeoPoint field encoded with encode, or nil for a nil pointer
+func geoValue(fVal reflect.Value, encode EncodeFunc) ([]byte, error) {
+	if fVal.Kind() == reflect.Ptr {
+		if fVal.IsNil() {
+			return nil, nil
+		}
+		fVal = fVal.Elem()
+	}
+	return indexEncode(pointGeohash(fVal.Interface().(GeoPoint)), encode)
+}
+
+// cells returns the geohashes of the cells covering the area, with the longest geohashes that keep their number
+// within geoMaxCells, sorted
+func (a *geoArea) cells() []string {
+	for precision := geohashPrecision; precision > 0; precision-- {
+		lonBits, _ := geohashBits(precision)
+		lonCells := uint64(1) << lonBits
+
+		lonFrom, latFrom := geohashCell(GeoPoint{Lat: a.min.Lat, Lon: math.Max(a.min.Lon, -180)}, precision)
+		lonTo, latTo := geohashCell(GeoPoint{Lat: a.max.Lat, Lon: math.Min(a.max.Lon, 180)}, precision)
+
+		// longitudes past 180 or before -180 wrap around the antimeridian
+		lonRanges := [][2]uint64{{lonFrom, lonTo}}
+		if a.max.Lon > 180 {
+			lonRanges = append(lonRanges, [2]uint64{0, gridCell(a.max.Lon-360, -180, 360, lonBits)})
+		}
+		if a.min.Lon < -180 {
+			lonRanges = append(lonRanges, [2]uint64{gridCell(a.min.Lon+360, -180, 360, lonBits), lonCells - 1})
+		}
+
+		var count uint64
+		for _, r := range lonRanges {
+			count += (r[1] - r[0] + 1) * (latTo - latFrom + 1)
+		}
+		if count > geoMaxCells && precision > 1 {
+			continue
+		}
+
+		seen := make(map[string]bool)
+		var hashes []string
+		for _, r := range lonRanges {
+			for lon := r[0]; lon <= r[1]; lon++ {
+				for lat := latFrom; lat <= latTo; lat++ {
+					hash := geohash(lon, lat, precision)
+					if !seen[hash] {
+						seen[hash] = true
+						hashes = append(hashes, hash)
+					}
+				}
+			}
+		}
+		sort.Strings(hashes)
+		return hashes
+	}
+	return nil
+}
+
+// geoKeys returns the nextKeys func of an iterator reading the record keys in the geohash cells covering the area from
+// a geo index.  The records are still tested against the criteria, as the cells are larger than the area
+func (i *iterator) geoKeys(typeName string, query *Query, area *geoArea,
+	cacheSize int) func(*badger.Iterator) ([][]byte, [][]byte, error) {
+	query.stats.usedIndex(query.index)
+	indexPrefix := indexKeyPrefix(typeName, query.index)
+
+	var cursor keyCursor = iteratorCursor{i.iter}
+	if keys, ok := query.preloads.lookup(i.tx, indexPrefix); ok {
+		cursor = &sliceCursor{keys: keys}
+	}
+
+	cells := area.cells()
+	var prefix []byte
+	// nextCell seeks to the start of the next cell, and returns false if there are none left
+	nextCell := func() bool {
+		if len(cells) == 0 {
+			return false
+		}
+		prefix = append(append([]byte{}, indexPrefix...), cells[0]...)
+		cells = cells[1:]
+		cursor.Seek(prefix)
+		return true
+	}
+	more := nextCell()
+
+	return func(*badger.Iterator) ([][]byte, [][]byte, error) {
+		var nKeys [][]byte
+
+		for more && len(nKeys) < cacheSize {
+			if !cursor.ValidForPrefix(prefix) {
+				more = nextCell()
+				continue
+			}
+			if query.expired() {
+				return nil, nil, ErrQueryTimeout
+			}
+
+			key := cursor.KeyCopy()
+			_, _, member, ok := splitIndexKey(indexPrefix, key)
+			if !ok {
+				return nil, nil, fmt.Errorf("The index entry %q is corrupt", key)
+			}
+
+			i.lastSeek = key
+			if len(member) == 0 {
+				// the header of a unique value
+				query.stats.scannedKey()
+			} else {
+				// each record is only in one cell
+				nKeys = append(nKeys, member)
+			}
+			cursor.Next()
+		}
+
+		// the index only holds the record keys, their values have to be retrieved separately
+		return nKeys, nil, nil
+	}
+}
@@ -6,15 +6,36 @@ package badgerhold
 
 import (
 	"errors"
+	"reflect"
 
 	"github.com/dgraph-io/badger"
 )
 
-// ErrNotFound is returned when no data is found for the given key
-var ErrNotFound = errors.New("No data found for this key")
+// ErrNotFound is returned when no data is found for the given key.  It wraps badger.ErrKeyNotFound, so
+// errors.Is matches either of them
+var ErrNotFound error = &wrappedError{"No data found for this key", badger.ErrKeyNotFound}
+
+// wrappedError is a sentinel error of the package that wraps the badger error it stands in for
+type wrappedError struct {
+	msg string
+	err error
+}
+
+func (e *wrappedError) Error() string {
+	return e.msg
+}
+
+// Unwrap returns the badger error
+func (e *wrappedError) Unwrap() error {
+	return e.err
+}
 
 // Get retrieves a value from badgerhold and puts it into result.  Result must be a pointer
 func (s *Store) Get(key, result interface{}) error {
+	if s.getCache != nil {
+		return s.cachedGet(key, result)
+	}
+
 	return s.Badger().View(func(tx *badger.Txn) error {
 		return s.TxGet(tx, key, result)
 	})
@@ -23,9 +44,9 @@ func (s *Store) Get(key, result interface{}) error {
 // TxGet allows you to pass in your own badger transaction to retrieve a value from the badgerhold and puts it
 // into result
 func (s *Store) TxGet(tx *badger.Txn, key, result interface{}) error {
-	storer := newStorer(result)
+	storer := newStorer(result, s.encodeValue)
 
-	gk, err := encodeKey(key, storer.Type())
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
 
 	if err != nil {
 		return err
@@ -35,12 +56,128 @@ func (s *Store) TxGet(tx *badger.Txn, key, result interface{}) error {
 	if err == badger.ErrKeyNotFound {
 		return ErrNotFound
 	}
+	if err != nil {
+		return err
+	}
 
 	return item.Value(func(value []byte) error {
-		return decode(value, result)
+		return s.decode(value, result)
+	})
+}
+
+// GetOK is the same as Get, except a missing key isn't an error.  found is false if there is no record for the key,
+// and err is only set for genuine failures, such as a decode error
+func (s *Store) GetOK(key, result interface{}) (found bool, err error) {
+	err = s.Get(key, result)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// TxGetOK is the same as GetOK, but allows you to specify your own transaction
+func (s *Store) TxGetOK(tx *badger.Txn, key, result interface{}) (bool, error) {
+	err := s.TxGet(tx, key, result)
+	if errors.Is(err, ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
+// GetMany retrieves the records for all of the passed in keys in a single transaction.  Result must be a pointer to a
+// slice, which is set to one record for each key, in the same order as the keys.  If any of the keys don't exist,
+// ErrNotFound is returned.  Use GetManyOK to skip missing keys instead
+func (s *Store) GetMany(keys []interface{}, result interface{}) error {
+	return s.Badger().View(func(tx *badger.Txn) error {
+		return s.TxGetMany(tx, keys, result)
 	})
 }
 
+// TxGetMany is the same as GetMany, but allows you to specify your own transaction
+func (s *Store) TxGetMany(tx *badger.Txn, keys []interface{}, result interface{}) error {
+	found, err := s.TxGetManyOK(tx, keys, result)
+	if err != nil {
+		return err
+	}
+
+	for i := range found {
+		if !found[i] {
+			return ErrNotFound
+		}
+	}
+	return nil
+}
+
+// GetManyOK is the same as GetMany, except missing keys aren't an error.  The entry in result for a missing key is
+// left as the zero value, or nil for a slice of pointers, and found reports which keys exist
+func (s *Store) GetManyOK(keys []interface{}, result interface{}) (found []bool, err error) {
+	err = s.Badger().View(func(tx *badger.Txn) error {
+		found, err = s.TxGetManyOK(tx, keys, result)
+		return err
+	})
+	return found, err
+}
+
+// TxGetManyOK is the same as GetManyOK, but allows you to specify your own transaction
+func (s *Store) TxGetManyOK(tx *badger.Txn, keys []interface{}, result interface{}) ([]bool, error) {
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+
+	sliceVal := reflect.MakeSlice(resultVal.Elem().Type(), len(keys), len(keys))
+	elType := sliceVal.Type().Elem()
+
+	tp := elType
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	storer := newStorer(reflect.New(tp).Interface(), s.encodeValue)
+	found := make([]bool, len(keys))
+
+	for i := range keys {
+		gk, err := encodeKey(keys[i], storer.Type(), s.encodeValue)
+		if err != nil {
+			return nil, err
+		}
+
+		item, err := tx.Get(gk)
+		if err == badger.ErrKeyNotFound {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		value := reflect.New(tp)
+		err = item.Value(func(v []byte) error {
+			return s.decode(v, value.Interface())
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if elType.Kind() == reflect.Ptr {
+			sliceVal.Index(i).Set(value)
+		} else {
+			sliceVal.Index(i).Set(value.Elem())
+		}
+		found[i] = true
+	}
+
+	resultVal.Elem().Set(sliceVal)
+	return found, nil
+}
+
 // Find retrieves a set of values from the badgerhold that matches the passed in query
 // result must be a pointer to a slice.
 // The result of the query will be appended to the passed in result slice, rather than the passed in slice being
@@ -51,6 +188,20 @@ func (s *Store) Find(result interface{}, query *Query) error {
 	})
 }
 
+// FindOne retrieves a single record from the badgerhold that matches the passed in query, and puts it into result.
+// Result must be a pointer.  The query stops at the first matching record, and if no records match, ErrNotFound
+// is returned
+func (s *Store) FindOne(result interface{}, query *Query) error {
+	return s.Badger().View(func(tx *badger.Txn) error {
+		return s.TxFindOne(tx, result, query)
+	})
+}
+
+// TxFindOne allows you to pass in your own badger transaction to retrieve a single record from the badgerhold
+func (s *Store) TxFindOne(tx *badger.Txn, result interface{}, query *Query) error {
+	return findOneQuery(tx, result, s.preloaded(query))
+}
+
 // FindPRS retrieves a set of values from the badgerhold that matches the passed in query
 // result must be a pointer to a slice.
 // The result of the query will be appended to the passed in result slice, rather than the passed in slice being
@@ -63,12 +214,12 @@ func (s *Store) FindPRS(result interface{}, query *Query, kuncian string) error
 
 // TxFind allows you to pass in your own badger transaction to retrieve a set of values from the badgerhold
 func (s *Store) TxFind(tx *badger.Txn, result interface{}, query *Query) error {
-	return findQuery(tx, result, query)
+	return findQuery(tx, result, s.preloaded(query))
 }
 
 // TxFindPRS allows you to pass in your own badger transaction to retrieve a set of values from the badgerhold
 func (s *Store) TxFindPRS(tx *badger.Txn, result interface{}, query *Query, kuncian string) error {
-	return findQueryPRS(tx, result, query, kuncian)
+	return findQueryPRS(tx, result, s.preloaded(query), kuncian)
 }
 
 func (s *Store) GetSourceCount(result interface{}, query *Query, kuncian string, count *int) error {
@@ -78,5 +229,5 @@ func (s *Store) GetSourceCount(result interface{}, query *Query, kuncian string,
 }
 
 func (s *Store) TxGetSourceCount(tx *badger.Txn, result interface{}, query *Query, kuncian string, count *int) error {
-	return countSource(tx, result, query, kuncian, count)
+	return countSource(tx, result, s.preloaded(query), kuncian, count)
 }
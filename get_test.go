@@ -5,9 +5,15 @@
 package badgerhold_test
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/timshannon/badgerhold"
 )
 
@@ -35,3 +41,329 @@ func TestGet(t *testing.T) {
 		}
 	})
 }
+
+func TestGetOK(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type LookupItem struct {
+			Name  string
+			Value decodeFailer
+		}
+
+		err := store.Insert("exists", &LookupItem{Name: "exists", Value: "ok"})
+		if err != nil {
+			t.Fatalf("Error creating data for get test: %s", err)
+		}
+
+		err = store.Insert("corrupt", &LookupItem{Name: "corrupt", Value: "fail"})
+		if err != nil {
+			t.Fatalf("Error creating data for get test: %s", err)
+		}
+
+		result := &LookupItem{}
+		found, err := store.GetOK("exists", result)
+		if err != nil {
+			t.Fatalf("Error getting data from badgerhold: %s", err)
+		}
+		if !found || result.Name != "exists" {
+			t.Fatalf("GetOK returned found %t and %v for an existing key", found, result)
+		}
+
+		found, err = store.GetOK("missing", &LookupItem{})
+		if err != nil {
+			t.Fatalf("GetOK returned an error for a missing key: %s", err)
+		}
+		if found {
+			t.Fatalf("GetOK found a missing key")
+		}
+
+		found, err = store.GetOK("corrupt", &LookupItem{})
+		if err == nil {
+			t.Fatalf("GetOK didn't return an error for a value that failed to decode")
+		}
+		if found {
+			t.Fatalf("GetOK returned found along with an error")
+		}
+	})
+}
+
+func TestGetMany(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		keys := []interface{}{testData[3].Key, testData[0].Key, testData[7].Key}
+
+		var result []ItemTest
+		err := store.GetMany(keys, &result)
+		if err != nil {
+			t.Fatalf("Error getting many records: %s", err)
+		}
+
+		if len(result) != len(keys) {
+			t.Fatalf("GetMany returned %d records wanted %d", len(result), len(keys))
+		}
+		for i := range keys {
+			if !result[i].equal(&testData[keys[i].(int)]) {
+				t.Fatalf("Record %d is %v wanted %v", i, result[i], testData[keys[i].(int)])
+			}
+		}
+
+		keys = []interface{}{testData[1].Key, 1000, testData[2].Key}
+
+		err = store.GetMany(keys, &result)
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("GetMany with a missing key returned %v wanted %s", err, badgerhold.ErrNotFound)
+		}
+
+		var ptrResult []*ItemTest
+		found, err := store.GetManyOK(keys, &ptrResult)
+		if err != nil {
+			t.Fatalf("Error getting many records: %s", err)
+		}
+
+		if len(found) != 3 || !found[0] || found[1] || !found[2] {
+			t.Fatalf("GetManyOK found %v wanted [true false true]", found)
+		}
+		if len(ptrResult) != 3 || ptrResult[1] != nil {
+			t.Fatalf("GetManyOK didn't leave a nil gap for the missing key: %v", ptrResult)
+		}
+		if !ptrResult[0].equal(&testData[1]) || !ptrResult[2].equal(&testData[2]) {
+			t.Fatalf("GetManyOK returned the wrong records: %v", ptrResult)
+		}
+	})
+}
+
+func TestGetCache(t *testing.T) {
+	opt := testOptions()
+	opt.GetCacheSize = 10
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	get := func(t *testing.T, key string, want string) {
+		t.Helper()
+		result := &ItemTest{}
+		err := store.Get(key, result)
+		if want == "" {
+			if err != badgerhold.ErrNotFound {
+				t.Fatalf("Expected ErrNotFound, got %v", err)
+			}
+			return
+		}
+		if err != nil {
+			t.Fatalf("Error getting data from badgerhold: %s", err)
+		}
+		if result.Name != want {
+			t.Fatalf("Got %q, wanted %q", result.Name, want)
+		}
+	}
+
+	t.Run("Writes", func(t *testing.T) {
+		key := "writes"
+		get(t, key, "")
+
+		err := store.Insert(key, &ItemTest{Name: "inserted"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		get(t, key, "inserted")
+		get(t, key, "inserted")
+
+		err = store.Update(key, &ItemTest{Name: "updated"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		get(t, key, "updated")
+
+		err = store.Upsert(key, &ItemTest{Name: "upserted"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		get(t, key, "upserted")
+
+		err = store.UpdateMatching(&ItemTest{}, badgerhold.Where("Name").Eq("upserted"),
+			func(record interface{}) error {
+				record.(*ItemTest).Name = "matched"
+				return nil
+			})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		get(t, key, "matched")
+
+		err = store.Delete(key, &ItemTest{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		get(t, key, "")
+
+		err = store.Insert(key, &ItemTest{Name: "reinserted"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		get(t, key, "reinserted")
+
+		err = store.DeleteMatching(&ItemTest{}, badgerhold.Where("Name").Eq("reinserted"))
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		get(t, key, "")
+	})
+
+	t.Run("Hit", func(t *testing.T) {
+		key := "hit"
+		err := store.Insert(key, &ItemTest{Name: "cached"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		get(t, key, "cached")
+
+		// a write made behind the store's back is only seen if the cache goes to badger
+		encoded, err := badgerhold.DefaultEncode(key)
+		if err != nil {
+			t.Fatalf("Error encoding key: %s", err)
+		}
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			return tx.Delete(append([]byte("bh:ItemTest:"), encoded...))
+		})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		get(t, key, "cached")
+	})
+
+	t.Run("Own Transaction", func(t *testing.T) {
+		key := "own"
+		err := store.Insert(key, &ItemTest{Name: "before"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		get(t, key, "before")
+
+		tx := store.Badger().NewTransaction(true)
+		err = store.TxUpdate(tx, key, &ItemTest{Name: "after"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		// the update isn't committed yet
+		get(t, key, "before")
+
+		err = tx.Commit()
+		if err != nil {
+			t.Fatalf("Error committing: %s", err)
+		}
+		get(t, key, "after")
+	})
+
+	t.Run("Eviction", func(t *testing.T) {
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("evict%d", i)
+			err := store.Insert(key, &ItemTest{Name: key})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+			get(t, key, key)
+		}
+		for i := 0; i < 30; i++ {
+			key := fmt.Sprintf("evict%d", i)
+			get(t, key, key)
+		}
+	})
+
+	t.Run("Concurrent", func(t *testing.T) {
+		key := "concurrent"
+		err := store.Insert(key, &ItemTest{Name: "0", ID: 0})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		done := make(chan struct{})
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for {
+					select {
+					case <-done:
+						return
+					default:
+					}
+					result := &ItemTest{}
+					_ = store.Get(key, result)
+				}
+			}()
+		}
+
+		for i := 1; i <= 200; i++ {
+			err := store.Update(key, &ItemTest{Name: strconv.Itoa(i), ID: i})
+			if err != nil {
+				t.Fatalf("Error updating data: %s", err)
+			}
+			get(t, key, strconv.Itoa(i))
+		}
+		close(done)
+		wg.Wait()
+	})
+}
+
+func TestErrorsIs(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Get("missing", &ItemTest{})
+		if !errors.Is(err, badgerhold.ErrNotFound) || !errors.Is(err, badger.ErrKeyNotFound) {
+			t.Fatalf("Getting a missing key returned %v wanted ErrNotFound wrapping badger.ErrKeyNotFound", err)
+		}
+
+		// callers wrapping the error can still match it
+		found, err := store.GetOK("missing", &ItemTest{})
+		if err != nil || found {
+			t.Fatalf("GetOK returned %t and %v for a missing key", found, err)
+		}
+		err = store.Badger().View(func(tx *badger.Txn) error {
+			return fmt.Errorf("Looking up a record: %w", store.TxGet(tx, "missing", &ItemTest{}))
+		})
+		if !errors.Is(err, badgerhold.ErrNotFound) {
+			t.Fatalf("Wrapped error %v wasn't matched as ErrNotFound", err)
+		}
+
+		type TestUnique struct {
+			Name string `badgerhold:"unique"`
+		}
+		err = store.Insert("one", &TestUnique{Name: "one"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		err = store.Insert("two", &TestUnique{Name: "one"})
+		if !errors.Is(err, badgerhold.ErrUniqueConstraint) {
+			t.Fatalf("Inserting a duplicate unique value returned %v wanted ErrUniqueConstraint", err)
+		}
+		err = store.Insert("one", &TestUnique{Name: "two"})
+		if !errors.Is(err, badgerhold.ErrKeyExists) {
+			t.Fatalf("Inserting an existing key returned %v wanted ErrKeyExists", err)
+		}
+
+		err = store.Insert("item", &ItemTest{Name: "item"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		var mismatch *badgerhold.ErrTypeMismatch
+		var result []ItemTest
+		err = store.Find(&result, badgerhold.Where("Name").Eq(nil))
+		if !errors.As(err, &mismatch) {
+			t.Fatalf("Comparing mismatched types returned %v wanted an *ErrTypeMismatch", err)
+		}
+
+		// a wrapped conflict is still retried
+		attempts := 0
+		err = store.WithRetry(2, func(tx *badger.Txn) error {
+			attempts++
+			return fmt.Errorf("Writing a record: %w", badger.ErrConflict)
+		})
+		if !errors.Is(err, badger.ErrConflict) || attempts != 2 {
+			t.Fatalf("Wrapped conflict returned %v after %d attempts wanted ErrConflict after 2", err, attempts)
+		}
+	})
+}
@@ -0,0 +1,219 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"container/list"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// getCache is a least recently used cache of the encoded records read by Get, along with their badger versions.
+//
+// Every record write removes the record from the cache, and the writes made in transactions the store runs itself are
+// removed again once the transaction has committed, as a Get that started before the commit can still read and cache
+// the previous value.  Every removal also moves the cache to a new epoch, and a read only adds its value to the cache
+// if no removals happened while it was running.  Writes made in a transaction the store doesn't run can't be tracked
+// to their commit, so after the first one, cached records are only used once their version is checked against badger
+type getCache struct {
+	sync.Mutex
+	size     int
+	entries  map[string]*list.Element
+	lru      *list.List
+	epoch    uint64
+	validate bool
+	pending  map[*badger.Txn][][]byte
+}
+
+type getCacheEntry struct {
+	key     string
+	value   []byte
+	version uint64
+}
+
+// newGetCache returns a cache of size records, or nil if size isn't positive
+func newGetCache(size int) *getCache {
+	if size <= 0 {
+		return nil
+	}
+
+	return &getCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		lru:     list.New(),
+		pending: make(map[*badger.Txn][][]byte),
+	}
+}
+
+// lookup returns the cached record for the key, and whether its version needs to be checked before it's used
+func (c *getCache) lookup(key []byte) (entry getCacheEntry, validate, ok bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[string(key)]
+	if !ok {
+		return getCacheEntry{}, false, false
+	}
+
+	c.lru.MoveToFront(element)
+	return *element.Value.(*getCacheEntry), c.validate, true
+}
+
+// currentEpoch returns the epoch to pass to add for a read that's about to start
+func (c *getCache) currentEpoch() uint64 {
+	c.Lock()
+	defer c.Unlock()
+
+	return c.epoch
+}
+
+// add caches a copy of the record read from badger, unless a record was written since epoch
+func (c *getCache) add(key, value []byte, version, epoch uint64) {
+	c.Lock()
+	defer c.Unlock()
+
+	if c.epoch != epoch {
+		return
+	}
+
+	if element, ok := c.entries[string(key)]; ok {
+		c.lru.Remove(element)
+	}
+
+	c.entries[string(key)] = c.lru.PushFront(&getCacheEntry{
+		key:     string(key),
+		value:   append([]byte{}, value...),
+		version: version,
+	})
+
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*getCacheEntry).key)
+	}
+}
+
+// remove removes the keys from the cache and starts a new epoch
+func (c *getCache) remove(keys ...[]byte) {
+	c.Lock()
+	defer c.Unlock()
+
+	c.epoch++
+	for i := range keys {
+		if element, ok := c.entries[string(keys[i])]; ok {
+			c.lru.Remove(element)
+			delete(c.entries, string(keys[i]))
+		}
+	}
+}
+
+// purge removes every record from the cache, such as after a Restore
+func (c *getCache) purge() {
+	c.Lock()
+	defer c.Unlock()
+
+	c.epoch++
+	c.entries = make(map[string]*list.Element)
+	c.lru.Init()
+}
+
+// written removes a record written in tx from the cache, and again once tx commits if it's run by the store
+func (c *getCache) written(tx *badger.Txn, key []byte) {
+	c.remove(key)
+
+	c.Lock()
+	defer c.Unlock()
+
+	if keys, ok := c.pending[tx]; ok {
+		c.pending[tx] = append(keys, append([]byte{}, key...))
+		return
+	}
+	c.validate = true
+}
+
+// tracked wraps fn so the records it writes are tracked to its transaction's commit.  settle needs to be called once
+// the transaction has committed or failed
+func (c *getCache) tracked(fn func(tx *badger.Txn) error) (wrapped func(tx *badger.Txn) error, settle func()) {
+	var txn *badger.Txn
+
+	wrapped = func(tx *badger.Txn) error {
+		txn = tx
+		c.Lock()
+		c.pending[tx] = nil
+		c.Unlock()
+		return fn(tx)
+	}
+
+	settle = func() {
+		if txn == nil {
+			return
+		}
+
+		c.Lock()
+		keys := c.pending[txn]
+		delete(c.pending, txn)
+		c.Unlock()
+
+		c.remove(keys...)
+	}
+
+	return wrapped, settle
+}
+
+// recordWritten removes the record with the encoded key from the Get cache, if the store has one, and drops the
+// preloaded indexes of its type
+func (s *Store) recordWritten(tx *badger.Txn, key []byte) {
+	if s.getCache != nil {
+		s.getCache.written(tx, key)
+	}
+	s.preloads.written(tx, key)
+}
+
+// purgeCaches empties the Get cache and drops every preloaded index, such as after records are written without going
+// through recordWritten
+func (s *Store) purgeCaches() {
+	if s.getCache != nil {
+		s.getCache.purge()
+	}
+	s.preloads.purge()
+}
+
+// cachedGet is Get for stores with a Get cache
+func (s *Store) cachedGet(key, result interface{}) error {
+	gk, err := encodeKey(key, newStorer(result, s.encodeValue).Type(), s.encodeValue)
+	if err != nil {
+		return err
+	}
+
+	entry, validate, ok := s.getCache.lookup(gk)
+	if ok && !validate {
+		return s.decode(entry.value, result)
+	}
+
+	epoch := s.getCache.currentEpoch()
+	return s.Badger().View(func(tx *badger.Txn) error {
+		item, err := tx.Get(gk)
+		if err == badger.ErrKeyNotFound {
+			return ErrNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if ok && item.Version() == entry.version {
+			return s.decode(entry.value, result)
+		}
+
+		return item.Value(func(value []byte) error {
+			err := s.decode(value, result)
+			if err != nil {
+				return err
+			}
+			s.getCache.add(gk, value, item.Version(), epoch)
+			return nil
+		})
+	})
+}
@@ -1,5 +1,7 @@
 module github.com/paquesid/badgerhold
 
+go 1.18
+
 require (
 	github.com/AndreasBriese/bbloom v0.0.0-20180913140656-343706a395b7 // indirect
 	github.com/davecgh/go-spew v1.1.1 // indirect
@@ -0,0 +1,95 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"crypto/sha256"
+	"reflect"
+)
+
+// BadgerHoldHashTag is the struct tag used to index a string or []byte field by a fixed size hash of its value rather
+// than the value itself, which keeps the index keys of large values small.  Only Eq and In criteria can use the index,
+// and the records it finds are still tested against the criteria
+const BadgerHoldHashTag = "badgerholdHash"
+
+// hashSize is the number of bytes of the sha256 of a value a hash index stores
+const hashSize = 16
+
+// isHashed returns whether the field is tagged to be indexed by the hash of its value
+// panics if the field isn't a string or []byte
+func isHashed(field reflect.StructField) bool {
+	if field.Tag.Get(BadgerHoldHashTag) == "" {
+		return false
+	}
+	if field.Type.Kind() != reflect.String &&
+		(field.Type.Kind() != reflect.Slice || field.Type.Elem().Kind() != reflect.Uint8) {
+		panic("Invalid Type for Storer.  The field " + field.Name + " isn't a string or []byte, so it can't be hashed")
+	}
+	return true
+}
+
+// hashValue returns the hash a string or []byte value is indexed under, and false for any other value, which can't be
+// equal to the field's
+func hashValue(value interface{}) (string, bool) {
+	// named types, such as type Email string, hash the same as their underlying string or []byte
+	var data []byte
+	v := reflect.ValueOf(value)
+	switch {
+	case v.Kind() == reflect.String:
+		data = []byte(v.String())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		data = v.Bytes()
+	default:
+		return "", false
+	}
+
+	sum := sha256.Sum256(data)
+	return string(sum[:hashSize]), true
+}
+
+// hashIndexValue returns the hash of the field encoded with encode, or nil for a nil []byte
+func hashIndexValue(fVal reflect.Value, encode EncodeFunc) ([]byte, error) {
+	if fVal.Kind() == reflect.Slice && fVal.IsNil() {
+		return nil, nil
+	}
+
+	hash, _ := hashValue(fVal.Interface())
+	return indexEncode(hash, encode)
+}
+
+// isHashedIndex returns whether the query's index is the struct tag index of a hashed field
+func isHashedIndex(tp reflect.Type, indexName string) bool {
+	field, ok := tagIndexField(tp, indexName)
+	return ok && isHashed(field)
+}
+
+// hashCriteria returns copies of the Eq and In criteria with their values hashed, so they can be tested against the
+// entries of a hash index
+func hashCriteria(criteria []*Criterion) []*Criterion {
+	hashed := make([]*Criterion, 0, len(criteria))
+	for _, c := range criteria {
+		if c.operator != eq && c.operator != in {
+			continue
+		}
+
+		entry := *c
+		if c.operator == eq {
+			hash, ok := hashValue(c.value)
+			if !ok {
+				continue
+			}
+			entry.value = hash
+		} else {
+			entry.inValues = make([]interface{}, 0, len(c.inValues))
+			for k := range c.inValues {
+				if hash, ok := hashValue(c.inValues[k]); ok {
+					entry.inValues = append(entry.inValues, hash)
+				}
+			}
+		}
+		hashed = append(hashed, &entry)
+	}
+	return hashed
+}
@@ -6,28 +6,185 @@ package badgerhold
 
 import (
 	"bytes"
+	"fmt"
+	"math"
 	"reflect"
 	"sort"
+	"strings"
 
 	"github.com/dgraph-io/badger"
 )
 
-const indexPrefix = "_bhIndex"
+// indexPrefix starts the badger keys of every index.  Each record in an index has its own key, made up of the
+// index's prefix, the escaped index value, a terminator, and the record's key, which all start with the header of the
+// index value.  Adding or removing a record from an index only writes its own key, however many other records share
+// the value
+const indexPrefix = "_bhIdx"
+
+// oldIndexPrefix starts the badger keys of the indexes written by older versions of badgerhold, which stored the keys
+// of every record with an index value in a single keyList
+const oldIndexPrefix = "_bhIndex"
 
 // size of iterator keys stored in memory before more are fetched
 const iteratorKeyMinCacheSize = 100
 
 // Index is a function that returns the indexable, encoded bytes of the passed in value
+// If MultiValueFunc is set, it's used instead of IndexFunc, and the value is indexed under each of the
+// encoded values it returns.
+// If Condition is set, only the values it returns true for are indexed, so queries using the index only find those
+// If Covers is set, the values of those fields are stored with each entry, so queries that Select only them can be
+// answered from the index
 type Index struct {
-	IndexFunc func(name string, value interface{}) ([]byte, error)
-	Unique    bool
+	IndexFunc      func(name string, value interface{}) ([]byte, error)
+	MultiValueFunc func(name string, value interface{}) ([][]byte, error)
+	Unique         bool
+	Condition      func(value interface{}) bool
+	Covers         []string
+}
+
+// values returns all of the encoded index values for the passed in value
+func (i Index) values(name string, value interface{}) ([][]byte, error) {
+	if i.Condition != nil && !i.Condition(value) {
+		return nil, nil
+	}
+
+	if i.MultiValueFunc != nil {
+		return i.MultiValueFunc(name, value)
+	}
+
+	indexValue, err := i.IndexFunc(name, value)
+	if err != nil {
+		return nil, err
+	}
+	if indexValue == nil {
+		return nil, nil
+	}
+
+	return [][]byte{indexValue}, nil
+}
+
+// AddIndex adds an index to every record of dataType, in addition to any indexes defined by its struct tags or
+// Storer interface.  This allows indexing computed values, such as the lower case of a field, which can then be
+// queried with Query.Index(name).  The IndexFunc should encode its values with the store's Encoder, so they can be
+// compared with the query's criteria.
+// Indexes added this way only last as long as the store is open, so they should be added every time it's opened. If
+// the index doesn't contain anything yet, it's built from any existing records of dataType, with its entries written in
+// batches.  An error is returned if dataType already has an index with the same name
+func (s *Store) AddIndex(dataType interface{}, name string, index Index) error {
+	storer := newStorer(dataType, s.encodeValue)
+	typeName := storer.Type()
+
+	// whether the index has entries is checked before it's added, so entries written for records stored in the meantime
+	// don't stop it being built
+	built, err := s.hasPrefix(indexKeyPrefix(typeName, name))
+	if err != nil {
+		return err
+	}
+
+	s.indexLock.Lock()
+	_, defined := storer.Indexes()[name]
+	_, added := s.indexes[typeName][name]
+	if defined || added {
+		s.indexLock.Unlock()
+		return fmt.Errorf("The type %s already has an index named %s", typeName, name)
+	}
+	if s.indexes[typeName] == nil {
+		s.indexes[typeName] = make(map[string]Index)
+	}
+	s.indexes[typeName][name] = index
+	s.indexLock.Unlock()
+
+	if built {
+		return nil
+	}
+
+	err = s.rebuildIndex(dataType, typeName, name, index)
+	if err != nil {
+		s.indexLock.Lock()
+		delete(s.indexes[typeName], name)
+		s.indexLock.Unlock()
+		// the batches already written would stop the index being built when it's added again
+		s.deletePrefix(indexKeyPrefix(typeName, name))
+		return err
+	}
+
+	return nil
+}
+
+// DeleteIndex removes every entry of the index of dataType, such as an index whose struct tag has been removed, along
+// with any entries of it written by older versions of badgerhold.  An index added with AddIndex is removed from the
+// store as well.  An error is returned if dataType still defines the index with its struct tags or Storer interface,
+// as its entries would be written again.  The entries are deleted in batches, so writes to the type aren't blocked
+func (s *Store) DeleteIndex(dataType interface{}, indexName string) error {
+	storer := newStorer(dataType, s.encodeValue)
+	if _, ok := storer.Indexes()[indexName]; ok {
+		return fmt.Errorf("The index %s is still defined by the type %s", indexName, storer.Type())
+	}
+
+	s.indexLock.Lock()
+	delete(s.indexes[storer.Type()], indexName)
+	s.indexLock.Unlock()
+
+	// the preloaded entries would outlive the index
+	s.preloads.purge()
+
+	for _, prefix := range [][]byte{
+		indexKeyPrefix(storer.Type(), indexName),
+		append(oldTypeIndexPrefix(storer.Type()), indexName+":"...),
+	} {
+		_, err := s.deletePrefix(prefix)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.update(func(tx *badger.Txn) error {
+		return tx.Delete(buildKey(storer.Type(), indexName))
+	})
+}
+
+// storer is the same as newStorer, except the returned Storer includes the indexes added to the store with AddIndex
+func (s *Store) storer(dataType interface{}) Storer {
+	storer := newStorer(dataType, s.encodeValue)
+
+	s.indexLock.RLock()
+	defer s.indexLock.RUnlock()
+
+	added := s.indexes[storer.Type()]
+	if len(added) == 0 {
+		return storer
+	}
+
+	indexes := make(map[string]Index, len(added))
+	for name, index := range storer.Indexes() {
+		indexes[name] = index
+	}
+	for name, index := range added {
+		indexes[name] = index
+	}
+
+	return &addedIndexStorer{
+		Storer:  storer,
+		indexes: indexes,
+	}
+}
+
+// addedIndexStorer adds indexes to a Storer
+type addedIndexStorer struct {
+	Storer
+	indexes map[string]Index
+}
+
+// Indexes returns the Storer's own indexes along with the added ones
+func (a *addedIndexStorer) Indexes() map[string]Index {
+	return a.indexes
 }
 
 // adds an item to the index
-func indexAdd(storer Storer, tx *badger.Txn, key []byte, data interface{}) error {
+func (s *Store) indexAdd(storer Storer, tx *badger.Txn, key []byte, data interface{}) error {
 	indexes := storer.Indexes()
 	for name, index := range indexes {
-		err := indexUpdate(storer.Type(), name, index, tx, key, data, false)
+		err := s.indexUpdate(storer.Type(), name, index, tx, key, data, false)
 		if err != nil {
 			return err
 		}
@@ -38,11 +195,11 @@ func indexAdd(storer Storer, tx *badger.Txn, key []byte, data interface{}) error
 
 // // removes an item from the index
 // // be sure to pass the data from the old record, not the new one
-func indexDelete(storer Storer, tx *badger.Txn, key []byte, originalData interface{}) error {
+func (s *Store) indexDelete(storer Storer, tx *badger.Txn, key []byte, originalData interface{}) error {
 	indexes := storer.Indexes()
 
 	for name, index := range indexes {
-		err := indexUpdate(storer.Type(), name, index, tx, key, originalData, true)
+		err := s.indexUpdate(storer.Type(), name, index, tx, key, originalData, true)
 		if err != nil {
 			return err
 		}
@@ -51,64 +208,240 @@ func indexDelete(storer Storer, tx *badger.Txn, key []byte, originalData interfa
 	return nil
 }
 
-// // adds or removes a specific index on an item
-func indexUpdate(typeName, indexName string, index Index, tx *badger.Txn, key []byte, value interface{},
-	delete bool) error {
+// indexValues returns the encoded values of each of the storer's indexes for data, so they can be passed to
+// indexReplace once data has changed
+func indexValues(storer Storer, data interface{}) (map[string][][]byte, error) {
+	indexes := storer.Indexes()
+	values := make(map[string][][]byte, len(indexes))
 
-	indexKey, err := index.IndexFunc(indexName, value)
-	if indexKey == nil {
-		return nil
+	for name, index := range indexes {
+		indexKeys, err := index.values(name, data)
+		if err != nil {
+			return nil, err
+		}
+		values[name] = indexKeys
 	}
 
-	indexValue := make(keyList, 0)
+	return values, nil
+}
+
+// indexReplace updates the indexes of an item whose index values were original, and are now those of data.  Only
+// the index values that changed are written, so updates that leave an indexed field alone don't rewrite its entries.
+// A nil original adds the item to every index
+func (s *Store) indexReplace(storer Storer, tx *badger.Txn, key []byte, original map[string][][]byte,
+	data interface{}) error {
+	for name, index := range storer.Indexes() {
+		indexKeys, err := index.values(name, data)
+		if err != nil {
+			return err
+		}
+		stored, err := index.coverValue(data, s.encodeValue)
+		if err != nil {
+			return err
+		}
 
+		for _, indexKey := range original[name] {
+			if containsValue(indexKeys, indexKey) {
+				continue
+			}
+			err = indexUpdateValue(storer.Type(), name, index.Unique, tx, key, indexKey, nil, true)
+			if err != nil {
+				return err
+			}
+		}
+
+		for _, indexKey := range indexKeys {
+			if containsValue(original[name], indexKey) && stored == nil {
+				// the covered fields may have changed even if the value hasn't
+				continue
+			}
+			err = indexUpdateValue(storer.Type(), name, index.Unique, tx, key, indexKey, stored, false)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func containsValue(values [][]byte, value []byte) bool {
+	for i := range values {
+		if bytes.Equal(values[i], value) {
+			return true
+		}
+	}
+	return false
+}
+
+// // adds or removes a specific index on an item
+func (s *Store) indexUpdate(typeName, indexName string, index Index, tx *badger.Txn, key []byte, value interface{},
+	delete bool) error {
+
+	indexKeys, err := index.values(indexName, value)
 	if err != nil {
 		return err
 	}
+	var stored []byte
+	if !delete {
+		stored, err = index.coverValue(value, s.encodeValue)
+		if err != nil {
+			return err
+		}
+	}
 
-	indexKey = append(indexKeyPrefix(typeName, indexName), indexKey...)
+	for i := range indexKeys {
+		err = indexUpdateValue(typeName, indexName, index.Unique, tx, key, indexKeys[i], stored, delete)
+		if err != nil {
+			return err
+		}
+	}
 
-	item, err := tx.Get(indexKey)
+	return nil
+}
+
+// adds or removes an item from the records stored under a single index value, along with the stored values of the
+// fields the index covers
+func indexUpdateValue(typeName, indexName string, unique bool, tx *badger.Txn, key, indexKey, stored []byte,
+	delete bool) error {
+
+	header := indexValueKey(indexKeyPrefix(typeName, indexName), indexKey)
+	member := append(append([]byte{}, header...), key...)
+
+	_, err := tx.Get(member)
 	if err != nil && err != badger.ErrKeyNotFound {
 		return err
 	}
+	if (err == nil) != delete {
+		// already added or removed
+		if !delete && stored != nil {
+			return tx.Set(member, stored)
+		}
+		return nil
+	}
+
+	if delete {
+		return indexRemove(tx, header, member)
+	}
 
-	if err != badger.ErrKeyNotFound {
-		if index.Unique && !delete {
+	if unique {
+		used, err := indexValueUsed(tx, header)
+		if err != nil {
+			return err
+		}
+		if used {
 			return ErrUniqueExists
 		}
-		err = item.Value(func(iVal []byte) error {
-			return decode(iVal, &indexValue)
-		})
+
+		// badger only detects conflicts on the keys a transaction reads, not the ranges it iterates, so a unique value
+		// has its header key written along with its record, to have concurrent writes of the same value conflict.  A
+		// unique value has a single record, so the header doesn't become a key every write shares
+		_, err = tx.Get(header)
+		if err != nil && err != badger.ErrKeyNotFound {
+			return err
+		}
+		err = tx.Set(header, []byte{})
 		if err != nil {
 			return err
 		}
 	}
 
-	if delete {
-		indexValue.remove(key)
-	} else {
-		indexValue.add(key)
+	if stored == nil {
+		stored = []byte{}
 	}
+	return tx.Set(member, stored)
+}
 
-	if len(indexValue) == 0 {
-		return tx.Delete(indexKey)
+// indexRemove removes a record's key from the records stored under the index value's header, along with the
+// header's own key, which only the values of unique indexes have
+func indexRemove(tx *badger.Txn, header, member []byte) error {
+	err := tx.Delete(member)
+	if err != nil {
+		return err
 	}
 
-	iVal, err := encode(indexValue)
+	_, err = tx.Get(header)
+	if err == badger.ErrKeyNotFound {
+		return nil
+	}
 	if err != nil {
 		return err
 	}
+	return tx.Delete(header)
+}
+
+// indexValueUsed returns whether any records are stored under the index value's header
+func indexValueUsed(tx *badger.Txn, header []byte) (bool, error) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	iter := tx.NewIterator(opts)
+	defer iter.Close()
 
-	return tx.Set(indexKey, iVal)
+	for iter.Seek(header); iter.ValidForPrefix(header); iter.Next() {
+		if !bytes.Equal(iter.Item().Key(), header) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// indexValueKey returns the header key of the index value, which the keys of the records with the value start with.
+// Zero bytes in the value are escaped, and it's terminated with a zero byte followed by a one, so the header keys
+// sort in the same order as their values, and a value can't run into the record keys that follow it
+func indexValueKey(prefix, value []byte) []byte {
+	key := make([]byte, 0, len(prefix)+len(value)+2)
+	key = append(key, prefix...)
+	for _, b := range value {
+		key = append(key, b)
+		if b == 0 {
+			key = append(key, 0xFF)
+		}
+	}
+	return append(key, 0, 1)
+}
+
+// splitIndexKey splits an index key into its header, unescaped value, and the key of the record it refers to, which
+// is empty for the header key itself.  ok is false if the key isn't a valid index key
+func splitIndexKey(prefix, key []byte) (header, value, member []byte, ok bool) {
+	for i := len(prefix); i+1 < len(key); i++ {
+		if key[i] != 0 {
+			value = append(value, key[i])
+			continue
+		}
+
+		i++
+		switch key[i] {
+		case 0xFF:
+			value = append(value, 0)
+		case 1:
+			return key[:i+1], value, key[i+1:], true
+		default:
+			return nil, nil, nil, false
+		}
+	}
+
+	return nil, nil, nil, false
 }
 
-// indexKeyPrefix returns the prefix of the badger key where this index is stored
+// skipIndexValue returns the first key after all of the record keys of an index value's header
+func skipIndexValue(header []byte) []byte {
+	skip := append([]byte{}, header...)
+	skip[len(skip)-1]++
+	return skip
+}
+
+// indexKeyPrefix returns the prefix of the badger key where this index is stored.  The index name is followed by a
+// separator, so that the index values that follow it can't be mistaken for part of the name
 func indexKeyPrefix(typeName, indexName string) []byte {
-	return []byte(indexPrefix + ":" + typeName + ":" + indexName)
+	return append(typeIndexPrefix(typeName), indexName+":"...)
+}
+
+// typeIndexPrefix returns the prefix of the badger keys of every index of the type
+func typeIndexPrefix(typeName string) []byte {
+	return []byte(indexPrefix + ":" + typeName + ":")
 }
 
-// keyList is a slice of unique, sorted keys([]byte) such as what an index points to
+// keyList is a slice of unique, sorted keys([]byte) such as the keys of the records a query has already retrieved
 type keyList [][]byte
 
 func (v *keyList) add(key []byte) {
@@ -126,18 +459,6 @@ func (v *keyList) add(key []byte) {
 	(*v)[i] = key
 }
 
-func (v *keyList) remove(key []byte) {
-	i := sort.Search(len(*v), func(i int) bool {
-		return bytes.Compare((*v)[i], key) >= 0
-	})
-
-	if i < len(*v) {
-		copy((*v)[i:], (*v)[i+1:])
-		(*v)[len(*v)-1] = nil
-		*v = (*v)[:len(*v)-1]
-	}
-}
-
 func (v *keyList) in(key []byte) bool {
 	i := sort.Search(len(*v), func(i int) bool {
 		return bytes.Compare((*v)[i], key) >= 0
@@ -167,14 +488,286 @@ func indexExists(it *badger.Iterator, typeName, indexName string) bool {
 	return false
 }
 
+// IndexEntry is a single value in an index, and the keys of the records with that value
+type IndexEntry struct {
+	// Value is the decoded index value, or nil if the type of the value isn't known, such as for indexes added with
+	// AddIndex
+	Value interface{}
+	// Encoded is the index value, as it's stored
+	Encoded []byte
+	// Keys are the badger keys of the records with the value
+	Keys [][]byte
+
+	header []byte
+}
+
+// DumpIndex returns every entry in the index of dataType, in the order they're stored.  It's meant for debugging,
+// such as for checking an index is consistent with the records it refers to, and only reads from the store
+func (s *Store) DumpIndex(dataType interface{}, indexName string) ([]IndexEntry, error) {
+	storer := s.storer(dataType)
+	if _, ok := storer.Indexes()[indexName]; !ok {
+		return nil, fmt.Errorf("The index %s does not exist", indexName)
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	valueType := indexValueType(tp, indexName)
+	prefix := indexKeyPrefix(storer.Type(), indexName)
+	var entries []IndexEntry
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			header, encoded, member, ok := splitIndexKey(prefix, iter.Item().KeyCopy(nil))
+			if !ok {
+				return fmt.Errorf("The index entry %q is corrupt", iter.Item().Key())
+			}
+
+			if len(member) == 0 {
+				// the header of a unique value
+				continue
+			}
+			if len(entries) != 0 && bytes.Equal(entries[len(entries)-1].header, header) {
+				entries[len(entries)-1].Keys = append(entries[len(entries)-1].Keys, member)
+				continue
+			}
+
+			entry := IndexEntry{
+				Encoded: encoded,
+				Keys:    [][]byte{member},
+				header:  header,
+			}
+
+			if valueType != nil {
+				value := reflect.New(valueType)
+				err := indexDecode(entry.Encoded, value.Interface(), s.decodeValue)
+				if err != nil {
+					return err
+				}
+				entry.Value = value.Elem().Interface()
+			}
+
+			entries = append(entries, entry)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
+
+// IndexStats describes how much an index holds, for deciding whether it's worth keeping
+type IndexStats struct {
+	Entries     int     // the number of index entries, one for each value of each record
+	Values      int     // the number of distinct values
+	AverageKeys float64 // the average number of records with each value
+	Bytes       int64   // the approximate number of bytes the index's keys and values take up in Badger
+}
+
+// IndexStats returns the IndexStats of the index of dataType.  Only the index's keys are read, and like SizeOf, the
+// size is Badger's estimate
+func (s *Store) IndexStats(dataType interface{}, indexName string) (IndexStats, error) {
+	storer := s.storer(dataType)
+	if _, ok := storer.Indexes()[indexName]; !ok {
+		return IndexStats{}, fmt.Errorf("The index %s does not exist", indexName)
+	}
+
+	prefix := indexKeyPrefix(storer.Type(), indexName)
+	var stats IndexStats
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+
+		var current []byte
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			header, _, member, ok := splitIndexKey(prefix, iter.Item().KeyCopy(nil))
+			if !ok {
+				return fmt.Errorf("The index entry %q is corrupt", iter.Item().Key())
+			}
+			stats.Bytes += iter.Item().EstimatedSize()
+
+			if len(member) == 0 {
+				// the header of a unique value
+				continue
+			}
+			stats.Entries++
+			if !bytes.Equal(header, current) {
+				stats.Values++
+				current = header
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return IndexStats{}, err
+	}
+
+	if stats.Values > 0 {
+		stats.AverageKeys = float64(stats.Entries) / float64(stats.Values)
+	}
+	return stats, nil
+}
+
+// indexValueType returns the type of the values in the index of the struct type tp, if it's an index defined by a
+// struct tag
+func indexValueType(tp reflect.Type, indexName string) reflect.Type {
+	field, ok := tagIndexField(tp, indexName)
+	if !ok {
+		return nil
+	}
+
+	if field.Type.Kind() == reflect.Map || isFullText(field) || isGeo(field) || isHashed(field) {
+		// map entries are indexed as key=value strings, text by its terms, points by their geohash, and hashed fields
+		// by their hash
+		return reflect.TypeOf("")
+	}
+	if elementKind(field.Type) {
+		// each element is indexed separately
+		return field.Type.Elem()
+	}
+	return field.Type
+}
+
+// number of entries of each index decoded by ValidateIndexes
+const validateIndexSampleSize = 100
+
+// ErrInvalidIndexes is returned by ValidateIndexes, and by Open with Options.ValidateIndexes set, when indexes are
+// missing or corrupt.  Indexes are named as type.index
+type ErrInvalidIndexes struct {
+	Missing []string
+	Corrupt []string
+}
+
+func (e *ErrInvalidIndexes) Error() string {
+	var problems []string
+	if len(e.Missing) > 0 {
+		problems = append(problems, "missing indexes: "+strings.Join(e.Missing, ", "))
+	}
+	if len(e.Corrupt) > 0 {
+		problems = append(problems, "corrupt indexes: "+strings.Join(e.Corrupt, ", "))
+	}
+	return "Invalid badgerhold indexes, " + strings.Join(problems, "; ")
+}
+
+// ValidateIndexes checks that every index of the passed in data types exists, if there are any records of the type,
+// and that the first entries of each index decode.  Only a sample of each index is decoded, so it stays fast on large
+// stores, but won't find every corrupt entry.  Indexes on pointer fields that are nil in every record have no entries,
+// and are reported as missing.  An *ErrInvalidIndexes is returned describing every problem found
+func (s *Store) ValidateIndexes(dataTypes ...interface{}) error {
+	invalid := &ErrInvalidIndexes{}
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for _, dataType := range dataTypes {
+			storer := s.storer(dataType)
+
+			names := make([]string, 0, len(storer.Indexes()))
+			for name := range storer.Indexes() {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				indexName := storer.Type() + "." + name
+				if !indexExists(iter, storer.Type(), name) {
+					invalid.Missing = append(invalid.Missing, indexName)
+					continue
+				}
+
+				if !sampleIndex(iter, indexKeyPrefix(storer.Type(), name)) {
+					invalid.Corrupt = append(invalid.Corrupt, indexName)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if len(invalid.Missing) > 0 || len(invalid.Corrupt) > 0 {
+		return invalid
+	}
+	return nil
+}
+
+// sampleIndex returns false if any of the first keys in the index aren't valid index keys, or don't refer to a record
+func sampleIndex(iter *badger.Iterator, prefix []byte) bool {
+	sampled := 0
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix) && sampled < validateIndexSampleSize; iter.Next() {
+		sampled++
+
+		_, _, member, ok := splitIndexKey(prefix, iter.Item().Key())
+		if !ok {
+			return false
+		}
+		if len(member) != 0 && !bytes.HasPrefix(member, []byte(recordPrefix)) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// RepairIndex checks every entry of the index, and if any of them don't decode, such as a key that isn't a valid
+// index key, or one that refers to the key of a record of another type, the index is dropped and rebuilt from the records of dataType.  The number of corrupt entries found is returned, and the
+// index is left as it is if there aren't any.  It shouldn't run alongside other writes to the type
+func (s *Store) RepairIndex(dataType interface{}, indexName string) (int, error) {
+	storer := s.storer(dataType)
+	index, ok := storer.Indexes()[indexName]
+	if !ok {
+		return 0, fmt.Errorf("The index %s does not exist", indexName)
+	}
+
+	typeName := storer.Type()
+	prefix := indexKeyPrefix(typeName, indexName)
+	corrupt := 0
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			_, _, member, ok := splitIndexKey(prefix, iter.Item().Key())
+			if !ok || (len(member) != 0 && !bytes.HasPrefix(member, typePrefix(typeName))) {
+				corrupt++
+			}
+		}
+		return nil
+	})
+	if err != nil || corrupt == 0 {
+		return corrupt, err
+	}
+
+	return corrupt, s.rebuildIndex(dataType, typeName, indexName, index)
+}
+
 type iterator struct {
-	keyCache [][]byte
-	nextKeys func(*badger.Iterator) ([][]byte, error)
-	iter     *badger.Iterator
-	bookmark *iterBookmark
-	lastSeek []byte
-	tx       *badger.Txn
-	err      error
+	keyCache   [][]byte
+	valueCache [][]byte // values read along with their keys, nil where the value still needs to be retrieved
+	nextKeys   func(*badger.Iterator) ([][]byte, [][]byte, error)
+	keysOnly   bool   // values aren't needed, so don't copy them
+	indexed    bool   // the keys are read from an index, whose entries can outlive records that expired with a TTL
+	expired    func() // called for the index entries of expired records, nil unless the store sweeps them
+	iter       *badger.Iterator
+	bookmark   *iterBookmark
+	lastSeek   []byte
+	tx         *badger.Txn
+	err        error
 }
 
 // iterBookmark stores a seek location in a specific iterator
@@ -186,7 +779,8 @@ type iterBookmark struct {
 
 func newIterator(tx *badger.Txn, typeName string, query *Query, bookmark *iterBookmark) *iterator {
 	i := &iterator{
-		tx: tx,
+		tx:      tx,
+		expired: query.store.foundExpired(),
 	}
 
 	if bookmark != nil {
@@ -197,31 +791,104 @@ func newIterator(tx *badger.Txn, typeName string, query *Query, bookmark *iterBo
 
 	var prefix []byte
 
+	// don't read further ahead than the query needs
+	cacheSize := iteratorKeyMinCacheSize
+	if query.limit > 0 && query.limit < cacheSize {
+		cacheSize = query.limit
+	}
+
 	if query.index != "" {
-		query.badIndex = !indexExists(i.iter, typeName, query.index)
+		// the indexes of a type being bulk loaded or migrated don't match its records yet
+		query.staleIndex = indexesStale(tx, typeName)
+		// an index being built in the background doesn't hold every record yet, so every record is scanned instead
+		query.buildingIndex = indexBuilding(tx, typeName, query.index)
+		query.badIndex = query.staleIndex || query.buildingIndex || !indexExists(i.iter, typeName, query.index)
+	}
+
+	if query.index != "" && !query.badIndex {
+		if fields := compositeIndexFields(query.dataType, query.index); fields != nil && query.coversComposite(fields) {
+			i.indexed = true
+			i.nextKeys = i.compositeKeys(typeName, query, fields, cacheSize)
+			return i
+		}
 	}
 
 	criteria := query.fieldCriteria[query.index]
-	if hasMatchFunc(criteria) {
+
+	var exact []byte
+	if query.index != "" && !query.badIndex {
+		// map key criteria are still tested against the record, but the index can narrow down which records
+		exact = mapIndexKey(indexKeyPrefix(typeName, query.index), query, criteria)
+	}
+
+	var operators []int
+	if query.index != "" && !query.badIndex {
+		operators = elementOperators(query.dataType, query.index)
+	}
+	query.partialIndex = operators != nil
+	if query.partialIndex {
+		// each element of the slice, or term of the text, is indexed separately, so only a Contains or Match
+		// criterion can be tested against the index, the rest are tested against the records
+		criteria = elementCriteria(criteria, operators)
+		if exact != nil {
+			// the map entry of a MapKey Eq criterion narrows down the records on its own
+			criteria = nil
+		}
+		if len(criteria) != 0 && criteria[0].operator == geo {
+			i.indexed = true
+			i.nextKeys = i.geoKeys(typeName, query, criteria[0].value.(*geoArea), cacheSize)
+			return i
+		}
+	} else if query.skipsIndex(query.index) || query.buildingIndex {
 		// can't use indexes on matchFuncs as the entire record isn't available for testing in the passed
-		// in function
+		// in function, and nil values aren't indexed
 		criteria = nil
 	}
 
 	// Key field or index not specified - test key against criteria (if it exists) or return everything
-	if query.index == "" || len(criteria) == 0 {
+	if query.index == "" || (len(criteria) == 0 && exact == nil) {
 		prefix = typePrefix(typeName)
-		i.iter.Seek(prefix)
-		i.nextKeys = func(iter *badger.Iterator) ([][]byte, error) {
+
+		var start, end []byte
+		if query.index == "" && !query.noIndex {
+			start, end = keyRange(prefix, criteria)
+		}
+		if start != nil {
+			i.iter.Seek(start)
+		} else {
+			i.iter.Seek(prefix)
+		}
+
+		i.nextKeys = func(iter *badger.Iterator) ([][]byte, [][]byte, error) {
 			var nKeys [][]byte
+			var nValues [][]byte
 
-			for len(nKeys) < iteratorKeyMinCacheSize {
+			for len(nKeys) < cacheSize {
 				if !iter.ValidForPrefix(prefix) {
-					return nKeys, nil
+					return nKeys, nValues, nil
+				}
+				if query.expired() {
+					return nil, nil, ErrQueryTimeout
 				}
+				query.stats.scannedKey()
 
 				item := iter.Item()
 				key := item.KeyCopy(nil)
+				if end != nil && bytes.Compare(key, end) > 0 {
+					// past the end of the key range
+					return nKeys, nValues, nil
+				}
+
+				// the record is already at hand, so keep a copy of its value rather than getting it again later
+				var value []byte
+				if !i.keysOnly || len(criteria) != 0 {
+					var err error
+					value, err = item.ValueCopy(nil)
+					if err != nil {
+						return nil, nil, err
+					}
+				}
+
 				var ok bool
 				if len(criteria) == 0 {
 					// nothing to check return key for value testing
@@ -230,108 +897,343 @@ func newIterator(tx *badger.Txn, typeName string, query *Query, bookmark *iterBo
 
 					val := reflect.New(query.dataType)
 
-					err := item.Value(func(v []byte) error {
-						return decode(v, val.Interface())
-					})
+					query.stats.decodedRecord()
+					err := query.store.decode(value, val.Interface())
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 
 					ok, err = matchesAllCriteria(criteria, key, true, typeName, val.Interface())
 					if err != nil {
-						return nil, err
+						return nil, nil, err
 					}
 				}
 
 				if ok {
 					nKeys = append(nKeys, key)
-
+					nValues = append(nValues, value)
 				}
 				i.lastSeek = key
 				iter.Next()
 			}
-			return nKeys, nil
+			return nKeys, nValues, nil
 		}
 
 		return i
 	}
 
 	// indexed field, get keys from index
+	query.stats.usedIndex(query.index)
 	prefix = indexKeyPrefix(typeName, query.index)
-	i.iter.Seek(prefix)
-	i.nextKeys = func(iter *badger.Iterator) ([][]byte, error) {
+	valueType := indexValueType(query.dataType, query.index)
+	i.indexed = true
+	if normalize := indexNormalizer(query.dataType, query.index); normalize != nil {
+		// the index holds the normalized values, so compare them with normalized criteria
+		criteria = normalizeCriteria(criteria, normalize)
+	}
+	if isHashedIndex(query.dataType, query.index) {
+		// the index holds the hashes of the values, so compare them with hashed criteria
+		criteria = hashCriteria(criteria)
+	}
+	if exact == nil {
+		exact = exactIndexKey(prefix, query, criteria)
+	}
+	var start, end []byte
+	if exact == nil {
+		start, end = indexRange(prefix, valueType, criteria)
+	}
+	if start == nil && end == nil && exact == nil {
+		start, end = mapKeyRange(prefix, criteria)
+	}
+
+	var cursor keyCursor = iteratorCursor{i.iter}
+	if keys, ok := query.preloads.lookup(tx, prefix); ok {
+		cursor = &sliceCursor{keys: keys}
+	}
+	if exact != nil {
+		cursor.Seek(exact)
+	} else if start != nil {
+		cursor.Seek(start)
+	} else {
+		cursor.Seek(prefix)
+	}
+	// the header of the index value being read, and whether it matched the criteria
+	var current []byte
+	var matched bool
+	// records indexed under more than one matching value are only returned the first time they're found
+	seen := make(map[string]bool)
+
+	i.nextKeys = func(*badger.Iterator) ([][]byte, [][]byte, error) {
 		var nKeys [][]byte
+		var nValues [][]byte
 
-		for len(nKeys) < iteratorKeyMinCacheSize {
-			if !iter.ValidForPrefix(prefix) {
-				return nKeys, nil
+		for len(nKeys) < cacheSize {
+			if !cursor.ValidForPrefix(prefix) {
+				return nKeys, nValues, nil
+			}
+			if query.expired() {
+				return nil, nil, ErrQueryTimeout
 			}
 
-			item := iter.Item()
-			key := item.KeyCopy(nil)
-			// no currentRow on indexes as it refers to multiple rows
-			// remove index prefix for matching
-			ok, err := matchesAllCriteria(criteria, key[len(prefix):], true, "", nil)
-			if err != nil {
-				return nil, err
+			key := cursor.KeyCopy()
+			header, value, member, ok := splitIndexKey(prefix, key)
+			if !ok {
+				return nil, nil, fmt.Errorf("The index entry %q is corrupt", key)
+			}
+			if exact != nil && !bytes.Equal(header, exact) {
+				// no other index value can be equal
+				return nKeys, nValues, nil
+			}
+			if end != nil && bytes.Compare(header, end) > 0 {
+				// past the last index value in range
+				return nKeys, nValues, nil
+			}
+
+			if !bytes.Equal(header, current) {
+				query.stats.scannedKey()
+
+				// no currentRow on indexes as it refers to multiple rows
+				var indexKey interface{} = value
+				if valueType != nil {
+					indexKey = indexValue{data: value, fieldType: valueType, decodeValue: query.store.decodeValue}
+				}
+				var err error
+				matched, err = matchesAllCriteria(criteria, indexKey, true, "", nil)
+				if err != nil {
+					return nil, nil, err
+				}
+				current = header
 			}
 
-			if ok {
-				item.Value(func(v []byte) error {
-					// append the slice of keys stored in the index
-					var keys = make(keyList, 0)
-					err := decode(v, &keys)
+			i.lastSeek = key
+			if !matched {
+				// skip the records of this index value
+				cursor.Seek(skipIndexValue(header))
+				continue
+			}
+
+			if len(member) != 0 && !seen[string(member)] {
+				seen[string(member)] = true
+				nKeys = append(nKeys, member)
+				if query.covered {
+					value, err := coveredValue(cursor)
 					if err != nil {
-						return err
+						return nil, nil, err
 					}
+					nValues = append(nValues, value)
+				}
+			}
+			cursor.Next()
+		}
+
+		// unless the index covers the query, it only holds the record keys, and their values have to be retrieved
+		// separately
+		return nKeys, nValues, nil
+	}
+
+	return i
+}
+
+var storerType = reflect.TypeOf((*Storer)(nil)).Elem()
 
-					nKeys = append(nKeys, [][]byte(keys)...)
+// exactIndexKey returns the index key holding every record that matches an Eq criterion, so that the
+// iterator can seek directly to it.  This is only possible for indexes created from struct tags, where the
+// index value is known to be the encoded field value. If there is no such key, nil is returned
+func exactIndexKey(prefix []byte, query *Query, criteria []*Criterion) []byte {
+	field, ok := tagIndexField(query.dataType, query.index)
+	if !ok {
+		return nil
+	}
+
+	valueType, operator := field.Type, eq
+	if isHashed(field) {
+		// the criteria are already hashed, see hashCriteria
+		valueType = reflect.TypeOf("")
+	}
+	if elementKind(field.Type) {
+		// the index holds each element, and records with an element equal to a Contains value
+		valueType, operator = field.Type.Elem(), ct
+	}
+	if isFullText(field) {
+		// the index holds each term, and records with the only term of a Match
+		for _, c := range criteria {
+			if found := distinctTerms(c.value.(string)); c.operator == mt && len(found) == 1 {
+				encoded, err := indexEncode(found[0], query.store.encodeValue)
+				if err != nil {
 					return nil
-				})
+				}
+				return indexValueKey(prefix, encoded)
 			}
+		}
+		return nil
+	}
 
-			i.lastSeek = key
-			iter.Next()
+	for _, c := range criteria {
+		if c.operator != operator || c.value == nil || reflect.TypeOf(c.value) != valueType {
+			continue
+		}
 
+		encoded, err := indexEncode(c.value, query.store.encodeValue)
+		if err != nil {
+			return nil
 		}
-		return nKeys, nil
 
+		return indexValueKey(prefix, encoded)
 	}
 
-	return i
+	return nil
 }
 
-func (i *iterator) createBookmark() *iterBookmark {
-	return &iterBookmark{
-		iter:    i.iter,
-		seekKey: i.lastSeek,
+// keyRange returns the first and last badger keys that can match the Key criteria, so that the iterator only needs to
+// read the keys in between.  This is only possible for keys that implement KeyEncoder, as they are compared in the
+// order they're stored in.  A nil start or end means the range isn't bounded on that side
+func keyRange(prefix []byte, criteria []*Criterion) (start, end []byte) {
+	for _, c := range criteria {
+		ke, ok := c.value.(KeyEncoder)
+		if !ok || c.mapped {
+			continue
+		}
+
+		encoded, err := ke.EncodeKey()
+		if err != nil {
+			// let the criteria return the error
+			continue
+		}
+		key := append(append([]byte{}, prefix...), encoded...)
+
+		switch c.operator {
+		case eq:
+			start, end = maxKey(start, key), minKey(end, key)
+		case gt, ge:
+			start = maxKey(start, key)
+		case lt, le:
+			end = minKey(end, key)
+		}
 	}
+
+	return start, end
 }
 
-// Next returns the next key value that matches the iterators criteria
-// If no more kv's are available the return nil, if there is an error, they return nil
-// and iterator.Error() will return the error
-func (i *iterator) Next() (key []byte, value []byte) {
-	if i.err != nil {
+// indexRange returns the first and last index keys that can match the criteria, for indexes whose values are encoded
+// in the same order as the values themselves, so that the iterator only needs to read the index keys in between.
+// Only criteria of the same type as the indexed field are used.  A nil start or end means the range isn't bounded on
+// that side
+func indexRange(prefix []byte, valueType reflect.Type, criteria []*Criterion) (start, end []byte) {
+	if valueType == nil || !orderedKind(valueType) {
 		return nil, nil
 	}
 
-	if len(i.keyCache) == 0 {
-		newKeys, err := i.nextKeys(i.iter)
+	for _, c := range criteria {
+		if c.mapped || c.value == nil || reflect.TypeOf(c.value) != valueType {
+			continue
+		}
+		if v := reflect.ValueOf(c.value); (v.Kind() == reflect.Float32 || v.Kind() == reflect.Float64) &&
+			math.IsNaN(v.Float()) {
+			// NaN isn't ordered with the other values
+			continue
+		}
+
+		encoded, err := indexEncode(c.value, c.query.store.encodeValue)
 		if err != nil {
-			i.err = err
-			return nil, nil
+			continue
+		}
+		key := indexValueKey(prefix, encoded)
+
+		switch c.operator {
+		case eq:
+			start, end = maxKey(start, key), minKey(end, key)
+		case gt, ge:
+			start = maxKey(start, key)
+		case lt, le:
+			end = minKey(end, key)
 		}
+	}
 
-		if len(newKeys) == 0 {
-			return nil, nil
+	return start, end
+}
+
+func maxKey(a, b []byte) []byte {
+	if a == nil || bytes.Compare(b, a) > 0 {
+		return b
+	}
+	return a
+}
+
+func minKey(a, b []byte) []byte {
+	if a == nil || bytes.Compare(b, a) < 0 {
+		return b
+	}
+	return a
+}
+
+// mapIndexKey returns the index key holding every record with the value of a MapKey Eq criterion at that key in
+// the indexed map field.  Like exactIndexKey, this is only possible for indexes created from struct tags.  If there
+// is no such key, nil is returned
+func mapIndexKey(prefix []byte, query *Query, criteria []*Criterion) []byte {
+	field, ok := tagIndexField(query.dataType, query.index)
+	if !ok || field.Type.Kind() != reflect.Map {
+		return nil
+	}
+
+	for _, c := range criteria {
+		if !c.mapped || c.operator != eq {
+			continue
+		}
+
+		encoded, err := indexEncode(mapIndexValue(c.mapKey, c.value), query.store.encodeValue)
+		if err != nil {
+			return nil
 		}
 
-		i.keyCache = append(i.keyCache, newKeys...)
+		return indexValueKey(prefix, encoded)
 	}
 
-	key = i.keyCache[0]
-	i.keyCache = i.keyCache[1:]
+	return nil
+}
+
+// mapIndexValue is the value an entry of an indexed map field is indexed under
+func mapIndexValue(key, value interface{}) string {
+	return mapIndexKeyPrefix(key) + fmt.Sprintf("%v", value)
+}
+
+// mapIndexKeyPrefix is the start of the values the entries of an indexed map field with the key are indexed under
+func mapIndexKeyPrefix(key interface{}) string {
+	return fmt.Sprintf("%v=", key)
+}
+
+// mapKeyRange returns the first and last index keys that can hold the entries of an indexed map field with the key of
+// a MapHasKey criterion, so that the iterator only needs to read the index keys in between.  A nil start or end means
+// there's no such criterion
+func mapKeyRange(prefix []byte, criteria []*Criterion) (start, end []byte) {
+	for _, c := range criteria {
+		if c.operator != hk {
+			continue
+		}
+
+		// the entries with the key are the index values starting with key=, which all sort before key>
+		start = indexValueKey(prefix, []byte(mapIndexKeyPrefix(c.value)))
+		start = start[:len(start)-2]
+		end = append(append([]byte{}, start[:len(start)-1]...), '='+1)
+		return start, end
+	}
+	return nil, nil
+}
+
+func (i *iterator) createBookmark() *iterBookmark {
+	return &iterBookmark{
+		iter:    i.iter,
+		seekKey: i.lastSeek,
+	}
+}
+
+// Next returns the next key value that matches the iterators criteria
+// If no more kv's are available the return nil, if there is an error, they return nil
+// and iterator.Error() will return the error
+func (i *iterator) Next() (key []byte, value []byte) {
+	key, value = i.next()
+	if key == nil || value != nil {
+		return key, value
+	}
 
 	item, err := i.tx.Get(key)
 	if err != nil {
@@ -339,10 +1241,8 @@ func (i *iterator) Next() (key []byte, value []byte) {
 		return nil, nil
 	}
 
-	err = item.Value(func(val []byte) error {
-		value = val
-		return nil
-	})
+	// values are only valid for the life of the item, so they need to be copied
+	value, err = item.ValueCopy(nil)
 	if err != nil {
 		i.err = err
 		return nil, nil
@@ -350,29 +1250,73 @@ func (i *iterator) Next() (key []byte, value []byte) {
 
 	return
 }
+
+// NextCounter returns the next key that matches the iterators criteria, without retrieving its value
 func (i *iterator) NextCounter() (key []byte, value []byte) {
+	i.keysOnly = true
+	key, _ = i.next()
+	return key, nil
+}
+
+// next returns the next key from the key cache, fetching more keys if it's empty, along with the key's value if it
+// was read at the same time
+func (i *iterator) next() (key []byte, value []byte) {
 	if i.err != nil {
 		return nil, nil
 	}
 
-	if len(i.keyCache) == 0 {
-		newKeys, err := i.nextKeys(i.iter)
+	for {
+		if len(i.keyCache) == 0 {
+			newKeys, newValues, err := i.nextKeys(i.iter)
+			if err != nil {
+				i.err = err
+				return nil, nil
+			}
+
+			if len(newKeys) == 0 {
+				return nil, nil
+			}
+
+			if newValues == nil {
+				newValues = make([][]byte, len(newKeys))
+			}
+
+			i.keyCache = append(i.keyCache, newKeys...)
+			i.valueCache = append(i.valueCache, newValues...)
+		}
+
+		key, value = i.keyCache[0], i.valueCache[0]
+		i.keyCache, i.valueCache = i.keyCache[1:], i.valueCache[1:]
+
+		// badger drops records whose TTL has expired, but not their index entries.  The record is only looked up
+		// when its value has to be retrieved anyway, or the store sweeps these entries, so counts and covered queries
+		// otherwise only read the index, and leave the entries to RemoveExpired
+		fetch := value == nil && !i.keysOnly
+		if !i.indexed || (!fetch && i.expired == nil) {
+			return key, value
+		}
+
+		item, err := i.tx.Get(key)
+		if err == badger.ErrKeyNotFound {
+			if i.expired != nil {
+				i.expired()
+			}
+			continue
+		}
 		if err != nil {
 			i.err = err
 			return nil, nil
 		}
 
-		if len(newKeys) == 0 {
-			return nil, nil
+		if fetch {
+			value, err = item.ValueCopy(nil)
+			if err != nil {
+				i.err = err
+				return nil, nil
+			}
 		}
-
-		i.keyCache = append(i.keyCache, newKeys...)
+		return key, value
 	}
-
-	key = i.keyCache[0]
-	i.keyCache = i.keyCache[1:]
-
-	return
 }
 
 // Error returns the last error, iterator.Next() will not continue if there is an error present
@@ -0,0 +1,1750 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/timshannon/badgerhold"
+)
+
+type Account struct {
+	Email string
+	Name  string
+}
+
+func lowerEmailIndex(name string, value interface{}) ([]byte, error) {
+	return badgerhold.DefaultEncode(strings.ToLower(value.(*Account).Email))
+}
+
+func TestAddIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		// records written before the index is added are indexed when it's added
+		err := store.Insert(1, &Account{Email: "Tim@Example.com", Name: "tim"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		err = store.AddIndex(&Account{}, "LowerEmail", badgerhold.Index{
+			IndexFunc: lowerEmailIndex,
+			Unique:    true,
+		})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		err = store.Insert(2, &Account{Email: "JANE@example.com", Name: "jane"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		find := func(email string) []Account {
+			var result []Account
+			err := store.Find(&result, badgerhold.Where("LowerEmail").Eq(email).Index("LowerEmail"))
+			if err != nil {
+				t.Fatalf("Error finding data through an added index: %s", err)
+			}
+			return result
+		}
+
+		if result := find("tim@example.com"); len(result) != 1 || result[0].Name != "tim" {
+			t.Fatalf("Found %v for a record inserted before the index was added", result)
+		}
+		if result := find("jane@example.com"); len(result) != 1 || result[0].Name != "jane" {
+			t.Fatalf("Found %v for a record inserted after the index was added", result)
+		}
+
+		err = store.Insert(3, &Account{Email: "tim@EXAMPLE.com", Name: "duplicate"})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Expected ErrUniqueExists from an added unique index, got %v", err)
+		}
+
+		err = store.Update(2, &Account{Email: "Janet@example.com", Name: "janet"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		if result := find("jane@example.com"); len(result) != 0 {
+			t.Fatalf("Found %v under the index value from before the update", result)
+		}
+		if result := find("janet@example.com"); len(result) != 1 || result[0].Name != "janet" {
+			t.Fatalf("Found %v for an updated record", result)
+		}
+
+		err = store.Delete(1, &Account{})
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+
+		if result := find("tim@example.com"); len(result) != 0 {
+			t.Fatalf("Found %v after the record was deleted", result)
+		}
+
+		err = store.AddIndex(&Account{}, "LowerEmail", badgerhold.Index{IndexFunc: lowerEmailIndex})
+		if err == nil {
+			t.Fatalf("Adding an index with a duplicate name didn't return an error")
+		}
+	})
+}
+
+type Contact struct {
+	First string
+	Last  string
+}
+
+func (c *Contact) Type() string { return "Contact" }
+
+func (c *Contact) Indexes() map[string]badgerhold.Index {
+	return map[string]badgerhold.Index{
+		"FullName": {
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				contact := value.(*Contact)
+				return badgerhold.DefaultEncode(contact.First + " " + contact.Last)
+			},
+		},
+	}
+}
+
+func TestComputedIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		contacts := []Contact{{First: "Tim", Last: "Shannon"}, {First: "Jane", Last: "Doe"}, {First: "Tim", Last: "Doe"}}
+		for i := range contacts {
+			err := store.Insert(i, &contacts[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query  *badgerhold.Query
+			result []int
+		}{
+			{badgerhold.Where("FullName").Eq("Tim Doe").Index("FullName"), []int{2}},
+			{badgerhold.Where("FullName").Eq("Tim Doe"), []int{2}},
+			{badgerhold.Where("FullName").Gt("Jane Doe").Index("FullName"), []int{2, 0}},
+			{badgerhold.Where("FullName").Gt("Jane Doe"), []int{0, 2}},
+			{badgerhold.Where("First").Eq("Tim").And("FullName").Ne("Tim Doe"), []int{0}},
+			{badgerhold.Where("First").Eq("Jane").Or(badgerhold.Where("FullName").Eq("Tim Shannon")), []int{1, 0}},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []Contact
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d. Results: %v", len(result), len(tests[i].result),
+						result)
+				}
+				for k := range result {
+					if result[k] != contacts[tests[i].result[k]] {
+						t.Fatalf("Result %d is %v wanted %v", k, result[k], contacts[tests[i].result[k]])
+					}
+				}
+			})
+		}
+
+		// criteria on an index added to the store are tested against the records when the index isn't used
+		err := store.AddIndex(&Contact{}, "Initials", badgerhold.Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				contact := value.(*Contact)
+				return badgerhold.DefaultEncode(contact.First[:1] + contact.Last[:1])
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		var result []Contact
+		err = store.Find(&result, badgerhold.Where("Initials").Eq("TD"))
+		if err != nil {
+			t.Fatalf("Error finding data through the records of an added computed index: %s", err)
+		}
+		if len(result) != 1 || result[0] != contacts[2] {
+			t.Fatalf("Found %v for the computed index value TD, wanted %v", result, contacts[2])
+		}
+
+		err = store.Find(&result, badgerhold.Where("MiddleName").Eq("TD"))
+		if err == nil {
+			t.Fatalf("Querying a field that's neither a field nor an index didn't return an error")
+		}
+	})
+}
+
+func TestConditionalIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Ticket struct {
+			Title  string
+			Closed bool
+		}
+
+		err := store.Insert(1, &Ticket{Title: "closed before the index", Closed: true})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		err = store.AddIndex(&Ticket{}, "OpenTitle", badgerhold.Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				return badgerhold.DefaultEncode(value.(*Ticket).Title)
+			},
+			Condition: func(value interface{}) bool {
+				return !value.(*Ticket).Closed
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		for key, ticket := range []Ticket{{Title: "a"}, {Title: "b", Closed: true}, {Title: "c"}} {
+			err = store.Insert(key+2, &ticket)
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		entries := func() int {
+			dump, err := store.DumpIndex(&Ticket{}, "OpenTitle")
+			if err != nil {
+				t.Fatalf("Error dumping index: %s", err)
+			}
+			return len(dump)
+		}
+
+		if count := entries(); count != 2 {
+			t.Fatalf("The conditional index has %d values wanted 2", count)
+		}
+
+		// closing a ticket removes it from the index, reopening one adds it back
+		err = store.Update(2, &Ticket{Title: "a", Closed: true})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		err = store.Update(3, &Ticket{Title: "b"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Ticket
+		err = store.Find(&result, badgerhold.Where("OpenTitle").Eq("a").Index("OpenTitle"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 0 {
+			t.Fatalf("Found %v for a record that no longer meets the index's condition", result)
+		}
+
+		err = store.Find(&result, badgerhold.Where("OpenTitle").Eq("b").Index("OpenTitle"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 1 || result[0].Title != "b" {
+			t.Fatalf("Found %v for a record that now meets the index's condition", result)
+		}
+
+		if count := entries(); count != 2 {
+			t.Fatalf("The conditional index has %d values after the updates wanted 2", count)
+		}
+	})
+}
+
+func TestHashIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Attachment struct {
+			ID   int    `badgerhold:"key"`
+			Body string `badgerholdHash:"Body"`
+			Data []byte `badgerholdHash:"Data"`
+		}
+
+		large := strings.Repeat("x", 10000)
+		attachments := []Attachment{
+			{ID: 0, Body: large, Data: []byte("first")},
+			{ID: 1, Body: "small", Data: []byte("second")},
+			{ID: 2, Body: large + "y"},
+		}
+		for i := range attachments {
+			err := store.Insert(attachments[i].ID, &attachments[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			query    *badgerhold.Query
+			result   []int
+			fullScan bool
+		}{
+			{badgerhold.Where("Body").Eq(large).Index("Body"), []int{0}, false},
+			{badgerhold.Where("Body").In("small", large+"y", 5).Index("Body"), []int{1, 2}, false},
+			{badgerhold.Where("Body").Eq(large).And("ID").Gt(0).Index("Body"), []int{}, false},
+			{badgerhold.Where("Body").Gt("small").Index("Body"), []int{0, 2}, true},
+			{badgerhold.Where("Data").Eq([]byte("second")).Index("Data"), []int{1}, false},
+			{badgerhold.Where("Body").Eq("missing").Index("Body"), []int{}, false},
+		}
+
+		for i := range tests {
+			t.Run(tests[i].query.String(), func(t *testing.T) {
+				var result []Attachment
+				err := store.Find(&result, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error finding data from badgerhold: %s", err)
+				}
+				if len(result) != len(tests[i].result) {
+					t.Fatalf("Find result count is %d wanted %d", len(result), len(tests[i].result))
+				}
+				for k := range result {
+					if result[k].ID != tests[i].result[k] {
+						t.Fatalf("Result %d has the key %d wanted %d", k, result[k].ID, tests[i].result[k])
+					}
+				}
+
+				_, fullScan, err := store.WillUseIndex(&Attachment{}, tests[i].query)
+				if err != nil {
+					t.Fatalf("Error planning the query: %s", err)
+				}
+				if fullScan != tests[i].fullScan {
+					t.Fatalf("The query will scan every record %t wanted %t", fullScan, tests[i].fullScan)
+				}
+			})
+		}
+
+		// the index keys are a fixed size, however large the value
+		err := store.Badger().View(func(tx *badger.Txn) error {
+			prefix := []byte("_bhIdx:Attachment:Body:")
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				if len(iter.Item().Key()) > 100 {
+					t.Fatalf("The hash index key %q is %d bytes long", iter.Item().Key(), len(iter.Item().Key()))
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error reading the index: %s", err)
+		}
+
+		attachments[0].Body = "updated"
+		err = store.Update(attachments[0].ID, &attachments[0])
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Attachment
+		err = store.Find(&result, badgerhold.Where("Body").Eq(large).Index("Body"))
+		if err != nil {
+			t.Fatalf("Error finding data from badgerhold: %s", err)
+		}
+		if len(result) != 0 {
+			t.Fatalf("Found %d records under the hash of the value from before the update", len(result))
+		}
+
+		type UniqueAttachment struct {
+			ID   int    `badgerhold:"key"`
+			Body string `badgerholdHash:"Body" badgerholdUnique:"Body"`
+		}
+		err = store.Insert(0, &UniqueAttachment{ID: 0, Body: large})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		err = store.Insert(1, &UniqueAttachment{ID: 1, Body: large})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Expected ErrUniqueExists from a unique hash index, got %v", err)
+		}
+
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Hashing a field that isn't a string or []byte didn't panic")
+			}
+		}()
+		// fields of named string types hash the same as plain strings
+		type Email string
+		type HashedContact struct {
+			ID    int   `badgerhold:"key"`
+			Email Email `badgerholdHash:"Email"`
+		}
+		err = store.Insert(0, &HashedContact{ID: 0, Email: "a@x"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		err = store.Insert(1, &HashedContact{ID: 1, Email: "b@x"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		for _, query := range []*badgerhold.Query{
+			badgerhold.Where("Email").Eq("b@x"),
+			badgerhold.Where("Email").Eq("b@x").Index("Email"),
+			badgerhold.Where("Email").Eq(Email("b@x")).Index("Email"),
+		} {
+			var contacts []HashedContact
+			err = store.Find(&contacts, query)
+			if err != nil {
+				t.Fatalf("Error finding data from badgerhold: %s", err)
+			}
+			if len(contacts) != 1 || contacts[0].ID != 1 {
+				t.Fatalf("%s found %v wanted the contact with key 1", query, contacts)
+			}
+		}
+
+		type BadHash struct {
+			Count int `badgerholdHash:"Count"`
+		}
+		store.Insert(1, &BadHash{Count: 1})
+	})
+}
+
+func TestNormalizedIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Member struct {
+			Email string `badgerhold:"unique" badgerholdNormalize:"lower"`
+			Name  string `badgerholdIndex:"Name" badgerholdNormalize:"fold"`
+		}
+
+		members := []Member{
+			{Email: "Tim@Example.com", Name: "STRASSE"},
+			{Email: "jane@example.com", Name: "Kelvin"},
+			{Email: "bob@example.com", Name: "\u212aelvin"}, // the Kelvin sign folds to k
+		}
+		for i := range members {
+			err := store.Insert(i, &members[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		err := store.Insert(3, &Member{Email: "TIM@example.com"})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Inserting an email differing only in case returned %v wanted ErrUniqueExists", err)
+		}
+
+		tests := []struct {
+			name  string
+			query *badgerhold.Query
+			count int
+		}{
+			{"lower eq", badgerhold.Where("Email").Eq("tim@EXAMPLE.COM").Index("Email"), 1},
+			{"lower in", badgerhold.Where("Email").In("JANE@example.com", "nobody@example.com").Index("Email"), 1},
+			{"lower range", badgerhold.Where("Email").Ge("C").And("Email").Lt("K").Index("Email"), 1},
+			{"fold eq", badgerhold.Where("Name").Eq("kelvin").Index("Name"), 2},
+			{"fold prefix", badgerhold.Where("Name").HasPrefix("stR").Index("Name"), 1},
+			{"without the index", badgerhold.Where("Name").Eq("kelvin"), 0},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []Member
+				err := store.Find(&result, tst.query)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+				if len(result) != tst.count {
+					t.Fatalf("Found %v wanted %d records", result, tst.count)
+				}
+			})
+		}
+	})
+}
+
+func TestGeoIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Place struct {
+			Name     string
+			Location *badgerhold.GeoPoint `badgerholdGeo:"Location"`
+		}
+
+		places := []Place{
+			{"Paris", &badgerhold.GeoPoint{Lat: 48.8566, Lon: 2.3522}},
+			{"Versailles", &badgerhold.GeoPoint{Lat: 48.8049, Lon: 2.1204}},
+			{"London", &badgerhold.GeoPoint{Lat: 51.5074, Lon: -0.1278}},
+			{"Suva", &badgerhold.GeoPoint{Lat: -18.1248, Lon: 178.4501}},
+			{"Apia", &badgerhold.GeoPoint{Lat: -13.8333, Lon: -171.75}},
+			{"Nowhere", nil},
+		}
+		for i := range places {
+			err := store.Insert(i, &places[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			name   string
+			query  *badgerhold.Query
+			result []string
+		}{
+			{"small radius", badgerhold.Where("Location").WithinRadius(48.8566, 2.3522, 20000),
+				[]string{"Paris", "Versailles"}},
+			{"large radius", badgerhold.Where("Location").WithinRadius(48.8566, 2.3522, 400000),
+				[]string{"London", "Paris", "Versailles"}},
+			{"radius across the antimeridian", badgerhold.Where("Location").WithinRadius(-16, 179.9, 1000000),
+				[]string{"Apia", "Suva"}},
+			{"box", badgerhold.Where("Location").WithinBox(45, -5, 50, 5), []string{"Paris", "Versailles"}},
+			{"box across the antimeridian", badgerhold.Where("Location").WithinBox(-25, 170, -10, -170),
+				[]string{"Apia", "Suva"}},
+			{"nothing in range", badgerhold.Where("Location").WithinRadius(0, 0, 1000), nil},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				for _, query := range []*badgerhold.Query{tst.query, tst.query.Index("Location")} {
+					var result []Place
+					err := store.Find(&result, query.SortBy("Name"))
+					if err != nil {
+						t.Fatalf("Error finding data: %s", err)
+					}
+
+					if len(result) != len(tst.result) {
+						t.Fatalf("%s found %v wanted %v", query, result, tst.result)
+					}
+					for i := range result {
+						if result[i].Name != tst.result[i] {
+							t.Fatalf("%s found %s wanted %s", query, result[i].Name, tst.result[i])
+						}
+					}
+				}
+
+				index, fullScan, err := store.WillUseIndex(&Place{}, tst.query)
+				if err != nil {
+					t.Fatalf("Error checking the query's index: %s", err)
+				}
+				if index != "Location" || fullScan {
+					t.Fatalf("WillUseIndex returned %q, %t wanted the geo index", index, fullScan)
+				}
+			})
+		}
+
+		// points are indexed by their geohash
+		err := store.Insert(len(places), &Place{"Aalborg", &badgerhold.GeoPoint{Lat: 57.64911, Lon: 10.40744}})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		dump, err := store.DumpIndex(&Place{}, "Location")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		found := false
+		for i := range dump {
+			if strings.HasPrefix(dump[i].Value.(string), "u4pruydqqvj") {
+				found = true
+			}
+		}
+		if !found {
+			t.Fatalf("The geo index %v doesn't have the geohash u4pruydqqvj", dump)
+		}
+	})
+}
+
+func TestValidateIndexes(t *testing.T) {
+	opt := testOptions()
+	defer os.RemoveAll(opt.Dir)
+
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	insertTestData(t, store)
+
+	err = store.ValidateIndexes(&ItemTest{})
+	if err != nil {
+		t.Fatalf("Validating healthy indexes failed: %s", err)
+	}
+
+	// drop the Category index, and corrupt an entry of the UpdateIndex index
+	err = store.Badger().Update(func(tx *badger.Txn) error {
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		prefix := []byte("_bhIdx:ItemTest:Category:")
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			err := tx.Delete(iter.Item().KeyCopy(nil))
+			if err != nil {
+				return err
+			}
+		}
+
+		prefix = []byte("_bhIdx:ItemTest:UpdateIndex:")
+		return tx.Set(append(prefix, 0, 2), []byte{})
+	})
+	if err != nil {
+		t.Fatalf("Error damaging indexes: %s", err)
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("Error closing store: %s", err)
+	}
+
+	opt.ValidateIndexes = []interface{}{&ItemTest{}}
+	store, err = badgerhold.Open(opt)
+	if err == nil {
+		store.Close()
+		t.Fatalf("Opening a store with invalid indexes didn't fail")
+	}
+
+	invalid, ok := err.(*badgerhold.ErrInvalidIndexes)
+	if !ok {
+		t.Fatalf("Opening a store with invalid indexes returned %v wanted an *ErrInvalidIndexes", err)
+	}
+
+	if len(invalid.Missing) != 1 || invalid.Missing[0] != "ItemTest.Category" {
+		t.Fatalf("Missing indexes are %v wanted [ItemTest.Category]", invalid.Missing)
+	}
+	if len(invalid.Corrupt) != 1 || invalid.Corrupt[0] != "ItemTest.UpdateIndex" {
+		t.Fatalf("Corrupt indexes are %v wanted [ItemTest.UpdateIndex]", invalid.Corrupt)
+	}
+}
+
+func TestDumpIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		entries, err := store.DumpIndex(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+
+		counts := make(map[string]int)
+		for i := range testData {
+			counts[testData[i].Category]++
+		}
+
+		if len(entries) != len(counts) {
+			t.Fatalf("Index has %d entries wanted %d", len(entries), len(counts))
+		}
+
+		for _, entry := range entries {
+			category, ok := entry.Value.(string)
+			if !ok {
+				t.Fatalf("Index value %v is a %T wanted a string", entry.Value, entry.Value)
+			}
+			if len(entry.Keys) != counts[category] {
+				t.Fatalf("Index value %s has %d keys wanted %d", category, len(entry.Keys), counts[category])
+			}
+
+			var result []ItemTest
+			err = store.Find(&result, badgerhold.Where("Category").Eq(category))
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+			if len(result) != len(entry.Keys) {
+				t.Fatalf("Index value %s has %d keys but %d records match", category, len(entry.Keys),
+					len(result))
+			}
+		}
+
+		err = store.AddIndex(&Account{}, "DumpEmail", badgerhold.Index{IndexFunc: lowerEmailIndex})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+		err = store.Insert("tim", &Account{Email: "Tim@Example.com"})
+		if err != nil {
+			t.Fatalf("Error inserting account: %s", err)
+		}
+
+		entries, err = store.DumpIndex(&Account{}, "DumpEmail")
+		if err != nil {
+			t.Fatalf("Error dumping added index: %s", err)
+		}
+		if len(entries) != 1 || entries[0].Value != nil || len(entries[0].Encoded) == 0 || len(entries[0].Keys) != 1 {
+			t.Fatalf("Unexpected entries for an added index: %+v", entries)
+		}
+
+		_, err = store.DumpIndex(&ItemTest{}, "BadIndex")
+		if err == nil {
+			t.Fatalf("Dumping an index that doesn't exist didn't fail")
+		}
+	})
+}
+
+func TestDeleteIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.AddIndex(&Account{}, "DeletedEmail", badgerhold.Index{IndexFunc: lowerEmailIndex})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		hasIndex := func() bool {
+			indexes, err := store.IndexesFor("Account")
+			if err != nil {
+				t.Fatalf("Error listing indexes: %s", err)
+			}
+			for i := range indexes {
+				if indexes[i] == "DeletedEmail" {
+					return true
+				}
+			}
+			return false
+		}
+
+		err = store.Insert("tim", &Account{Email: "Tim@Example.com", Name: "tim"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		if !hasIndex() {
+			t.Fatalf("The added index has no entries")
+		}
+
+		err = store.DeleteIndex(&Account{}, "DeletedEmail")
+		if err != nil {
+			t.Fatalf("Error deleting index: %s", err)
+		}
+		if hasIndex() {
+			t.Fatalf("The index still has entries after it was deleted")
+		}
+
+		// the deleted index isn't written again
+		err = store.Insert("jane", &Account{Email: "jane@example.com", Name: "jane"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		if hasIndex() {
+			t.Fatalf("A record was added to the deleted index")
+		}
+
+		insertTestData(t, store)
+		err = store.DeleteIndex(&ItemTest{}, "Category")
+		if err == nil {
+			t.Fatalf("Deleting an index the type still defines didn't fail")
+		}
+	})
+}
+
+func TestIndexStats(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		stats, err := store.IndexStats(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error getting the stats of an empty index: %s", err)
+		}
+		if stats != (badgerhold.IndexStats{}) {
+			t.Fatalf("Empty index has stats %+v", stats)
+		}
+
+		insertTestData(t, store)
+
+		counts := make(map[string]int)
+		for i := range testData {
+			counts[testData[i].Category]++
+		}
+
+		stats, err = store.IndexStats(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error getting index stats: %s", err)
+		}
+		if stats.Entries != len(testData) || stats.Values != len(counts) {
+			t.Fatalf("Index has %d entries of %d values wanted %d of %d", stats.Entries, stats.Values,
+				len(testData), len(counts))
+		}
+		if want := float64(len(testData)) / float64(len(counts)); stats.AverageKeys != want {
+			t.Fatalf("Index has %g keys per value wanted %g", stats.AverageKeys, want)
+		}
+		if stats.Bytes <= 0 {
+			t.Fatalf("Index takes up %d bytes", stats.Bytes)
+		}
+
+		_, err = store.IndexStats(&ItemTest{}, "BadIndex")
+		if err == nil {
+			t.Fatalf("Getting the stats of an index that doesn't exist didn't fail")
+		}
+	})
+}
+
+func TestIndexZeroBytes(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		// index values that are prefixes of each other, and contain the byte that ends a value in the index keys
+		names := []string{"a", "a\x00", "a\x00\x01", "a\x00\xff", "b"}
+
+		err := store.AddIndex(&Account{}, "RawName", badgerhold.Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				return []byte(value.(*Account).Name), nil
+			},
+		})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		for i := len(names) - 1; i >= 0; i-- {
+			err = store.Insert(i, &Account{Email: fmt.Sprintf("%d@example.com", i), Name: names[i]})
+			if err != nil {
+				t.Fatalf("Error inserting account: %s", err)
+			}
+		}
+		err = store.Insert(len(names), &Account{Email: "extra@example.com", Name: "a\x00"})
+		if err != nil {
+			t.Fatalf("Error inserting account: %s", err)
+		}
+
+		entries, err := store.DumpIndex(&Account{}, "RawName")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		if len(entries) != len(names) {
+			t.Fatalf("Index has %d entries wanted %d: %+v", len(entries), len(names), entries)
+		}
+
+		for i := range entries {
+			if string(entries[i].Encoded) != names[i] {
+				t.Fatalf("Index entry %d is %q wanted %q", i, entries[i].Encoded, names[i])
+			}
+			keys := 1
+			if names[i] == "a\x00" {
+				keys = 2
+			}
+			if len(entries[i].Keys) != keys {
+				t.Fatalf("Index value %q has %d keys wanted %d", names[i], len(entries[i].Keys), keys)
+			}
+		}
+
+		err = store.Delete(len(names), &Account{})
+		if err != nil {
+			t.Fatalf("Error deleting account: %s", err)
+		}
+
+		entries, err = store.DumpIndex(&Account{}, "RawName")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		if len(entries) != len(names) || len(entries[1].Keys) != 1 {
+			t.Fatalf("Unexpected entries after a delete: %+v", entries)
+		}
+	})
+}
+
+type Measurement struct {
+	Degrees int       `badgerholdIndex:"Degrees"`
+	Ratio   float64   `badgerholdIndex:"Ratio"`
+	Taken   time.Time `badgerholdIndex:"Taken"`
+	Label   string    `badgerholdIndex:"Label"`
+}
+
+func TestIndexOrder(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		degrees := []int{3, -1, 70, 0, -50, -5}
+		start := time.Date(1960, 1, 1, 0, 0, 0, 0, time.UTC)
+		for i, d := range degrees {
+			err := store.Insert(i, &Measurement{
+				Degrees: d,
+				Ratio:   float64(d) / 10,
+				Taken:   start.AddDate(d, 0, 0),
+				Label:   string(rune('m' + d/10)),
+			})
+			if err != nil {
+				t.Fatalf("Error inserting measurement: %s", err)
+			}
+		}
+
+		wanted := []int{-50, -5, -1, 0, 3, 70}
+
+		queries := map[string]*badgerhold.Query{
+			"int":   badgerhold.Where("Degrees").Ge(-100).Index("Degrees"),
+			"float": badgerhold.Where("Ratio").Ge(-10.0).Index("Ratio"),
+			"time":  badgerhold.Where("Taken").Ge(start.AddDate(-100, 0, 0)).Index("Taken"),
+			"int64": badgerhold.Where("Degrees").In(int64(-50), int64(-5), int64(-1), int64(0), int64(3),
+				int64(70)).Index("Degrees"),
+			"string": badgerhold.Where("Label").Ne("").Index("Label"),
+		}
+
+		for name, query := range queries {
+			var result []Measurement
+			err := store.Find(&result, query)
+			if err != nil {
+				t.Fatalf("Error finding %s measurements: %s", name, err)
+			}
+			if len(result) != len(wanted) {
+				t.Fatalf("Found %d %s measurements wanted %d", len(result), name, len(wanted))
+			}
+			if name == "string" {
+				// labels of the same tens are equal, so only the order of the tens is known
+				for i := 1; i < len(result); i++ {
+					if result[i-1].Label > result[i].Label {
+						t.Fatalf("Labels aren't in order: %v", result)
+					}
+				}
+				continue
+			}
+			for i := range wanted {
+				if result[i].Degrees != wanted[i] {
+					t.Fatalf("%s index returned %d at %d wanted %d", name, result[i].Degrees, i, wanted[i])
+				}
+			}
+		}
+
+		var result []Measurement
+		err := store.Find(&result, badgerhold.Where("Taken").Eq(start.AddDate(-5, 0, 0)).Index("Taken"))
+		if err != nil {
+			t.Fatalf("Error finding measurement by time: %s", err)
+		}
+		if len(result) != 1 || result[0].Degrees != -5 {
+			t.Fatalf("Found %v by time wanted the -5 measurement", result)
+		}
+
+		entries, err := store.DumpIndex(&Measurement{}, "Degrees")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		for i := range entries {
+			if entries[i].Value != wanted[i] {
+				t.Fatalf("Index entry %d is %v wanted %d", i, entries[i].Value, wanted[i])
+			}
+		}
+
+		groups, err := store.FindAggregate(&Measurement{}, nil, "Degrees")
+		if err != nil {
+			t.Fatalf("Error aggregating measurements: %s", err)
+		}
+		if len(groups) != len(wanted) {
+			t.Fatalf("Aggregate returned %d groups wanted %d", len(groups), len(wanted))
+		}
+	})
+}
+
+type Extreme struct {
+	Signed   int64   `badgerholdIndex:"Signed"`
+	Unsigned uint64  `badgerholdIndex:"Unsigned"`
+	Float    float64 `badgerholdIndex:"Float"`
+	Plain    int64
+}
+
+func TestIndexRange(t *testing.T) {
+	var stats []badgerhold.QueryStats
+
+	opt := testOptions()
+	opt.QueryObserver = func(s badgerhold.QueryStats) {
+		stats = append(stats, s)
+	}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	signed := []int64{math.MinInt64, math.MinInt64 + 1, -5, -1, 0, 3, math.MaxInt64 - 1, math.MaxInt64}
+	unsigned := []uint64{0, 1, 5, 1 << 63, math.MaxUint64 - 1, math.MaxUint64, 2, 3}
+	floats := []float64{math.Inf(-1), -math.MaxFloat64, -5.5, -math.SmallestNonzeroFloat64, math.Copysign(0, -1),
+		math.SmallestNonzeroFloat64, 3.25, math.Inf(1)}
+
+	// plenty of records out of most ranges, so a full scan of the index stands out
+	for i := 0; i < 100; i++ {
+		signed = append(signed, int64(1000+i))
+		unsigned = append(unsigned, uint64(1000+i))
+		floats = append(floats, float64(1000+i))
+	}
+
+	for i := range signed {
+		err = store.Insert(i, &Extreme{Signed: signed[i], Unsigned: unsigned[i], Float: floats[i], Plain: signed[i]})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		query *badgerhold.Query
+		match func(e Extreme) bool
+	}{
+		{"signed gt", badgerhold.Where("Signed").Gt(int64(-5)), func(e Extreme) bool { return e.Signed > -5 }},
+		{"signed lt", badgerhold.Where("Signed").Lt(int64(0)), func(e Extreme) bool { return e.Signed < 0 }},
+		{"signed range", badgerhold.Where("Signed").Ge(int64(-5)).And("Signed").Le(int64(3)),
+			func(e Extreme) bool { return e.Signed >= -5 && e.Signed <= 3 }},
+		{"signed min", badgerhold.Where("Signed").Le(int64(math.MinInt64)),
+			func(e Extreme) bool { return e.Signed == math.MinInt64 }},
+		{"signed max", badgerhold.Where("Signed").Ge(int64(math.MaxInt64)),
+			func(e Extreme) bool { return e.Signed == math.MaxInt64 }},
+		{"unsigned gt", badgerhold.Where("Unsigned").Gt(uint64(3)), func(e Extreme) bool { return e.Unsigned > 3 }},
+		{"unsigned max", badgerhold.Where("Unsigned").Eq(uint64(math.MaxUint64)),
+			func(e Extreme) bool { return e.Unsigned == math.MaxUint64 }},
+		{"float lt", badgerhold.Where("Float").Lt(0.0), func(e Extreme) bool { return e.Float < 0 }},
+		{"float zero", badgerhold.Where("Float").Eq(0.0), func(e Extreme) bool { return e.Float == 0 }},
+		{"float range", badgerhold.Where("Float").Gt(-6.0).And("Float").Lt(4.0),
+			func(e Extreme) bool { return e.Float > -6 && e.Float < 4 }},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			wanted := 0
+			for i := range signed {
+				if tc.match(Extreme{Signed: signed[i], Unsigned: unsigned[i], Float: floats[i]}) {
+					wanted++
+				}
+			}
+
+			field := strings.Fields(tc.name)[0]
+			index := strings.ToUpper(field[:1]) + field[1:]
+
+			var scanned, indexed []Extreme
+			err := store.Find(&scanned, tc.query)
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+
+			stats = nil
+			err = store.Find(&indexed, tc.query.Index(index))
+			if err != nil {
+				t.Fatalf("Error finding data with the index: %s", err)
+			}
+
+			if len(scanned) != wanted || len(indexed) != wanted {
+				t.Fatalf("Found %d records and %d with the index wanted %d", len(scanned), len(indexed), wanted)
+			}
+			for i := 1; i < len(indexed); i++ {
+				if indexed[i-1].Signed >= indexed[i].Signed && index == "Signed" {
+					t.Fatalf("Index results aren't in order: %v", indexed)
+				}
+			}
+			// the index is only read from the first index value in range, to just past the last, and the value
+			// past the end is read again when the iterator asks for more keys
+			if len(stats) != 1 || stats[0].Scanned > wanted+3 {
+				t.Fatalf("Index scan read more than the range: %+v", stats)
+			}
+		})
+	}
+
+	var result []Extreme
+	err = store.Find(&result, badgerhold.Where("Plain").Lt(int64(-1)).And("Plain").Gt(int64(math.MinInt64)))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Found %d records between MinInt64 and -1 wanted 2", len(result))
+	}
+}
+
+func TestWillUseIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		tests := []struct {
+			name     string
+			query    *badgerhold.Query
+			index    string
+			fullScan bool
+		}{
+			{"index", badgerhold.Where("Category").Eq("food").Index("Category"), "Category", false},
+			{"no index", badgerhold.Where("Category").Eq("food"), "", true},
+			{"no criteria on the index", badgerhold.Where("Name").Eq("apple").Index("Category"), "", true},
+			{"match func", badgerhold.Where("Category").MatchFunc(func(ra *badgerhold.RecordAccess) (bool, error) {
+				return true, nil
+			}).Index("Category"), "", true},
+			{"or without the index", badgerhold.Where("Category").Eq("food").Index("Category").
+				Or(badgerhold.Where("Name").Eq("apple")), "Category", true},
+			{"or with the index", badgerhold.Where("Category").Eq("food").Index("Category").
+				Or(badgerhold.Where("Category").Eq("animal").Index("Category")), "Category", false},
+			{"not on the index", badgerhold.Not(badgerhold.Where("Category").Eq("food").Index("Category")), "", true},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				index, fullScan, err := store.WillUseIndex(&ItemTest{}, tst.query)
+				if err != nil {
+					t.Fatalf("Error checking the query's index: %s", err)
+				}
+				if index != tst.index || fullScan != tst.fullScan {
+					t.Fatalf("WillUseIndex returned %q, %t wanted %q, %t", index, fullScan, tst.index,
+						tst.fullScan)
+				}
+
+				// the query still runs as usual afterwards
+				var result []ItemTest
+				err = store.Find(&result, tst.query)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+			})
+		}
+
+		_, _, err := store.WillUseIndex(&ItemTest{}, badgerhold.Where("Name").Eq("apple").Index("BadIndex"))
+		if err == nil {
+			t.Fatalf("Checking a query on an index that doesn't exist didn't fail")
+		}
+	})
+}
+
+func TestPreloadIndex(t *testing.T) {
+	// the store needs to be new, as writes in transactions it doesn't run are remembered for as long as it's open
+	opt := testOptions()
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+
+	err = store.PreloadIndex(&ItemTest{}, "Category")
+	if err != nil {
+		t.Fatalf("Error preloading the index: %s", err)
+	}
+	size, ok := store.PreloadedIndexSize(&ItemTest{}, "Category")
+	if !ok || size <= 0 {
+		t.Fatalf("PreloadedIndexSize returned %d, %t after preloading the index", size, ok)
+	}
+
+	for _, tst := range testResults {
+		t.Run(tst.name, func(t *testing.T) {
+			var result []ItemTest
+			err := store.Find(&result, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding data with the preloaded index: %s", err)
+			}
+			if len(result) != len(tst.result) {
+				t.Fatalf("Find result count is %d wanted %d.", len(result), len(tst.result))
+			}
+		})
+	}
+
+	// writes drop the preloaded index, so they're seen by the next query
+	item := testData[0]
+	item.Category = "mineral"
+	err = store.Update(item.Key, item)
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+	if _, ok := store.PreloadedIndexSize(&ItemTest{}, "Category"); ok {
+		t.Fatalf("The index is still preloaded after a write")
+	}
+
+	err = store.PreloadIndex(&ItemTest{}, "Category")
+	if err != nil {
+		t.Fatalf("Error preloading the index again: %s", err)
+	}
+
+	var result []ItemTest
+	err = store.Find(&result, badgerhold.Where("Category").Eq("mineral").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(result) != 1 || result[0].Key != item.Key {
+		t.Fatalf("Found %v with the preloaded index wanted the updated item", result)
+	}
+
+	err = store.PreloadIndex(&ItemTest{}, "Bad")
+	if err == nil {
+		t.Fatalf("Preloading an index that doesn't exist didn't fail")
+	}
+
+	// writes in a transaction the store doesn't run can't be tracked
+	err = store.Badger().Update(func(tx *badger.Txn) error {
+		return store.TxUpsert(tx, item.Key, item)
+	})
+	if err != nil {
+		t.Fatalf("Error upserting data: %s", err)
+	}
+	if _, ok := store.PreloadedIndexSize(&ItemTest{}, "Category"); ok {
+		t.Fatalf("The index is still preloaded after an untracked write")
+	}
+	err = store.PreloadIndex(&ItemTest{}, "Category")
+	if err != badgerhold.ErrPreloadUntracked {
+		t.Fatalf("PreloadIndex returned %v after an untracked write wanted ErrPreloadUntracked", err)
+	}
+}
+
+func TestRepairIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		corrupt, err := store.RepairIndex(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error repairing a healthy index: %s", err)
+		}
+		if corrupt != 0 {
+			t.Fatalf("RepairIndex found %d corrupt entries in a healthy index", corrupt)
+		}
+
+		// add an entry that refers to a record of another type, and a key that isn't a valid index key
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			prefix := []byte("_bhIdx:ItemTest:Category:")
+			err := tx.Set(append(append(prefix, "animal"...), append([]byte{0, 1}, "bh:Other:1"...)...), []byte{})
+			if err != nil {
+				return err
+			}
+			return tx.Set(append(prefix, 0, 2), []byte{})
+		})
+		if err != nil {
+			t.Fatalf("Error damaging the index: %s", err)
+		}
+
+		var result []ItemTest
+		err = store.Find(&result, badgerhold.Where("Category").Ne("mineral").Index("Category"))
+		if err == nil {
+			t.Fatalf("Finding data with a corrupt index entry didn't fail")
+		}
+
+		corrupt, err = store.RepairIndex(&ItemTest{}, "Category")
+		if err != nil {
+			t.Fatalf("Error repairing the index: %s", err)
+		}
+		if corrupt != 2 {
+			t.Fatalf("RepairIndex found %d corrupt entries wanted 2", corrupt)
+		}
+
+		result = nil
+		err = store.Find(&result, badgerhold.Where("Category").Eq("animal").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data with the repaired index: %s", err)
+		}
+		if len(result) != 7 {
+			t.Fatalf("Found %d animals with the repaired index wanted 7", len(result))
+		}
+
+		_, err = store.RepairIndex(&ItemTest{}, "Bad")
+		if err == nil {
+			t.Fatalf("Repairing an index that doesn't exist didn't fail")
+		}
+	})
+}
+
+func nameWordsIndex(name string, value interface{}) ([][]byte, error) {
+	var values [][]byte
+	for _, word := range strings.Fields(value.(*Account).Name) {
+		encoded, err := badgerhold.DefaultEncode(word)
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, encoded)
+	}
+	return values, nil
+}
+
+func TestIndexDuplicateKeys(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.AddIndex(&Account{}, "NameWords", badgerhold.Index{MultiValueFunc: nameWordsIndex})
+		if err != nil {
+			t.Fatalf("Error adding index: %s", err)
+		}
+
+		for i, name := range []string{"tim shannon", "jane doe", "tim doe"} {
+			err = store.Insert(i+1, &Account{Email: fmt.Sprintf("%d@example.com", i), Name: name})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			name  string
+			query *badgerhold.Query
+			names []string
+		}{
+			// the index is read in order of its values, so the records under doe come first
+			{"in", badgerhold.Where("NameWords").In("tim", "doe").Index("NameWords"),
+				[]string{"jane doe", "tim doe", "tim shannon"}},
+			{"limit", badgerhold.Where("NameWords").In("tim", "doe").Index("NameWords").Limit(2),
+				[]string{"jane doe", "tim doe"}},
+			{"or", badgerhold.Where("NameWords").Eq("tim").Index("NameWords").
+				Or(badgerhold.Where("NameWords").Eq("doe").Index("NameWords")),
+				[]string{"tim shannon", "tim doe", "jane doe"}},
+			{"skipped in the or", badgerhold.Where("NameWords").Eq("tim").Index("NameWords").
+				Or(badgerhold.Where("NameWords").Eq("doe").Index("NameWords")).Skip(2),
+				[]string{"jane doe"}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				var result []Account
+				err := store.Find(&result, tst.query)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+				if len(result) != len(tst.names) {
+					t.Fatalf("Found %v wanted %v", result, tst.names)
+				}
+				for i := range result {
+					if result[i].Name != tst.names[i] {
+						t.Fatalf("Found %v wanted %v", result, tst.names)
+					}
+				}
+			})
+		}
+	})
+}
+
+func TestVerifyIndexes(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type VerifyItem struct {
+			Name string `badgerhold:"index"`
+		}
+
+		for key, name := range map[string]string{"a": "x", "b": "y", "c": "z"} {
+			err := store.Insert(key, &VerifyItem{Name: name})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		found, err := store.VerifyIndexes(&VerifyItem{})
+		if err != nil {
+			t.Fatalf("Error verifying healthy indexes: %s", err)
+		}
+		if len(found) != 0 {
+			t.Fatalf("VerifyIndexes found %v in healthy indexes", found)
+		}
+
+		// overwrite b with the value of a, delete c, and add an entry that isn't a valid index key, all without
+		// updating the index
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			var keys [][]byte
+			var values [][]byte
+			prefix := []byte("bh:VerifyItem:")
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				value, err := iter.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+				keys = append(keys, iter.Item().KeyCopy(nil))
+				values = append(values, value)
+			}
+			if len(keys) != 3 {
+				t.Fatalf("Found %d records wanted 3", len(keys))
+			}
+
+			err := tx.Set(keys[1], values[0])
+			if err != nil {
+				return err
+			}
+			err = tx.Delete(keys[2])
+			if err != nil {
+				return err
+			}
+			return tx.Set(append([]byte("_bhIdx:VerifyItem:Name:"), 0, 2), []byte{})
+		})
+		if err != nil {
+			t.Fatalf("Error damaging the store: %s", err)
+		}
+
+		found, err = store.VerifyIndexes(&VerifyItem{})
+		if err != nil {
+			t.Fatalf("Error verifying indexes: %s", err)
+		}
+
+		kinds := make(map[badgerhold.InconsistencyKind]int)
+		for i := range found {
+			if found[i].Index != "Name" {
+				t.Fatalf("Inconsistency %v is on the wrong index", found[i])
+			}
+			kinds[found[i].Kind]++
+		}
+		if len(found) != 4 || kinds[badgerhold.CorruptIndexEntry] != 1 || kinds[badgerhold.StaleIndexEntry] != 1 ||
+			kinds[badgerhold.MissingRecord] != 1 || kinds[badgerhold.MissingIndexEntry] != 1 {
+			t.Fatalf("VerifyIndexes found %v wanted one inconsistency of each kind", found)
+		}
+
+		repaired, err := store.RepairIndexes(&VerifyItem{})
+		if err != nil {
+			t.Fatalf("Error repairing indexes: %s", err)
+		}
+		if len(repaired) != len(found) {
+			t.Fatalf("RepairIndexes repaired %v wanted %v", repaired, found)
+		}
+
+		found, err = store.VerifyIndexes(&VerifyItem{})
+		if err != nil {
+			t.Fatalf("Error verifying repaired indexes: %s", err)
+		}
+		if len(found) != 0 {
+			t.Fatalf("VerifyIndexes found %v in repaired indexes", found)
+		}
+
+		repaired, err = store.RepairIndexes(&VerifyItem{})
+		if err != nil {
+			t.Fatalf("Error repairing healthy indexes: %s", err)
+		}
+		if len(repaired) != 0 {
+			t.Fatalf("RepairIndexes repaired %v in healthy indexes", repaired)
+		}
+	})
+}
+
+type CompositeItem struct {
+	Name     string
+	Category string `badgerholdIndex:"Category, Created"`
+	Created  time.Time
+}
+
+func TestCompositeIndex(t *testing.T) {
+	var stats []badgerhold.QueryStats
+
+	opt := testOptions()
+	opt.QueryObserver = func(s badgerhold.QueryStats) {
+		stats = append(stats, s)
+	}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	start := time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)
+	categories := []string{"a", "b", "c"}
+	for i := 0; i < 60; i++ {
+		err = store.Insert(i, &CompositeItem{
+			Name:     fmt.Sprintf("item %d", i),
+			Category: categories[i%3],
+			Created:  start.AddDate(0, 0, i/3),
+		})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+	}
+
+	const index = "Category,Created"
+	cutoff := start.AddDate(0, 0, 15)
+
+	tests := []struct {
+		name     string
+		query    *badgerhold.Query
+		match    func(item CompositeItem) bool
+		fullScan bool
+	}{
+		{"first field", badgerhold.Where("Category").Eq("b").Index(index),
+			func(item CompositeItem) bool { return item.Category == "b" }, false},
+		{"both fields", badgerhold.Where("Category").Eq("b").And("Created").Ge(cutoff).Index(index),
+			func(item CompositeItem) bool { return item.Category == "b" && !item.Created.Before(cutoff) }, false},
+		{"first field range", badgerhold.Where("Category").Gt("a").And("Created").Lt(start.AddDate(0, 0, 2)).
+			Index(index), func(item CompositeItem) bool {
+			return item.Category > "a" && item.Created.Before(start.AddDate(0, 0, 2))
+		}, false},
+		{"second field only", badgerhold.Where("Created").Ge(cutoff).Index(index),
+			func(item CompositeItem) bool { return !item.Created.Before(cutoff) }, true},
+	}
+
+	for _, tst := range tests {
+		t.Run(tst.name, func(t *testing.T) {
+			usedIndex, fullScan, err := store.WillUseIndex(&CompositeItem{}, tst.query)
+			if err != nil {
+				t.Fatalf("Error checking the query's index: %s", err)
+			}
+			if fullScan != tst.fullScan || (usedIndex == index) == tst.fullScan {
+				t.Fatalf("WillUseIndex returned %q, %t wanted a full scan %t", usedIndex, fullScan,
+					tst.fullScan)
+			}
+
+			stats = nil
+			var result []CompositeItem
+			err = store.Find(&result, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+
+			want := 0
+			for i := 0; i < 60; i++ {
+				if tst.match(CompositeItem{Category: categories[i%3], Created: start.AddDate(0, 0, i/3)}) {
+					want++
+				}
+			}
+			if len(result) != want {
+				t.Fatalf("Found %d records wanted %d", len(result), want)
+			}
+			for i := range result {
+				if !tst.match(result[i]) {
+					t.Fatalf("Found %v which doesn't match the query", result[i])
+				}
+			}
+			if !tst.fullScan && stats[0].Decoded != want {
+				t.Fatalf("Decoded %d records using the composite index wanted %d", stats[0].Decoded, want)
+			}
+		})
+	}
+
+	// updates move a record's index entry
+	err = store.Update(0, &CompositeItem{Name: "item 0", Category: "b", Created: cutoff})
+	if err != nil {
+		t.Fatalf("Error updating data: %s", err)
+	}
+
+	var result []CompositeItem
+	err = store.Find(&result, badgerhold.Where("Category").Eq("b").And("Created").Eq(cutoff).Index(index))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Found %d records after the update wanted 2", len(result))
+	}
+
+	var old []CompositeItem
+	err = store.Find(&old, badgerhold.Where("Category").Eq("a").And("Created").Eq(start).Index(index))
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(old) != 0 {
+		t.Fatalf("Found %v at the old value after the update wanted none", old)
+	}
+}
+
+type Subscriber struct {
+	ID    int
+	Email string `badgerholdIndexFunc:"lowercaseEmail"`
+	Name  string
+}
+
+type UnregisteredSubscriber struct {
+	Email string `badgerholdIndexFunc:"unregisteredIndex"`
+}
+
+func init() {
+	badgerhold.RegisterIndex("lowercaseEmail", badgerhold.Index{
+		IndexFunc: func(name string, value interface{}) ([]byte, error) {
+			return badgerhold.DefaultEncode(strings.ToLower(value.(*Subscriber).Email))
+		},
+		Unique: true,
+	})
+}
+
+func TestRegisterIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Insert(1, &Subscriber{ID: 1, Email: "Tim@Example.com", Name: "tim"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+		err = store.Insert(2, &Subscriber{ID: 2, Email: "jane@example.com", Name: "jane"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		var result []Subscriber
+		err = store.Find(&result, badgerhold.Where("Email").Eq("tim@example.com").Index("Email"))
+		if err != nil {
+			t.Fatalf("Error finding data through a registered index: %s", err)
+		}
+		if len(result) != 1 || result[0].Name != "tim" {
+			t.Fatalf("Found %v through the registered index, wanted tim", result)
+		}
+
+		err = store.Insert(3, &Subscriber{ID: 3, Email: "TIM@example.com", Name: "duplicate"})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Expected ErrUniqueExists from a unique registered index, got %v", err)
+		}
+
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatalf("Expected a panic when re-registering an index name")
+				}
+			}()
+			badgerhold.RegisterIndex("lowercaseEmail", badgerhold.Index{IndexFunc: lowerEmailIndex})
+		}()
+
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Fatalf("Expected a panic when a tag refers to an index that isn't registered")
+				}
+			}()
+			_ = store.Insert(1, &UnregisteredSubscriber{Email: "tim@example.com"})
+		}()
+	})
+}
+
+type CoveredItem struct {
+	ID       int `badgerhold:"key"`
+	Name     string
+	Category string `badgerholdIndex:"Category" badgerholdCover:"Name"`
+	Notes    string
+}
+
+func TestCoveringIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for _, item := range []CoveredItem{
+			{ID: 1, Name: "apple", Category: "food", Notes: "red"},
+			{ID: 2, Name: "pear", Category: "food", Notes: "green"},
+			{ID: 3, Name: "chair", Category: "furniture", Notes: "wood"},
+		} {
+			err := store.Insert(item.ID, item)
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		find := func(query *badgerhold.Query) []CoveredItem {
+			var result []CoveredItem
+			err := store.Find(&result, query)
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+			return result
+		}
+		covered := func() *badgerhold.Query {
+			return badgerhold.Where("Category").Eq("food").Index("Category").Select("Name")
+		}
+
+		result := find(covered())
+		if len(result) != 2 {
+			t.Fatalf("Found %d records wanted 2", len(result))
+		}
+		for i := range result {
+			if result[i].ID == 0 || result[i].Name == "" || result[i].Category != "" || result[i].Notes != "" {
+				t.Fatalf("Found %+v wanted only the key and the selected Name", result[i])
+			}
+		}
+
+		result = find(badgerhold.Where("Category").Eq("food").And("Notes").Eq("red").Index("Category").
+			Select("Name"))
+		if len(result) != 1 || result[0].Name != "apple" || result[0].Notes != "" {
+			t.Fatalf("Found %+v for a query that isn't covered, wanted the apple's Name", result)
+		}
+
+		// the covered fields are stored again even when the indexed value doesn't change
+		err := store.Update(1, CoveredItem{ID: 1, Name: "cherry", Category: "food", Notes: "red"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		// delete the records without updating the index, the covered query is still answered from the index
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			prefix := []byte("bh:CoveredItem:")
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			var keys [][]byte
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				keys = append(keys, iter.Item().KeyCopy(nil))
+			}
+			iter.Close()
+
+			for i := range keys {
+				err := tx.Delete(keys[i])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error deleting the records: %s", err)
+		}
+
+		result = find(covered())
+		names := make(map[string]bool)
+		for i := range result {
+			names[result[i].Name] = true
+		}
+		if len(result) != 2 || !names["cherry"] || !names["pear"] {
+			t.Fatalf("Found %+v from the covering index wanted cherry and pear", result)
+		}
+	})
+}
+
+type BuildItem struct {
+	ID       int    `badgerhold:"key"`
+	Category string `badgerholdIndex:"Category"`
+}
+
+func TestBuildIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			for i := 0; i < 1500; i++ {
+				category := "b"
+				if i%3 == 0 {
+					category = "a"
+				}
+				err := store.TxInsert(tx, i, &BuildItem{ID: i, Category: category})
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		// drop the index entries, like a tag added to a type that already has records
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			prefix := []byte("_bhIdx:BuildItem:Category:")
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			var keys [][]byte
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+				keys = append(keys, iter.Item().KeyCopy(nil))
+			}
+			iter.Close()
+
+			for i := range keys {
+				err := tx.Delete(keys[i])
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error deleting the index: %s", err)
+		}
+
+		query := badgerhold.Where("Category").Eq("a").Index("Category")
+		count := func() (int, error) {
+			var result []BuildItem
+			err := store.Find(&result, query)
+			return len(result), err
+		}
+
+		_, err = count()
+		if err == nil {
+			t.Fatalf("Querying the missing index didn't fail")
+		}
+
+		var progress []int
+		var duringCount int
+		var duringScan bool
+		var duringErr error
+		err = <-store.BuildIndex(&BuildItem{}, "Category", func(indexed int) {
+			if len(progress) == 0 {
+				// only part of the records are indexed, so the query scans them all
+				duringCount, duringErr = count()
+				if duringErr == nil {
+					_, duringScan, duringErr = store.WillUseIndex(&BuildItem{}, query)
+				}
+			}
+			progress = append(progress, indexed)
+		})
+		if err != nil {
+			t.Fatalf("Error building the index: %s", err)
+		}
+
+		if len(progress) != 2 || progress[0] != 1000 || progress[1] != 1500 {
+			t.Fatalf("Build progress was %v wanted [1000 1500]", progress)
+		}
+		if duringErr != nil {
+			t.Fatalf("Error querying during the build: %s", duringErr)
+		}
+		if duringCount != 500 || !duringScan {
+			t.Fatalf("Found %d records in a full scan %t during the build, wanted 500 in a full scan", duringCount,
+				duringScan)
+		}
+
+		found, err := count()
+		if err != nil {
+			t.Fatalf("Error querying the built index: %s", err)
+		}
+		if found != 500 {
+			t.Fatalf("Found %d records with the built index wanted 500", found)
+		}
+		index, fullScan, err := store.WillUseIndex(&BuildItem{}, query)
+		if err != nil || index != "Category" || fullScan {
+			t.Fatalf("The built index isn't used, got %q %t %v", index, fullScan, err)
+		}
+
+		inconsistencies, err := store.VerifyIndexes(&BuildItem{})
+		if err != nil || len(inconsistencies) != 0 {
+			t.Fatalf("The built index has inconsistencies %v %v", inconsistencies, err)
+		}
+
+		err = <-store.BuildIndex(&BuildItem{}, "Bad", nil)
+		if err == nil {
+			t.Fatalf("Building an index that doesn't exist didn't fail")
+		}
+	})
+}
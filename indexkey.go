@@ -0,0 +1,169 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+)
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// orderedKind returns whether values of the type are indexed with indexEncode's own byte encoding, rather than the
+// store's Encoder
+func orderedKind(tp reflect.Type) bool {
+	if tp == timeType {
+		return true
+	}
+
+	switch tp.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64, reflect.String, reflect.Bool:
+		return true
+	}
+	return false
+}
+
+// indexEncode encodes a field value for an index created from a struct tag.  Numbers, strings, bools and times are
+// encoded so that their bytes sort in the same order as the values, and always encode the same way, no matter the
+// Encoder or Go version.  Signed integers and floats have their sign bit flipped so negative values sort first.  Other
+// types are encoded with encode, the store's Encoder
+func indexEncode(value interface{}, encode EncodeFunc) ([]byte, error) {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !orderedKind(v.Type()) {
+		return encode(value)
+	}
+
+	if v.Type() == timeType {
+		t := value.(time.Time)
+		b := make([]byte, 12)
+		binary.BigEndian.PutUint64(b, uint64(t.Unix())^(1<<63))
+		binary.BigEndian.PutUint32(b[8:], uint32(t.Nanosecond()))
+		return b, nil
+	}
+
+	b := make([]byte, 8)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		binary.BigEndian.PutUint64(b, uint64(v.Int())^(1<<63))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		binary.BigEndian.PutUint64(b, v.Uint())
+	case reflect.Float32, reflect.Float64:
+		f := v.Float()
+		if f == 0 {
+			// -0 and 0 are equal, so they need to be indexed the same
+			f = 0
+		}
+		bits := math.Float64bits(f)
+		if bits&(1<<63) != 0 {
+			bits = ^bits
+		} else {
+			bits |= 1 << 63
+		}
+		binary.BigEndian.PutUint64(b, bits)
+	case reflect.String:
+		return []byte(v.String()), nil
+	case reflect.Bool:
+		if v.Bool() {
+			return []byte{1}, nil
+		}
+		return []byte{0}, nil
+	}
+
+	return b, nil
+}
+
+// indexDecode decodes an index value encoded with indexEncode into the value pointed to by value, with decode, the
+// store's Decoder, for the types indexEncode encodes with the store's Encoder
+func indexDecode(data []byte, value interface{}, decode DecodeFunc) error {
+	v := reflect.ValueOf(value)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("Index values can only be decoded into a pointer, not %T", value)
+	}
+	v = v.Elem()
+
+	if !orderedKind(v.Type()) {
+		return decode(data, value)
+	}
+
+	size := 8
+	switch {
+	case v.Type() == timeType:
+		size = 12
+	case v.Kind() == reflect.String:
+		v.SetString(string(data))
+		return nil
+	case v.Kind() == reflect.Bool:
+		size = 1
+	}
+	if len(data) != size {
+		return fmt.Errorf("The index value %x isn't a valid %s", data, v.Type())
+	}
+
+	if v.Type() == timeType {
+		sec := int64(binary.BigEndian.Uint64(data) ^ (1 << 63))
+		v.Set(reflect.ValueOf(time.Unix(sec, int64(binary.BigEndian.Uint32(data[8:])))))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		v.SetInt(int64(binary.BigEndian.Uint64(data) ^ (1 << 63)))
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		v.SetUint(binary.BigEndian.Uint64(data))
+	case reflect.Float32, reflect.Float64:
+		bits := binary.BigEndian.Uint64(data)
+		if bits&(1<<63) != 0 {
+			bits &^= 1 << 63
+		} else {
+			bits = ^bits
+		}
+		v.SetFloat(math.Float64frombits(bits))
+	case reflect.Bool:
+		v.SetBool(data[0] == 1)
+	}
+
+	return nil
+}
+
+// indexValue is a value read from an index created from a struct tag, which is decoded into the type of the indexed
+// field before it's tested against the criteria
+type indexValue struct {
+	data        []byte
+	fieldType   reflect.Type
+	decodeValue DecodeFunc
+}
+
+// decode returns the field value, converted to the criterion's type if they are different kinds of number, so that
+// an int64 criterion still matches an indexed int field
+func (iv indexValue) decode(criterionType reflect.Type) (interface{}, error) {
+	value := reflect.New(iv.fieldType)
+	err := indexDecode(iv.data, value.Interface(), iv.decodeValue)
+	if err != nil {
+		return nil, err
+	}
+
+	field := value.Elem()
+	if criterionType != nil && field.Type() != criterionType && isNumber(field.Kind()) &&
+		isNumber(criterionType.Kind()) {
+		return field.Convert(criterionType).Interface(), nil
+	}
+
+	return field.Interface(), nil
+}
+
+func isNumber(kind reflect.Kind) bool {
+	switch kind {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,113 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// FindJoin retrieves the records that match the query, and whose foreignField is the key of one of the records of
+// foreignType that match foreignQuery, such as the Orders whose CustomerID is the key of a Customer in a given
+// country.  Both queries are run in the same transaction.  foreignField needs to be the same type as the foreign keys.
+// result must be a pointer to a slice, and like Find, the results are appended to it
+func (s *Store) FindJoin(result interface{}, query *Query, foreignField string, foreignType interface{},
+	foreignQuery *Query) error {
+	return s.Badger().View(func(tx *badger.Txn) error {
+		return s.TxFindJoin(tx, result, query, foreignField, foreignType, foreignQuery)
+	})
+}
+
+// TxFindJoin is the same as FindJoin, but you specify your own transaction
+func (s *Store) TxFindJoin(tx *badger.Txn, result interface{}, query *Query, foreignField string,
+	foreignType interface{}, foreignQuery *Query) error {
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.Elem().Kind() != reflect.Slice {
+		panic("result argument must be a slice address")
+	}
+	if foreignField == Key {
+		panic("FindJoin's foreignField must be a field of the record, not its Key")
+	}
+
+	tp := resultVal.Elem().Type().Elem()
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	field, ok := fieldByName(tp, foreignField, s.fieldNameTag)
+	if !ok {
+		return fmt.Errorf("The field %s does not exist in the type %s", foreignField, tp)
+	}
+
+	foreignQuery = s.preloaded(foreignQuery)
+	keys, err := foreignKeys(tx, foreignType, foreignQuery, field.Type)
+	if err != nil {
+		return err
+	}
+	if len(keys) == 0 {
+		return foreignQuery.skippedError()
+	}
+
+	if query == nil {
+		query = &Query{}
+	}
+	err = findQuery(tx, result, joinQuery(s.preloaded(query), foreignField, keys))
+	if err != nil {
+		return err
+	}
+
+	return foreignQuery.skippedError()
+}
+
+// foreignKeys returns the keys of the records of foreignType that match the query, decoded as keyType
+func foreignKeys(tx *badger.Txn, foreignType interface{}, query *Query, keyType reflect.Type) ([]interface{}, error) {
+	query.begin()
+	defer query.end()
+
+	query.writable = false
+
+	typeName := newStorer(foreignType, query.store.encodeValue).Type()
+	var keys []interface{}
+
+	err := runQuery(tx, foreignType, query, nil, query.skip,
+		func(r *record) error {
+			key := reflect.New(keyType)
+			err := decodeKey(r.key, key.Interface(), typeName, query.store.decodeValue)
+			if err != nil {
+				return err
+			}
+			keys = append(keys, key.Elem().Interface())
+			return nil
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// joinQuery returns a copy of the query, and its ors, that also requires field to be in the passed in keys
+func joinQuery(query *Query, field string, keys []interface{}) *Query {
+	joined := *query
+
+	joined.fieldCriteria = make(map[string][]*Criterion, len(query.fieldCriteria)+1)
+	for name, criteria := range query.fieldCriteria {
+		joined.fieldCriteria[name] = criteria
+	}
+	joined.fieldCriteria[field] = append(append([]*Criterion{}, query.fieldCriteria[field]...), &Criterion{
+		query:    &joined,
+		operator: in,
+		inValues: keys,
+	})
+
+	joined.ors = make([]*Query, len(query.ors))
+	for i := range query.ors {
+		joined.ors[i] = joinQuery(query.ors[i], field, keys)
+	}
+
+	return &joined
+}
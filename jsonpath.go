@@ -0,0 +1,213 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// jsonPath is the parsed path to a value inside a JSON document
+type jsonPath struct {
+	path  string
+	steps []jsonStep
+}
+
+// jsonStep is an object member, or an array element if index is set
+type jsonStep struct {
+	key     string
+	index   int
+	isIndex bool
+}
+
+// WhereJSON starts a query on the value at path inside the JSON encoded []byte or string field, see JSONPath
+// 	WhereJSON("Payload", "$.user.role").Eq("admin")
+func WhereJSON(field, path string) *Criterion {
+	return Where(field).JSONPath(path)
+}
+
+// AndJSON adds criteria on the value at path inside the JSON encoded []byte or string field, see JSONPath
+func (q *Query) AndJSON(field, path string) *Criterion {
+	return q.And(field).JSONPath(path)
+}
+
+// JSONPath tests the value at path inside the current field, which must hold JSON encoded as a []byte or string,
+// rather than the field itself.  The path is either a JSONPath such as $.user.roles[0], with object members after a
+// dot or quoted in brackets, as in $["user name"], and array elements in brackets, or a JSON pointer such as
+// /user/roles/0.  The field is parsed for every record, so the criteria are always tested against the records rather
+// than an index.  JSON numbers are compared as float64s with numeric values.  Records where the path doesn't exist
+// don't match, and neither do records where the field isn't valid JSON, or the value can't be compared with the
+// criterion's, unless StrictJSON is set.  A path that can't be parsed panics
+func (c *Criterion) JSONPath(path string) *Criterion {
+	if c.query.currentField == Key {
+		panic("JSONPath cannot be used against Keys")
+	}
+
+	steps, err := parseJSONPath(path)
+	if err != nil {
+		panic(err.Error())
+	}
+
+	c.json = &jsonPath{path: path, steps: steps}
+	return c
+}
+
+// StrictJSON has a JSONPath criterion return an error for records where the field isn't valid JSON, or the value at
+// the path can't be compared with the criterion's, rather than not matching them
+func (c *Criterion) StrictJSON() *Criterion {
+	if c.json == nil {
+		panic("StrictJSON can only be used after JSONPath")
+	}
+	c.jsonStrict = true
+	return c
+}
+
+// parseJSONPath parses either a JSONPath starting with $, or a JSON pointer starting with /
+func parseJSONPath(path string) ([]jsonStep, error) {
+	if path == "" {
+		return nil, nil
+	}
+
+	if path[0] == '/' {
+		var steps []jsonStep
+		for _, segment := range strings.Split(path[1:], "/") {
+			segment = strings.Replace(strings.Replace(segment, "~1", "/", -1), "~0", "~", -1)
+			steps = append(steps, jsonStep{key: segment})
+		}
+		return steps, nil
+	}
+
+	if path[0] != '$' {
+		return nil, fmt.Errorf("The JSON path %s must start with $ or /", path)
+	}
+
+	var steps []jsonStep
+	rest := path[1:]
+	for rest != "" {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end < 0 {
+				end = len(rest) - 1
+			}
+			if end == 0 {
+				return nil, fmt.Errorf("The JSON path %s has an empty member name", path)
+			}
+			steps = append(steps, jsonStep{key: rest[1 : end+1]})
+			rest = rest[end+1:]
+		case '[':
+			end := strings.IndexByte(rest, ']')
+			if end < 0 {
+				return nil, fmt.Errorf("The JSON path %s has an unclosed [", path)
+			}
+			inner := rest[1:end]
+			if len(inner) >= 2 && (inner[0] == '"' || inner[0] == '\'') && inner[len(inner)-1] == inner[0] {
+				steps = append(steps, jsonStep{key: inner[1 : len(inner)-1]})
+			} else {
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, fmt.Errorf("The JSON path %s has an invalid array index %s", path, inner)
+				}
+				steps = append(steps, jsonStep{index: index, isIndex: true})
+			}
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("The JSON path %s has an unexpected %q", path, rest[0])
+		}
+	}
+
+	return steps, nil
+}
+
+// lookup returns the value at the path in the decoded JSON document, and whether it exists
+func (p *jsonPath) lookup(doc interface{}) (interface{}, bool) {
+	current := doc
+	for _, step := range p.steps {
+		switch node := current.(type) {
+		case map[string]interface{}:
+			if step.isIndex {
+				return nil, false
+			}
+			value, ok := node[step.key]
+			if !ok {
+				return nil, false
+			}
+			current = value
+		case []interface{}:
+			index := step.index
+			if !step.isIndex {
+				// JSON pointers don't tell members and elements apart
+				var err error
+				index, err = strconv.Atoi(step.key)
+				if err != nil {
+					return nil, false
+				}
+			}
+			if index < 0 || index >= len(node) {
+				return nil, false
+			}
+			current = node[index]
+		default:
+			return nil, false
+		}
+	}
+	return current, true
+}
+
+// testJSON tests the criterion against the value at its path in the JSON field
+func (c *Criterion) testJSON(testValue interface{}, currentRow interface{}) (bool, error) {
+	var raw []byte
+	switch v := reflect.ValueOf(testValue); {
+	case v.Kind() == reflect.String:
+		raw = []byte(v.String())
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8:
+		raw = v.Bytes()
+	default:
+		return false, fmt.Errorf("JSONPath can only be used on []byte or string fields, not %T", testValue)
+	}
+
+	var doc interface{}
+	err := json.Unmarshal(raw, &doc)
+	if err != nil {
+		if c.jsonStrict {
+			return false, fmt.Errorf("The field isn't valid JSON: %w", err)
+		}
+		return false, nil
+	}
+
+	value, ok := c.json.lookup(doc)
+	if !ok && c.operator != isnil && c.operator != notnil {
+		return false, nil
+	}
+
+	// test the value with a copy of the criterion that compares numbers as float64s
+	entry := *c
+	entry.json = nil
+	if _, ok := value.(float64); ok {
+		entry.value = jsonNumber(entry.value)
+		entry.inValues = make([]interface{}, len(c.inValues))
+		for i := range c.inValues {
+			entry.inValues[i] = jsonNumber(c.inValues[i])
+		}
+	}
+
+	result, err := entry.test(value, false, "", currentRow)
+	if _, ok := err.(*ErrTypeMismatch); ok && !c.jsonStrict {
+		return false, nil
+	}
+	return result, err
+}
+
+// jsonNumber returns numeric values as a float64, to compare them with JSON numbers
+func jsonNumber(value interface{}) interface{} {
+	v := reflect.ValueOf(value)
+	if !v.IsValid() || !isNumber(v.Kind()) {
+		return value
+	}
+	return tryFloat(v)
+}
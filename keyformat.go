@@ -0,0 +1,220 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// oldRecordPrefix starts the badger keys of records written by older versions of badgerhold, which ran the type name
+// straight into the encoded key
+const oldRecordPrefix = "bh_"
+
+// migrateBatchSize is the most keys MigrateKeys moves or deletes in each transaction, a transaction that gets too big
+// for badger before then is committed early
+const migrateBatchSize = 1000
+
+// MigrateKeys moves the records of the passed in data types from the key layout of older versions of badgerhold,
+// "bh_" followed by the type name and the encoded key, to the current one, where the type name is followed by a
+// separator, and rebuilds their indexes, replacing any indexes in the older layout.  The old layout can't tell the
+// records of a type from those of another type whose name starts with it, such as User and UserProfile, so pass in
+// every type in the store, and each record is moved to the longest type name its key starts with.  Records of types
+// that aren't passed in are left where they are.  Records are moved in batches, so if MigrateKeys fails part of the
+// way through, it can be run again to move the rest.  The number of records moved is returned
+func (s *Store) MigrateKeys(dataTypes ...interface{}) (int, error) {
+	types := make(map[string]interface{}, len(dataTypes))
+	names := make([]string, 0, len(dataTypes))
+	for _, dataType := range dataTypes {
+		name := s.storer(dataType).Type()
+		if _, ok := types[name]; !ok {
+			names = append(names, name)
+		}
+		types[name] = dataType
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return len(names[i]) > len(names[j])
+	})
+
+	prefix := []byte(oldRecordPrefix)
+	seek := prefix
+	moved := 0
+	migrated := make(map[string]bool)
+
+	for done := false; !done; {
+		batch := 0
+		err := s.Badger().Update(func(tx *badger.Txn) error {
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			for iter.Seek(seek); iter.ValidForPrefix(prefix); iter.Next() {
+				key := iter.Item().KeyCopy(nil)
+				if batch == migrateBatchSize {
+					seek = key
+					return nil
+				}
+
+				rest := key[len(prefix):]
+				owner := ""
+				for _, name := range names {
+					if bytes.HasPrefix(rest, []byte(name)) {
+						owner = name
+						break
+					}
+				}
+				if owner == "" {
+					continue
+				}
+
+				value, err := iter.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+
+				err = tx.Set(append(typePrefix(owner), rest[len(owner):]...), value)
+				if err == nil {
+					err = tx.Delete(key)
+				}
+				if err == badger.ErrTxnTooBig && batch > 0 {
+					// the transaction is full, commit the records moved so far and move this one in the next
+					seek = key
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+				migrated[owner] = true
+				batch++
+			}
+
+			done = true
+			return nil
+		})
+		if err != nil {
+			return moved, err
+		}
+		moved += batch
+	}
+
+	s.purgeCaches()
+
+	for _, name := range names {
+		oldIndexes, err := s.hasPrefix(oldTypeIndexPrefix(name))
+		if err != nil {
+			return moved, err
+		}
+		if !migrated[name] && !oldIndexes {
+			continue
+		}
+
+		if migrated[name] {
+			// the index entries point to the old keys
+			_, err = s.deletePrefix(typeIndexPrefix(name))
+			if err != nil {
+				return moved, err
+			}
+		}
+
+		for indexName, index := range s.storer(types[name]).Indexes() {
+			err = s.rebuildIndex(types[name], name, indexName, index)
+			if err != nil {
+				return moved, err
+			}
+		}
+
+		// the old indexes are only removed once the new ones are built, so a failed migration can be run again
+		_, err = s.deletePrefix(oldTypeIndexPrefix(name))
+		if err != nil {
+			return moved, err
+		}
+	}
+
+	return moved, nil
+}
+
+// oldTypeIndexPrefix returns the prefix of the badger keys of every index of the type written by older versions of
+// badgerhold
+func oldTypeIndexPrefix(typeName string) []byte {
+	return []byte(oldIndexPrefix + ":" + typeName + ":")
+}
+
+// hasPrefix returns whether any badger key starts with prefix
+func (s *Store) hasPrefix(prefix []byte) (bool, error) {
+	found := false
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+
+		iter.Seek(prefix)
+		found = iter.ValidForPrefix(prefix)
+		return nil
+	})
+	return found, err
+}
+
+// deletePrefix deletes every key that starts with prefix, in batches, and returns the number of keys deleted
+func (s *Store) deletePrefix(prefix []byte) (int, error) {
+	deleted := 0
+	for {
+		var keys [][]byte
+		err := s.Badger().View(func(tx *badger.Txn) error {
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			iter := tx.NewIterator(opts)
+			defer iter.Close()
+
+			for iter.Seek(prefix); iter.ValidForPrefix(prefix) && len(keys) < migrateBatchSize; iter.Next() {
+				keys = append(keys, iter.Item().KeyCopy(nil))
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		if len(keys) == 0 {
+			return deleted, nil
+		}
+
+		err = s.Badger().Update(func(tx *badger.Txn) error {
+			for i := range keys {
+				err := tx.Delete(keys[i])
+				if err == badger.ErrTxnTooBig && i > 0 {
+					// the transaction is full, the rest of the keys are deleted in the next batch
+					keys = keys[:i]
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += len(keys)
+	}
+}
+
+// warnOldKeys logs a warning if the store has records or indexes in the key layout of older versions of badgerhold
+func (s *Store) warnOldKeys() {
+	if s.logger == nil {
+		return
+	}
+
+	for _, prefix := range []string{oldRecordPrefix, oldIndexPrefix + ":"} {
+		found, err := s.hasPrefix([]byte(prefix))
+		if err == nil && found {
+			s.logger.Warningf("The badger DB has records or indexes that were written by an older version of " +
+				"badgerhold, which can't be read until they're moved with Store.MigrateKeys")
+			return
+		}
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/timshannon/badgerhold"
+)
+
+type User struct {
+	Name string `badgerholdIndex:"Name"`
+}
+
+type UserProfile struct {
+	Name string `badgerholdIndex:"Name"`
+	Bio  string
+}
+
+func TestPrefixTypeNames(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		// without a separator, the User keys that start with Profile would run into the UserProfile records
+		keys := []string{"Profile", "Profilebob", "alice"}
+		for i, key := range keys {
+			err := store.Insert(regionKey{Region: key, ID: uint32(i)}, &User{Name: key})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+			err = store.Insert(key, &UserProfile{Name: key, Bio: "profile"})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		var users []User
+		err := store.Find(&users, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(users) != len(keys) {
+			t.Fatalf("Found %d users, wanted %d: %v", len(users), len(keys), users)
+		}
+
+		var profiles []UserProfile
+		err = store.Find(&profiles, badgerhold.Where("Bio").Ne("profile"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(profiles) != 0 {
+			t.Fatalf("Found users among the profiles: %v", profiles)
+		}
+
+		counts, err := store.TypeCounts()
+		if err != nil {
+			t.Fatalf("Error counting types: %s", err)
+		}
+		if !reflect.DeepEqual(counts, map[string]int{"User": 3, "UserProfile": 3}) {
+			t.Fatalf("Unexpected type counts: %v", counts)
+		}
+
+		err = store.DeleteMatching(&User{}, nil)
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+
+		err = store.Find(&profiles, nil)
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(profiles) != len(keys) {
+			t.Fatalf("Deleting the users deleted profiles, %d are left", len(profiles))
+		}
+	})
+}
+
+func TestMigrateKeys(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		// write records in the old layout, where the type name runs into the key
+		err := store.Badger().Update(func(tx *badger.Txn) error {
+			for _, key := range []string{"alice", "bob"} {
+				encodedKey, err := badgerhold.DefaultEncode(key)
+				if err != nil {
+					return err
+				}
+
+				user, err := badgerhold.DefaultEncode(&User{Name: key})
+				if err != nil {
+					return err
+				}
+				err = tx.Set(append([]byte("bh_User"), encodedKey...), user)
+				if err != nil {
+					return err
+				}
+
+				profile, err := badgerhold.DefaultEncode(&UserProfile{Name: key, Bio: "profile"})
+				if err != nil {
+					return err
+				}
+				err = tx.Set(append([]byte("bh_UserProfile"), encodedKey...), profile)
+				if err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error writing old keys: %s", err)
+		}
+
+		err = store.Get("alice", &User{})
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Read an old key before it was migrated: %v", err)
+		}
+
+		moved, err := store.MigrateKeys(&User{}, &UserProfile{})
+		if err != nil {
+			t.Fatalf("Error migrating keys: %s", err)
+		}
+		if moved != 4 {
+			t.Fatalf("Moved %d records, wanted 4", moved)
+		}
+
+		user := &User{}
+		err = store.Get("alice", user)
+		if err != nil {
+			t.Fatalf("Error getting a migrated record: %s", err)
+		}
+		if user.Name != "alice" {
+			t.Fatalf("Got the wrong user: %v", user)
+		}
+
+		profile := &UserProfile{}
+		err = store.Get("bob", profile)
+		if err != nil {
+			t.Fatalf("Error getting a migrated record: %s", err)
+		}
+		if profile.Bio != "profile" {
+			t.Fatalf("Got the wrong profile: %v", profile)
+		}
+
+		var users []User
+		err = store.Find(&users, badgerhold.Where("Name").Eq("bob").Index("Name"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(users) != 1 || users[0].Name != "bob" {
+			t.Fatalf("The migrated index found %v", users)
+		}
+
+		moved, err = store.MigrateKeys(&User{}, &UserProfile{})
+		if err != nil {
+			t.Fatalf("Error migrating keys: %s", err)
+		}
+		if moved != 0 {
+			t.Fatalf("Migrating again moved %d records", moved)
+		}
+	})
+}
+
+func TestMigrateKeysTxnTooBig(t *testing.T) {
+	opt := testOptions()
+	// a small table size keeps badger's transactions small, so the migration can't fit in one
+	opt.MaxTableSize = 1 << 16
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	size := 100
+	bio := strings.Repeat("b", 1024)
+	for i := 0; i < size; i++ {
+		err = store.Badger().Update(func(tx *badger.Txn) error {
+			encodedKey, err := badgerhold.DefaultEncode(i)
+			if err != nil {
+				return err
+			}
+
+			profile, err := badgerhold.DefaultEncode(&UserProfile{Name: fmt.Sprintf("user %d", i), Bio: bio})
+			if err != nil {
+				return err
+			}
+			return tx.Set(append([]byte("bh_UserProfile"), encodedKey...), profile)
+		})
+		if err != nil {
+			t.Fatalf("Error writing old keys: %s", err)
+		}
+	}
+
+	moved, err := store.MigrateKeys(&UserProfile{})
+	if err != nil {
+		t.Fatalf("Error migrating keys: %s", err)
+	}
+	if moved != size {
+		t.Fatalf("Moved %d records, wanted %d", moved, size)
+	}
+
+	var profiles []UserProfile
+	err = store.Find(&profiles, nil)
+	if err != nil {
+		t.Fatalf("Error finding data: %s", err)
+	}
+	if len(profiles) != size {
+		t.Fatalf("Found %d migrated records, wanted %d", len(profiles), size)
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+)
+
+// lengthCheck is a criterion on the length of a slice, array, map or string field
+type lengthCheck struct {
+	operator int
+	length   int
+}
+
+// test compares the length of the field value, nil values have a length of 0
+func (l lengthCheck) test(value interface{}) (bool, error) {
+	v := reflect.ValueOf(value)
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return compareResult(l.operator, 0-l.length), nil
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Invalid:
+		return compareResult(l.operator, 0-l.length), nil
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.String:
+		return compareResult(l.operator, v.Len()-l.length), nil
+	default:
+		return false, fmt.Errorf("Length criteria can only be used on slice, array, map and string fields, not %T",
+			value)
+	}
+}
+
+func (l lengthCheck) String() string {
+	switch l.operator {
+	case eq:
+		return fmt.Sprintf("length == %d", l.length)
+	case gt:
+		return fmt.Sprintf("length > %d", l.length)
+	default:
+		return fmt.Sprintf("length < %d", l.length)
+	}
+}
+
+// LenEq will test if the length of a slice, array, map or string field is equal to the passed in length
+func (c *Criterion) LenEq(length int) *Query {
+	return c.lenOp(eq, length)
+}
+
+// LenGt will test if the length of a slice, array, map or string field is greater than the passed in length
+func (c *Criterion) LenGt(length int) *Query {
+	return c.lenOp(gt, length)
+}
+
+// LenLt will test if the length of a slice, array, map or string field is less than the passed in length
+func (c *Criterion) LenLt(length int) *Query {
+	return c.lenOp(lt, length)
+}
+
+func (c *Criterion) lenOp(operator, length int) *Query {
+	if c.query.currentField == Key {
+		panic("Length criteria cannot be used against Keys")
+	}
+
+	return c.op(ln, lengthCheck{operator: operator, length: length})
+}
@@ -0,0 +1,272 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// schemaPrefix starts the badger key that stores the schema version of each type, along with the progress of a
+// migration that's running
+const schemaPrefix = "_bhSchema:"
+
+// schemaReindex is set in a schema state when the type's records have been migrated or bulk loaded, but its indexes
+// haven't been rebuilt yet.  Queries on the type's indexes fail with ErrIndexesStale while it's set
+const schemaReindex = 1 << 0
+
+// Migration moves the records of a type from the previous schema version to Version.  Each record is decoded into a
+// new value of From's type, the shape the records had at the previous version, and passed to Migrate as a pointer.  The
+// value Migrate returns is stored in its place, and needs to be the From of the next migration, or the type being
+// migrated for the last migration
+type Migration struct {
+	Version int
+	From    interface{}
+	Migrate func(record interface{}) (interface{}, error)
+}
+
+// schemaState is the stored schema version of a type.  next is the key of the first record that the migration to
+// version+1 hasn't moved yet, or nil if that migration hasn't started
+type schemaState struct {
+	version uint64
+	flags   byte
+	next    []byte
+}
+
+// Migrate runs the migrations whose versions are newer than the stored schema version of dataType, in order, then
+// rebuilds the type's indexes.  The records are migrated in batches, and the batch's progress and the new schema version
+// are stored in the same transaction as its records, so if Migrate fails part of the way through, running it again
+// picks up where it stopped.  A type without any records is already in its latest shape, so its schema version is set
+// to the newest migration without running any, which means Migrate needs to be called before records of the new shape
+// are written.  If the indexes can't be rebuilt, they stay stale, and queries that use them return an error until
+// they're rebuilt with ReIndex.  Migrate panics if the migrations aren't in increasing order of version
+func (s *Store) Migrate(dataType interface{}, migrations []Migration) error {
+	for i := range migrations {
+		if migrations[i].Version <= 0 || (i > 0 && migrations[i].Version <= migrations[i-1].Version) {
+			panic("Migrations must have positive versions in increasing order")
+		}
+		if migrations[i].From == nil || migrations[i].Migrate == nil {
+			panic("Migrations must have a From type and a Migrate func")
+		}
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	storer := s.storer(dataType)
+	typeName := storer.Type()
+
+	state, err := s.schemaState(typeName)
+	if err != nil {
+		return err
+	}
+
+	if state.version == 0 && state.next == nil && len(migrations) > 0 {
+		found, err := s.hasPrefix(typePrefix(typeName))
+		if err != nil {
+			return err
+		}
+		if !found {
+			return s.update(func(tx *badger.Txn) error {
+				return setSchemaState(tx, typeName, schemaState{
+					version: uint64(migrations[len(migrations)-1].Version),
+				})
+			})
+		}
+	}
+
+	for i := range migrations {
+		if uint64(migrations[i].Version) <= state.version {
+			continue
+		}
+
+		wanted := tp
+		if i+1 < len(migrations) {
+			wanted = derefType(reflect.TypeOf(migrations[i+1].From))
+		}
+
+		state, err = s.runMigration(typeName, state, migrations[i], wanted)
+		if err != nil {
+			return err
+		}
+	}
+
+	s.purgeCaches()
+
+	if state.flags&schemaReindex == 0 {
+		return nil
+	}
+
+	// the index entries hold the values of the old shape
+	_, err = s.deletePrefix(typeIndexPrefix(typeName))
+	if err != nil {
+		return err
+	}
+
+	for indexName, index := range storer.Indexes() {
+		err = s.rebuildIndex(dataType, typeName, indexName, index)
+		if err != nil {
+			// the indexes are left marked as stale, so queries don't use the partly rebuilt ones until ReIndex is run
+			s.update(func(tx *badger.Txn) error {
+				return setSchemaState(tx, typeName, state)
+			})
+			return err
+		}
+	}
+
+	state.flags &^= schemaReindex
+	return s.update(func(tx *badger.Txn) error {
+		return setSchemaState(tx, typeName, state)
+	})
+}
+
+// runMigration migrates every record of the type that the migration hasn't moved yet, in batches, and returns the
+// schema state once it's done
+func (s *Store) runMigration(typeName string, state schemaState, migration Migration, wanted reflect.Type) (
+	schemaState, error) {
+	prefix := typePrefix(typeName)
+	from := derefType(reflect.TypeOf(migration.From))
+
+	for {
+		next := state
+		err := s.update(func(tx *badger.Txn) error {
+			next = state
+
+			iter := tx.NewIterator(badger.DefaultIteratorOptions)
+			defer iter.Close()
+
+			seek := prefix
+			if state.next != nil {
+				seek = state.next
+			}
+
+			batch := 0
+			for iter.Seek(seek); iter.ValidForPrefix(prefix); iter.Next() {
+				key := iter.Item().KeyCopy(nil)
+				if batch == migrateBatchSize {
+					next.next = key
+					return setSchemaState(tx, typeName, next)
+				}
+
+				value, err := iter.Item().ValueCopy(nil)
+				if err != nil {
+					return err
+				}
+
+				record := reflect.New(from)
+				err = s.decode(value, record.Interface())
+				if err != nil {
+					return err
+				}
+
+				migrated, err := migration.Migrate(record.Interface())
+				if err != nil {
+					return err
+				}
+				if migrated == nil || derefType(reflect.TypeOf(migrated)) != wanted {
+					return fmt.Errorf("The migration to version %d returned a %T instead of a %s",
+						migration.Version, migrated, wanted)
+				}
+
+				value, err = s.encode(migrated)
+				if err != nil {
+					return err
+				}
+
+				err = tx.Set(key, value)
+				if err != nil {
+					return err
+				}
+				batch++
+			}
+
+			next = schemaState{
+				version: uint64(migration.Version),
+				flags:   state.flags | schemaReindex,
+			}
+			return setSchemaState(tx, typeName, next)
+		})
+		if err != nil {
+			return state, err
+		}
+
+		state = next
+		if state.next == nil {
+			return state, nil
+		}
+	}
+}
+
+// SchemaVersion returns the stored schema version of dataType, which is 0 if Migrate has never been run for it
+func (s *Store) SchemaVersion(dataType interface{}) (int, error) {
+	state, err := s.schemaState(s.storer(dataType).Type())
+	return int(state.version), err
+}
+
+// schemaState reads the stored schema state of the type
+func (s *Store) schemaState(typeName string) (schemaState, error) {
+	var state schemaState
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		var err error
+		state, err = txSchemaState(tx, typeName)
+		return err
+	})
+	return state, err
+}
+
+// txSchemaState reads the stored schema state of the type in the transaction
+func txSchemaState(tx *badger.Txn, typeName string) (schemaState, error) {
+	var state schemaState
+	item, err := tx.Get(schemaKey(typeName))
+	if err == badger.ErrKeyNotFound {
+		return state, nil
+	}
+	if err != nil {
+		return state, err
+	}
+
+	err = item.Value(func(value []byte) error {
+		if len(value) < 9 {
+			return fmt.Errorf("The schema version of the type %s is corrupt", typeName)
+		}
+		state.version = binary.BigEndian.Uint64(value)
+		state.flags = value[8]
+		if len(value) > 9 {
+			state.next = append([]byte{}, value[9:]...)
+		}
+		return nil
+	})
+	return state, err
+}
+
+// setSchemaState stores the schema state of the type
+func setSchemaState(tx *badger.Txn, typeName string, state schemaState) error {
+	var value bytes.Buffer
+	var version [8]byte
+	binary.BigEndian.PutUint64(version[:], state.version)
+	value.Write(version[:])
+	value.WriteByte(state.flags)
+	value.Write(state.next)
+
+	return tx.Set(schemaKey(typeName), value.Bytes())
+}
+
+// schemaKey returns the badger key of the type's schema state
+func schemaKey(typeName string) []byte {
+	return []byte(schemaPrefix + typeName)
+}
+
+// derefType returns the type tp points to, through any number of pointers
+func derefType(tp reflect.Type) reflect.Type {
+	for tp != nil && tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	return tp
+}
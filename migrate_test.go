@@ -0,0 +1,141 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/timshannon/badgerhold"
+)
+
+type Member struct {
+	FirstName string
+	LastName  string `badgerholdIndex:"LastName"`
+	Age       int
+}
+
+// memberV1 is the shape Member records were first written in
+type memberV1 struct {
+	Name string
+	Age  int
+}
+
+func (m *memberV1) Type() string                         { return "Member" }
+func (m *memberV1) Indexes() map[string]badgerhold.Index { return nil }
+
+type memberV2 struct {
+	FirstName string
+	LastName  string
+	Age       int
+}
+
+func memberMigrations(calls *int, failAt int) []badgerhold.Migration {
+	return []badgerhold.Migration{
+		{
+			Version: 1,
+			From:    memberV1{},
+			Migrate: func(record interface{}) (interface{}, error) {
+				*calls++
+				if *calls == failAt {
+					return nil, fmt.Errorf("migration failed")
+				}
+				old := record.(*memberV1)
+				names := strings.SplitN(old.Name, " ", 2)
+				return &memberV2{FirstName: names[0], LastName: names[1], Age: old.Age}, nil
+			},
+		},
+		{
+			Version: 2,
+			From:    memberV2{},
+			Migrate: func(record interface{}) (interface{}, error) {
+				old := record.(*memberV2)
+				return &Member{FirstName: old.FirstName, LastName: strings.ToUpper(old.LastName), Age: old.Age}, nil
+			},
+		},
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		const count = 2500
+		for i := 0; i < count; i++ {
+			err := store.Insert(i, &memberV1{Name: fmt.Sprintf("Member %d", i%10), Age: i})
+			if err != nil {
+				t.Fatalf("Error inserting member: %s", err)
+			}
+		}
+
+		// fail part of the way through the second batch, the first batch stays migrated
+		calls := 0
+		err := store.Migrate(&Member{}, memberMigrations(&calls, 1500))
+		if err == nil {
+			t.Fatalf("Migrate didn't return the migration's error")
+		}
+
+		calls = 0
+		err = store.Migrate(&Member{}, memberMigrations(&calls, -1))
+		if err != nil {
+			t.Fatalf("Error migrating members: %s", err)
+		}
+		if calls != count-1000 {
+			t.Fatalf("Migrate migrated %d records after the failure wanted %d", calls, count-1000)
+		}
+
+		version, err := store.SchemaVersion(&Member{})
+		if err != nil {
+			t.Fatalf("Error getting the schema version: %s", err)
+		}
+		if version != 2 {
+			t.Fatalf("Schema version is %d wanted 2", version)
+		}
+
+		var member Member
+		err = store.Get(1234, &member)
+		if err != nil {
+			t.Fatalf("Error getting member: %s", err)
+		}
+		if member.FirstName != "Member" || member.LastName != "4" || member.Age != 1234 {
+			t.Fatalf("Unexpected migrated member: %+v", member)
+		}
+
+		var result []Member
+		err = store.Find(&result, badgerhold.Where("LastName").Eq("7").Index("LastName"))
+		if err != nil {
+			t.Fatalf("Error finding members: %s", err)
+		}
+		if len(result) != count/10 {
+			t.Fatalf("Index found %d members wanted %d", len(result), count/10)
+		}
+
+		// running it again doesn't migrate anything
+		calls = 0
+		err = store.Migrate(&Member{}, memberMigrations(&calls, -1))
+		if err != nil {
+			t.Fatalf("Error migrating members again: %s", err)
+		}
+		if calls != 0 {
+			t.Fatalf("Migrating members again migrated %d records", calls)
+		}
+	})
+
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		// a type without records is already in its latest shape
+		calls := 0
+		err := store.Migrate(&Member{}, memberMigrations(&calls, -1))
+		if err != nil {
+			t.Fatalf("Error migrating members: %s", err)
+		}
+
+		version, err := store.SchemaVersion(&Member{})
+		if err != nil {
+			t.Fatalf("Error getting the schema version: %s", err)
+		}
+		if version != 2 || calls != 0 {
+			t.Fatalf("Migrating an empty type set version %d and migrated %d records", version, calls)
+		}
+	})
+}
@@ -0,0 +1,158 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"reflect"
+	"strings"
+)
+
+// nestedIndex is an index on a field of a struct field, named after its dotted path, such as Address.City
+type nestedIndex struct {
+	path   string
+	field  reflect.StructField
+	unique bool
+}
+
+// nestedIndexes returns the indexes defined on the fields of the struct fields of tp.  These are either tagged on the
+// nested field itself, or declared on the struct field with the path of the nested field as its badgerholdIndex or
+// badgerholdUnique tag, `badgerholdIndex:"Address.City"`
+func nestedIndexes(tp reflect.Type) []nestedIndex {
+	var indexes []nestedIndex
+
+	var walk func(current reflect.Type, prefix string, visited map[reflect.Type]bool)
+	walk = func(current reflect.Type, prefix string, visited map[reflect.Type]bool) {
+		visited[current] = true
+		defer delete(visited, current)
+
+		for _, field := range promotedFields(current) {
+			if field.Anonymous || isSkipped(field) {
+				// promoted fields are indexed by their own name
+				continue
+			}
+
+			path := field.Name
+			if prefix != "" {
+				path = prefix + "." + field.Name
+				if name, unique := indexTag(field); name != "" {
+					indexes = append(indexes, nestedIndex{path: path, field: field, unique: unique})
+				}
+			}
+
+			if declared, unique := pathTag(field); declared != "" {
+				nested, ok := pathField(field.Type, strings.TrimPrefix(declared, field.Name+"."))
+				if !ok {
+					panic("Invalid Type for Storer.  The index path " + declared + " does not exist")
+				}
+				indexes = append(indexes, nestedIndex{path: prefixPath(prefix, declared), field: nested,
+					unique: unique})
+			}
+
+			nested := field.Type
+			if nested.Kind() == reflect.Ptr {
+				nested = nested.Elem()
+			}
+			if nested.Kind() == reflect.Struct && !visited[nested] {
+				walk(nested, path, visited)
+			}
+		}
+	}
+	walk(tp, "", make(map[reflect.Type]bool))
+
+	return indexes
+}
+
+// prefixPath returns the path under the prefix, if there is one
+func prefixPath(prefix, path string) string {
+	if prefix == "" {
+		return path
+	}
+	return prefix + "." + path
+}
+
+// pathTag returns the path of the nested field the struct field's badgerholdIndex or badgerholdUnique tag declares an
+// index on, and whether it's unique.  The path is empty if the tag doesn't declare one
+func pathTag(field reflect.StructField) (path string, unique bool) {
+	if tag := field.Tag.Get(BadgerHoldUniqueTag); tag != "" {
+		if strings.HasPrefix(tag, field.Name+".") {
+			return tag, true
+		}
+		return "", false
+	}
+
+	if tag := field.Tag.Get(BadgerHoldIndexTag); strings.HasPrefix(tag, field.Name+".") {
+		return tag, false
+	}
+	return "", false
+}
+
+// pathField returns the struct field at the dotted path in the struct type tp
+func pathField(tp reflect.Type, path string) (reflect.StructField, bool) {
+	var field reflect.StructField
+	for _, name := range strings.Split(path, ".") {
+		for tp.Kind() == reflect.Ptr {
+			tp = tp.Elem()
+		}
+		if tp.Kind() != reflect.Struct {
+			return reflect.StructField{}, false
+		}
+
+		var ok bool
+		field, ok = tp.FieldByName(name)
+		if !ok {
+			return reflect.StructField{}, false
+		}
+		tp = field.Type
+	}
+	return field, true
+}
+
+// pathValue returns the value of the field at the dotted path in the struct value, or false if a nil pointer along
+// the path means there isn't one
+func pathValue(value reflect.Value, path string) (reflect.Value, bool) {
+	current := value
+	for _, name := range strings.Split(path, ".") {
+		for current.Kind() == reflect.Ptr {
+			if current.IsNil() {
+				return reflect.Value{}, false
+			}
+			current = current.Elem()
+		}
+
+		// the paths of struct tag indexes are made of Go field names
+		current = fieldValueByName(current, name, "")
+		if !current.IsValid() {
+			return reflect.Value{}, false
+		}
+	}
+	return current, true
+}
+
+// tagIndexField returns the field indexed by the index of tp, if the index is defined by struct tags, whether on one
+// of the type's own fields or a nested one
+func tagIndexField(tp reflect.Type, indexName string) (reflect.StructField, bool) {
+	if tp == nil || tp.Kind() != reflect.Struct || reflect.PtrTo(tp).Implements(storerType) {
+		return reflect.StructField{}, false
+	}
+
+	if !strings.Contains(indexName, ".") {
+		field, ok := tp.FieldByName(indexName)
+		if !ok {
+			return reflect.StructField{}, false
+		}
+		if name, _ := indexTag(field); name != indexName {
+			// an index added with AddIndex that happens to share the field's name
+			return reflect.StructField{}, false
+		}
+		return field, true
+	}
+
+	for _, nested := range nestedIndexes(tp) {
+		if nested.path == indexName {
+			return nested.field, true
+		}
+	}
+	return reflect.StructField{}, false
+}
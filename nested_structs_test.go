@@ -205,3 +205,94 @@ func TestNested(t *testing.T) {
 		}
 	})
 }
+
+type Address struct {
+	City    string `badgerholdIndex:"City"`
+	Country string
+}
+
+type Recipient struct {
+	Name     string
+	Home     Address
+	Shipping *Address `badgerholdIndex:"Shipping.Country"`
+}
+
+func TestNestedIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		recipients := []Recipient{
+			{Name: "a", Home: Address{City: "Paris", Country: "FR"}, Shipping: &Address{Country: "FR"}},
+			{Name: "b", Home: Address{City: "Lyon", Country: "FR"}, Shipping: &Address{Country: "DE"}},
+			{Name: "c", Home: Address{City: "Paris", Country: "FR"}},
+		}
+		for i := range recipients {
+			err := store.Insert(i, &recipients[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		tests := []struct {
+			name   string
+			query  *badgerhold.Query
+			result []string
+		}{
+			{"tagged on the nested field", badgerhold.Where("Home.City").Eq("Paris").Index("Home.City"),
+				[]string{"a", "c"}},
+			{"declared on the struct field", badgerhold.Where("Shipping.Country").Eq("DE").Index("Shipping.Country"),
+				[]string{"b"}},
+			{"nested pointer range", badgerhold.Where("Shipping.Country").Ge("A").Index("Shipping.Country"),
+				[]string{"b", "a"}},
+			{"nested and other criteria", badgerhold.Where("Home.City").Eq("Paris").And("Name").Ne("a").
+				Index("Home.City"), []string{"c"}},
+		}
+
+		for _, tst := range tests {
+			t.Run(tst.name, func(t *testing.T) {
+				index, fullScan, err := store.WillUseIndex(&Recipient{}, tst.query)
+				if err != nil {
+					t.Fatalf("Error checking the query's index: %s", err)
+				}
+				if index == "" || fullScan {
+					t.Fatalf("WillUseIndex returned %q, %t wanted the nested index", index, fullScan)
+				}
+
+				var result []Recipient
+				err = store.Find(&result, tst.query)
+				if err != nil {
+					t.Fatalf("Error finding data: %s", err)
+				}
+				if len(result) != len(tst.result) {
+					t.Fatalf("Found %v wanted %v", result, tst.result)
+				}
+				for i := range result {
+					if result[i].Name != tst.result[i] {
+						t.Fatalf("Result %d is %s wanted %s", i, result[i].Name, tst.result[i])
+					}
+				}
+			})
+		}
+
+		// the nested index is kept up to date, and records with a nil pointer on the path aren't indexed
+		err := store.Update(2, &Recipient{Name: "c", Home: Address{City: "Lyon"}, Shipping: &Address{Country: "DE"}})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		var result []Recipient
+		err = store.Find(&result, badgerhold.Where("Home.City").Eq("Lyon").Index("Home.City"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 2 {
+			t.Fatalf("Found %v in the nested index after the update wanted b and c", result)
+		}
+
+		dump, err := store.DumpIndex(&Recipient{}, "Shipping.Country")
+		if err != nil {
+			t.Fatalf("Error dumping index: %s", err)
+		}
+		if len(dump) != 2 || dump[0].Value != "DE" || len(dump[0].Keys) != 2 {
+			t.Fatalf("The nested index holds %v wanted DE for b and c, and FR for a", dump)
+		}
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+const (
+	// BadgerHoldNormalizeTag is the struct tag used to normalize the values of an indexed string field before they're
+	// written to the index, either "lower" to lower case them, or "fold" to apply unicode case folding
+	BadgerHoldNormalizeTag = "badgerholdNormalize"
+
+	normalizeLowerValue = "lower"
+	normalizeFoldValue  = "fold"
+)
+
+// normalizer returns the func that normalizes the values of the indexed field, or nil if they aren't normalized
+// panics if the field's normalize tag is invalid
+func normalizer(field reflect.StructField) func(string) string {
+	tag := field.Tag.Get(BadgerHoldNormalizeTag)
+	if tag == "" {
+		return nil
+	}
+	if field.Type.Kind() != reflect.String {
+		panic("Invalid Type for Storer.  The field " + field.Name + " isn't a string, so it can't be normalized")
+	}
+
+	switch tag {
+	case normalizeLowerValue:
+		return strings.ToLower
+	case normalizeFoldValue:
+		return foldCase
+	default:
+		panic("Invalid Type for Storer.  The field " + field.Name + " has an unknown normalization " + tag)
+	}
+}
+
+// foldCase replaces every rune with the lower case of the smallest rune it's equivalent to under unicode simple case
+// folding, so strings that are equal under strings.EqualFold are folded to the same string
+func foldCase(s string) string {
+	return strings.Map(func(r rune) rune {
+		least := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			if f < least {
+				least = f
+			}
+		}
+		return unicode.ToLower(least)
+	}, s)
+}
+
+// indexNormalizer returns the func that normalizes the values of the query's index, or nil if they aren't normalized
+func indexNormalizer(tp reflect.Type, indexName string) func(string) string {
+	field, ok := tagIndexField(tp, indexName)
+	if !ok {
+		return nil
+	}
+	return normalizer(field)
+}
+
+// normalizeCriteria returns copies of the criteria with their string values normalized, so they can be tested against
+// the values of a normalized index
+func normalizeCriteria(criteria []*Criterion, normalize func(string) string) []*Criterion {
+	normalized := make([]*Criterion, len(criteria))
+	for i, c := range criteria {
+		entry := *c
+		if value, ok := entry.value.(string); ok {
+			entry.value = normalize(value)
+		}
+		if entry.inValues != nil {
+			entry.inValues = make([]interface{}, len(c.inValues))
+			for k := range c.inValues {
+				entry.inValues[k] = c.inValues[k]
+				if value, ok := c.inValues[k].(string); ok {
+					entry.inValues[k] = normalize(value)
+				}
+			}
+		}
+		normalized[i] = &entry
+	}
+	return normalized
+}
@@ -0,0 +1,136 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+)
+
+// number of records read per worker before a batch is handed off to the workers
+const parallelBatchSize = 100
+
+var errParallelSubQuery = errors.New("SubQueries cannot be run from within a parallel query")
+
+// parallelMatcher reads records from an iterator in batches, and decodes and tests them against the query criteria
+// in a pool of goroutines.  Badger transactions aren't safe to use across goroutines, so the workers only
+// see the copies of the values returned from the iterator
+type parallelMatcher struct {
+	iter          *iterator
+	query         *Query
+	dataType      reflect.Type
+	retrievedKeys keyList
+
+	batch []*parallelResult
+	done  bool
+}
+
+type parallelResult struct {
+	key   []byte
+	value []byte
+	rec   reflect.Value
+	ok    bool
+	err   error
+}
+
+func newParallelMatcher(iter *iterator, query *Query, dataType reflect.Type,
+	retrievedKeys keyList) *parallelMatcher {
+	return &parallelMatcher{
+		iter:          iter,
+		query:         query,
+		dataType:      dataType,
+		retrievedKeys: retrievedKeys,
+	}
+}
+
+// next returns the next record that matches the query, in iterator order, or nil if there are no more matches
+func (p *parallelMatcher) next() (*record, error) {
+	for {
+		if len(p.batch) == 0 {
+			if p.done {
+				return nil, nil
+			}
+
+			err := p.fill()
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		r := p.batch[0]
+		p.batch = p.batch[1:]
+
+		if r.err != nil {
+			return nil, r.err
+		}
+
+		if r.ok {
+			return &record{
+				key:   r.key,
+				value: r.rec,
+			}, nil
+		}
+	}
+}
+
+// fill reads the next batch of records from the iterator and tests them across the workers
+func (p *parallelMatcher) fill() error {
+	batch := make([]*parallelResult, 0, p.query.parallel*parallelBatchSize)
+
+	for len(batch) < cap(batch) {
+		k, v := p.iter.Next()
+		if k == nil {
+			if p.iter.Error() != nil {
+				return p.iter.Error()
+			}
+			p.done = true
+			break
+		}
+
+		if len(p.retrievedKeys) != 0 {
+			// don't check this record if it's already been retrieved
+			if p.retrievedKeys.in(k) {
+				continue
+			}
+		}
+
+		batch = append(batch, &parallelResult{
+			key:   k,
+			value: v,
+		})
+	}
+
+	jobs := make(chan *parallelResult, p.query.parallel)
+	var wg sync.WaitGroup
+
+	for i := 0; i < p.query.parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for r := range jobs {
+				r.rec = reflect.New(p.dataType)
+				p.query.stats.decodedRecord()
+				r.err = p.query.store.decode(r.value, r.rec.Interface())
+				if r.err != nil {
+					r.err = p.query.skipDecodeError(r.key, r.err)
+					continue
+				}
+
+				r.ok, r.err = p.query.matchesAllFields(r.key, r.rec, r.rec.Interface())
+			}
+		}()
+	}
+
+	for i := range batch {
+		jobs <- batch[i]
+	}
+	close(jobs)
+	wg.Wait()
+
+	p.batch = batch
+	return nil
+}
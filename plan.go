@@ -0,0 +1,98 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/dgraph-io/badger"
+)
+
+// WillUseIndex returns the index the query will read its matching keys from, without running the query, and whether
+// it will scan every record of dataType instead.  indexName is empty when the query reads the records directly, which
+// isn't a full scan if its Key criteria narrow down the range of keys.  If any of the query's Ors scans every record,
+// so does the query.  It returns the same error the query would if its index doesn't exist
+func (s *Store) WillUseIndex(dataType interface{}, query *Query) (indexName string, fullScan bool, err error) {
+	err = s.Badger().View(func(tx *badger.Txn) error {
+		indexName, fullScan, err = s.TxWillUseIndex(tx, dataType, query)
+		return err
+	})
+	return indexName, fullScan, err
+}
+
+// TxWillUseIndex is the same as WillUseIndex, but you specify your own transaction
+func (s *Store) TxWillUseIndex(tx *badger.Txn, dataType interface{}, query *Query) (indexName string, fullScan bool,
+	err error) {
+	query = s.preloaded(query)
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if query.boundType != nil && query.boundType != tp {
+		return "", false, &ErrTypeMismatch{reflect.Zero(query.boundType).Interface(), reflect.Zero(tp).Interface()}
+	}
+
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	iter := tx.NewIterator(opts)
+	defer iter.Close()
+
+	return queryPlan(tx, iter, newStorer(dataType, s.encodeValue).Type(), tp, query)
+}
+
+// queryPlan makes the same choice newIterator does for the query and its ors, without changing the query
+func queryPlan(tx *badger.Txn, iter *badger.Iterator, typeName string, tp reflect.Type, query *Query) (string, bool,
+	error) {
+	indexName, fullScan := "", true
+
+	if query.index != "" {
+		if indexesStale(tx, typeName) {
+			return "", false, ErrIndexesStale
+		}
+		if indexBuilding(tx, typeName, query.index) {
+			// every record is scanned until the index is built
+			return "", true, nil
+		}
+		if !indexExists(iter, typeName, query.index) {
+			return "", false, fmt.Errorf("The index %s does not exist", query.index)
+		}
+
+		planned := *query
+		planned.dataType = tp
+
+		criteria := query.fieldCriteria[query.index]
+		exact := mapIndexKey(indexKeyPrefix(typeName, query.index), &planned, criteria)
+		if operators := elementOperators(tp, query.index); operators != nil {
+			criteria = elementCriteria(criteria, operators)
+		} else if query.skipsIndex(query.index) {
+			criteria = nil
+		}
+		if fields := compositeIndexFields(tp, query.index); fields != nil {
+			// a composite index is used when there are criteria on its first field
+			criteria, exact = nil, nil
+			if query.coversComposite(fields) {
+				indexName, fullScan = query.index, false
+			}
+		}
+		if len(criteria) != 0 || exact != nil {
+			indexName, fullScan = query.index, false
+		}
+	} else if !query.noIndex && !query.skipsIndex(Key) {
+		start, end := keyRange(typePrefix(typeName), query.fieldCriteria[Key])
+		fullScan = start == nil && end == nil
+	}
+
+	for i := range query.ors {
+		_, orScan, err := queryPlan(tx, iter, typeName, tp, query.ors[i])
+		if err != nil {
+			return "", false, err
+		}
+		fullScan = fullScan || orScan
+	}
+
+	return indexName, fullScan, nil
+}
@@ -0,0 +1,285 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/dgraph-io/badger"
+)
+
+// indexPreloads holds the keys of the indexes loaded into memory with PreloadIndex.
+//
+// Like the Get cache, every record write drops the preloaded indexes of the record's type, and the writes made in
+// transactions the store runs itself drop them again once the transaction has committed.  A preloaded index is only
+// kept if nothing was written to its type while it was loading, and is only used by transactions that started after it
+// was read.  Writes made in a transaction the store doesn't run can't be tracked to their commit, so the indexes of
+// their type can't be preloaded from then on.  Those types are kept by the start of their record keys up to the first
+// separator, which also covers any type whose name starts with the same segment
+type indexPreloads struct {
+	sync.Mutex
+	entries   map[string]*preloadedIndex
+	epoch     uint64
+	untracked map[string]bool
+	pending   map[*badger.Txn][][]byte
+}
+
+// preloadedIndex is every badger key of an index, in order, as of the readTs of the transaction that read them
+type preloadedIndex struct {
+	typePrefix []byte
+	keys       [][]byte
+	readTs     uint64
+	size       int
+}
+
+func newIndexPreloads() *indexPreloads {
+	return &indexPreloads{
+		entries:   make(map[string]*preloadedIndex),
+		untracked: make(map[string]bool),
+		pending:   make(map[*badger.Txn][][]byte),
+	}
+}
+
+// PreloadIndex reads every key of the index into memory, so queries on the index don't read it from badger.  It's meant
+// for indexes of read mostly types that fit in RAM.  Any write to a record of dataType drops its preloaded indexes,
+// and they're only loaded again by another call to PreloadIndex, so check with PreloadedIndexSize whether an index
+// is still preloaded.  Once a record of dataType has been written in a transaction you manage yourself, unless it was
+// started by WithRetry, the write can't be tracked to its commit, and PreloadIndex returns ErrPreloadUntracked
+func (s *Store) PreloadIndex(dataType interface{}, indexName string) error {
+	typeName := s.storer(dataType).Type()
+	prefix := indexKeyPrefix(typeName, indexName)
+
+	entry := &preloadedIndex{typePrefix: typePrefix(typeName)}
+
+	s.preloads.Lock()
+	epoch := s.preloads.epoch
+	untracked := s.preloads.untracked[string(untrackedPrefix(entry.typePrefix))]
+	s.preloads.Unlock()
+
+	if untracked {
+		return ErrPreloadUntracked
+	}
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		entry.readTs = tx.ReadTs()
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+		iter := tx.NewIterator(opts)
+		defer iter.Close()
+
+		if !indexExists(iter, typeName, indexName) {
+			return fmt.Errorf("The index %s does not exist", indexName)
+		}
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			entry.keys = append(entry.keys, key)
+			entry.size += len(key) + preloadedKeyOverhead
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	s.preloads.install(string(prefix), entry, epoch)
+	return nil
+}
+
+// PreloadedIndexSize returns the approximate number of bytes of memory the preloaded keys of the index use, and
+// whether the index is currently preloaded
+func (s *Store) PreloadedIndexSize(dataType interface{}, indexName string) (int, bool) {
+	prefix := indexKeyPrefix(s.storer(dataType).Type(), indexName)
+
+	s.preloads.Lock()
+	defer s.preloads.Unlock()
+
+	entry, ok := s.preloads.entries[string(prefix)]
+	if !ok {
+		return 0, false
+	}
+	return entry.size, true
+}
+
+// ErrPreloadUntracked is the error returned by PreloadIndex once a record of the type has been written in a transaction
+// the store can't track to its commit
+var ErrPreloadUntracked = errors.New("The indexes of the type can't be preloaded, as its records have been written " +
+	"in a transaction the store doesn't manage")
+
+// preloadedKeyOverhead is the memory used by each preloaded key on top of its bytes, the size of its slice header
+const preloadedKeyOverhead = 24
+
+// install keeps the preloaded index, unless one of its type's records was written since epoch, or is being written
+// in a transaction that hasn't settled yet
+func (p *indexPreloads) install(prefix string, entry *preloadedIndex, epoch uint64) {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.epoch != epoch {
+		return
+	}
+	for _, keys := range p.pending {
+		for i := range keys {
+			if bytes.HasPrefix(keys[i], entry.typePrefix) {
+				return
+			}
+		}
+	}
+
+	p.entries[prefix] = entry
+}
+
+// lookup returns the keys of the preloaded index, if it's preloaded and was read before tx started
+func (p *indexPreloads) lookup(tx *badger.Txn, prefix []byte) ([][]byte, bool) {
+	if p == nil {
+		return nil, false
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	entry, ok := p.entries[string(prefix)]
+	if !ok || tx.ReadTs() < entry.readTs {
+		return nil, false
+	}
+	return entry.keys, true
+}
+
+// drop removes the preloaded indexes of the types of the records with the encoded keys, and starts a new epoch
+func (p *indexPreloads) drop(keys ...[]byte) {
+	p.Lock()
+	defer p.Unlock()
+
+	p.epoch++
+	for prefix, entry := range p.entries {
+		for i := range keys {
+			if bytes.HasPrefix(keys[i], entry.typePrefix) {
+				delete(p.entries, prefix)
+				break
+			}
+		}
+	}
+}
+
+// purge drops every preloaded index, such as after a Restore
+func (p *indexPreloads) purge() {
+	p.Lock()
+	defer p.Unlock()
+
+	p.epoch++
+	p.entries = make(map[string]*preloadedIndex)
+}
+
+// written drops the preloaded indexes of the type of a record written in tx, and again once tx commits if it's run by
+// the store
+func (p *indexPreloads) written(tx *badger.Txn, key []byte) {
+	p.drop(key)
+
+	p.Lock()
+	defer p.Unlock()
+
+	if keys, ok := p.pending[tx]; ok {
+		p.pending[tx] = append(keys, append([]byte{}, key...))
+		return
+	}
+	p.untracked[string(untrackedPrefix(key))] = true
+}
+
+// untrackedPrefix returns the start of the record key up to the first separator after the record prefix
+func untrackedPrefix(key []byte) []byte {
+	end := bytes.IndexByte(key[len(recordPrefix):], ':')
+	if end < 0 {
+		return key
+	}
+	return key[:len(recordPrefix)+end+1]
+}
+
+// tracked wraps fn so the records it writes are tracked to its transaction's commit.  settle needs to be called once
+// the transaction has committed or failed
+func (p *indexPreloads) tracked(fn func(tx *badger.Txn) error) (wrapped func(tx *badger.Txn) error, settle func()) {
+	var txn *badger.Txn
+
+	wrapped = func(tx *badger.Txn) error {
+		txn = tx
+		p.Lock()
+		p.pending[tx] = nil
+		p.Unlock()
+		return fn(tx)
+	}
+
+	settle = func() {
+		if txn == nil {
+			return
+		}
+
+		p.Lock()
+		keys := p.pending[txn]
+		delete(p.pending, txn)
+		p.Unlock()
+
+		if len(keys) != 0 {
+			p.drop(keys...)
+		}
+	}
+
+	return wrapped, settle
+}
+
+// preloaded has the query, or an empty one if it's nil, run against the store, so it reads the store's preloaded
+// indexes, looks up the indexes added to it, and decodes records with its Decoder and EncryptionKey
+func (s *Store) preloaded(query *Query) *Query {
+	if query == nil {
+		query = &Query{}
+	}
+	query.preloads = s.preloads
+	query.withStore(s)
+	return query
+}
+
+// keyCursor walks through an ordered set of badger keys, either from a badger iterator or a preloaded index
+type keyCursor interface {
+	Seek(key []byte)
+	ValidForPrefix(prefix []byte) bool
+	Next()
+	KeyCopy() []byte
+}
+
+// iteratorCursor is a keyCursor over a badger iterator
+type iteratorCursor struct {
+	*badger.Iterator
+}
+
+func (c iteratorCursor) KeyCopy() []byte {
+	return c.Item().KeyCopy(nil)
+}
+
+// sliceCursor is a keyCursor over the keys of a preloaded index
+type sliceCursor struct {
+	keys [][]byte
+	pos  int
+}
+
+func (c *sliceCursor) Seek(key []byte) {
+	c.pos = sort.Search(len(c.keys), func(i int) bool {
+		return bytes.Compare(c.keys[i], key) >= 0
+	})
+}
+
+func (c *sliceCursor) ValidForPrefix(prefix []byte) bool {
+	return c.pos < len(c.keys) && bytes.HasPrefix(c.keys[c.pos], prefix)
+}
+
+func (c *sliceCursor) Next() {
+	c.pos++
+}
+
+func (c *sliceCursor) KeyCopy() []byte {
+	return append([]byte{}, c.keys[c.pos]...)
+}
@@ -6,6 +6,7 @@ package badgerhold
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
 
 	"github.com/dgraph-io/badger"
@@ -17,6 +18,9 @@ var ErrKeyExists = errors.New("This Key already exists in badgerhold for this ty
 // ErrUniqueExists is the error thrown when data is being inserted for a unique constraint value that already exists
 var ErrUniqueExists = errors.New("This value cannot be written due to the unique constraint on the field")
 
+// ErrUniqueConstraint is the same error as ErrUniqueExists
+var ErrUniqueConstraint = ErrUniqueExists
+
 // sequence tells badgerhold to insert the key as the next sequence in the bucket
 type sequence struct{}
 
@@ -27,24 +31,51 @@ func NextSequence() interface{} {
 	return sequence{}
 }
 
+// KeyGenerator is the interface to implement on a record type to derive its key from the record's fields, such as a
+// slug from its title.  A record that implements it, inserted with a nil key, is stored under its key field if that's
+// set, and otherwise under the key GenerateKey returns
+type KeyGenerator interface {
+	GenerateKey() (interface{}, error)
+}
+
 // Insert inserts the passed in data into the the badgerhold
 //
-// If the the key already exists in the badgerhold, then an ErrKeyExists is returned
+// If the the key already exists in the badgerhold, then an ErrKeyExists is returned.  The key is checked in the same
+// transaction as the write, so of several concurrent Inserts for the same key, only one succeeds and the rest return
+// ErrKeyExists
 // If the data struct has a field tagged as `badgerholdKey` and it is the same type
 // as the Insert key, AND the data struct is passed by reference, AND the key field
 // is currently set to the zero-value for that type, then that field will be set to
 // the value of the insert key.
 //
 // To use this with badgerhold.NextSequence() use a type of `uint64` for the key field.
+//
+// If the data implements KeyGenerator and the key is nil, the key is generated from the data, and set on its key field
+// the same way.  A generated key that already exists returns ErrKeyExists
 func (s *Store) Insert(key, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	// the sequence is taken once, rather than on each attempt, so that a retried insert uses the key the failed
+	// attempt already set on the record's key field
+	if _, ok := key.(sequence); ok {
+		var err error
+		key, err = s.getSequence(s.storer(data).Type())
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxInsert(tx, key, data)
 	})
 }
 
 // TxInsert is the same as Insert except it allows you specify your own transaction
 func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
-	storer := newStorer(data)
+	return s.insert(tx, key, data, true)
+}
+
+// insert is TxInsert, except the record is only added to its type's indexes if indexed is true
+func (s *Store) insert(tx *badger.Txn, key, data interface{}, indexed bool) error {
+	storer := s.storer(data)
 	var err error
 
 	if _, ok := key.(sequence); ok {
@@ -54,18 +85,28 @@ func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
 		}
 	}
 
-	gk, err := encodeKey(key, storer.Type())
+	if kg, ok := data.(KeyGenerator); ok && key == nil {
+		key, err = generateKey(data, kg)
+		if err != nil {
+			return err
+		}
+	}
+
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
 
 	if err != nil {
 		return err
 	}
 
 	_, err = tx.Get(gk)
-	if err != badger.ErrKeyNotFound {
+	if err == nil {
 		return ErrKeyExists
 	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
 
-	value, err := encode(data)
+	value, err := s.encode(data)
 	if err != nil {
 		return err
 	}
@@ -77,12 +118,23 @@ func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
 		return err
 	}
 
+	s.recordWritten(tx, gk)
+
 	// insert any indexes
-	err = indexAdd(storer, tx, gk, data)
-	if err != nil {
-		return err
+	if indexed {
+		err = s.indexAdd(storer, tx, gk, data)
+		if err != nil {
+			return err
+		}
 	}
 
+	s.recordChange(tx, ChangeEvent{
+		Type:      storer.Type(),
+		Key:       gk,
+		Operation: ChangeInsert,
+		Value:     data,
+	})
+
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	if !dataVal.CanSet() {
 		return nil
@@ -112,23 +164,54 @@ func (s *Store) TxInsert(tx *badger.Txn, key, data interface{}) error {
 	return nil
 }
 
+// generateKey returns the value of the record's key field if it's set, otherwise the key generated by kg
+func generateKey(data interface{}, kg KeyGenerator) (interface{}, error) {
+	dataVal := reflect.Indirect(reflect.ValueOf(data))
+	if dataVal.Kind() == reflect.Struct {
+		dataType := dataVal.Type()
+		for i := 0; i < dataType.NumField(); i++ {
+			tf := dataType.Field(i)
+			if _, ok := tf.Tag.Lookup(BadgerholdKeyTag); ok ||
+				tf.Tag.Get(badgerholdPrefixTag) == badgerholdPrefixKeyValue {
+				fieldValue := dataVal.Field(i)
+				if fieldValue.CanInterface() && !fieldValue.IsZero() {
+					return fieldValue.Interface(), nil
+				}
+				break
+			}
+		}
+	}
+
+	key, err := kg.GenerateKey()
+	if err != nil {
+		return nil, err
+	}
+	if key == nil {
+		return nil, fmt.Errorf("GenerateKey of the type %T returned a nil key", data)
+	}
+	return key, nil
+}
+
 // TxInsertPRS is the same as Insert except it allows you specify your own transaction
 func (s *Store) TxInsertPRS(tx *badger.Txn, key, data interface{}, kuncian string) error {
-	storer := newStorer(data)
+	storer := newStorer(data, s.encodeValue)
 	var err error
 
-	gk, err := encodeKey(key, kuncian+storer.Type())
+	gk, err := encodeKey(key, kuncian+storer.Type(), s.encodeValue)
 
 	if err != nil {
 		return err
 	}
 
 	_, err = tx.Get(gk)
-	if err != badger.ErrKeyNotFound {
+	if err == nil {
 		return ErrKeyExists
 	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
 
-	value, err := encode(data)
+	value, err := s.encode(data)
 	if err != nil {
 		return err
 	}
@@ -140,6 +223,8 @@ func (s *Store) TxInsertPRS(tx *badger.Txn, key, data interface{}, kuncian strin
 		return err
 	}
 
+	s.recordWritten(tx, gk)
+
 	dataVal := reflect.Indirect(reflect.ValueOf(data))
 	if !dataVal.CanSet() {
 		return nil
@@ -172,16 +257,16 @@ func (s *Store) TxInsertPRS(tx *badger.Txn, key, data interface{}, kuncian strin
 // Update updates an existing record in the badgerhold
 // if the Key doesn't already exist in the store, then it fails with ErrNotFound
 func (s *Store) Update(key interface{}, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxUpdate(tx, key, data)
 	})
 }
 
 // TxUpdate is the same as Update except it allows you to specify your own transaction
 func (s *Store) TxUpdate(tx *badger.Txn, key interface{}, data interface{}) error {
-	storer := newStorer(data)
+	storer := s.storer(data)
 
-	gk, err := encodeKey(key, storer.Type())
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
 
 	if err != nil {
 		return err
@@ -195,21 +280,20 @@ func (s *Store) TxUpdate(tx *badger.Txn, key interface{}, data interface{}) erro
 		return err
 	}
 
-	// delete any existing indexes
 	existingVal := reflect.New(reflect.TypeOf(data)).Interface()
 
 	err = existingItem.Value(func(existing []byte) error {
-		return decode(existing, existingVal)
+		return s.decode(existing, existingVal)
 	})
 	if err != nil {
 		return err
 	}
-	err = indexDelete(storer, tx, gk, existingVal)
+	original, err := indexValues(storer, reflect.ValueOf(existingVal).Elem().Interface())
 	if err != nil {
 		return err
 	}
 
-	value, err := encode(data)
+	value, err := s.encode(data)
 	if err != nil {
 		return err
 	}
@@ -220,53 +304,297 @@ func (s *Store) TxUpdate(tx *badger.Txn, key interface{}, data interface{}) erro
 		return err
 	}
 
-	// insert any new indexes
-	return indexAdd(storer, tx, gk, data)
+	s.recordWritten(tx, gk)
+
+	// move the index entries that changed
+	err = s.indexReplace(storer, tx, gk, original, data)
+	if err != nil {
+		return err
+	}
+
+	s.recordChange(tx, ChangeEvent{
+		Type:      storer.Type(),
+		Key:       gk,
+		Operation: ChangeUpdate,
+		Value:     data,
+		Previous:  reflect.ValueOf(existingVal).Elem().Interface(),
+	})
+
+	return nil
+}
+
+// Modify reads the record with the key, passes a pointer to it to the modify func, and writes it back, all in one
+// transaction, so the record can't be changed in between.  Only the index entries whose values changed are updated.
+// If modify returns an error, nothing is written and the error is returned.  ErrNotFound is returned if there is no
+// record with the key
+func (s *Store) Modify(key, dataType interface{}, modify func(record interface{}) error) error {
+	return s.update(func(tx *badger.Txn) error {
+		return s.TxModify(tx, key, dataType, modify)
+	})
+}
+
+// TxModify is the same as Modify except it allows you to specify your own transaction
+func (s *Store) TxModify(tx *badger.Txn, key, dataType interface{}, modify func(record interface{}) error) error {
+	if modify == nil {
+		panic("Modify func cannot be nil")
+	}
+
+	storer := s.storer(dataType)
+
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
+	if err != nil {
+		return err
+	}
+
+	item, err := tx.Get(gk)
+	if err == badger.ErrKeyNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	value := reflect.New(tp)
+
+	err = item.Value(func(existing []byte) error {
+		return s.decode(existing, value.Interface())
+	})
+	if err != nil {
+		return err
+	}
+
+	original, err := indexValues(storer, value.Interface())
+	if err != nil {
+		return err
+	}
+
+	var previous interface{}
+	tracked := s.tracksChanges(tx)
+	if tracked {
+		previous, err = s.copyRecord(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = modify(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	encoded, err := s.encode(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Set(gk, encoded)
+	if err != nil {
+		return err
+	}
+
+	s.recordWritten(tx, gk)
+
+	// move the index entries that changed
+	err = s.indexReplace(storer, tx, gk, original, value.Interface())
+	if err != nil {
+		return err
+	}
+
+	if tracked {
+		s.recordChange(tx, ChangeEvent{
+			Type:      storer.Type(),
+			Key:       gk,
+			Operation: ChangeUpdate,
+			Value:     value.Interface(),
+			Previous:  previous,
+		})
+	}
+
+	return nil
+}
+
+// Rekey moves the record of dataType stored under oldKey to newKey, along with its index entries, in one transaction.
+// If the record has a key field of the same type as newKey, it's set to newKey.  ErrNotFound is returned if there's no
+// record under oldKey, and ErrKeyExists if there's already one under newKey.  Change hooks see the record deleted
+// from oldKey and inserted at newKey
+func (s *Store) Rekey(dataType, oldKey, newKey interface{}) error {
+	return s.update(func(tx *badger.Txn) error {
+		return s.TxRekey(tx, dataType, oldKey, newKey)
+	})
+}
+
+// TxRekey is the same as Rekey except it allows you to specify your own transaction
+func (s *Store) TxRekey(tx *badger.Txn, dataType, oldKey, newKey interface{}) error {
+	storer := s.storer(dataType)
+
+	oldGK, err := encodeKey(oldKey, storer.Type(), s.encodeValue)
+	if err != nil {
+		return err
+	}
+
+	newGK, err := encodeKey(newKey, storer.Type(), s.encodeValue)
+	if err != nil {
+		return err
+	}
+
+	item, err := tx.Get(oldGK)
+	if err == badger.ErrKeyNotFound {
+		return ErrNotFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Get(newGK)
+	if err == nil {
+		return ErrKeyExists
+	}
+	if err != badger.ErrKeyNotFound {
+		return err
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	value := reflect.New(tp)
+
+	err = item.Value(func(existing []byte) error {
+		return s.decode(existing, value.Interface())
+	})
+	if err != nil {
+		return err
+	}
+
+	var previous interface{}
+	tracked := s.tracksChanges(tx)
+	if tracked {
+		previous, err = s.copyRecord(value)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = tx.Delete(oldGK)
+	if err != nil {
+		return err
+	}
+
+	s.recordWritten(tx, oldGK)
+
+	err = s.indexDelete(storer, tx, oldGK, value.Interface())
+	if err != nil {
+		return err
+	}
+
+	if tp.Kind() == reflect.Struct {
+		for i := 0; i < tp.NumField(); i++ {
+			tf := tp.Field(i)
+			if _, ok := tf.Tag.Lookup(BadgerholdKeyTag); ok ||
+				tf.Tag.Get(badgerholdPrefixTag) == badgerholdPrefixKeyValue {
+				fieldValue := value.Elem().Field(i)
+				keyValue := reflect.ValueOf(newKey)
+				if keyValue.Type() == tf.Type && fieldValue.CanSet() {
+					fieldValue.Set(keyValue)
+				}
+				break
+			}
+		}
+	}
+
+	encoded, err := s.encode(value.Interface())
+	if err != nil {
+		return err
+	}
+
+	err = tx.Set(newGK, encoded)
+	if err != nil {
+		return err
+	}
+
+	s.recordWritten(tx, newGK)
+
+	err = s.indexAdd(storer, tx, newGK, value.Interface())
+	if err != nil {
+		return err
+	}
+
+	if tracked {
+		s.recordChange(tx, ChangeEvent{
+			Type:      storer.Type(),
+			Key:       oldGK,
+			Operation: ChangeDelete,
+			Previous:  previous,
+		})
+		s.recordChange(tx, ChangeEvent{
+			Type:      storer.Type(),
+			Key:       newGK,
+			Operation: ChangeInsert,
+			Value:     value.Interface(),
+		})
+	}
+
+	return nil
 }
 
 // Upsert inserts the record into the badgerhold if it doesn't exist.  If it does already exist, then it updates
 // the existing record
 func (s *Store) Upsert(key interface{}, data interface{}) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxUpsert(tx, key, data)
 	})
 }
 
 // TxUpsert is the same as Upsert except it allows you to specify your own transaction
 func (s *Store) TxUpsert(tx *badger.Txn, key interface{}, data interface{}) error {
-	storer := newStorer(data)
+	storer := s.storer(data)
 
-	gk, err := encodeKey(key, storer.Type())
+	gk, err := encodeKey(key, storer.Type(), s.encodeValue)
 
 	if err != nil {
 		return err
 	}
 
+	ev := ChangeEvent{
+		Type:      storer.Type(),
+		Key:       gk,
+		Operation: ChangeInsert,
+		Value:     data,
+	}
+
+	var original map[string][][]byte
+
 	existingItem, err := tx.Get(gk)
 
 	if err == nil {
 		// existing entry found
-		// delete any existing indexes
 		existingVal := reflect.New(reflect.TypeOf(data)).Interface()
 
 		err = existingItem.Value(func(existing []byte) error {
-			return decode(existing, existingVal)
+			return s.decode(existing, existingVal)
 		})
 		if err != nil {
 			return err
 		}
 
-		err = indexDelete(storer, tx, gk, existingVal)
+		original, err = indexValues(storer, reflect.ValueOf(existingVal).Elem().Interface())
 		if err != nil {
 			return err
 		}
+
+		ev.Operation = ChangeUpdate
+		ev.Previous = reflect.ValueOf(existingVal).Elem().Interface()
 	} else if err != badger.ErrKeyNotFound {
 		return err
 	}
 
 	// existing entry not found
 
-	value, err := encode(data)
+	value, err := s.encode(data)
 	if err != nil {
 		return err
 	}
@@ -277,14 +605,23 @@ func (s *Store) TxUpsert(tx *badger.Txn, key interface{}, data interface{}) erro
 		return err
 	}
 
-	// insert any new indexes
-	return indexAdd(storer, tx, gk, data)
+	s.recordWritten(tx, gk)
+
+	// insert any new indexes, or move the existing entries that changed
+	err = s.indexReplace(storer, tx, gk, original, data)
+	if err != nil {
+		return err
+	}
+
+	s.recordChange(tx, ev)
+
+	return nil
 }
 
 // UpdateMatching runs the update function for every record that match the passed in query
 // Note that the type  of record in the update func always has to be a pointer
 func (s *Store) UpdateMatching(dataType interface{}, query *Query, update func(record interface{}) error) error {
-	return s.Badger().Update(func(tx *badger.Txn) error {
+	return s.update(func(tx *badger.Txn) error {
 		return s.TxUpdateMatching(tx, dataType, query, update)
 	})
 }
@@ -292,5 +629,88 @@ func (s *Store) UpdateMatching(dataType interface{}, query *Query, update func(r
 // TxUpdateMatching does the same as UpdateMatching, but allows you to specify your own transaction
 func (s *Store) TxUpdateMatching(tx *badger.Txn, dataType interface{}, query *Query,
 	update func(record interface{}) error) error {
-	return updateQuery(tx, dataType, query, update)
+	return s.updateQuery(tx, dataType, query, update)
+}
+
+// UpdateMatchingBatches is UpdateMatching for more records than fit in a single transaction.  The keys of the records
+// that match the query are found first, then the records are updated in transactions of up to batchSize records each,
+// skipping any that were deleted in the meantime.  Each batch is committed on its own, so if the update func or a write
+// fails, only the failing batch is rolled back, and the number of records updated by the batches committed before it
+// is returned along with the error.  UpdateMatchingBatches panics if batchSize isn't positive
+func (s *Store) UpdateMatchingBatches(dataType interface{}, query *Query, batchSize int,
+	update func(record interface{}) error) (int, error) {
+	if batchSize <= 0 {
+		panic("UpdateMatchingBatches needs a positive batch size")
+	}
+
+	query = s.preloaded(query)
+
+	var keys [][]byte
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		query.begin()
+		defer query.end()
+
+		query.writable = false
+
+		return runKeyQuery(tx, dataType, query, func(k []byte) error {
+			keys = append(keys, append([]byte{}, k...))
+			return nil
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	storer := s.storer(dataType)
+
+	updated := 0
+	for len(keys) > 0 {
+		batch := keys
+		if len(batch) > batchSize {
+			batch = batch[:batchSize]
+		}
+
+		count := 0
+		err = s.update(func(tx *badger.Txn) error {
+			count = 0
+			tracked := s.tracksChanges(tx)
+
+			for i := range batch {
+				item, err := tx.Get(batch[i])
+				if err == badger.ErrKeyNotFound {
+					continue
+				}
+				if err != nil {
+					return err
+				}
+
+				value := reflect.New(tp)
+				err = item.Value(func(data []byte) error {
+					return s.decode(data, value.Interface())
+				})
+				if err != nil {
+					return err
+				}
+
+				err = s.updateRecord(tx, storer, tracked, &record{key: batch[i], value: value}, update)
+				if err != nil {
+					return err
+				}
+				count++
+			}
+			return nil
+		})
+		if err != nil {
+			return updated, err
+		}
+
+		updated += count
+		keys = keys[len(batch):]
+	}
+
+	return updated, nil
 }
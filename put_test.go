@@ -6,6 +6,9 @@ package badgerhold_test
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
@@ -51,6 +54,76 @@ func TestInsert(t *testing.T) {
 	})
 }
 
+func TestInsertConcurrentSameKey(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		const inserts = 8
+		errs := make(chan error, inserts)
+		for i := 0; i < inserts; i++ {
+			go func(i int) {
+				errs <- store.Insert("testKey", &ItemTest{ID: i, Name: "Test Name"})
+			}(i)
+		}
+
+		succeeded := 0
+		for i := 0; i < inserts; i++ {
+			err := <-errs
+			switch err {
+			case nil:
+				succeeded++
+			case badgerhold.ErrKeyExists:
+			default:
+				t.Fatalf("Insert failed with %s wanted nil or %s", err, badgerhold.ErrKeyExists)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Fatalf("%d concurrent inserts of the same key succeeded wanted 1", succeeded)
+		}
+	})
+}
+
+func TestInsertConcurrentSameUnique(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type ConcurrentUnique struct {
+			Key  int    `badgerhold:"key"`
+			Name string `badgerhold:"unique"`
+		}
+
+		const inserts = 8
+		// every insert checks the value is unused before any of them commit
+		var ready sync.WaitGroup
+		ready.Add(inserts)
+
+		errs := make(chan error, inserts)
+		for i := 0; i < inserts; i++ {
+			go func(i int) {
+				errs <- store.Badger().Update(func(tx *badger.Txn) error {
+					err := store.TxInsert(tx, i, &ConcurrentUnique{Key: i, Name: "Test Name"})
+					ready.Done()
+					ready.Wait()
+					return err
+				})
+			}(i)
+		}
+
+		succeeded := 0
+		for i := 0; i < inserts; i++ {
+			err := <-errs
+			switch err {
+			case nil:
+				succeeded++
+			case badger.ErrConflict:
+			default:
+				t.Fatalf("Insert failed with %s wanted nil or %s", err, badger.ErrConflict)
+			}
+		}
+
+		if succeeded != 1 {
+			t.Fatalf("%d concurrent inserts of the same unique value succeeded wanted 1", succeeded)
+		}
+	})
+}
+
 func TestInsertReadTxn(t *testing.T) {
 	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
 		key := "testKey"
@@ -281,6 +354,65 @@ func TestUpdateMatching(t *testing.T) {
 	}
 }
 
+func TestUpdateMatchingBatches(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		var food []ItemTest
+		err := store.Find(&food, badgerhold.Where("Category").Eq("food"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+
+		updated, err := store.UpdateMatchingBatches(&ItemTest{}, badgerhold.Where("Category").Eq("food").
+			Index("Category"), 2, func(record interface{}) error {
+			record.(*ItemTest).UpdateIndex = "archived"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		if updated != len(food) {
+			t.Fatalf("Updated %d records wanted %d", updated, len(food))
+		}
+
+		var result []ItemTest
+		err = store.Find(&result, badgerhold.Where("UpdateIndex").Eq("archived").Index("UpdateIndex"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != len(food) {
+			t.Fatalf("Index found %d updated records wanted %d", len(result), len(food))
+		}
+
+		// the failing batch is rolled back, the batches before it stay committed
+		calls := 0
+		updated, err = store.UpdateMatchingBatches(&ItemTest{}, nil, 2, func(record interface{}) error {
+			calls++
+			if calls == 5 {
+				return fmt.Errorf("update failed")
+			}
+			record.(*ItemTest).UpdateField = "batched"
+			return nil
+		})
+		if err == nil {
+			t.Fatalf("UpdateMatchingBatches didn't return the update's error")
+		}
+		if updated != 4 {
+			t.Fatalf("Updated %d records before the failure wanted 4", updated)
+		}
+
+		result = nil
+		err = store.Find(&result, badgerhold.Where("UpdateField").Eq("batched"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 4 {
+			t.Fatalf("Found %d updated records wanted 4", len(result))
+		}
+	})
+}
+
 func TestIssue14(t *testing.T) {
 	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
 		key := "testKey"
@@ -432,6 +564,51 @@ func TestInsertSequence(t *testing.T) {
 	})
 }
 
+func TestStoreNextSequence(t *testing.T) {
+	opt := testOptions()
+	opt.TypeSequenceBandwith = map[string]uint64{
+		"PreallocatedItem": 1000,
+	}
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	type PreallocatedItem struct {
+		Key uint64 `badgerhold:"key"`
+	}
+
+	for i := 0; i < 5; i++ {
+		key, err := store.NextSequence(&PreallocatedItem{})
+		if err != nil {
+			t.Fatalf("Error getting next sequence: %s", err)
+		}
+
+		if key != uint64(i*2) {
+			t.Fatalf("Sequence is not correct.  Wanted %d, got %d", i*2, key)
+		}
+
+		err = store.Insert(key, &PreallocatedItem{Key: key})
+		if err != nil {
+			t.Fatalf("Error inserting data for sequence test: %s", err)
+		}
+
+		// inserts share the same sequence
+		item := &PreallocatedItem{}
+		err = store.Insert(badgerhold.NextSequence(), item)
+		if err != nil {
+			t.Fatalf("Error inserting data for sequence test: %s", err)
+		}
+
+		if item.Key != key+1 {
+			t.Fatalf("Sequence is not correct.  Wanted %d, got %d", key+1, item.Key)
+		}
+	}
+}
+
 func TestInsertSequenceSetKey(t *testing.T) {
 	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
 
@@ -520,6 +697,135 @@ func TestInsertSetKey(t *testing.T) {
 	})
 }
 
+type sluggedPost struct {
+	Slug  string `badgerhold:"key"`
+	Title string `badgerhold:"index"`
+}
+
+func (p *sluggedPost) GenerateKey() (interface{}, error) {
+	return strings.ToLower(strings.Replace(p.Title, " ", "-", -1)), nil
+}
+
+func TestInsertGenerateKey(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		post := &sluggedPost{Title: "Hello World"}
+		err := store.Insert(nil, post)
+		if err != nil {
+			t.Fatalf("Error inserting data with a generated key: %s", err)
+		}
+		if post.Slug != "hello-world" {
+			t.Fatalf("Generated key wasn't set.  Wanted %s, got %s", "hello-world", post.Slug)
+		}
+
+		result := &sluggedPost{}
+		err = store.Get("hello-world", result)
+		if err != nil {
+			t.Fatalf("Error getting data by its generated key: %s", err)
+		}
+		if result.Title != post.Title {
+			t.Fatalf("Got %v wanted %v", result, post)
+		}
+
+		var found []sluggedPost
+		err = store.Find(&found, badgerhold.Where("Title").Eq("Hello World").Index("Title"))
+		if err != nil {
+			t.Fatalf("Error finding data by index: %s", err)
+		}
+		if len(found) != 1 || found[0].Slug != "hello-world" {
+			t.Fatalf("Index found %v wanted the record under its generated key", found)
+		}
+
+		err = store.Insert(nil, &sluggedPost{Title: "hello world"})
+		if err != badgerhold.ErrKeyExists {
+			t.Fatalf("Inserting a colliding generated key returned %v wanted ErrKeyExists", err)
+		}
+
+		// a key field that's already set is used as is
+		custom := &sluggedPost{Slug: "custom", Title: "Hello World"}
+		err = store.Insert(nil, custom)
+		if err != nil {
+			t.Fatalf("Error inserting data with its key field set: %s", err)
+		}
+		err = store.Get("custom", result)
+		if err != nil {
+			t.Fatalf("Error getting data by its key field: %s", err)
+		}
+	})
+}
+
+func TestRekey(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Country struct {
+			Code string `badgerhold:"key"`
+			Name string `badgerhold:"unique"`
+			Zone string `badgerhold:"index"`
+		}
+
+		for _, c := range []Country{{"DEU", "Germany", "EU"}, {"FRX", "France", "EU"}, {"USA", "United States", "NA"}} {
+			err := store.Insert(c.Code, &c)
+			if err != nil {
+				t.Fatalf("Error inserting data for rekey test: %s", err)
+			}
+		}
+
+		err := store.Rekey(&Country{}, "FRX", "FRA")
+		if err != nil {
+			t.Fatalf("Error rekeying record: %s", err)
+		}
+
+		err = store.Get("FRX", &Country{})
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Getting the old key returned %v wanted ErrNotFound", err)
+		}
+
+		result := &Country{}
+		err = store.Get("FRA", result)
+		if err != nil {
+			t.Fatalf("Error getting the rekeyed record: %s", err)
+		}
+		if result.Code != "FRA" || result.Name != "France" {
+			t.Fatalf("Rekeyed record is %v", result)
+		}
+
+		var found []Country
+		err = store.Find(&found, badgerhold.Where("Zone").Eq("EU").Index("Zone"))
+		if err != nil {
+			t.Fatalf("Error finding data by index: %s", err)
+		}
+		if len(found) != 2 || found[0].Code != "DEU" || found[1].Code != "FRA" {
+			t.Fatalf("Index found %v after rekeying", found)
+		}
+
+		found = nil
+		err = store.Find(&found, badgerhold.Where("Name").Eq("France").Index("Name"))
+		if err != nil {
+			t.Fatalf("Error finding data by unique index: %s", err)
+		}
+		if len(found) != 1 || found[0].Code != "FRA" {
+			t.Fatalf("Unique index found %v after rekeying", found)
+		}
+
+		err = store.Rekey(&Country{}, "FRA", "USA")
+		if err != badgerhold.ErrKeyExists {
+			t.Fatalf("Rekeying onto an existing key returned %v wanted ErrKeyExists", err)
+		}
+
+		err = store.Rekey(&Country{}, "FRX", "FRB")
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Rekeying a missing key returned %v wanted ErrNotFound", err)
+		}
+
+		var all []Country
+		err = store.Find(&all, nil)
+		if err != nil {
+			t.Fatalf("Error finding records: %s", err)
+		}
+		if len(all) != 3 {
+			t.Fatalf("Found %d records after rekeying wanted 3", len(all))
+		}
+	})
+}
+
 func TestAlternateTags(t *testing.T) {
 	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
 		type TestAlternate struct {
@@ -657,3 +963,192 @@ func TestUniqueConstraint(t *testing.T) {
 
 	})
 }
+
+func TestUniqueTag(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type TaggedUnique struct {
+			Email  string `badgerholdUnique:"Email"`
+			Team   string `badgerholdUnique:"Team,Number"`
+			Number int
+		}
+
+		err := store.Insert(1, &TaggedUnique{Email: "a@example.com", Team: "red", Number: 1})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		err = store.Insert(2, &TaggedUnique{Email: "a@example.com", Team: "blue", Number: 1})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Inserting a duplicate unique field returned %v wanted ErrUniqueExists", err)
+		}
+
+		// only the combination of a composite index's fields has to be unique
+		err = store.Insert(2, &TaggedUnique{Email: "b@example.com", Team: "red", Number: 2})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		err = store.Update(2, &TaggedUnique{Email: "b@example.com", Team: "red", Number: 1})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Updating to a duplicate composite value returned %v wanted ErrUniqueExists", err)
+		}
+
+		err = store.Upsert(3, &TaggedUnique{Email: "c@example.com", Team: "red", Number: 2})
+		if err != badgerhold.ErrUniqueExists {
+			t.Fatalf("Upserting a duplicate composite value returned %v wanted ErrUniqueExists", err)
+		}
+
+		var result []TaggedUnique
+		err = store.Find(&result, badgerhold.Where("Team").Eq("red").And("Number").Eq(2).Index("Team,Number"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 1 || result[0].Email != "b@example.com" {
+			t.Fatalf("Found %v using the unique composite index wanted b@example.com", result)
+		}
+	})
+}
+
+func TestUpdateUnchangedIndex(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		for i := 0; i < 2; i++ {
+			err := store.Insert(i, &ItemTest{ID: i, Name: "apple", Category: "food"})
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		version := func() uint64 {
+			entries, err := store.DumpIndex(&ItemTest{}, "Category")
+			if err != nil {
+				t.Fatalf("Error dumping index: %s", err)
+			}
+			if len(entries) != 1 {
+				t.Fatalf("Index has %d entries wanted 1", len(entries))
+			}
+
+			var v uint64
+			err = store.Badger().View(func(tx *badger.Txn) error {
+				key := append(append([]byte("_bhIdx:ItemTest:Category:"), entries[0].Encoded...), 0, 1)
+				item, err := tx.Get(append(key, entries[0].Keys[0]...))
+				if err != nil {
+					return err
+				}
+				v = item.Version()
+				return nil
+			})
+			if err != nil {
+				t.Fatalf("Error reading index entry: %s", err)
+			}
+			return v
+		}
+
+		before := version()
+
+		err := store.Update(0, &ItemTest{ID: 0, Name: "pear", Category: "food"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		err = store.Upsert(1, &ItemTest{ID: 1, Name: "plum", Category: "food"})
+		if err != nil {
+			t.Fatalf("Error upserting data: %s", err)
+		}
+		err = store.UpdateMatching(&ItemTest{}, badgerhold.Where("Category").Eq("food"), func(record interface{}) error {
+			record.(*ItemTest).Name = "fig"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error updating matching data: %s", err)
+		}
+
+		if after := version(); after != before {
+			t.Fatalf("Unchanged index entry was rewritten, version %d is now %d", before, after)
+		}
+
+		err = store.Update(0, &ItemTest{ID: 0, Name: "fig", Category: "drink"})
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+
+		for category, count := range map[string]int{"food": 1, "drink": 1} {
+			var result []ItemTest
+			err = store.Find(&result, badgerhold.Where("Category").Eq(category).Index("Category"))
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+			if len(result) != count {
+				t.Fatalf("Found %d records in %s wanted %d", len(result), category, count)
+			}
+		}
+	})
+}
+
+func TestModify(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		err := store.Insert(1, &ItemTest{ID: 1, Name: "apple", Category: "food"})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+
+		err = store.Modify(1, &ItemTest{}, func(record interface{}) error {
+			item, ok := record.(*ItemTest)
+			if !ok {
+				return fmt.Errorf("Record isn't the correct type!  Wanted *ItemTest, got %T", record)
+			}
+			if item.Name != "apple" {
+				return fmt.Errorf("Modify was passed %+v wanted the stored record", item)
+			}
+			item.Name = "pear"
+			item.Category = "fruit"
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error modifying data: %s", err)
+		}
+
+		var result ItemTest
+		err = store.Get(1, &result)
+		if err != nil {
+			t.Fatalf("Error getting data: %s", err)
+		}
+		if result.Name != "pear" || result.Category != "fruit" {
+			t.Fatalf("Got %+v after Modify wanted Name pear and Category fruit", result)
+		}
+
+		for category, count := range map[string]int{"food": 0, "fruit": 1} {
+			var found []ItemTest
+			err = store.Find(&found, badgerhold.Where("Category").Eq(category).Index("Category"))
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+			if len(found) != count {
+				t.Fatalf("Found %d records in the %s index wanted %d", len(found), category, count)
+			}
+		}
+
+		failed := fmt.Errorf("modify failed")
+		err = store.Modify(1, &ItemTest{}, func(record interface{}) error {
+			record.(*ItemTest).Name = "plum"
+			return failed
+		})
+		if err != failed {
+			t.Fatalf("Modify returned %v wanted the modify func's error", err)
+		}
+
+		err = store.Get(1, &result)
+		if err != nil {
+			t.Fatalf("Error getting data: %s", err)
+		}
+		if result.Name != "pear" {
+			t.Fatalf("Failed Modify wrote %+v", result)
+		}
+
+		err = store.Modify(2, &ItemTest{}, func(record interface{}) error {
+			t.Fatalf("Modify func was called for a missing record")
+			return nil
+		})
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Modify of a missing record returned %v wanted ErrNotFound", err)
+		}
+	})
+}
@@ -5,29 +5,40 @@
 package badgerhold
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
 	"reflect"
 	"regexp"
 	"sort"
 	"strings"
+	"time"
 	"unicode"
 
 	"github.com/dgraph-io/badger"
 )
 
 const (
-	eq    = iota // ==
-	ne           // !=
-	gt           // >
-	lt           // <
-	ge           // >=
-	le           // <=
-	in           // in
-	re           // regular expression
-	fn           // func
-	isnil        // test's for nil
-	sw           // string starts with
-	ew           // string ends with
+	eq     = iota // ==
+	ne            // !=
+	gt            // >
+	lt            // <
+	ge            // >=
+	le            // <=
+	in            // in
+	re            // regular expression
+	fn            // func
+	isnil         // test's for nil
+	sw            // string starts with
+	ew            // string ends with
+	notnil        // test's for not nil
+	tc            // time component
+	ln            // length comparison
+	ct            // slice contains
+	ca            // slice contains any
+	mt            // full text match
+	geo           // geospatial area
+	hk            // map has key
 )
 
 // Key is shorthand for specifying a query to run again the Key in a badgerhold, simply returns ""
@@ -38,21 +49,50 @@ const Key = ""
 // an empty query matches against all records
 type Query struct {
 	index         string
+	noIndex       bool
 	currentField  string
 	fieldCriteria map[string][]*Criterion
 	ors           []*Query
+	groups        []*Query
+	negated       bool
+
+	badIndex      bool
+	staleIndex    bool
+	partialIndex  bool // the index only narrows down the records, which are tested against all of its criteria, see elementOperators
+	buildingIndex bool // the index is being built, so the records are scanned and tested against all of its criteria, see BuildIndex
+	unranked      bool // the records are ranked by Match criteria after the query runs, see ranked
+	dataType      reflect.Type
+	boundType     reflect.Type
+	tx            *badger.Txn
+	writable      bool
+	subquery      bool
+	bookmark      *iterBookmark
+	preloads      *indexPreloads
+	store         *Store // the store the query runs against, see Store.preloaded
+
+	limit      int
+	skip       int
+	sort       []string
+	descending map[string]bool
+	reverse    bool
+	parallel   int
+
+	deadline       time.Time
+	stats          *queryStats
+	includeDeleted bool
+	skipErrors     bool
+	skipped        *skippedRecords
+	accumulate     []string
+	selected       []string
+	covered        bool // the records are read from the index entries, see coveredBy
+}
 
-	badIndex bool
-	dataType reflect.Type
-	tx       *badger.Txn
-	writable bool
-	subquery bool
-	bookmark *iterBookmark
+// ErrQueryTimeout is the error returned when a query runs for longer than the store's QueryTimeout option
+var ErrQueryTimeout = errors.New("The query took longer than the store's QueryTimeout")
 
-	limit   int
-	skip    int
-	sort    []string
-	reverse bool
+// expired returns whether or not the query has run past its deadline
+func (q *Query) expired() bool {
+	return !q.deadline.IsZero() && time.Now().After(q.deadline)
 }
 
 // IsEmpty returns true if the query is an empty query
@@ -65,7 +105,7 @@ func (q *Query) IsEmpty() bool {
 		return false
 	}
 
-	if q.ors != nil {
+	if q.ors != nil || q.groups != nil {
 		return false
 	}
 
@@ -78,18 +118,75 @@ type Criterion struct {
 	operator int
 	value    interface{}
 	inValues []interface{}
+	mapped   bool
+	mapKey   interface{}
+	location *time.Location
+
+	json       *jsonPath
+	jsonStrict bool
 }
 
-func hasMatchFunc(criteria []*Criterion) bool {
+// skipsIndex returns whether or not the criteria on the field need to be tested against the record instead of an
+// index
+func (q *Query) skipsIndex(field string) bool {
+	criteria := q.fieldCriteria[field]
 	for _, c := range criteria {
-		if c.operator == fn {
+		switch c.operator {
+		case fn, isnil, notnil, tc, ln, ct, ca, mt, geo, hk:
+			// element, full text, geo and map indexes test their own criteria separately, see elementCriteria
+			return true
+		}
+		if c.mapped {
+			// only the exact key=value of a map entry is indexed
+			return true
+		}
+		if c.json != nil {
+			// the index holds the encoded JSON, not the values inside it
 			return true
 		}
+		if _, ok := c.value.(Field); ok {
+			// the other field is only available on the record
+			return true
+		}
+		for i := range c.inValues {
+			if _, ok := c.inValues[i].(Field); ok {
+				return true
+			}
+		}
+	}
+
+	return q.comparesText(field)
+}
+
+// comparesText returns whether or not the criteria on the field compare a non-string field, such as an enum, with
+// strings, which are compared with the field's text form, rather than the value stored in the index
+func (q *Query) comparesText(field string) bool {
+	if q.dataType == nil || field == Key {
+		return false
+	}
+
+	sf, ok := q.dataType.FieldByName(field)
+	if !ok || sf.Type.Kind() == reflect.String {
+		return false
+	}
+
+	for _, c := range q.fieldCriteria[field] {
+		if _, ok := c.value.(string); ok {
+			return true
+		}
+		for i := range c.inValues {
+			if _, ok := c.inValues[i].(string); ok {
+				return true
+			}
+		}
 	}
 	return false
 }
 
-// Field allows for referencing a field in structure being compared
+// Field allows for referencing a field in structure being compared, so that two fields of the same record can be
+// compared with each other.  Nested fields can be referenced with dots, as in Where
+// 	Where("ShippedAt").Gt(badgerhold.Field("OrderedAt"))
+// Field criteria are always tested against the record, even when the queried field is indexed
 type Field string
 
 // Where starts a query for specifying the criteria that an object in the badgerhold needs to match to
@@ -100,13 +197,10 @@ Query API Example
 	s.Find(badgerhold.Where("FieldName").Eq(value).And("AnotherField").Lt(AnotherValue).
 		Or(badgerhold.Where("FieldName").Eq(anotherValue)
 
-Since Gobs only encode exported fields, this will panic if you pass in a field with a lower case first letter
+Since Gobs only encode exported fields, running the query will panic if you pass in a field with a lower case first
+letter, unless the store has a FieldNameTag to match it against
 */
 func Where(field string) *Criterion {
-	if !startsUpper(field) {
-		panic("The first letter of a field in a badgerhold query must be upper-case")
-	}
-
 	return &Criterion{
 		query: &Query{
 			currentField:  field,
@@ -117,10 +211,6 @@ func Where(field string) *Criterion {
 
 // And creates a nother set of criterion the needs to apply to a query
 func (q *Query) And(field string) *Criterion {
-	if !startsUpper(field) {
-		panic("The first letter of a field in a badgerhold query must be upper-case")
-	}
-
 	q.currentField = field
 	return &Criterion{
 		query: q,
@@ -160,26 +250,47 @@ func (q *Query) Limit(amount int) *Query {
 }
 
 // SortBy sorts the results by the given fields name
-// Multiple fields can be used
+// Multiple fields can be used, and each can be wrapped in Asc or Desc to set its direction
+// 	SortBy(badgerhold.Asc("Name"), badgerhold.Desc("CreatedAt"))
 func (q *Query) SortBy(fields ...string) *Query {
 	for i := range fields {
-		if fields[i] == Key {
+		field := strings.TrimPrefix(fields[i], sortDescPrefix)
+		if field == Key {
 			panic("Cannot sort by Key.")
 		}
 		var found bool
 		for k := range q.sort {
-			if q.sort[k] == fields[i] {
+			if q.sort[k] == field {
 				found = true
 				break
 			}
 		}
 		if !found {
-			q.sort = append(q.sort, fields[i])
+			q.sort = append(q.sort, field)
+			if field != fields[i] {
+				if q.descending == nil {
+					q.descending = make(map[string]bool)
+				}
+				q.descending[field] = true
+			}
 		}
 	}
 	return q
 }
 
+// sortDescPrefix marks a SortBy field that's sorted in descending order
+const sortDescPrefix = "-"
+
+// Asc sorts by the field in ascending order, which is the default, with nil values first
+func Asc(field string) string {
+	return field
+}
+
+// Desc sorts by the field in descending order, with nil values last
+func Desc(field string) string {
+	return sortDescPrefix + field
+}
+
 // Reverse will reverse the current result set
 // useful with SortBy
 func (q *Query) Reverse() *Query {
@@ -187,13 +298,45 @@ func (q *Query) Reverse() *Query {
 	return q
 }
 
+// Parallel decodes and tests records against the query's criteria across a pool of n goroutines.  This only helps
+// queries with expensive criteria, such as RegExp or MatchFunc, that have to check every record instead of using an
+// index.  Results are returned in the same order as a non-parallel query.
+// MatchFuncs in a parallel query cannot run SubQueries.  Setting Parallel to a value less than 1 will panic
+func (q *Query) Parallel(n int) *Query {
+	if n < 1 {
+		panic("Parallel must be set to a positive number")
+	}
+
+	q.parallel = n
+
+	return q
+}
+
+// ForType binds the query to the passed in type, so that running it against records of any other type returns
+// an ErrTypeMismatch instead of checking the query's criteria against the wrong fields
+func (q *Query) ForType(dataType interface{}) *Query {
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr || tp.Kind() == reflect.Slice {
+		tp = tp.Elem()
+	}
+
+	q.boundType = tp
+	return q
+}
+
 // Index specifies the index to use when running this query
 func (q *Query) Index(indexName string) *Query {
-	if strings.Contains(indexName, ".") {
-		// NOTE: I may reconsider this in the future
-		panic("Nested indexes are not supported.  Only top level structures can be indexed")
-	}
 	q.index = indexName
+	q.noIndex = false
+	return q
+}
+
+// NoIndex runs the query as a full scan of every record of the type, without using an index or seeking to the range
+// of keys covered by its Key criteria.  It undoes an earlier call to Index, and is meant for the rare data where a
+// full scan is faster than the index
+func (q *Query) NoIndex() *Query {
+	q.index = ""
+	q.noIndex = true
 	return q
 }
 
@@ -208,19 +351,93 @@ func (q *Query) Or(query *Query) *Query {
 	return q
 }
 
+// AndGroup adds a grouped query that records also need to match, which is how criteria are combined with an Or'd
+// query without the Or applying to the whole query, i.e. A = 1 AND (B = 2 OR C = 3):
+// 	Where("A").Eq(1).AndGroup(Where("B").Eq(2).Or(Where("C").Eq(3)))
+// Groups can be nested in other groups, and are always tested against the record, so they don't use indexes.
+// AndGroup will panic if the group contains a limit or skip value
+func (q *Query) AndGroup(group *Query) *Query {
+	if group.skip != 0 || group.limit != 0 {
+		panic("Grouped queries cannot contain skip or limit values")
+	}
+	q.groups = append(q.groups, group)
+	return q
+}
+
+// Group starts a query with a grouped query, so that the query can begin with an Or'd group, i.e.
+// (A = 1 OR B = 2) AND (C = 3 OR D = 4):
+// 	Group(Where("A").Eq(1).Or(Where("B").Eq(2))).AndGroup(Where("C").Eq(3).Or(Where("D").Eq(4)))
+func Group(group *Query) *Query {
+	q := &Query{
+		fieldCriteria: make(map[string][]*Criterion),
+	}
+	return q.AndGroup(group)
+}
+
+// Not adds a grouped query that records must not match, i.e. A = 1 AND NOT (B = 2 AND C = 3):
+// 	Where("A").Eq(1).Not(Where("B").Eq(2).And("C").Eq(3))
+// Like any group, it's tested against the record, so an index on one of its fields isn't used.
+// Not will panic if the group contains a limit or skip value
+func (q *Query) Not(group *Query) *Query {
+	negated := &Query{
+		fieldCriteria: make(map[string][]*Criterion),
+		negated:       true,
+	}
+	return q.AndGroup(negated.AndGroup(group))
+}
+
+// Not starts a query with a negated group, i.e. NOT (A = 1 OR B = 2):
+// 	Not(Where("A").Eq(1).Or(Where("B").Eq(2)))
+func Not(group *Query) *Query {
+	q := &Query{
+		fieldCriteria: make(map[string][]*Criterion),
+	}
+	return q.Not(group)
+}
+
 func (q *Query) matchesAllFields(key []byte, value reflect.Value, currentRow interface{}) (bool, error) {
+	if q.excludesDeleted(value) {
+		return false, nil
+	}
+
 	if q.IsEmpty() {
 		return true, nil
 	}
 
+	return q.matchesCriteria(key, value, currentRow, q.dataType, true)
+}
+
+// matchesGroup returns whether the record matches the grouped query, either its own criteria or one of its ors, or
+// whether it matches neither if the group is negated
+func (q *Query) matchesGroup(key []byte, value reflect.Value, currentRow interface{},
+	dataType reflect.Type) (bool, error) {
+	ok, err := q.matchesCriteria(key, value, currentRow, dataType, false)
+	if err != nil {
+		return false, err
+	}
+
+	for i := 0; i < len(q.ors) && !ok; i++ {
+		ok, err = q.ors[i].matchesGroup(key, value, currentRow, dataType)
+		if err != nil {
+			return false, err
+		}
+	}
+
+	return ok != q.negated, nil
+}
+
+// matchesCriteria returns whether the record matches all of the query's field criteria and groups.  If indexed is
+// true, the criteria already handled by the query's index are skipped
+func (q *Query) matchesCriteria(key []byte, value reflect.Value, currentRow interface{}, dataType reflect.Type,
+	indexed bool) (bool, error) {
 	for field, criteria := range q.fieldCriteria {
-		if field == q.index && !q.badIndex && !hasMatchFunc(criteria) {
+		if indexed && field == q.index && !q.badIndex && !q.skipsIndex(field) && !q.partialIndex {
 			// already handled by index Iterator
 			continue
 		}
 
 		if field == Key {
-			ok, err := matchesAllCriteria(criteria, key, true, q.dataType.Name(), currentRow)
+			ok, err := matchesAllCriteria(criteria, key, true, dataType.Name(), currentRow)
 			if err != nil {
 				return false, err
 			}
@@ -231,9 +448,22 @@ func (q *Query) matchesAllFields(key []byte, value reflect.Value, currentRow int
 			continue
 		}
 
-		fVal, err := fieldValue(value, field)
+		fVal, err := fieldValue(value, field, q.store.fieldNameTag)
 		if err != nil {
-			return false, err
+			index, computed := q.computedIndex(dataType, field)
+			if !computed {
+				return false, err
+			}
+
+			ok, err := matchesComputed(criteria, index, field, value, currentRow)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, nil
+			}
+
+			continue
 		}
 
 		ok, err := matchesAllCriteria(criteria, fVal.Interface(), false, "", currentRow)
@@ -245,18 +475,28 @@ func (q *Query) matchesAllFields(key []byte, value reflect.Value, currentRow int
 		}
 	}
 
+	for i := range q.groups {
+		ok, err := q.groups[i].matchesGroup(key, value, currentRow, dataType)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return false, nil
+		}
+	}
+
 	return true, nil
 }
 
-func fieldValue(value reflect.Value, field string) (reflect.Value, error) {
+func fieldValue(value reflect.Value, field, nameTag string) (reflect.Value, error) {
 	fields := strings.Split(field, ".")
 
 	current := value
 	for i := range fields {
 		if current.Kind() == reflect.Ptr {
-			current = current.Elem().FieldByName(fields[i])
+			current = fieldValueByName(current.Elem(), fields[i], nameTag)
 		} else {
-			current = current.FieldByName(fields[i])
+			current = fieldValueByName(current, fields[i], nameTag)
 		}
 		if !current.IsValid() {
 			return reflect.Value{}, fmt.Errorf("The field %s does not exist in the type %s", field, value)
@@ -323,10 +563,16 @@ func (c *Criterion) RegExp(expression *regexp.Regexp) *Query {
 }
 
 // IsNil will test if a field is equal to nil
+// Pointer, interface, map, slice, chan and func fields can be nil, fields of any other type never are
 func (c *Criterion) IsNil() *Query {
 	return c.op(isnil, nil)
 }
 
+// IsNotNil will test if a field is not equal to nil
+func (c *Criterion) IsNotNil() *Query {
+	return c.op(notnil, nil)
+}
+
 // HasPrefix will test if a field starts with provided string
 func (c *Criterion) HasPrefix(prefix string) *Query {
 	return c.op(sw, prefix)
@@ -361,16 +607,24 @@ func (r *RecordAccess) Record() interface{} {
 // SubQuery allows you to run another query in the same transaction for each
 // record in a parent query
 func (r *RecordAccess) SubQuery(result interface{}, query *Query) error {
+	if r.query.parallel > 1 {
+		return errParallelSubQuery
+	}
 	query.subquery = true
 	query.bookmark = r.query.bookmark
+	query.share(r.query)
 	return findQuery(r.query.tx, result, query)
 }
 
 // SubAggregateQuery allows you to run another aggregate query in the same transaction for each
 // record in a parent query
 func (r *RecordAccess) SubAggregateQuery(query *Query, groupBy ...string) ([]*AggregateResult, error) {
+	if r.query.parallel > 1 {
+		return nil, errParallelSubQuery
+	}
 	query.subquery = true
 	query.bookmark = r.query.bookmark
+	query.share(r.query)
 	return aggregateQuery(r.query.tx, r.record, query, groupBy...)
 }
 
@@ -384,15 +638,118 @@ func (c *Criterion) MatchFunc(match MatchFunc) *Query {
 	return c.op(fn, match)
 }
 
+// MapKey tests the value at the passed in key of the current map field, rather than the field itself
+// Records where the map doesn't contain the key don't match
+// 	Where("Attributes").MapKey("color").Eq("red")
+func (c *Criterion) MapKey(key interface{}) *Criterion {
+	if c.query.currentField == Key {
+		panic("MapKey cannot be used against Keys")
+	}
+
+	c.mapped = true
+	c.mapKey = key
+	return c
+}
+
+// MapHasKey tests if the current map field contains the passed in key, whatever its value.  An indexed map field has
+// an entry for each key and value, so a MapHasKey query using its index only reads the records with the key
+// 	Where("Attributes").MapHasKey("color").Index("Attributes")
+func (c *Criterion) MapHasKey(key interface{}) *Query {
+	if c.query.currentField == Key {
+		panic("MapHasKey cannot be used against Keys")
+	}
+
+	return c.op(hk, key)
+}
+
+// MapHasKeyValue tests if the current map field contains the passed in key, with the passed in value.  It's the same
+// as MapKey(key).Eq(value)
+func (c *Criterion) MapHasKeyValue(key, value interface{}) *Query {
+	return c.MapKey(key).Eq(value)
+}
+
+// testHasKey tests the MapHasKey criterion against the map field, or against a single key=value entry read from a map
+// index
+func (c *Criterion) testHasKey(value interface{}, entry bool) (bool, error) {
+	if entry {
+		return strings.HasPrefix(fmt.Sprintf("%s", value), mapIndexKeyPrefix(c.value)), nil
+	}
+
+	mapValue := reflect.ValueOf(value)
+	if mapValue.Kind() != reflect.Map {
+		return false, fmt.Errorf("MapHasKey can only be used on map fields, not %T", value)
+	}
+
+	key := reflect.ValueOf(c.value)
+	if !key.IsValid() || !key.Type().AssignableTo(mapValue.Type().Key()) {
+		return false, fmt.Errorf("The map key %v is a %T, but the map's keys are %s", c.value, c.value,
+			mapValue.Type().Key())
+	}
+	return mapValue.MapIndex(key).IsValid(), nil
+}
+
 // test if the criterion passes with the passed in value
 func (c *Criterion) test(testValue interface{}, encoded bool, keyType string, currentRow interface{}) (bool, error) {
+	if c.json != nil {
+		return c.testJSON(testValue, currentRow)
+	}
+
+	if c.mapped {
+		mapValue := reflect.ValueOf(testValue)
+		if mapValue.Kind() != reflect.Map {
+			return false, fmt.Errorf("MapKey can only be used on map fields, not %T", testValue)
+		}
+
+		key := reflect.ValueOf(c.mapKey)
+		if !key.IsValid() || !key.Type().AssignableTo(mapValue.Type().Key()) {
+			return false, fmt.Errorf("The map key %v is a %T, but the map's keys are %s", c.mapKey, c.mapKey,
+				mapValue.Type().Key())
+		}
+
+		entry := mapValue.MapIndex(key)
+		if !entry.IsValid() {
+			return false, nil
+		}
+		testValue = entry.Interface()
+	}
+
+	if encoded && keyType != "" {
+		if ke, ok := c.value.(KeyEncoder); ok && c.operator <= le {
+			// keys with their own encoding are compared in the order they're stored in
+			other, err := ke.EncodeKey()
+			if err != nil {
+				return false, err
+			}
+
+			result := bytes.Compare(testValue.([]byte)[len(typePrefix(keyType)):], other)
+			if keyComparer(c.value) {
+				return c.compareKey(testValue.([]byte), keyType, result)
+			}
+			return compareResult(c.operator, result), nil
+		}
+	}
+
 	var value interface{}
-	if encoded {
+	iv, element := testValue.(indexValue)
+	if element {
+		var criterionType reflect.Type
+		if (c.operator == in || c.operator == ca) && len(c.inValues) > 0 {
+			criterionType = reflect.TypeOf(c.inValues[0])
+		} else if (c.operator <= le || c.operator == ct || c.operator == mt) && c.value != nil {
+			criterionType = reflect.TypeOf(c.value)
+		}
+
+		var err error
+		value, err = iv.decode(criterionType)
+		if err != nil {
+			return false, err
+		}
+	} else if encoded {
 		if len(testValue.([]byte)) != 0 {
 			if c.operator == in {
 				// value is a slice of values, use c.inValues
 				value = reflect.New(reflect.TypeOf(c.inValues[0])).Interface()
-				err := decode(testValue.([]byte), value)
+				err := c.query.store.decodeValue(testValue.([]byte), value)
 				if err != nil {
 					return false, err
 				}
@@ -401,12 +758,12 @@ func (c *Criterion) test(testValue interface{}, encoded bool, keyType string, cu
 				// used with keys
 				value = reflect.New(reflect.TypeOf(c.value)).Interface()
 				if keyType != "" {
-					err := decodeKey(testValue.([]byte), value, keyType)
+					err := decodeKey(testValue.([]byte), value, keyType, c.query.store.decodeValue)
 					if err != nil {
 						return false, err
 					}
 				} else {
-					err := decode(testValue.([]byte), value)
+					err := c.query.store.decodeValue(testValue.([]byte), value)
 					if err != nil {
 						return false, err
 					}
@@ -439,7 +796,21 @@ func (c *Criterion) test(testValue interface{}, encoded bool, keyType string, cu
 			query:  c.query,
 		})
 	case isnil:
-		return reflect.ValueOf(value).IsNil(), nil
+		return isNil(value), nil
+	case notnil:
+		return !isNil(value), nil
+	case tc:
+		return c.value.(timeComponent).test(value, c.location)
+	case ln:
+		return c.value.(lengthCheck).test(value)
+	case ct, ca:
+		return c.testContains(value, element, currentRow)
+	case mt:
+		return c.testMatch(value, element)
+	case geo:
+		return c.testGeo(value)
+	case hk:
+		return c.testHasKey(value, element)
 	case sw:
 		return strings.HasPrefix(fmt.Sprintf("%s", value), fmt.Sprintf("%s", c.value)), nil
 	case ew:
@@ -451,22 +822,27 @@ func (c *Criterion) test(testValue interface{}, encoded bool, keyType string, cu
 			return false, err
 		}
 
-		switch c.operator {
-		case eq:
-			return result == 0, nil
-		case ne:
-			return result != 0, nil
-		case gt:
-			return result > 0, nil
-		case lt:
-			return result < 0, nil
-		case le:
-			return result < 0 || result == 0, nil
-		case ge:
-			return result > 0 || result == 0, nil
-		default:
-			panic("invalid operator")
-		}
+		return compareResult(c.operator, result), nil
+	}
+}
+
+// compareResult returns whether the result of a comparison satisfies the comparison operator
+func compareResult(operator, result int) bool {
+	switch operator {
+	case eq:
+		return result == 0
+	case ne:
+		return result != 0
+	case gt:
+		return result > 0
+	case lt:
+		return result < 0
+	case le:
+		return result < 0 || result == 0
+	case ge:
+		return result > 0 || result == 0
+	default:
+		panic("invalid operator")
 	}
 }
 
@@ -486,6 +862,21 @@ func matchesAllCriteria(criteria []*Criterion, value interface{}, encoded bool,
 	return true, nil
 }
 
+// isNil returns whether or not the passed in value is nil
+func isNil(value interface{}) bool {
+	if value == nil {
+		return true
+	}
+
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface, reflect.Map, reflect.Slice, reflect.Chan, reflect.Func:
+		return v.IsNil()
+	default:
+		return false
+	}
+}
+
 func startsUpper(str string) bool {
 	if str == "" {
 		return true
@@ -498,7 +889,26 @@ func startsUpper(str string) bool {
 	return false
 }
 
+// checkFieldNames panics if one of the query's fields starts with a lower case letter, which is only the name of a
+// field when it's matched against the store's FieldNameTag
+func (q *Query) checkFieldNames() {
+	for field := range q.fieldCriteria {
+		if !startsUpper(field) {
+			panic("The first letter of a field in a badgerhold query must be upper-case")
+		}
+	}
+	for i := range q.accumulate {
+		if !startsUpper(q.accumulate[i]) {
+			panic("The first letter of a field must be upper-case")
+		}
+	}
+}
+
 func (q *Query) String() string {
+	if q.negated {
+		return "NOT (" + q.groups[0].String() + ")"
+	}
+
 	s := ""
 
 	if q.index != "" {
@@ -513,6 +923,11 @@ func (q *Query) String() string {
 		}
 	}
 
+	for i := range q.groups {
+		s += "(" + q.groups[i].String() + ")"
+		s += "\n\tAND "
+	}
+
 	// remove last AND
 	s = s[:len(s)-6]
 
@@ -524,6 +939,18 @@ func (q *Query) String() string {
 }
 
 func (c *Criterion) String() string {
+	if c.mapped {
+		entry := *c
+		entry.mapped = false
+		return fmt.Sprintf("[%v] %s", c.mapKey, entry.String())
+	}
+
+	if c.json != nil {
+		entry := *c
+		entry.json = nil
+		return fmt.Sprintf("%s %s", c.json.path, entry.String())
+	}
+
 	s := ""
 	switch c.operator {
 	case eq:
@@ -546,6 +973,26 @@ func (c *Criterion) String() string {
 		s += "matches the function"
 	case isnil:
 		return "is nil"
+	case notnil:
+		return "is not nil"
+	case tc:
+		desc := c.value.(timeComponent).description
+		if c.location != nil {
+			desc += " in " + c.location.String()
+		}
+		return desc
+	case ln:
+		return c.value.(lengthCheck).String()
+	case ct:
+		s += "contains"
+	case ca:
+		return "contains any of " + fmt.Sprintf("%v", c.inValues)
+	case mt:
+		s += "matches the text"
+	case geo:
+		return c.value.(*geoArea).String()
+	case hk:
+		return "has the key " + fmt.Sprintf("%v", c.value)
 	case sw:
 		return "starts with " + fmt.Sprintf("%+v", c.value)
 	case ew:
@@ -563,7 +1010,7 @@ type record struct {
 
 func runQuery(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys keyList, skip int,
 	action func(r *record) error) error {
-	storer := newStorer(dataType)
+	storer := newStorer(dataType, query.store.encodeValue)
 
 	tp := dataType
 
@@ -572,8 +1019,11 @@ func runQuery(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys
 	}
 
 	query.dataType = reflect.TypeOf(tp)
+	if query.boundType != nil && query.boundType != query.dataType {
+		return &ErrTypeMismatch{reflect.Zero(query.boundType).Interface(), tp}
+	}
 
-	if len(query.sort) > 0 {
+	if len(query.sort) > 0 || query.ranked() {
 		return runQuerySort(tx, dataType, query, action)
 	}
 
@@ -587,65 +1037,86 @@ func runQuery(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys
 		query.bookmark = nil
 	}()
 
-	if query.index != "" && query.badIndex {
-		return fmt.Errorf("The index %s does not exist", query.index)
+	if query.index != "" && query.badIndex && !query.buildingIndex {
+		return query.indexError()
 	}
 
 	newKeys := make(keyList, 0)
 
 	limit := query.limit - len(retrievedKeys)
 
-	for k, v := iter.Next(); k != nil; k, v = iter.Next() {
-		if len(retrievedKeys) != 0 {
-			// don't check this record if it's already been retrieved
-			if retrievedKeys.in(k) {
+	query.tx = tx
+
+	nextMatch := func() (*record, error) {
+		for k, v := iter.Next(); k != nil; k, v = iter.Next() {
+			if len(retrievedKeys) != 0 {
+				// don't check this record if it's already been retrieved
+				if retrievedKeys.in(k) {
+					continue
+				}
+			}
+
+			val := reflect.New(reflect.TypeOf(tp))
+
+			query.stats.decodedRecord()
+			err := query.store.decode(v, val.Interface())
+			if err != nil {
+				err = query.skipDecodeError(k, err)
+				if err != nil {
+					return nil, err
+				}
 				continue
 			}
-		}
 
-		val := reflect.New(reflect.TypeOf(tp))
+			ok, err := query.matchesAllFields(k, val, val.Interface())
+			if err != nil {
+				return nil, err
+			}
 
-		err := decode(v, val.Interface())
-		if err != nil {
-			return err
+			if ok {
+				return &record{
+					key:   k,
+					value: val,
+				}, nil
+			}
 		}
 
-		query.tx = tx
+		return nil, iter.Error()
+	}
+
+	if query.parallel > 1 {
+		nextMatch = newParallelMatcher(iter, query, reflect.TypeOf(tp), retrievedKeys).next
+	}
 
-		ok, err := query.matchesAllFields(k, val, val.Interface())
+	for {
+		r, err := nextMatch()
 		if err != nil {
 			return err
 		}
+		if r == nil {
+			break
+		}
+		query.stats.matchedRecord()
 
-		if ok {
-			if skip > 0 {
-				skip--
-				continue
-			}
+		// track that this key has been matched, so the ors don't match it again, even if it was skipped
+		newKeys.add(r.key)
 
-			err = action(&record{
-				key:   k,
-				value: val,
-			})
-			if err != nil {
-				return err
-			}
+		if skip > 0 {
+			skip--
+			continue
+		}
 
-			// track that this key's entry has been added to the result list
-			newKeys.add(k)
+		err = action(r)
+		if err != nil {
+			return err
+		}
 
-			if query.limit != 0 {
-				limit--
-				if limit == 0 {
-					break
-				}
+		if query.limit != 0 {
+			limit--
+			if limit == 0 {
+				break
 			}
 		}
-
-	}
-
-	if iter.Error() != nil {
-		return iter.Error()
 	}
 
 	if query.limit != 0 && limit == 0 {
@@ -659,6 +1130,7 @@ func runQuery(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys
 		}
 
 		for i := range query.ors {
+			query.ors[i].share(query)
 			err := runQuery(tx, tp, query.ors[i], retrievedKeys, skip, action)
 			if err != nil {
 				return err
@@ -671,7 +1143,7 @@ func runQuery(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys
 
 func runQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys keyList, skip int, kuncian string,
 	action func(r *record, kuncian string) error) error {
-	storer := newStorer(dataType)
+	storer := newStorer(dataType, query.store.encodeValue)
 
 	tp := dataType
 
@@ -680,6 +1152,9 @@ func runQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, retrievedKe
 	}
 
 	query.dataType = reflect.TypeOf(tp)
+	if query.boundType != nil && query.boundType != query.dataType {
+		return &ErrTypeMismatch{reflect.Zero(query.boundType).Interface(), tp}
+	}
 
 	if len(query.sort) > 0 {
 		return runQuerySortPRS(tx, dataType, query, kuncian, action)
@@ -695,65 +1170,86 @@ func runQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, retrievedKe
 		query.bookmark = nil
 	}()
 
-	if query.index != "" && query.badIndex {
-		return fmt.Errorf("The index %s does not exist", query.index)
+	if query.index != "" && query.badIndex && !query.buildingIndex {
+		return query.indexError()
 	}
 
 	newKeys := make(keyList, 0)
 
 	limit := query.limit - len(retrievedKeys)
 
-	for k, v := iter.Next(); k != nil; k, v = iter.Next() {
-		if len(retrievedKeys) != 0 {
-			// don't check this record if it's already been retrieved
-			if retrievedKeys.in(k) {
+	query.tx = tx
+
+	nextMatch := func() (*record, error) {
+		for k, v := iter.Next(); k != nil; k, v = iter.Next() {
+			if len(retrievedKeys) != 0 {
+				// don't check this record if it's already been retrieved
+				if retrievedKeys.in(k) {
+					continue
+				}
+			}
+
+			val := reflect.New(reflect.TypeOf(tp))
+
+			query.stats.decodedRecord()
+			err := query.store.decode(v, val.Interface())
+			if err != nil {
+				err = query.skipDecodeError(k, err)
+				if err != nil {
+					return nil, err
+				}
 				continue
 			}
-		}
 
-		val := reflect.New(reflect.TypeOf(tp))
+			ok, err := query.matchesAllFields(k, val, val.Interface())
+			if err != nil {
+				return nil, err
+			}
 
-		err := decode(v, val.Interface())
-		if err != nil {
-			return err
+			if ok {
+				return &record{
+					key:   k,
+					value: val,
+				}, nil
+			}
 		}
 
-		query.tx = tx
+		return nil, iter.Error()
+	}
+
+	if query.parallel > 1 {
+		nextMatch = newParallelMatcher(iter, query, reflect.TypeOf(tp), retrievedKeys).next
+	}
 
-		ok, err := query.matchesAllFields(k, val, val.Interface())
+	for {
+		r, err := nextMatch()
 		if err != nil {
 			return err
 		}
+		if r == nil {
+			break
+		}
+		query.stats.matchedRecord()
 
-		if ok {
-			if skip > 0 {
-				skip--
-				continue
-			}
+		// track that this key has been matched, so the ors don't match it again, even if it was skipped
+		newKeys.add(r.key)
 
-			err = action(&record{
-				key:   k,
-				value: val,
-			}, kuncian)
-			if err != nil {
-				return err
-			}
+		if skip > 0 {
+			skip--
+			continue
+		}
 
-			// track that this key's entry has been added to the result list
-			newKeys.add(k)
+		err = action(r, kuncian)
+		if err != nil {
+			return err
+		}
 
-			if query.limit != 0 {
-				limit--
-				if limit == 0 {
-					break
-				}
+		if query.limit != 0 {
+			limit--
+			if limit == 0 {
+				break
 			}
 		}
-
-	}
-
-	if iter.Error() != nil {
-		return iter.Error()
 	}
 
 	if query.limit != 0 && limit == 0 {
@@ -767,6 +1263,7 @@ func runQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, retrievedKe
 		}
 
 		for i := range query.ors {
+			query.ors[i].share(query)
 			err := runQueryPRS(tx, tp, query.ors[i], retrievedKeys, skip, kuncian, action)
 			if err != nil {
 				return err
@@ -788,9 +1285,9 @@ func runQuerySort(tx *badger.Txn, dataType interface{}, query *Query, action fun
 			var structField reflect.StructField
 			found := false
 			if current.Kind() == reflect.Ptr {
-				structField, found = current.Elem().FieldByName(fields[i])
+				structField, found = fieldByName(current.Elem(), fields[i], query.store.fieldNameTag)
 			} else {
-				structField, found = current.FieldByName(fields[i])
+				structField, found = fieldByName(current, fields[i], query.store.fieldNameTag)
 			}
 
 			if !found {
@@ -806,12 +1303,23 @@ func runQuerySort(tx *badger.Txn, dataType interface{}, query *Query, action fun
 	qCopy.sort = nil
 	qCopy.limit = 0
 	qCopy.skip = 0
+	qCopy.unranked = true
 
 	var records []*record
+	runs := &sortRuns{query: query}
+	defer runs.close()
+
 	err := runQuery(tx, dataType, &qCopy, nil, 0,
 		func(r *record) error {
 			records = append(records, r)
 
+			if query.store.sortMemoryLimit > 0 && len(records) >= query.store.sortMemoryLimit {
+				// too many records to sort in memory, spill them to disk
+				err := runs.write(records)
+				records = nil
+				return err
+			}
+
 			return nil
 		})
 
@@ -819,46 +1327,19 @@ func runQuerySort(tx *badger.Txn, dataType interface{}, query *Query, action fun
 		return err
 	}
 
-	sort.Slice(records, func(i, j int) bool {
-		for _, field := range query.sort {
-			val, err := fieldValue(records[i].value.Elem(), field)
-			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-			value := val.Interface()
-
-			val, err = fieldValue(records[j].value.Elem(), field)
+	if len(runs.files) > 0 {
+		if len(records) > 0 {
+			err = runs.write(records)
 			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-
-			other := val.Interface()
-
-			if query.reverse {
-				value, other = other, value
+				return err
 			}
+		}
 
-			cmp, cerr := compare(value, other)
-			if cerr != nil {
-				// if for some reason there is an error on compare, fallback to a lexicographic compare
-				valS := fmt.Sprintf("%s", value)
-				otherS := fmt.Sprintf("%s", other)
-				if valS < otherS {
-					return true
-				} else if valS == otherS {
-					continue
-				}
-				return false
-			}
+		return runs.merge(query.skip, query.limit, action)
+	}
 
-			if cmp == -1 {
-				return true
-			} else if cmp == 0 {
-				continue
-			}
-			return false
-		}
-		return false
+	sort.SliceStable(records, func(i, j int) bool {
+		return lessRecord(query, records[i], records[j])
 	})
 
 	// apply skip and limit
@@ -897,9 +1378,9 @@ func runQuerySortPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian
 			var structField reflect.StructField
 			found := false
 			if current.Kind() == reflect.Ptr {
-				structField, found = current.Elem().FieldByName(fields[i])
+				structField, found = fieldByName(current.Elem(), fields[i], query.store.fieldNameTag)
 			} else {
-				structField, found = current.FieldByName(fields[i])
+				structField, found = fieldByName(current, fields[i], query.store.fieldNameTag)
 			}
 
 			if !found {
@@ -929,45 +1410,7 @@ func runQuerySortPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian
 	}
 
 	sort.Slice(records, func(i, j int) bool {
-		for _, field := range query.sort {
-			val, err := fieldValue(records[i].value.Elem(), field)
-			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-			value := val.Interface()
-
-			val, err = fieldValue(records[j].value.Elem(), field)
-			if err != nil {
-				panic(err.Error()) // shouldn't happen due to field check above
-			}
-
-			other := val.Interface()
-
-			if query.reverse {
-				value, other = other, value
-			}
-
-			cmp, cerr := compare(value, other)
-			if cerr != nil {
-				// if for some reason there is an error on compare, fallback to a lexicographic compare
-				valS := fmt.Sprintf("%s", value)
-				otherS := fmt.Sprintf("%s", other)
-				if valS < otherS {
-					return true
-				} else if valS == otherS {
-					continue
-				}
-				return false
-			}
-
-			if cmp == -1 {
-				return true
-			} else if cmp == 0 {
-				continue
-			}
-			return false
-		}
-		return false
+		return lessRecord(query, records[i], records[j])
 	})
 
 	// apply skip and limit
@@ -999,6 +1442,8 @@ func findQuery(tx *badger.Txn, result interface{}, query *Query) error {
 	if query == nil {
 		query = &Query{}
 	}
+	query.begin()
+	defer query.end()
 
 	query.writable = false
 
@@ -1030,15 +1475,25 @@ func findQuery(tx *badger.Txn, result interface{}, query *Query) error {
 	}
 
 	val := reflect.New(tp)
+	query.covered = query.coveredBy(tp, newStorer(val.Interface(), query.store.encodeValue).Indexes()[query.index])
 
 	err := runQuery(tx, val.Interface(), query, nil, query.skip,
 		func(r *record) error {
+			value := r.value
+			if len(query.selected) != 0 {
+				var err error
+				value, err = query.selectFields(r.value)
+				if err != nil {
+					return err
+				}
+			}
+
 			var rowValue reflect.Value
 
 			if elType.Kind() == reflect.Ptr {
-				rowValue = r.value
+				rowValue = value
 			} else {
-				rowValue = r.value.Elem()
+				rowValue = value.Elem()
 			}
 
 			if keyType != nil {
@@ -1046,7 +1501,7 @@ func findQuery(tx *badger.Txn, result interface{}, query *Query) error {
 				for rowKey.Kind() == reflect.Ptr {
 					rowKey = rowKey.Elem()
 				}
-				err := decodeKey(r.key, rowKey.FieldByName(keyField).Addr().Interface(), tp.Name())
+				err := decodeKey(r.key, rowKey.FieldByName(keyField).Addr().Interface(), tp.Name(), query.store.decodeValue)
 				if err != nil {
 					return err
 				}
@@ -1063,13 +1518,48 @@ func findQuery(tx *badger.Txn, result interface{}, query *Query) error {
 
 	resultVal.Elem().Set(sliceVal.Slice(0, sliceVal.Len()))
 
-	return nil
+	return query.skippedError()
+}
+
+func findOneQuery(tx *badger.Txn, result interface{}, query *Query) error {
+	if query == nil {
+		query = &Query{}
+	}
+
+	resultVal := reflect.ValueOf(result)
+	if resultVal.Kind() != reflect.Ptr || resultVal.IsNil() {
+		panic("result argument must be an address")
+	}
+
+	// stop the query at the first match
+	qCopy := *query
+	qCopy.limit = 1
+
+	found := reflect.New(reflect.SliceOf(resultVal.Type()))
+
+	err := findQuery(tx, found.Interface(), &qCopy)
+	if _, skipped := err.(*ErrSkippedRecords); err != nil && !skipped {
+		return err
+	}
+
+	if found.Elem().Len() == 0 {
+		if err != nil {
+			return err
+		}
+		return ErrNotFound
+	}
+
+	resultVal.Elem().Set(found.Elem().Index(0).Elem())
+
+	return err
 }
 
 func findQueryPRS(tx *badger.Txn, result interface{}, query *Query, kuncian string) error {
 	if query == nil {
 		query = &Query{}
 	}
+	query.begin()
+	defer query.end()
 
 	query.writable = false
 
@@ -1113,7 +1603,8 @@ func findQueryPRS(tx *badger.Txn, result interface{}, query *Query, kuncian stri
 			}
 
 			if keyType != nil {
-				err := decodeKey(r.key, rowValue.FieldByName(keyField).Addr().Interface(), kuncian+tp.Name())
+				err := decodeKey(r.key, rowValue.FieldByName(keyField).Addr().Interface(), kuncian+tp.Name(),
+					query.store.decodeValue)
 				if err != nil {
 					return err
 				}
@@ -1130,13 +1621,14 @@ func findQueryPRS(tx *badger.Txn, result interface{}, query *Query, kuncian stri
 
 	resultVal.Elem().Set(sliceVal.Slice(0, sliceVal.Len()))
 
-	return nil
+	return query.skippedError()
 }
 
-func deleteQuery(tx *badger.Txn, dataType interface{}, query *Query) error {
-	if query == nil {
-		query = &Query{}
-	}
+// deleteQuery deletes the records matching the query, and returns how many were deleted
+func (s *Store) deleteQuery(tx *badger.Txn, dataType interface{}, query *Query) (int, error) {
+	query = s.preloaded(query)
+	query.begin()
+	defer query.end()
 	query.writable = true
 
 	var records []*record
@@ -1149,31 +1641,40 @@ func deleteQuery(tx *badger.Txn, dataType interface{}, query *Query) error {
 		})
 
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	storer := newStorer(dataType)
+	storer := s.storer(dataType)
 
 	for i := range records {
 		err := tx.Delete(records[i].key)
 		if err != nil {
-			return err
+			return 0, err
 		}
 
+		s.recordWritten(tx, records[i].key)
+
 		// remove any indexes
-		err = indexDelete(storer, tx, records[i].key, records[i].value.Interface())
+		err = s.indexDelete(storer, tx, records[i].key, records[i].value.Interface())
 		if err != nil {
-			return err
+			return 0, err
 		}
+
+		s.recordChange(tx, ChangeEvent{
+			Type:      storer.Type(),
+			Key:       records[i].key,
+			Operation: ChangeDelete,
+			Previous:  records[i].value.Interface(),
+		})
 	}
 
-	return nil
+	return len(records), nil
 }
 
-func deleteQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian string) error {
-	if query == nil {
-		query = &Query{}
-	}
+func (s *Store) deleteQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian string) error {
+	query = s.preloaded(query)
+	query.begin()
+	defer query.end()
 	query.writable = true
 
 	var records []*record
@@ -1194,15 +1695,17 @@ func deleteQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kuncian
 		if err != nil {
 			return err
 		}
+
+		s.recordWritten(tx, records[i].key)
 	}
 
 	return nil
 }
 
-func updateQuery(tx *badger.Txn, dataType interface{}, query *Query, update func(record interface{}) error) error {
-	if query == nil {
-		query = &Query{}
-	}
+func (s *Store) updateQuery(tx *badger.Txn, dataType interface{}, query *Query, update func(record interface{}) error) error {
+	query = s.preloaded(query)
+	query.begin()
+	defer query.end()
 
 	query.writable = true
 	var records []*record
@@ -1219,38 +1722,69 @@ func updateQuery(tx *badger.Txn, dataType interface{}, query *Query, update func
 		return err
 	}
 
-	storer := newStorer(dataType)
+	storer := s.storer(dataType)
+	tracked := s.tracksChanges(tx)
 	for i := range records {
-		upVal := records[i].value.Interface()
-
-		// delete any existing indexes bad on original value
-		err := indexDelete(storer, tx, records[i].key, upVal)
+		err = s.updateRecord(tx, storer, tracked, records[i], update)
 		if err != nil {
 			return err
 		}
+	}
 
-		err = update(upVal)
-		if err != nil {
-			return err
-		}
+	return nil
+}
 
-		encVal, err := encode(upVal)
-		if err != nil {
-			return err
-		}
+// updateRecord runs the update function on the record, and writes it back along with the index entries that changed
+func (s *Store) updateRecord(tx *badger.Txn, storer Storer, tracked bool, r *record,
+	update func(record interface{}) error) error {
+	upVal := r.value.Interface()
 
-		err = tx.Set(records[i].key, encVal)
-		if err != nil {
-			return err
-		}
+	original, err := indexValues(storer, upVal)
+	if err != nil {
+		return err
+	}
 
-		// insert any new indexes
-		err = indexAdd(storer, tx, records[i].key, upVal)
+	var previous interface{}
+	if tracked {
+		previous, err = s.copyRecord(r.value)
 		if err != nil {
 			return err
 		}
 	}
 
+	err = update(upVal)
+	if err != nil {
+		return err
+	}
+
+	encVal, err := s.encode(upVal)
+	if err != nil {
+		return err
+	}
+
+	err = tx.Set(r.key, encVal)
+	if err != nil {
+		return err
+	}
+
+	s.recordWritten(tx, r.key)
+
+	// move the index entries that changed
+	err = s.indexReplace(storer, tx, r.key, original, upVal)
+	if err != nil {
+		return err
+	}
+
+	if tracked {
+		s.recordChange(tx, ChangeEvent{
+			Type:      storer.Type(),
+			Key:       r.key,
+			Operation: ChangeUpdate,
+			Value:     upVal,
+			Previous:  previous,
+		})
+	}
+
 	return nil
 }
 
@@ -1258,12 +1792,14 @@ func aggregateQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kunci
 	if query == nil {
 		query = &Query{}
 	}
+	query.begin()
+	defer query.end()
 
 	query.writable = false
 	var result []*AggregateResult
 
 	if len(groupBy) == 0 {
-		result = append(result, &AggregateResult{})
+		result = append(result, &AggregateResult{fieldNameTag: query.store.fieldNameTag})
 	}
 
 	err := runQueryPRS(tx, dataType, query, nil, query.skip, kuncian,
@@ -1276,7 +1812,7 @@ func aggregateQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kunci
 			grouping := make([]reflect.Value, len(groupBy))
 
 			for i := range groupBy {
-				fVal := r.value.Elem().FieldByName(groupBy[i])
+				fVal := fieldValueByName(r.value.Elem(), groupBy[i], query.store.fieldNameTag)
 				if !fVal.IsValid() {
 					return fmt.Errorf("The field %s does not exist in the type %s", groupBy[i],
 						r.value.Type())
@@ -1320,8 +1856,9 @@ func aggregateQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kunci
 			result = append(result, nil)
 			copy(result[i+1:], result[i:])
 			result[i] = &AggregateResult{
-				group:     grouping,
-				reduction: []reflect.Value{r.value},
+				group:        grouping,
+				reduction:    []reflect.Value{r.value},
+				fieldNameTag: query.store.fieldNameTag,
 			}
 
 			return nil
@@ -1331,41 +1868,93 @@ func aggregateQueryPRS(tx *badger.Txn, dataType interface{}, query *Query, kunci
 		return nil, err
 	}
 
-	return result, nil
+	return result, query.skippedError()
 }
 
 func aggregateQuery(tx *badger.Txn, dataType interface{}, query *Query, groupBy ...string) ([]*AggregateResult, error) {
+	var grouper func(record reflect.Value) ([]reflect.Value, error)
+
+	if len(groupBy) != 0 {
+		grouper = func(record reflect.Value) ([]reflect.Value, error) {
+			grouping := make([]reflect.Value, len(groupBy))
+
+			for i := range groupBy {
+				fVal := fieldValueByName(record.Elem(), groupBy[i], query.store.fieldNameTag)
+				if !fVal.IsValid() {
+					return nil, fmt.Errorf("The field %s does not exist in the type %s", groupBy[i],
+						record.Type())
+				}
+
+				grouping[i] = fVal
+			}
+			return grouping, nil
+		}
+	}
+
+	return groupQuery(tx, dataType, query, groupBy, grouper)
+}
+
+func aggregateQueryFunc(tx *badger.Txn, dataType interface{}, query *Query,
+	groupBy GroupFunc) ([]*AggregateResult, error) {
+	return groupQuery(tx, dataType, query, nil, func(record reflect.Value) ([]reflect.Value, error) {
+		group := groupBy(record.Interface())
+		if group == nil {
+			return nil, fmt.Errorf("The group func returned nil for the record %v", record.Interface())
+		}
+		return []reflect.Value{reflect.ValueOf(group)}, nil
+	})
+}
+
+// groupQuery runs the query and groups the matching records by the values returned from grouper, sorted by group.
+// If grouper is nil, all records are put in a single group
+func groupQuery(tx *badger.Txn, dataType interface{}, query *Query, groupBy []string,
+	grouper func(record reflect.Value) ([]reflect.Value, error)) ([]*AggregateResult, error) {
 	if query == nil {
 		query = &Query{}
 	}
+	query.begin()
+	defer query.end()
 
 	query.writable = false
 	var result []*AggregateResult
 
-	if len(groupBy) == 0 {
-		result = append(result, &AggregateResult{})
+	// newGroup returns a group holding the record
+	newGroup := func(grouping []reflect.Value, r *record) (*AggregateResult, error) {
+		if query.accumulating() {
+			group := newAccumulated(grouping, groupBy, query.accumulate, query.store.fieldNameTag)
+			return group, group.fold(r.value)
+		}
+		return &AggregateResult{
+			group:        grouping,
+			groupBy:      groupBy,
+			reduction:    []reflect.Value{r.value},
+			fieldNameTag: query.store.fieldNameTag,
+		}, nil
+	}
+
+	if grouper == nil {
+		if query.accumulating() {
+			result = append(result, newAccumulated(nil, nil, query.accumulate, query.store.fieldNameTag))
+		} else {
+			result = append(result, &AggregateResult{fieldNameTag: query.store.fieldNameTag})
+		}
 	}
 
 	err := runQuery(tx, dataType, query, nil, query.skip,
 		func(r *record) error {
-			if len(groupBy) == 0 {
+			if grouper == nil {
+				if query.accumulating() {
+					return result[0].fold(r.value)
+				}
 				result[0].reduction = append(result[0].reduction, r.value)
 				return nil
 			}
 
-			grouping := make([]reflect.Value, len(groupBy))
-
-			for i := range groupBy {
-				fVal := r.value.Elem().FieldByName(groupBy[i])
-				if !fVal.IsValid() {
-					return fmt.Errorf("The field %s does not exist in the type %s", groupBy[i],
-						r.value.Type())
-				}
-
-				grouping[i] = fVal
+			grouping, err := grouper(r.value)
+			if err != nil {
+				return err
 			}
 
-			var err error
 			var c int
 			var allEqual bool
 
@@ -1391,18 +1980,22 @@ func aggregateQuery(tx *badger.Txn, dataType interface{}, query *Query, groupBy
 			if i < len(result) {
 				if allEqual {
 					// group already exists, append results to reduction
+					if query.accumulating() {
+						return result[i].fold(r.value)
+					}
 					result[i].reduction = append(result[i].reduction, r.value)
 					return nil
 				}
 			}
 
 			// group  not found, create another grouping at i
+			group, err := newGroup(grouping, r)
+			if err != nil {
+				return err
+			}
 			result = append(result, nil)
 			copy(result[i+1:], result[i:])
-			result[i] = &AggregateResult{
-				group:     grouping,
-				reduction: []reflect.Value{r.value},
-			}
+			result[i] = group
 
 			return nil
 		})
@@ -1411,13 +2004,15 @@ func aggregateQuery(tx *badger.Txn, dataType interface{}, query *Query, groupBy
 		return nil, err
 	}
 
-	return result, nil
+	return result, query.skippedError()
 }
 
 func countSource(tx *badger.Txn, datatype interface{}, query *Query, kuncian string, count *int) error {
 	if query == nil {
 		query = &Query{}
 	}
+	query.begin()
+	defer query.end()
 
 	query.writable = false
 
@@ -1440,7 +2035,7 @@ func countSource(tx *badger.Txn, datatype interface{}, query *Query, kuncian str
 
 func runQuerySourceCount(tx *badger.Txn, dataType interface{}, query *Query, retrievedKeys keyList, kuncian string,
 	action func() error) error {
-	storer := newStorer(dataType)
+	storer := newStorer(dataType, query.store.encodeValue)
 
 	tp := dataType
 
@@ -1449,6 +2044,9 @@ func runQuerySourceCount(tx *badger.Txn, dataType interface{}, query *Query, ret
 	}
 
 	query.dataType = reflect.TypeOf(tp)
+	if query.boundType != nil && query.boundType != query.dataType {
+		return &ErrTypeMismatch{reflect.Zero(query.boundType).Interface(), tp}
+	}
 
 	iter := newIterator(tx, kuncian+storer.Type(), query, query.bookmark)
 	if (query.writable || query.subquery) && query.bookmark == nil {
@@ -1470,6 +2068,7 @@ func runQuerySourceCount(tx *badger.Txn, dataType interface{}, query *Query, ret
 			}
 		}
 
+		query.stats.matchedRecord()
 		err := action()
 		if err != nil {
 			return err
@@ -1491,6 +2090,7 @@ func runQuerySourceCount(tx *badger.Txn, dataType interface{}, query *Query, ret
 		}
 
 		for i := range query.ors {
+			query.ors[i].share(query)
 			err := runQuerySourceCount(tx, tp, query.ors[i], retrievedKeys, kuncian, action)
 			if err != nil {
 				return err
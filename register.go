@@ -0,0 +1,71 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BadgerHoldIndexFuncTag is the struct tag used to index a field with an Index registered with RegisterIndex, in place
+// of the field's own value
+const BadgerHoldIndexFuncTag = "badgerholdIndexFunc"
+
+var registered = struct {
+	sync.RWMutex
+	indexes map[string]Index
+}{indexes: make(map[string]Index)}
+
+// RegisterIndex registers the Index under the name, so the fields of any type can be indexed with it by setting their
+// badgerholdIndexFunc struct tag to the name, without implementing the Storer interface.  The index is named after the
+// field, and like the ones added with AddIndex, the Index's funcs are passed the whole record.  RegisterIndex panics if
+// an Index is already registered under the name
+// 	badgerhold.RegisterIndex("lowercaseEmail", badgerhold.Index{IndexFunc: lowerEmail})
+//
+// 	type User struct {
+// 		Email string `badgerholdIndexFunc:"lowercaseEmail"`
+// 	}
+//
+// 	Where("Email").Eq("tim@example.com").Index("Email")
+func RegisterIndex(name string, index Index) {
+	if name == "" {
+		panic("A registered index must have a name")
+	}
+	if index.IndexFunc == nil && index.MultiValueFunc == nil {
+		panic(fmt.Sprintf("The registered index %s has no IndexFunc or MultiValueFunc", name))
+	}
+
+	registered.Lock()
+	defer registered.Unlock()
+
+	if _, ok := registered.indexes[name]; ok {
+		panic(fmt.Sprintf("An index named %s is already registered", name))
+	}
+	registered.indexes[name] = index
+}
+
+// registeredIndex returns the registered Index the field's badgerholdIndexFunc tag refers to, or false if the field
+// doesn't have the tag
+// panics if no Index is registered under the name, or the field is indexed by other tags as well
+func registeredIndex(field reflect.StructField) (Index, bool) {
+	name := field.Tag.Get(BadgerHoldIndexFuncTag)
+	if name == "" {
+		return Index{}, false
+	}
+	if indexName, _ := indexTag(field); indexName != "" {
+		panic("Invalid Type for Storer.  The field " + field.Name + " can't have both an index func and an index tag")
+	}
+
+	registered.RLock()
+	defer registered.RUnlock()
+
+	index, ok := registered.indexes[name]
+	if !ok {
+		panic("Invalid Type for Storer.  The index func " + name + " of the field " + field.Name +
+			" isn't registered")
+	}
+	return index, true
+}
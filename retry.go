@@ -0,0 +1,42 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// WithRetry runs fn in a new read-write transaction, and if the transaction fails to commit with badger.ErrConflict
+// fn is run again in a new transaction, up to attempts times in total.  Each attempt waits twice as long as the
+// previous one, starting with the store's ConflictBackoff, plus a random amount of up to the same again, so that
+// transactions which conflicted with each other don't retry in lockstep and conflict again.
+// fn must read any data it depends on from the transaction passed into it, rather than reusing values from an
+// earlier attempt, so that each attempt sees the current state of the store
+func (s *Store) WithRetry(attempts int, fn func(tx *badger.Txn) error) error {
+	backoff := s.conflictBackoff
+
+	for attempt := 1; ; attempt++ {
+		err := s.trackedUpdate(fn)
+		if !errors.Is(err, badger.ErrConflict) || attempt >= attempts {
+			return err
+		}
+
+		wait := backoff
+		if backoff > 0 {
+			wait += time.Duration(rand.Int63n(int64(backoff)))
+		}
+		time.Sleep(wait)
+		backoff *= 2
+	}
+}
+
+// update runs fn in a new read-write transaction, retrying on conflicts as configured in the store's options
+func (s *Store) update(fn func(tx *badger.Txn) error) error {
+	return s.WithRetry(s.conflictRetries+1, fn)
+}
@@ -0,0 +1,85 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/timshannon/badgerhold"
+)
+
+func TestWithRetry(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		key := "testKey"
+		err := store.Insert(key, &ItemTest{Name: "original"})
+		if err != nil {
+			t.Fatalf("Error inserting data for retry test: %s", err)
+		}
+
+		// conflict writes the record from another transaction, so that committing a transaction which has
+		// already read it fails
+		conflict := func(name string) {
+			err := store.Update(key, &ItemTest{Name: name})
+			if err != nil {
+				t.Fatalf("Error updating data from a conflicting transaction: %s", err)
+			}
+		}
+
+		attempts := 0
+		err = store.WithRetry(3, func(tx *badger.Txn) error {
+			attempts++
+			current := &ItemTest{}
+			err := store.TxGet(tx, key, current)
+			if err != nil {
+				return err
+			}
+
+			if attempts == 1 {
+				conflict("conflict")
+			}
+
+			current.Name += " retried"
+			return store.TxUpdate(tx, key, current)
+		})
+		if err != nil {
+			t.Fatalf("Error running transaction with retry: %s", err)
+		}
+
+		if attempts != 2 {
+			t.Fatalf("Expected %d attempts got %d", 2, attempts)
+		}
+
+		result := &ItemTest{}
+		err = store.Get(key, result)
+		if err != nil {
+			t.Fatalf("Error getting data from badgerhold: %s", err)
+		}
+
+		if result.Name != "conflict retried" {
+			t.Fatalf("Retry didn't read fresh data.  Got %s wanted %s", result.Name, "conflict retried")
+		}
+
+		attempts = 0
+		err = store.WithRetry(2, func(tx *badger.Txn) error {
+			attempts++
+			current := &ItemTest{}
+			err := store.TxGet(tx, key, current)
+			if err != nil {
+				return err
+			}
+
+			conflict("conflict")
+			return store.TxUpdate(tx, key, current)
+		})
+		if err != badger.ErrConflict {
+			t.Fatalf("Expected ErrConflict once retries ran out, got %v", err)
+		}
+
+		if attempts != 2 {
+			t.Fatalf("Expected %d attempts got %d", 2, attempts)
+		}
+	})
+}
@@ -0,0 +1,101 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ErrRecordDecode is a record that a query with SkipErrors couldn't decode.  Key is the record's full Badger key,
+// including its type prefix, so it can be read or deleted directly with the Badger DB
+type ErrRecordDecode struct {
+	Key []byte
+	Err error
+}
+
+func (e *ErrRecordDecode) Error() string {
+	return fmt.Sprintf("Unable to decode the record with the key %q: %s", e.Key, e.Err)
+}
+
+// Unwrap returns the decode error
+func (e *ErrRecordDecode) Unwrap() error {
+	return e.Err
+}
+
+// ErrSkippedRecords is returned along with the results of a query with SkipErrors, when it skipped any records that
+// couldn't be decoded
+type ErrSkippedRecords struct {
+	Errors []*ErrRecordDecode
+}
+
+func (e *ErrSkippedRecords) Error() string {
+	errs := make([]string, len(e.Errors))
+	for i := range e.Errors {
+		errs[i] = e.Errors[i].Error()
+	}
+	return fmt.Sprintf("Skipped %d records that couldn't be decoded: %s", len(e.Errors), strings.Join(errs, "; "))
+}
+
+// Unwrap returns the decode errors of the skipped records
+func (e *ErrSkippedRecords) Unwrap() []error {
+	errs := make([]error, len(e.Errors))
+	for i := range e.Errors {
+		errs[i] = e.Errors[i]
+	}
+	return errs
+}
+
+// SkipErrors skips records that can't be decoded, rather than failing the query at the first one.  Find, FindOne,
+// aggregate queries and CountDistinct return the records that could be decoded, along with an *ErrSkippedRecords
+// listing the ones that couldn't.  Queries that update or delete records still fail at the first error
+func (q *Query) SkipErrors() *Query {
+	q.skipErrors = true
+	return q
+}
+
+// skippedRecords collects the decode errors of a query with SkipErrors, it's shared with the query's Ors and
+// subqueries, which can run in parallel, and read the same records again.  A nil skippedRecords doesn't skip anything
+type skippedRecords struct {
+	sync.Mutex
+	errors []*ErrRecordDecode
+	keys   map[string]bool
+}
+
+// skipDecodeError returns nil if the record that failed to decode can be skipped, otherwise it returns the error
+func (q *Query) skipDecodeError(key []byte, err error) error {
+	if q.skipped == nil || q.writable {
+		return err
+	}
+
+	q.skipped.Lock()
+	defer q.skipped.Unlock()
+	if q.skipped.keys[string(key)] {
+		return nil
+	}
+	q.skipped.keys[string(key)] = true
+	q.skipped.errors = append(q.skipped.errors, &ErrRecordDecode{
+		Key: append([]byte{}, key...),
+		Err: err,
+	})
+	return nil
+}
+
+// skippedError returns an *ErrSkippedRecords if the top level query skipped any records, otherwise nil
+func (q *Query) skippedError() error {
+	if q.subquery || q.skipped == nil {
+		return nil
+	}
+
+	q.skipped.Lock()
+	defer q.skipped.Unlock()
+	if len(q.skipped.errors) == 0 {
+		return nil
+	}
+	return &ErrSkippedRecords{
+		Errors: append([]*ErrRecordDecode{}, q.skipped.errors...),
+	}
+}
@@ -0,0 +1,97 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// IncludeDeleted includes soft deleted records in the query results, see Options.SoftDeleteField
+func (q *Query) IncludeDeleted() *Query {
+	q.includeDeleted = true
+	return q
+}
+
+// excludesDeleted returns whether the record is soft deleted and left out of the query
+func (q *Query) excludesDeleted(value reflect.Value) bool {
+	if q.store.softDeleteField == "" || q.includeDeleted {
+		return false
+	}
+
+	for value.Kind() == reflect.Ptr {
+		value = value.Elem()
+	}
+	if value.Kind() != reflect.Struct {
+		return false
+	}
+
+	field := fieldValueByName(value, q.store.softDeleteField, q.store.fieldNameTag)
+	return field.IsValid() && field.Kind() == reflect.Ptr && !field.IsNil()
+}
+
+// softDeletes returns whether records of the type can be soft deleted, which needs the store's soft delete field to be
+// a pointer field of the type
+func (s *Store) softDeletes(tp reflect.Type) bool {
+	if s.softDeleteField == "" {
+		return false
+	}
+
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+	if tp.Kind() != reflect.Struct {
+		return false
+	}
+
+	field, ok := fieldByName(tp, s.softDeleteField, s.fieldNameTag)
+	return ok && field.Type.Kind() == reflect.Ptr
+}
+
+// SoftDelete marks the record as soft deleted by setting its Options.SoftDeleteField, to the current time if it's a
+// *time.Time, and updates the record and its indexes.  The record stays in the store, and can still be read with Get
+// or by queries that call IncludeDeleted.  dataType needs to be a pointer to the record's type, such as &Item{}
+func (s *Store) SoftDelete(key, dataType interface{}) error {
+	return s.update(func(tx *badger.Txn) error {
+		return s.TxSoftDelete(tx, key, dataType)
+	})
+}
+
+// TxSoftDelete is the same as SoftDelete except it allows you to specify your own transaction
+func (s *Store) TxSoftDelete(tx *badger.Txn, key, dataType interface{}) error {
+	value := reflect.ValueOf(dataType)
+	if value.Kind() != reflect.Ptr || value.Elem().Kind() != reflect.Struct {
+		panic("dataType passed to SoftDelete must be a pointer to a struct")
+	}
+
+	if !s.softDeletes(value.Type()) {
+		return fmt.Errorf("The type %s doesn't have a soft delete field, set Options.SoftDeleteField to a pointer field",
+			value.Elem().Type())
+	}
+
+	record := reflect.New(value.Elem().Type())
+	err := s.TxGet(tx, key, record.Interface())
+	if err != nil {
+		return err
+	}
+
+	field := fieldValueByName(record.Elem(), s.softDeleteField, s.fieldNameTag)
+	if !field.IsNil() {
+		// already deleted
+		return nil
+	}
+
+	if field.Type() == reflect.TypeOf(&time.Time{}) {
+		now := time.Now()
+		field.Set(reflect.ValueOf(&now))
+	} else {
+		field.Set(reflect.New(field.Type().Elem()))
+	}
+
+	return s.TxUpdate(tx, key, record.Interface())
+}
@@ -0,0 +1,150 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/timshannon/badgerhold"
+)
+
+type Customer struct {
+	Name      string
+	Region    string `badgerholdIndex:"Region"`
+	DeletedAt *time.Time
+}
+
+type Tag struct {
+	Name      string
+	DeletedAt []string
+}
+
+func TestSoftDelete(t *testing.T) {
+	opt := testOptions()
+	opt.SoftDeleteField = "DeletedAt"
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	for _, name := range []string{"ann", "bob", "cat"} {
+		err = store.Insert(name, &Customer{Name: name, Region: "north"})
+		if err != nil {
+			t.Fatalf("Error inserting customer: %s", err)
+		}
+	}
+
+	err = store.SoftDelete("bob", &Customer{})
+	if err != nil {
+		t.Fatalf("Error soft deleting customer: %s", err)
+	}
+
+	var bob Customer
+	err = store.Get("bob", &bob)
+	if err != nil {
+		t.Fatalf("Error getting a soft deleted customer: %s", err)
+	}
+	if bob.DeletedAt == nil || time.Since(*bob.DeletedAt) > time.Minute {
+		t.Fatalf("Soft deleted customer has DeletedAt %v", bob.DeletedAt)
+	}
+
+	count := func(query *badgerhold.Query) int {
+		var result []Customer
+		err := store.Find(&result, query)
+		if err != nil {
+			t.Fatalf("Error finding customers: %s", err)
+		}
+		return len(result)
+	}
+
+	if n := count(nil); n != 2 {
+		t.Fatalf("Found %d customers wanted 2", n)
+	}
+	if n := count(badgerhold.Where("Region").Eq("north").Index("Region")); n != 2 {
+		t.Fatalf("Found %d customers with an index wanted 2", n)
+	}
+	if n := count(badgerhold.Where("Name").Eq("ann").Or(badgerhold.Where("Name").Eq("bob"))); n != 1 {
+		t.Fatalf("Found %d customers with an Or wanted 1", n)
+	}
+	if n := count(badgerhold.Where("Region").Eq("north").IncludeDeleted()); n != 3 {
+		t.Fatalf("Found %d customers including deleted wanted 3", n)
+	}
+	if n := count(badgerhold.Where("Name").Eq("ann").Or(badgerhold.Where("Name").Eq("bob")).IncludeDeleted()); n != 2 {
+		t.Fatalf("Found %d customers with an Or including deleted wanted 2", n)
+	}
+
+	err = store.FindOne(&Customer{}, badgerhold.Where("Name").Eq("bob"))
+	if err != badgerhold.ErrNotFound {
+		t.Fatalf("FindOne of a soft deleted customer returned %v wanted %v", err, badgerhold.ErrNotFound)
+	}
+
+	result, err := store.FindAggregate(&Customer{}, nil, "Region")
+	if err != nil {
+		t.Fatalf("Error aggregating customers: %s", err)
+	}
+	if len(result) != 1 || result[0].Count() != 2 {
+		t.Fatalf("Aggregate counted %v wanted 2", result)
+	}
+
+	result, err = store.FindAggregate(&Customer{}, (&badgerhold.Query{}).IncludeDeleted(), "Region")
+	if err != nil {
+		t.Fatalf("Error aggregating customers: %s", err)
+	}
+	if len(result) != 1 || result[0].Count() != 3 {
+		t.Fatalf("Aggregate including deleted counted %v wanted 3", result)
+	}
+
+	err = store.Insert("item", &ItemTest{Name: "item"})
+	if err != nil {
+		t.Fatalf("Error inserting item: %s", err)
+	}
+	err = store.SoftDelete("item", &ItemTest{})
+	if err == nil {
+		t.Fatalf("Soft deleting a type without the soft delete field didn't fail")
+	}
+	var items []ItemTest
+	err = store.Find(&items, nil)
+	if err != nil {
+		t.Fatalf("Error finding items: %s", err)
+	}
+	if len(items) != 1 {
+		t.Fatalf("Found %d items wanted 1", len(items))
+	}
+
+	// only pointer fields mark records as soft deleted
+	err = store.Insert("tag", &Tag{Name: "tag", DeletedAt: []string{"set"}})
+	if err != nil {
+		t.Fatalf("Error inserting tag: %s", err)
+	}
+	err = store.SoftDelete("tag", &Tag{})
+	if err == nil {
+		t.Fatalf("Soft deleting a type whose soft delete field isn't a pointer didn't fail")
+	}
+	var tags []Tag
+	err = store.Find(&tags, nil)
+	if err != nil {
+		t.Fatalf("Error finding tags: %s", err)
+	}
+	if len(tags) != 1 {
+		t.Fatalf("Found %d tags wanted 1", len(tags))
+	}
+
+	// the soft delete field belongs to the store, so opening another store without one doesn't change it
+	other := testOptions()
+	otherStore, err := badgerhold.Open(other)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", other.Dir, err)
+	}
+	defer os.RemoveAll(other.Dir)
+	defer otherStore.Close()
+
+	if n := count(nil); n != 2 {
+		t.Fatalf("Found %d customers after opening another store wanted 2", n)
+	}
+}
@@ -0,0 +1,286 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bufio"
+	"container/heap"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"reflect"
+	"sort"
+)
+
+// lessRecord returns whether or not the record a sorts before b by the query's sort fields
+func lessRecord(query *Query, a, b *record) bool {
+	if query.ranked() {
+		return moreRelevant(query, a, b)
+	}
+
+	for _, field := range query.sort {
+		value := sortValue(a, field, query.store.fieldNameTag)
+		other := sortValue(b, field, query.store.fieldNameTag)
+
+		if query.reverse != query.descending[field] {
+			value, other = other, value
+		}
+
+		cmp := compareSortValues(value, other)
+		if cmp != 0 {
+			return cmp < 0
+		}
+	}
+	return false
+}
+
+// sortValue returns the value of the record's sort field, dereferenced if it's a pointer, or nil if it's a nil pointer
+func sortValue(r *record, field, nameTag string) interface{} {
+	val, err := fieldValue(r.value.Elem(), field, nameTag)
+	if err != nil {
+		panic(err.Error()) // shouldn't happen, sort fields are checked before sorting
+	}
+
+	for val.Kind() == reflect.Ptr {
+		if val.IsNil() {
+			return nil
+		}
+		val = val.Elem()
+	}
+
+	return val.Interface()
+}
+
+// compareSortValues compares two sort field values in ascending order, where nil values sort before everything else
+func compareSortValues(value, other interface{}) int {
+	if value == nil || other == nil {
+		switch {
+		case value == other:
+			return 0
+		case value == nil:
+			return -1
+		default:
+			return 1
+		}
+	}
+
+	cmp, err := compare(value, other)
+	if err != nil {
+		// if for some reason there is an error on compare, fallback to a lexicographic compare
+		valS := fmt.Sprintf("%s", value)
+		otherS := fmt.Sprintf("%s", other)
+		if valS < otherS {
+			return -1
+		} else if valS == otherS {
+			return 0
+		}
+		return 1
+	}
+
+	return cmp
+}
+
+// sortRuns is an external merge sort.  Records are sorted in batches, and each sorted batch is written to its own
+// temporary file, so that only one batch, and one record per file while merging, is held in memory at a time
+type sortRuns struct {
+	query *Query
+	files []*os.File
+}
+
+// write sorts the records, and writes them to a new run file
+func (s *sortRuns) write(records []*record) error {
+	sort.Slice(records, func(i, j int) bool {
+		return lessRecord(s.query, records[i], records[j])
+	})
+
+	file, err := ioutil.TempFile("", "badgerhold-sort-")
+	if err != nil {
+		return err
+	}
+	s.files = append(s.files, file)
+
+	w := bufio.NewWriter(file)
+	for i := range records {
+		value, err := s.query.store.encode(records[i].value.Interface())
+		if err != nil {
+			return err
+		}
+
+		err = writeSortEntry(w, records[i].key)
+		if err != nil {
+			return err
+		}
+		err = writeSortEntry(w, value)
+		if err != nil {
+			return err
+		}
+	}
+
+	return w.Flush()
+}
+
+// merge calls action on every record from all of the runs in sorted order, after skipping the first skip records,
+// and stops after limit records if limit is set
+func (s *sortRuns) merge(skip, limit int, action func(r *record) error) error {
+	runs := &runHeap{query: s.query}
+	for i := range s.files {
+		_, err := s.files[i].Seek(0, io.SeekStart)
+		if err != nil {
+			return err
+		}
+
+		run := &sortRun{
+			index:    i,
+			r:        bufio.NewReader(s.files[i]),
+			dataType: s.query.dataType,
+			decode:   s.query.store.decode,
+		}
+		err = run.next()
+		if err != nil {
+			return err
+		}
+		if run.current != nil {
+			runs.runs = append(runs.runs, run)
+		}
+	}
+	heap.Init(runs)
+
+	for runs.Len() > 0 {
+		run := runs.runs[0]
+		r := run.current
+
+		err := run.next()
+		if err != nil {
+			return err
+		}
+		if run.current == nil {
+			heap.Pop(runs)
+		} else {
+			heap.Fix(runs, 0)
+		}
+
+		if skip > 0 {
+			skip--
+			continue
+		}
+
+		err = action(r)
+		if err != nil {
+			return err
+		}
+
+		if limit > 0 {
+			limit--
+			if limit == 0 {
+				return nil
+			}
+		}
+	}
+
+	return nil
+}
+
+// close closes and removes all of the run files
+func (s *sortRuns) close() {
+	for i := range s.files {
+		s.files[i].Close()
+		os.Remove(s.files[i].Name())
+	}
+	s.files = nil
+}
+
+// sortRun reads the records back out of a single run file
+type sortRun struct {
+	index    int
+	r        *bufio.Reader
+	dataType reflect.Type
+	decode   DecodeFunc
+	current  *record
+}
+
+// next reads the next record in the run into current, current is nil once the run is exhausted
+func (s *sortRun) next() error {
+	key, err := readSortEntry(s.r)
+	if err == io.EOF {
+		s.current = nil
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	data, err := readSortEntry(s.r)
+	if err != nil {
+		return err
+	}
+
+	value := reflect.New(s.dataType)
+	err = s.decode(data, value.Interface())
+	if err != nil {
+		return err
+	}
+
+	s.current = &record{
+		key:   key,
+		value: value,
+	}
+	return nil
+}
+
+// runHeap orders the runs being merged by their current record
+type runHeap struct {
+	query *Query
+	runs  []*sortRun
+}
+
+func (h *runHeap) Len() int { return len(h.runs) }
+
+func (h *runHeap) Less(i, j int) bool {
+	if lessRecord(h.query, h.runs[i].current, h.runs[j].current) {
+		return true
+	}
+	if lessRecord(h.query, h.runs[j].current, h.runs[i].current) {
+		return false
+	}
+	// keep equal records in the order they were read
+	return h.runs[i].index < h.runs[j].index
+}
+
+func (h *runHeap) Swap(i, j int) { h.runs[i], h.runs[j] = h.runs[j], h.runs[i] }
+
+func (h *runHeap) Push(x interface{}) { h.runs = append(h.runs, x.(*sortRun)) }
+
+func (h *runHeap) Pop() interface{} {
+	last := h.runs[len(h.runs)-1]
+	h.runs = h.runs[:len(h.runs)-1]
+	return last
+}
+
+func writeSortEntry(w *bufio.Writer, data []byte) error {
+	var length [binary.MaxVarintLen64]byte
+	_, err := w.Write(length[:binary.PutUvarint(length[:], uint64(len(data)))])
+	if err != nil {
+		return err
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+func readSortEntry(r *bufio.Reader) ([]byte, error) {
+	length, err := binary.ReadUvarint(r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, length)
+	_, err = io.ReadFull(r, data)
+	if err == io.EOF {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return data, err
+}
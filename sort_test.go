@@ -6,6 +6,7 @@ package badgerhold_test
 
 import (
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/timshannon/badgerhold"
@@ -32,6 +33,21 @@ var sortTests = []test{
 		query:  badgerhold.Where("ID").In(8, 3, 13).SortBy("Category", "Name").Reverse(),
 		result: []int{3, 4, 15, 13},
 	},
+	test{
+		name:   "Sort By Mixed Directions",
+		query:  badgerhold.Where("ID").In(8, 3, 13).SortBy(badgerhold.Asc("Category"), badgerhold.Desc("Name")),
+		result: []int{13, 4, 15, 3},
+	},
+	test{
+		name:   "Sort By Mixed Directions Reversed",
+		query:  badgerhold.Where("ID").In(8, 3, 13).SortBy(badgerhold.Asc("Category"), badgerhold.Desc("Name")).Reverse(),
+		result: []int{3, 15, 4, 13},
+	},
+	test{
+		name:   "Sort By Descending Then Ascending",
+		query:  badgerhold.Where("ID").In(8, 3, 13).SortBy(badgerhold.Desc("Category"), "Name"),
+		result: []int{3, 15, 4, 13},
+	},
 	test{
 		name:   "Sort By Duplicate Field Names",
 		query:  badgerhold.Where("ID").In(8, 3, 13).SortBy("Category", "Name", "Category"),
@@ -107,6 +123,39 @@ func TestSortedFind(t *testing.T) {
 	})
 }
 
+func TestSortedFindSpilled(t *testing.T) {
+	opt := testOptions()
+	opt.SortMemoryLimit = 2
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTestData(t, store)
+
+	for _, tst := range sortTests {
+		t.Run(tst.name, func(t *testing.T) {
+			var result []ItemTest
+			err := store.Find(&result, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding sort data from badgerhold: %s", err)
+			}
+			if len(result) != len(tst.result) {
+				t.Fatalf("Sorted Find result count is %d wanted %d.  Results: %v", len(result),
+					len(tst.result), result)
+			}
+
+			for i := range result {
+				if !result[i].equal(&testData[tst.result[i]]) {
+					t.Fatalf("Expected index %d to be %v, Got %v", i, &testData[tst.result[i]], result[i])
+				}
+			}
+		})
+	}
+}
+
 func TestSortedUpdateMatching(t *testing.T) {
 	for _, tst := range sortTests {
 		t.Run(tst.name, func(t *testing.T) {
@@ -259,3 +308,48 @@ func TestSortedFindWithNonSlicePtr(t *testing.T) {
 		_ = store.Find(result, badgerhold.Where("Name").Eq("blah").SortBy("Name"))
 	})
 }
+
+func TestSortNilValues(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		type Task struct {
+			Name     string
+			Priority *int
+		}
+
+		priority := func(p int) *int { return &p }
+		for i, task := range []Task{
+			{Name: "b", Priority: priority(2)},
+			{Name: "a"},
+			{Name: "c", Priority: priority(1)},
+			{Name: "d"},
+		} {
+			err := store.Insert(i, task)
+			if err != nil {
+				t.Fatalf("Error inserting task: %s", err)
+			}
+		}
+
+		for _, tst := range []struct {
+			query *badgerhold.Query
+			names string
+		}{
+			{badgerhold.Where("Name").Ne("").SortBy("Priority", "Name"), "[a d c b]"},
+			{badgerhold.Where("Name").Ne("").SortBy(badgerhold.Desc("Priority"), "Name"), "[b c a d]"},
+			{badgerhold.Where("Name").Ne("").SortBy("Priority", "Name").Reverse(), "[b c d a]"},
+		} {
+			var result []Task
+			err := store.Find(&result, tst.query)
+			if err != nil {
+				t.Fatalf("Error finding tasks: %s", err)
+			}
+
+			names := make([]string, len(result))
+			for i := range result {
+				names[i] = result[i].Name
+			}
+			if fmt.Sprint(names) != tst.names {
+				t.Fatalf("%s sorted the tasks %v wanted %s", tst.query, names, tst.names)
+			}
+		}
+	})
+}
@@ -0,0 +1,126 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// QueryStats describes the work done by a query, and is passed to the QueryObserver option after every query is run
+type QueryStats struct {
+	Type  string // storer type name of the records queried
+	Query string // the query, as it's printed by Query.String
+	Index string // the index the records were found with, empty if every record of the type was scanned
+
+	Scanned int // keys read, from the index if one was used, otherwise from the records of the type
+	Decoded int // records decoded to test them against the query
+	Matched int // records that matched the query, including any skipped over by Skip
+
+	Duration time.Duration
+}
+
+// queryStats counts the work done by a query while it runs.  It's shared by the query's Ors and subqueries, which
+// can run in parallel, so the counts are updated atomically.  A nil queryStats doesn't count anything
+type queryStats struct {
+	start   time.Time
+	index   string
+	scanned int64
+	decoded int64
+	matched int64
+}
+
+func (s *queryStats) scannedKey() {
+	if s != nil {
+		atomic.AddInt64(&s.scanned, 1)
+	}
+}
+
+func (s *queryStats) decodedRecord() {
+	if s != nil {
+		atomic.AddInt64(&s.decoded, 1)
+	}
+}
+
+func (s *queryStats) matchedRecord() {
+	if s != nil {
+		atomic.AddInt64(&s.matched, 1)
+	}
+}
+
+// usedIndex records the first index the query used
+func (s *queryStats) usedIndex(index string) {
+	if s != nil && s.index == "" {
+		s.index = index
+	}
+}
+
+// begin starts the deadline the query has to finish by, collecting skipped records, and counting its stats if they're
+// needed.  Subqueries share the deadline and stats of the query running them, so they're only started for top level
+// queries
+func (q *Query) begin() {
+	if q.subquery {
+		return
+	}
+
+	q.deadline = time.Time{}
+	if q.store.queryTimeout > 0 {
+		q.deadline = time.Now().Add(q.store.queryTimeout)
+	}
+
+	q.skipped = nil
+	if q.skipErrors {
+		q.skipped = &skippedRecords{keys: make(map[string]bool)}
+	}
+
+	q.stats = nil
+	if q.store.queryObserver != nil || q.store.slowQueryThreshold > 0 {
+		q.stats = &queryStats{start: time.Now()}
+	}
+}
+
+// end reports the stats of a top level query once it's finished
+func (q *Query) end() {
+	if q.subquery || q.stats == nil {
+		return
+	}
+
+	stats := QueryStats{
+		Query:    q.String(),
+		Index:    q.stats.index,
+		Scanned:  int(atomic.LoadInt64(&q.stats.scanned)),
+		Decoded:  int(atomic.LoadInt64(&q.stats.decoded)),
+		Matched:  int(atomic.LoadInt64(&q.stats.matched)),
+		Duration: time.Since(q.stats.start),
+	}
+	if q.dataType != nil {
+		stats.Type = q.dataType.Name()
+	}
+	q.stats = nil
+
+	if q.store.queryObserver != nil {
+		q.store.queryObserver(stats)
+	}
+
+	if q.store.slowQueryThreshold > 0 && stats.Duration >= q.store.slowQueryThreshold && q.store.logger != nil {
+		q.store.logger.Warningf("Slow badgerhold query on %s took %s, scanned %d keys, decoded %d records and "+
+			"matched %d, using index %q: %s", stats.Type, stats.Duration, stats.Scanned, stats.Decoded,
+			stats.Matched, stats.Index, stats.Query)
+	}
+}
+
+// share runs the query with the same deadline, stats and skipped records as another, such as the query it's an Or of, or the query
+// whose MatchFunc is running it as a subquery, and runs against the same store, reading its preloaded and added indexes.  It also
+// includes soft deleted records if the other query does
+func (q *Query) share(other *Query) {
+	q.deadline = other.deadline
+	q.stats = other.stats
+	q.skipped = other.skipped
+	q.preloads = other.preloads
+	if other.store != nil {
+		q.withStore(other.store)
+	}
+	q.includeDeleted = q.includeDeleted || other.includeDeleted
+}
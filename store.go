@@ -5,9 +5,13 @@
 package badgerhold
 
 import (
+	"crypto/cipher"
+	"io/ioutil"
+	"os"
 	"reflect"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/dgraph-io/badger"
 )
@@ -16,32 +20,151 @@ const (
 	// BadgerHoldIndexTag is the struct tag used to define an a field as indexable for a badgerhold
 	BadgerHoldIndexTag = "badgerholdIndex"
 
+	// BadgerHoldUniqueTag is the struct tag used to define a field as indexable with a unique constraint
+	BadgerHoldUniqueTag = "badgerholdUnique"
+
 	// BadgerholdKeyTag is the struct tag used to define an a field as a key for use in a Find query
 	BadgerholdKeyTag = "badgerholdKey"
 
 	// badgerholdPrefixTag is the prefix for an alternate (more standard) version of a struct tag
-	badgerholdPrefixTag         = "badgerhold"
-	badgerholdPrefixIndexValue  = "index"
-	badgerholdPrefixKeyValue    = "key"
-	badgerholdPrefixUniqueValue = "unique"
+	badgerholdPrefixTag          = "badgerhold"
+	badgerholdPrefixIndexValue   = "index"
+	badgerholdPrefixKeyValue     = "key"
+	badgerholdPrefixUniqueValue  = "unique"
+	badgerholdPrefixSkipValue    = "-"
+	badgerholdPrefixEncryptValue = "encrypt"
 )
 
 // Store is a badgerhold wrapper around a badger DB
 type Store struct {
 	db               *badger.DB
+	ownsDB           bool
 	sequenceBandwith uint64
+	typeBandwiths    map[string]uint64
 	sequences        *sync.Map
+	sequenceLock     sync.Mutex
+	changes          *changeHooks
+	getCache         *getCache
+	preloads         *indexPreloads
+	conflictRetries  int
+	conflictBackoff  time.Duration
+	tempDir          string
+	queryTimeout     time.Duration
+	softDeleteField  string
+	fieldNameTag     string
+	sortMemoryLimit  int
+
+	gcDeleteThreshold int
+	gcDiscardRatio    float64
+	logger            badger.Logger
+
+	// encode and decode are used for records, they use the record type's encoding.BinaryMarshaler and
+	// encoding.BinaryUnmarshaler if it has them, otherwise the store's Encoder and Decoder, and they encrypt and
+	// decrypt the fields tagged `badgerhold:"encrypt"` with the store's EncryptionKey
+	encode EncodeFunc
+	decode DecodeFunc
+
+	// encodeValue and decodeValue are used for keys and index values, with the store's Encoder and Decoder
+	encodeValue EncodeFunc
+	decodeValue DecodeFunc
+
+	// queryObserver is called with the stats of every query, if it's set, and queries that run for at least
+	// slowQueryThreshold are logged as slow, 0 doesn't log any
+	queryObserver      func(stats QueryStats)
+	slowQueryThreshold time.Duration
+
+	indexLock sync.RWMutex
+	indexes   map[string]map[string]Index // indexes added with AddIndex by storer type
+
+	sweeper *expiredSweeper
 }
 
 // Options allows you set different options from the defaults
 // For example the encoding and decoding funcs which default to Gob
 type Options struct {
-	Encoder          EncodeFunc
-	Decoder          DecodeFunc
+	Encoder EncodeFunc
+	Decoder DecodeFunc
+
+	// SequenceBandwith is the number of sequence keys leased from badger at a time
 	SequenceBandwith uint64
+	// TypeSequenceBandwith overrides the SequenceBandwith for the storer types in the map
+	TypeSequenceBandwith map[string]uint64
+
+	// FieldNameTag is an optional struct tag (such as "json") used to look up fields by name in queries, sorts and
+	// aggregates, so a field tagged `json:"created_at"` can be queried with Where("created_at").  Go field names
+	// continue to work for fields without the tag
+	FieldNameTag string
+
+	// ConflictRetries is the number of times a write is retried when its transaction conflicts with another
+	// transaction, waiting ConflictBackoff before the first retry, and doubling the wait on each following retry
+	ConflictRetries int
+	ConflictBackoff time.Duration
+
+	// InMemory opens a throwaway store, such as for use in tests.  The version of badger this is built on can't
+	// run purely in memory, so the store is kept in a new temporary directory, which is removed when the store is
+	// closed.  Dir and ValueDir are ignored for InMemory stores
+	InMemory bool
+
+	// GCDeleteThreshold is the number of records a single DeleteMatching call has to remove before the value log
+	// garbage collection is run with GCDiscardRatio to reclaim the space they used.  0 disables it
+	GCDeleteThreshold int
+	GCDiscardRatio    float64
+
+	// SortMemoryLimit is the number of records a query using SortBy will sort in memory.  Larger result sets are
+	// sorted in batches of this size written to temporary files, which are then merged.  0 is unlimited
+	SortMemoryLimit int
+
+	// QueryTimeout is the longest a query can run before it's aborted with ErrQueryTimeout, including any subqueries
+	// it runs.  0 is unlimited
+	QueryTimeout time.Duration
+
+	// QueryObserver is called with the stats of every query after it's run, such as for collecting metrics
+	QueryObserver func(stats QueryStats)
+
+	// SlowQueryThreshold logs every query that takes at least this long as a warning to the badger Logger, along
+	// with its stats.  0 doesn't log any queries
+	SlowQueryThreshold time.Duration
+
+	// EncryptionKey is the AES key, 16, 24 or 32 bytes long, used to encrypt the string and []byte fields tagged
+	// `badgerhold:"encrypt"`.  Encrypted fields can't be indexed, but can still be queried, as records are decrypted
+	// before they're matched
+	EncryptionKey []byte
+
+	// SoftDeleteField is the name of a pointer field, such as a DeletedAt *time.Time, that marks a record as soft
+	// deleted when it isn't nil.  Queries against types with the field leave out soft deleted records, unless the
+	// query calls IncludeDeleted
+	SoftDeleteField string
+
+	// GetCacheSize is the number of records kept in an in-process least recently used cache in front of Get, so that
+	// repeated Gets of the same records skip badger and the store's Decoder.  Records are removed from the cache
+	// whenever they're written.  0 disables the cache
+	GetCacheSize int
+
+	// ValidateIndexes is a list of data types, such as &Item{}, whose indexes are checked with Store.ValidateIndexes
+	// when the store is opened.  Open fails with an *ErrInvalidIndexes if any are missing or corrupt
+	ValidateIndexes []interface{}
+
+	// RegisterTypes is a list of values, such as Circle{}, whose types are registered with gob when the store is
+	// opened, so values of them can be stored in interface fields.  It's ignored when the store doesn't use gob
+	RegisterTypes []interface{}
+
+	// ExpiredSweepInterval is how often the index entries of records that have expired with a badger TTL are removed
+	// in the background with Store.RemoveExpired, which also runs whenever a query comes across one.  Setting it also
+	// has queries that only read the index check that each record still exists.  0 only removes them when
+	// RemoveExpired is called
+	ExpiredSweepInterval time.Duration
+
+	// SilentLogger discards badger's own log output, such as its messages on startup and during compactions, rather
+	// than sending it to Logger.  Badgerhold's warnings, such as slow queries, are still sent to Logger, so set
+	// Logger to nil to discard them as well.  It's ignored by OpenWithDB, as the DB is already open
+	SilentLogger bool
+
 	badger.Options
 }
 
+// Logger is badger's logger interface, which the store's Logger option implements
+type Logger = badger.Logger
+
 // DefaultOptions are a default set of options for opening a BadgerHold database
 // Includes badgers own default options
 var DefaultOptions = Options{
@@ -49,24 +172,124 @@ var DefaultOptions = Options{
 	Encoder:          DefaultEncode,
 	Decoder:          DefaultDecode,
 	SequenceBandwith: 100,
+	ConflictRetries:  3,
+	ConflictBackoff:  5 * time.Millisecond,
+	GCDiscardRatio:   0.5,
 }
 
 // Open opens or creates a badgerhold file.
 func Open(options Options) (*Store, error) {
 
-	encode = options.Encoder
-	decode = options.Decoder
+	fieldCipher, err := newFieldCipher(options.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	registerTypes(options)
+
+	var tempDir string
+	if options.InMemory {
+		dir, err := ioutil.TempDir("", "badgerhold-memory-")
+		if err != nil {
+			return nil, err
+		}
+		options.Dir = dir
+		options.ValueDir = dir
+		tempDir = dir
+	}
+
+	badgerOptions := options.Options
+	if options.SilentLogger {
+		// badger doesn't log when it has no logger
+		badgerOptions.Logger = nil
+	}
+
+	db, err := badger.Open(badgerOptions)
+	if err != nil {
+		if tempDir != "" {
+			os.RemoveAll(tempDir)
+		}
+		return nil, err
+	}
+
+	s := newStore(db, options, fieldCipher)
+	s.ownsDB = true
+	s.tempDir = tempDir
+
+	s.warnOldKeys()
+
+	if len(options.ValidateIndexes) > 0 {
+		err = s.ValidateIndexes(options.ValidateIndexes...)
+		if err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+
+	s.startSweeper(options.ExpiredSweepInterval)
+
+	return s, nil
+}
 
-	db, err := badger.Open(options.Options)
+// OpenWithDB opens a badgerhold store on a badger DB that's already open and managed by the caller.  Closing the
+// store doesn't close the DB.  Badgerhold only writes keys starting with "bh:" for records, "_bhIdx" for indexes,
+// "_bhBuild:" for indexes being built and "_bhSchema:" for schema versions, along with a key named after each type inserted with NextSequence to lease its
+// sequence, so other keys in the DB are left alone.  The badger options and InMemory are ignored, as the DB is already
+// open
+func OpenWithDB(db *badger.DB, options Options) (*Store, error) {
+	fieldCipher, err := newFieldCipher(options.EncryptionKey)
 	if err != nil {
 		return nil, err
 	}
+	registerTypes(options)
 
+	s := newStore(db, options, fieldCipher)
+	s.warnOldKeys()
+
+	if len(options.ValidateIndexes) > 0 {
+		err = s.ValidateIndexes(options.ValidateIndexes...)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	s.startSweeper(options.ExpiredSweepInterval)
+
+	return s, nil
+}
+
+func newStore(db *badger.DB, options Options, fieldCipher cipher.AEAD) *Store {
 	return &Store{
 		db:               db,
 		sequenceBandwith: options.SequenceBandwith,
+		typeBandwiths:    options.TypeSequenceBandwith,
 		sequences:        &sync.Map{},
-	}, nil
+		changes: &changeHooks{
+			pending: make(map[*badger.Txn][]ChangeEvent),
+		},
+		getCache:        newGetCache(options.GetCacheSize),
+		preloads:        newIndexPreloads(),
+		conflictRetries: options.ConflictRetries,
+		conflictBackoff: options.ConflictBackoff,
+		queryTimeout:    options.QueryTimeout,
+		softDeleteField: options.SoftDeleteField,
+		fieldNameTag:    options.FieldNameTag,
+		sortMemoryLimit: options.SortMemoryLimit,
+
+		gcDeleteThreshold: options.GCDeleteThreshold,
+		gcDiscardRatio:    options.GCDiscardRatio,
+		logger:            options.Logger,
+
+		encode: binaryEncoder(fieldEncoder(options.Encoder, fieldCipher)),
+		decode: binaryDecoder(fieldDecoder(options.Decoder, fieldCipher)),
+
+		encodeValue: fieldEncoder(options.Encoder, nil),
+		decodeValue: fieldDecoder(options.Decoder, nil),
+
+		queryObserver:      options.QueryObserver,
+		slowQueryThreshold: options.SlowQueryThreshold,
+
+		indexes: make(map[string]map[string]Index),
+	}
 }
 
 // Badger returns the underlying Badger DB the badgerhold is based on
@@ -74,8 +297,11 @@ func (s *Store) Badger() *badger.DB {
 	return s.db
 }
 
-// Close closes the badger db
+// Close stops removing the index entries of expired records, releases the store's sequences and closes the badger db,
+// unless the store was opened with OpenWithDB
 func (s *Store) Close() error {
+	s.stopSweeper()
+
 	var err error
 	s.sequences.Range(func(key, value interface{}) bool {
 		err = value.(*badger.Sequence).Release()
@@ -87,16 +313,21 @@ func (s *Store) Close() error {
 	if err != nil {
 		return err
 	}
-	return s.db.Close()
-}
 
-/*
-	NOTE: Not going to implement ReIndex and Remove index
-	I had originally created these to make the transition from a plain bolt or badger DB easier
-	but there is too much chance for lost data, and it's probably better that any conversion be
-	done by the developer so they can directly manage how they want data to be migrated.
-	If you disagree, feel free to open an issue and we can revisit this.
-*/
+	if !s.ownsDB {
+		return nil
+	}
+
+	err = s.db.Close()
+	if err != nil {
+		return err
+	}
+
+	if s.tempDir != "" {
+		return os.RemoveAll(s.tempDir)
+	}
+	return nil
+}
 
 // Storer is the Interface to implement to skip reflect calls on all data passed into the badgerhold
 type Storer interface {
@@ -120,10 +351,11 @@ func (t *anonStorer) Indexes() map[string]Index {
 	return t.indexes
 }
 
-// newStorer creates a type which satisfies the Storer interface based on reflection of the passed in dataType
+// newStorer creates a type which satisfies the Storer interface based on reflection of the passed in dataType, whose
+// struct tag indexes encode their values with encode, the store's Encoder
 // if the Type doesn't meet the requirements of a Storer (i.e. doesn't have a name) it panics
 // You can avoid any reflection costs, by implementing the Storer interface on a type
-func newStorer(dataType interface{}) Storer {
+func newStorer(dataType interface{}, encode EncodeFunc) Storer {
 	s, ok := dataType.(Storer)
 
 	if ok {
@@ -149,58 +381,333 @@ func newStorer(dataType interface{}) Storer {
 		panic("Invalid Type for Storer.  BadgerHold only works with structs")
 	}
 
-	for i := 0; i < storer.rType.NumField(); i++ {
+	fields := promotedFields(storer.rType)
+	for i := range fields {
+		if index, ok := registeredIndex(fields[i]); ok {
+			storer.indexes[fields[i].Name] = index
+			continue
+		}
 
-		indexName := ""
-		unique := false
+		if name, compositeFields, unique := compositeTag(fields[i]); name != "" {
+			storer.indexes[name] = compositeIndex(storer.rType, compositeFields, unique, encode)
+			continue
+		}
 
-		if strings.Contains(string(storer.rType.Field(i).Tag), BadgerHoldIndexTag) {
-			indexName = storer.rType.Field(i).Tag.Get(BadgerHoldIndexTag)
+		indexName, unique := indexTag(fields[i])
+		if indexName != "" {
+			index := fieldIndex(fields[i], fields[i].Name, unique, encode)
+			index.Covers = coverTag(storer.rType, fields[i])
+			storer.indexes[indexName] = index
+		}
+	}
+
+	for _, nested := range nestedIndexes(storer.rType) {
+		storer.indexes[nested.path] = fieldIndex(nested.field, nested.path, nested.unique, encode)
+	}
 
-			if indexName != "" {
-				indexName = storer.rType.Field(i).Name
+	return storer
+}
+
+// fieldIndex returns the Index of the struct tag index on the field at the path, which is either the name of one of
+// the type's fields, or the dotted path of a nested one.  Its values are encoded with encode
+// panics if the field can't be indexed
+func fieldIndex(field reflect.StructField, path string, unique bool, encode EncodeFunc) Index {
+	if isSkipped(field) {
+		panic("Invalid Type for Storer.  The field " + path + " is tagged to be skipped, so it can't be indexed")
+	}
+	if isEncrypted(field) {
+		panic("Invalid Type for Storer.  The field " + path + " is encrypted, so it can't be indexed")
+	}
+
+	if isFullText(field) {
+		if field.Type.Kind() != reflect.String {
+			panic("Invalid Type for Storer.  The field " + path + " isn't a string, so it can't be searched")
+		}
+
+		// each term of the text is indexed separately
+		return Index{
+			MultiValueFunc: func(name string, value interface{}) ([][]byte, error) {
+				fVal, ok := pathValue(reflect.ValueOf(value), name)
+				if !ok {
+					return nil, nil
+				}
+
+				return fullTextValues(fVal, encode)
+			},
+		}
+	}
+
+	if isGeo(field) {
+		return Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				fVal, ok := pathValue(reflect.ValueOf(value), name)
+				if !ok {
+					return nil, nil
+				}
+
+				return geoValue(fVal, encode)
+			},
+		}
+	}
+
+	if isHashed(field) {
+		return Index{
+			IndexFunc: func(name string, value interface{}) ([]byte, error) {
+				fVal, ok := pathValue(reflect.ValueOf(value), name)
+				if !ok {
+					return nil, nil
+				}
+
+				return hashIndexValue(fVal, encode)
+			},
+			Unique: unique,
+		}
+	}
+
+	if field.Type.Kind() == reflect.Map {
+		// each map entry is indexed separately as key=value
+		return Index{
+			MultiValueFunc: func(name string, value interface{}) ([][]byte, error) {
+				fVal, ok := pathValue(reflect.ValueOf(value), name)
+				if !ok {
+					return nil, nil
+				}
+
+				values := make([][]byte, 0, fVal.Len())
+				iter := fVal.MapRange()
+				for iter.Next() {
+					encoded, err := indexEncode(mapIndexValue(iter.Key().Interface(), iter.Value().Interface()),
+						encode)
+					if err != nil {
+						return nil, err
+					}
+					values = append(values, encoded)
+				}
+
+				return values, nil
+			},
+			Unique: unique,
+		}
+	}
+
+	if elementKind(field.Type) {
+		// each element of a slice is indexed separately
+		return Index{
+			MultiValueFunc: func(name string, value interface{}) ([][]byte, error) {
+				fVal, ok := pathValue(reflect.ValueOf(value), name)
+				if !ok {
+					return nil, nil
+				}
+
+				return elementValues(fVal, encode)
+			},
+			Unique: unique,
+		}
+	}
+
+	normalize := normalizer(field)
+
+	return Index{
+		IndexFunc: func(name string, value interface{}) ([]byte, error) {
+			fVal, ok := pathValue(reflect.ValueOf(value), name)
+			if !ok || ((fVal.Kind() == reflect.Ptr || fVal.Kind() == reflect.Interface) && fVal.IsNil()) {
+				// nil values can't be encoded, and aren't indexed
+				return nil, nil
 			}
-		} else if tag := storer.rType.Field(i).Tag.Get(badgerholdPrefixTag); tag != "" {
-			if tag == badgerholdPrefixIndexValue {
-				indexName = storer.rType.Field(i).Name
-			} else if tag == badgerholdPrefixUniqueValue {
-				indexName = storer.rType.Field(i).Name
-				unique = true
+
+			if normalize != nil {
+				return indexEncode(normalize(fVal.String()), encode)
 			}
+			return indexEncode(fVal.Interface(), encode)
+		},
+		Unique: unique,
+	}
+}
+
+// indexTag returns the name of the index the field's struct tags define, and whether or not it's unique.
+// If the field isn't indexed, the name is empty
+func indexTag(field reflect.StructField) (indexName string, unique bool) {
+	if path, _ := pathTag(field); path != "" {
+		// the index is on a field of this one, see nestedIndexes
+		return "", false
+	}
+	if isFullText(field) || isGeo(field) {
+		// indexed by its terms or geohash, see fieldIndex
+		return field.Name, false
+	}
+	if isHashed(field) {
+		// indexed by the hash of its value, see fieldIndex
+		return field.Name, field.Tag.Get(BadgerHoldUniqueTag) != ""
+	}
+
+	if tag := field.Tag.Get(BadgerHoldUniqueTag); tag != "" {
+		if strings.Contains(tag, compositeIndexSeparator) {
+			return "", false
 		}
+		return field.Name, true
+	}
 
-		if indexName != "" {
-			storer.indexes[indexName] = Index{
-				IndexFunc: func(name string, value interface{}) ([]byte, error) {
-					tp := reflect.ValueOf(value)
-					for tp.Kind() == reflect.Ptr {
-						tp = tp.Elem()
-					}
+	if tag, ok := field.Tag.Lookup(BadgerHoldIndexTag); ok {
+		if tag != "" && !strings.Contains(tag, compositeIndexSeparator) {
+			// a list of fields is a composite index, see compositeTag
+			return field.Name, false
+		}
+	} else if tag := field.Tag.Get(badgerholdPrefixTag); tag != "" {
+		if tag == badgerholdPrefixIndexValue {
+			return field.Name, false
+		} else if tag == badgerholdPrefixUniqueValue {
+			return field.Name, true
+		}
+	}
+
+	return "", false
+}
 
-					return encode(tp.FieldByName(name).Interface())
-				},
-				Unique: unique,
+// promotedFields returns every field that can be accessed by name directly on the struct type tp, including the
+// fields promoted from embedded structs.  Go's promotion rules apply, so shallower fields shadow deeper ones with the
+// same name, and names that are ambiguous at the same depth aren't included
+func promotedFields(tp reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	seen := make(map[string]bool)
+
+	var walk func(current reflect.Type, visited map[reflect.Type]bool)
+	walk = func(current reflect.Type, visited map[reflect.Type]bool) {
+		visited[current] = true
+		for i := 0; i < current.NumField(); i++ {
+			field := current.Field(i)
+			if !seen[field.Name] {
+				seen[field.Name] = true
+				// let reflect resolve which field of this name, if any, wins
+				if promoted, ok := tp.FieldByName(field.Name); ok {
+					fields = append(fields, promoted)
+				}
+			}
+
+			if field.Anonymous {
+				embedded := field.Type
+				if embedded.Kind() == reflect.Ptr {
+					embedded = embedded.Elem()
+				}
+				if embedded.Kind() == reflect.Struct && !visited[embedded] {
+					walk(embedded, visited)
+				}
 			}
 		}
 	}
+	walk(tp, make(map[reflect.Type]bool))
 
-	return storer
+	return fields
+}
+
+// fieldByName returns the struct field with the passed in name, the name is matched against the
+// nameTag struct tag first if one is set, which is the store's FieldNameTag for names passed in by the caller
+func fieldByName(tp reflect.Type, name, nameTag string) (reflect.StructField, bool) {
+	if nameTag != "" {
+		fields := promotedFields(tp)
+		for i := range fields {
+			tag := fields[i].Tag.Get(nameTag)
+			if comma := strings.Index(tag, ","); comma != -1 {
+				tag = tag[:comma]
+			}
+			if tag != "" && tag == name {
+				return fields[i], true
+			}
+		}
+	}
+
+	return tp.FieldByName(name)
+}
+
+// fieldValueByName is the same as reflect.Value.FieldByName, except it uses fieldByName to match the name, and
+// a field promoted through a nil embedded struct pointer returns the zero value of the field instead of panicking
+func fieldValueByName(value reflect.Value, name, nameTag string) reflect.Value {
+	structField, ok := fieldByName(value.Type(), name, nameTag)
+	if !ok {
+		return reflect.Value{}
+	}
+
+	for i, x := range structField.Index {
+		if i > 0 && value.Kind() == reflect.Ptr {
+			if value.IsNil() {
+				return reflect.Zero(structField.Type)
+			}
+			value = value.Elem()
+		}
+		value = value.Field(x)
+	}
+
+	return value
+}
+
+// NextSequence returns the next key from the same sequence used when inserting dataType with
+// badgerhold.NextSequence().  This allows you to allocate a key before creating the record that uses it.
+// Keys that are allocated but never used leave gaps in the sequence
+func (s *Store) NextSequence(dataType interface{}) (uint64, error) {
+	return s.getSequence(newStorer(dataType, s.encodeValue).Type())
 }
 
 func (s *Store) getSequence(typeName string) (uint64, error) {
 	seq, ok := s.sequences.Load(typeName)
 	if !ok {
-		newSeq, err := s.Badger().GetSequence([]byte(typeName), s.sequenceBandwith)
+		var err error
+		seq, err = s.newSequence(typeName)
 		if err != nil {
 			return 0, err
 		}
-		s.sequences.Store(typeName, newSeq)
-		seq = newSeq
 	}
 
 	return seq.(*badger.Sequence).Next()
 }
 
+// resetSequence releases the type's sequence and deletes its key, so the next sequence starts again from 0
+func (s *Store) resetSequence(typeName string) error {
+	s.sequenceLock.Lock()
+	defer s.sequenceLock.Unlock()
+
+	if seq, ok := s.sequences.Load(typeName); ok {
+		err := seq.(*badger.Sequence).Release()
+		if err != nil {
+			return err
+		}
+		s.sequences.Delete(typeName)
+	}
+
+	return s.update(func(tx *badger.Txn) error {
+		return tx.Delete([]byte(typeName))
+	})
+}
+
+// newSequence leases a new sequence for the type.  Leasing writes to the same key in badger, so concurrent callers
+// would conflict with each other, and only one sequence is leased at a time
+func (s *Store) newSequence(typeName string) (interface{}, error) {
+	s.sequenceLock.Lock()
+	defer s.sequenceLock.Unlock()
+
+	seq, ok := s.sequences.Load(typeName)
+	if ok {
+		// another caller created the sequence first
+		return seq, nil
+	}
+
+	bandwith, ok := s.typeBandwiths[typeName]
+	if !ok {
+		bandwith = s.sequenceBandwith
+	}
+
+	newSeq, err := s.Badger().GetSequence([]byte(typeName), bandwith)
+	if err != nil {
+		return nil, err
+	}
+
+	s.sequences.Store(typeName, newSeq)
+	return newSeq, nil
+}
+
+// recordPrefix starts the badger key of every record
+const recordPrefix = "bh:"
+
+// typePrefix returns the prefix of the badger keys of the records of the type.  The type name is followed by a
+// separator, so that it can't run into the encoded key, or the name of another type it's a prefix of
 func typePrefix(typeName string) []byte {
-	return []byte("bh_" + typeName)
+	return []byte(recordPrefix + typeName + ":")
 }
@@ -5,12 +5,18 @@
 package badgerhold_test
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
+	"github.com/dgraph-io/badger"
 	"github.com/timshannon/badgerhold"
 )
 
@@ -58,6 +64,74 @@ func TestOpen(t *testing.T) {
 	}
 }
 
+func TestOpenWithDB(t *testing.T) {
+	opt := testOptions()
+	db, err := badger.Open(opt.Options)
+	if err != nil {
+		t.Fatalf("Error opening badger %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer db.Close()
+
+	err = db.Update(func(tx *badger.Txn) error {
+		return tx.Set([]byte("raw key"), []byte("raw value"))
+	})
+	if err != nil {
+		t.Fatalf("Error setting raw key: %s", err)
+	}
+
+	store, err := badgerhold.OpenWithDB(db, opt)
+	if err != nil {
+		t.Fatalf("Error opening store on an open badger DB: %s", err)
+	}
+
+	if store.Badger() != db {
+		t.Fatalf("Store isn't using the passed in badger DB")
+	}
+
+	err = store.Insert("testKey", &ItemTest{Name: "Test Name", Category: "Test Category"})
+	if err != nil {
+		t.Fatalf("Error inserting data: %s", err)
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("Error closing store: %s", err)
+	}
+
+	err = db.View(func(tx *badger.Txn) error {
+		item, err := tx.Get([]byte("raw key"))
+		if err != nil {
+			return err
+		}
+		value, err := item.ValueCopy(nil)
+		if err != nil {
+			return err
+		}
+		if string(value) != "raw value" {
+			t.Fatalf("Raw value is %s wanted raw value", value)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error reading raw key after closing the store: %s", err)
+	}
+
+	store, err = badgerhold.OpenWithDB(db, opt)
+	if err != nil {
+		t.Fatalf("Error reopening store on an open badger DB: %s", err)
+	}
+
+	result := &ItemTest{}
+	err = store.Get("testKey", result)
+	if err != nil {
+		t.Fatalf("Error getting data after reopening the store: %s", err)
+	}
+	if result.Name != "Test Name" {
+		t.Fatalf("Got %v wanted Test Name", result.Name)
+	}
+}
+
 func TestBadger(t *testing.T) {
 	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
 		b := store.Badger()
@@ -101,6 +175,48 @@ func TestAlternateEncoding(t *testing.T) {
 
 }
 
+func TestAlternateEncodingPerStore(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		opt := testOptions()
+		opt.Encoder = json.Marshal
+		opt.Decoder = json.Unmarshal
+		jsonStore, err := badgerhold.Open(opt)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", opt.Dir, err)
+		}
+
+		defer os.RemoveAll(opt.Dir)
+		defer jsonStore.Close()
+
+		insertTestData(t, jsonStore)
+
+		tData := testData[3]
+
+		// opening a store with a different encoding mustn't change how the other store encodes its keys
+		for _, s := range []*badgerhold.Store{store, jsonStore} {
+			var result ItemTest
+			err = s.Get(tData.Key, &result)
+			if err != nil {
+				t.Fatalf("Error getting data: %s", err)
+			}
+			if !result.equal(&tData) {
+				t.Fatalf("Results not equal! Wanted %v, got %v", tData, result)
+			}
+
+			var found []ItemTest
+			err = s.Find(&found, badgerhold.Where(badgerhold.Key).Eq(tData.Key))
+			if err != nil {
+				t.Fatalf("Error finding data: %s", err)
+			}
+			if len(found) != 1 || !found[0].equal(&tData) {
+				t.Fatalf("Found %v wanted %v", found, tData)
+			}
+		}
+	})
+}
+
 func TestGetUnknownType(t *testing.T) {
 	opt := testOptions()
 	store, err := badgerhold.Open(opt)
@@ -122,6 +238,130 @@ func TestGetUnknownType(t *testing.T) {
 	}
 }
 
+func TestFieldNameTag(t *testing.T) {
+	opt := testOptions()
+	opt.FieldNameTag = "json"
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	type TaggedItem struct {
+		Name      string `json:"name"`
+		Category  string `json:"category,omitempty" badgerhold:"index"`
+		CreatedAt int    `json:"created_at"`
+		Untagged  int
+	}
+
+	items := []TaggedItem{
+		{Name: "car", Category: "vehicle", CreatedAt: 3, Untagged: 1},
+		{Name: "truck", Category: "vehicle", CreatedAt: 1, Untagged: 2},
+		{Name: "seal", Category: "animal", CreatedAt: 2, Untagged: 3},
+	}
+
+	for i := range items {
+		err = store.Insert(i, items[i])
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+	}
+
+	var result []TaggedItem
+	err = store.Find(&result, badgerhold.Where("category").Eq("vehicle").And("Untagged").Gt(0).
+		SortBy("created_at"))
+	if err != nil {
+		t.Fatalf("Error finding data by tag name: %s", err)
+	}
+
+	if len(result) != 2 || result[0].Name != "truck" || result[1].Name != "car" {
+		t.Fatalf("Find by tag name returned %v", result)
+	}
+
+	agg, err := store.FindAggregate(&TaggedItem{}, nil, "category")
+	if err != nil {
+		t.Fatalf("Error finding aggregate data by tag name: %s", err)
+	}
+
+	if len(agg) != 2 {
+		t.Fatalf("Wrong number of groupings.  Wanted %d got %d", 2, len(agg))
+	}
+
+	if agg[1].Sum("created_at") != 4 {
+		t.Fatalf("Expected vehicle sum of created_at %d got %v", 4, agg[1].Sum("created_at"))
+	}
+
+	// the tag belongs to the store, so opening another store without one doesn't change it
+	other := testOptions()
+	otherStore, err := badgerhold.Open(other)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", other.Dir, err)
+	}
+	defer os.RemoveAll(other.Dir)
+	defer otherStore.Close()
+
+	result = nil
+	err = store.Find(&result, badgerhold.Where("name").Eq("seal"))
+	if err != nil {
+		t.Fatalf("Error finding data by tag name after opening another store: %s", err)
+	}
+	if len(result) != 1 {
+		t.Fatalf("Find by tag name after opening another store returned %v", result)
+	}
+
+	func() {
+		defer func() {
+			if r := recover(); r == nil {
+				t.Fatalf("Querying a store without the tag by a tag name didn't panic")
+			}
+		}()
+		_ = otherStore.Find(&result, badgerhold.Where("name").Eq("seal"))
+	}()
+}
+
+func TestInMemory(t *testing.T) {
+	existing, err := filepath.Glob(filepath.Join(os.TempDir(), "badgerhold-memory-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	opt := badgerhold.DefaultOptions
+	opt.InMemory = true
+	opt.Logger = emptyLogger{}
+
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening in memory store: %s", err)
+	}
+
+	insertTestData(t, store)
+
+	var result []ItemTest
+	err = store.Find(&result, badgerhold.Where("Category").Eq("vehicle").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding data from in memory store: %s", err)
+	}
+
+	if len(result) != 5 {
+		t.Fatalf("Find result count is %d wanted %d.", len(result), 5)
+	}
+
+	err = store.Close()
+	if err != nil {
+		t.Fatalf("Error closing in memory store: %s", err)
+	}
+
+	remaining, err := filepath.Glob(filepath.Join(os.TempDir(), "badgerhold-memory-*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(remaining) != len(existing) {
+		t.Fatalf("In memory store left %d directories behind", len(remaining)-len(existing))
+	}
+}
+
 // utilities
 
 // testWrap creates a temporary database for testing and closes and cleans it up when
@@ -163,3 +403,270 @@ func tempdir() string {
 	}
 	return name
 }
+
+func TestTypes(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		types, err := store.Types()
+		if err != nil {
+			t.Fatalf("Error getting types: %s", err)
+		}
+		if len(types) != 0 {
+			t.Fatalf("Empty store returned types: %v", types)
+		}
+
+		insertTestData(t, store)
+		for i := 0; i < 3; i++ {
+			err = store.Insert(timeKey(i), &Reading{Value: i})
+			if err != nil {
+				t.Fatalf("Error inserting reading: %s", err)
+			}
+		}
+
+		types, err = store.Types()
+		if err != nil {
+			t.Fatalf("Error getting types: %s", err)
+		}
+		if fmt.Sprint(types) != "[ItemTest Reading]" {
+			t.Fatalf("Types returned %v wanted [ItemTest Reading]", types)
+		}
+
+		counts, err := store.TypeCounts()
+		if err != nil {
+			t.Fatalf("Error getting type counts: %s", err)
+		}
+		if counts["ItemTest"] != len(testData) || counts["Reading"] != 3 {
+			t.Fatalf("TypeCounts returned %v wanted ItemTest:%d Reading:3", counts, len(testData))
+		}
+
+		indexes, err := store.IndexesFor("ItemTest")
+		if err != nil {
+			t.Fatalf("Error getting indexes: %s", err)
+		}
+		if fmt.Sprint(indexes) != "[Category UpdateIndex]" {
+			t.Fatalf("IndexesFor returned %v wanted [Category UpdateIndex]", indexes)
+		}
+
+		indexes, err = store.IndexesFor("Reading")
+		if err != nil {
+			t.Fatalf("Error getting indexes: %s", err)
+		}
+		if len(indexes) != 0 {
+			t.Fatalf("IndexesFor returned %v for a type with no indexes", indexes)
+		}
+	})
+}
+
+func TestSizes(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		records, recordBytes, indexBytes, err := store.SizeOf(&ItemTest{})
+		if err != nil {
+			t.Fatalf("Error getting size: %s", err)
+		}
+		if records != 0 || recordBytes != 0 || indexBytes != 0 {
+			t.Fatalf("Empty store returned %d records, %d record bytes, %d index bytes", records, recordBytes,
+				indexBytes)
+		}
+
+		insertTestData(t, store)
+		for i := 0; i < 3; i++ {
+			err = store.Insert(timeKey(i), &Reading{Value: i})
+			if err != nil {
+				t.Fatalf("Error inserting reading: %s", err)
+			}
+		}
+
+		records, recordBytes, indexBytes, err = store.SizeOf(&ItemTest{})
+		if err != nil {
+			t.Fatalf("Error getting size: %s", err)
+		}
+		if records != len(testData) {
+			t.Fatalf("SizeOf returned %d records wanted %d", records, len(testData))
+		}
+		if recordBytes <= 0 || indexBytes <= 0 {
+			t.Fatalf("SizeOf returned %d record bytes and %d index bytes", recordBytes, indexBytes)
+		}
+
+		sizes, err := store.Sizes()
+		if err != nil {
+			t.Fatalf("Error getting sizes: %s", err)
+		}
+		if len(sizes) != 2 {
+			t.Fatalf("Sizes returned %v wanted ItemTest and Reading", sizes)
+		}
+		item := sizes["ItemTest"]
+		if item.Records != records || item.RecordBytes != recordBytes || item.IndexBytes != indexBytes {
+			t.Fatalf("Sizes returned %+v for ItemTest, SizeOf returned %d, %d, %d", item, records, recordBytes,
+				indexBytes)
+		}
+		reading := sizes["Reading"]
+		if reading.Records != 3 || reading.RecordBytes <= 0 || reading.IndexBytes != 0 {
+			t.Fatalf("Sizes returned %+v for Reading", reading)
+		}
+
+		err = store.Delete(testData[0].Key, &ItemTest{})
+		if err != nil {
+			t.Fatalf("Error deleting: %s", err)
+		}
+
+		smaller, smallerBytes, _, err := store.SizeOf(&ItemTest{})
+		if err != nil {
+			t.Fatalf("Error getting size: %s", err)
+		}
+		if smaller != records-1 || smallerBytes >= recordBytes {
+			t.Fatalf("SizeOf returned %d records, %d bytes after a delete, wanted %d records, fewer than %d bytes",
+				smaller, smallerBytes, records-1, recordBytes)
+		}
+	})
+}
+
+func TestForEachRecord(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+		for i := 0; i < 3; i++ {
+			err := store.Insert(timeKey(i), &Reading{Value: i})
+			if err != nil {
+				t.Fatalf("Error inserting reading: %s", err)
+			}
+		}
+
+		counts := make(map[string]int)
+		err := store.ForEachRecord(context.Background(), func(typeName string, raw []byte) error {
+			counts[typeName]++
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error iterating records: %s", err)
+		}
+		// index entries and sequences aren't records
+		if len(counts) != 2 || counts["ItemTest"] != len(testData) || counts["Reading"] != 3 {
+			t.Fatalf("ForEachRecord returned %v wanted ItemTest:%d Reading:3", counts, len(testData))
+		}
+
+		total := 0
+		err = store.ForEachDecoded(context.Background(), func(typeName string) interface{} {
+			if typeName == "Reading" {
+				return &Reading{}
+			}
+			return nil
+		}, func(typeName string, record interface{}) error {
+			total += record.(*Reading).Value
+			return nil
+		})
+		if err != nil {
+			t.Fatalf("Error iterating decoded records: %s", err)
+		}
+		if total != 3 {
+			t.Fatalf("Decoded readings add up to %d wanted 3", total)
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		seen := 0
+		err = store.ForEachRecord(ctx, func(typeName string, raw []byte) error {
+			seen++
+			cancel()
+			return nil
+		})
+		if err != context.Canceled {
+			t.Fatalf("Cancelled iteration returned %v wanted %v", err, context.Canceled)
+		}
+		if seen != 1 {
+			t.Fatalf("Cancelled iteration saw %d records wanted 1", seen)
+		}
+
+		seen = 0
+		err = store.ForEachRecord(context.Background(), func(typeName string, raw []byte) error {
+			seen++
+			return badgerhold.ErrStopForEach
+		})
+		if err != nil || seen != 1 {
+			t.Fatalf("Stopped iteration returned %v after %d records wanted no error after 1", err, seen)
+		}
+	})
+}
+
+func TestForEachRecordProgress(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTestData(t, store)
+
+		for _, count := range []bool{false, true} {
+			total := -1
+			if count {
+				total = len(testData)
+			}
+
+			next := 0
+			err := store.ForEachRecordProgress(context.Background(), count,
+				func(typeName string, raw []byte, progress badgerhold.Progress) error {
+					if progress.Index != next || progress.Total != total {
+						t.Fatalf("Progress was %+v wanted Index %d Total %d", progress, next, total)
+					}
+					next++
+					return nil
+				})
+			if err != nil {
+				t.Fatalf("Error iterating records: %s", err)
+			}
+			if next != len(testData) {
+				t.Fatalf("Iterated %d records wanted %d", next, len(testData))
+			}
+		}
+	})
+}
+
+type recordingLogger struct {
+	sync.Mutex
+	messages []string
+}
+
+func (l *recordingLogger) record(msg string, data ...interface{}) {
+	l.Lock()
+	defer l.Unlock()
+	l.messages = append(l.messages, fmt.Sprintf(msg, data...))
+}
+
+func (l *recordingLogger) Errorf(msg string, data ...interface{})   { l.record(msg, data...) }
+func (l *recordingLogger) Infof(msg string, data ...interface{})    { l.record(msg, data...) }
+func (l *recordingLogger) Warningf(msg string, data ...interface{}) { l.record(msg, data...) }
+func (l *recordingLogger) Debugf(msg string, data ...interface{})   { l.record(msg, data...) }
+
+func TestSilentLogger(t *testing.T) {
+	for _, silent := range []bool{false, true} {
+		logger := &recordingLogger{}
+		opt := testOptions()
+		opt.Logger = logger
+		opt.SilentLogger = silent
+		opt.SlowQueryThreshold = time.Nanosecond
+
+		store, err := badgerhold.Open(opt)
+		if err != nil {
+			t.Fatalf("Error opening %s: %s", opt.Dir, err)
+		}
+
+		var result []ItemTest
+		err = store.Find(&result, badgerhold.Where("Name").Eq("car"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+
+		store.Close()
+		os.RemoveAll(opt.Dir)
+
+		logged, slow := 0, 0
+		for _, msg := range logger.messages {
+			if strings.Contains(msg, "Slow badgerhold query") {
+				slow++
+			} else {
+				logged++
+			}
+		}
+		if slow != 1 {
+			t.Fatalf("Logged %d slow queries with SilentLogger %t wanted 1", slow, silent)
+		}
+		if silent && logged != 0 {
+			t.Fatalf("Badger logged %v with SilentLogger set", logger.messages)
+		}
+		if !silent && logged == 0 {
+			t.Fatalf("Badger didn't log anything without SilentLogger")
+		}
+	}
+}
@@ -0,0 +1,106 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"fmt"
+	"time"
+)
+
+// timeComponent is a criterion on a part of a time.Time field, such as its weekday
+type timeComponent struct {
+	description string
+	match       func(t time.Time) bool
+}
+
+// test decodes the time from the field value and matches its component in the location, or in UTC if location is nil
+func (component timeComponent) test(value interface{}, location *time.Location) (bool, error) {
+	var t time.Time
+	switch v := value.(type) {
+	case time.Time:
+		t = v
+	case *time.Time:
+		if v == nil {
+			return false, nil
+		}
+		t = *v
+	default:
+		return false, fmt.Errorf("%s can only be used on time.Time fields, not %T", component.description, value)
+	}
+
+	if location == nil {
+		location = time.UTC
+	}
+	return component.match(t.In(location)), nil
+}
+
+// Location sets the time zone the time components of the current field are compared in.  Without it, times are
+// compared in UTC
+// 	Where("CreatedAt").Location(loc).Weekday(time.Monday)
+func (c *Criterion) Location(loc *time.Location) *Criterion {
+	if loc == nil {
+		panic("Location cannot be nil")
+	}
+
+	c.location = loc
+	return c
+}
+
+// Weekday will test if a time.Time field falls on one of the passed in days of the week
+func (c *Criterion) Weekday(days ...time.Weekday) *Query {
+	return c.timeOp(timeComponent{
+		description: fmt.Sprintf("weekday in %v", days),
+		match: func(t time.Time) bool {
+			for i := range days {
+				if t.Weekday() == days[i] {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// Month will test if a time.Time field falls in one of the passed in months
+func (c *Criterion) Month(months ...time.Month) *Query {
+	return c.timeOp(timeComponent{
+		description: fmt.Sprintf("month in %v", months),
+		match: func(t time.Time) bool {
+			for i := range months {
+				if t.Month() == months[i] {
+					return true
+				}
+			}
+			return false
+		},
+	})
+}
+
+// HourRange will test if the hour of a time.Time field is at least from, and less than to, so HourRange(9, 17)
+// matches 9:00 through 16:59.  If from is greater than to, the range wraps past midnight
+func (c *Criterion) HourRange(from, to int) *Query {
+	if from < 0 || from > 23 || to < 0 || to > 24 || from == to {
+		panic(fmt.Sprintf("Invalid hour range %d to %d", from, to))
+	}
+
+	return c.timeOp(timeComponent{
+		description: fmt.Sprintf("hour in [%d, %d)", from, to),
+		match: func(t time.Time) bool {
+			hour := t.Hour()
+			if from > to {
+				return hour >= from || hour < to
+			}
+			return hour >= from && hour < to
+		},
+	})
+}
+
+func (c *Criterion) timeOp(component timeComponent) *Query {
+	if c.query.currentField == Key {
+		panic("Time components cannot be used against Keys")
+	}
+
+	return c.op(tc, component)
+}
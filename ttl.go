@@ -0,0 +1,165 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"time"
+
+	"github.com/dgraph-io/badger"
+)
+
+// expiredSweeper removes the index entries of expired records in the background, every interval and whenever a query
+// comes across one
+type expiredSweeper struct {
+	found chan struct{}
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+// RemoveExpired removes the index entries of every type whose records no longer exist, such as records written with a
+// badger TTL that have since expired, which badger drops without badgerhold removing them from their indexes.  Queries
+// that retrieve the records skip these entries, but counts, FindKeys and covered queries only read the index, so they
+// include them unless Options.ExpiredSweepInterval is set.  Entries are removed in batches, each in its own
+// transaction, and the number removed is returned
+func (s *Store) RemoveExpired() (int, error) {
+	prefix := []byte(indexPrefix + ":")
+	seek := prefix
+	removed := 0
+
+	for {
+		var last []byte
+		var count, batchRemoved int
+
+		err := s.update(func(tx *badger.Txn) error {
+			last, count, batchRemoved = nil, 0, 0
+
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			iter := tx.NewIterator(opts)
+			defer iter.Close()
+
+			for iter.Seek(seek); iter.ValidForPrefix(prefix) && count < migrateBatchSize; iter.Next() {
+				key := iter.Item().KeyCopy(nil)
+				last = key
+				count++
+
+				iPrefix, ok := entryIndexPrefix(key)
+				if !ok {
+					continue
+				}
+				header, _, member, ok := splitIndexKey(iPrefix, key)
+				if !ok || len(member) == 0 {
+					continue
+				}
+
+				_, err := tx.Get(member)
+				if err == nil {
+					continue
+				}
+				if err != badger.ErrKeyNotFound {
+					return err
+				}
+
+				err = indexRemove(tx, header, key)
+				if err != nil {
+					return err
+				}
+				batchRemoved++
+			}
+			return nil
+		})
+		if err != nil {
+			return removed, err
+		}
+		if count == 0 {
+			break
+		}
+
+		removed += batchRemoved
+		// the smallest key after the last entry of the batch
+		seek = append(last, 0)
+	}
+
+	if removed > 0 {
+		s.preloads.purge()
+	}
+	return removed, nil
+}
+
+// entryIndexPrefix returns the prefix of the index the index key belongs to, made up of its type and index names
+func entryIndexPrefix(key []byte) ([]byte, bool) {
+	start := len(indexPrefix) + 1
+	typeEnd := bytes.IndexByte(key[start:], ':')
+	if typeEnd < 0 {
+		return nil, false
+	}
+	nameEnd := bytes.IndexByte(key[start+typeEnd+1:], ':')
+	if nameEnd < 0 {
+		return nil, false
+	}
+	return key[:start+typeEnd+1+nameEnd+1], true
+}
+
+// startSweeper starts removing the index entries of expired records every interval, until the store is closed
+func (s *Store) startSweeper(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+
+	s.sweeper = &expiredSweeper{
+		found: make(chan struct{}, 1),
+		stop:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.sweeper.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-s.sweeper.stop:
+				return
+			case <-ticker.C:
+			case <-s.sweeper.found:
+			}
+
+			_, err := s.RemoveExpired()
+			if err != nil && s.logger != nil {
+				s.logger.Warningf("Error removing the index entries of expired badgerhold records: %s", err)
+			}
+		}
+	}()
+}
+
+// stopSweeper stops the sweeper, and waits for a sweep that's running to finish
+func (s *Store) stopSweeper() {
+	if s.sweeper == nil {
+		return
+	}
+	close(s.sweeper.stop)
+	<-s.sweeper.done
+	s.sweeper = nil
+}
+
+// foundExpired returns the func queries call when they come across the index entry of an expired record, which has
+// the sweeper remove it without waiting for the next interval
+func (s *Store) foundExpired() func() {
+	if s.sweeper == nil {
+		return nil
+	}
+
+	found := s.sweeper.found
+	return func() {
+		select {
+		case found <- struct{}{}:
+		default:
+			// a sweep is already due
+		}
+	}
+}
@@ -0,0 +1,179 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/timshannon/badgerhold"
+)
+
+type TTLItem struct {
+	ID       int    `badgerhold:"key"`
+	Category string `badgerholdIndex:"Category"`
+}
+
+// expireRecords rewrites the records of TTLItem with the keys as already expired, the way badger drops a record whose
+// TTL has passed, without removing its index entries
+func expireRecords(t *testing.T, store *badgerhold.Store, keys ...int) {
+	expired := make(map[int]bool)
+	for _, key := range keys {
+		expired[key] = true
+	}
+
+	err := store.Badger().Update(func(tx *badger.Txn) error {
+		prefix := []byte("bh:TTLItem:")
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		var entries []*badger.Entry
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			value, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+
+			var item TTLItem
+			err = badgerhold.DefaultDecode(value, &item)
+			if err != nil {
+				iter.Close()
+				return err
+			}
+			if expired[item.ID] {
+				entries = append(entries, &badger.Entry{Key: iter.Item().KeyCopy(nil), Value: value, ExpiresAt: 1})
+			}
+		}
+		iter.Close()
+
+		for i := range entries {
+			err := tx.SetEntry(entries[i])
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Error expiring records: %s", err)
+	}
+}
+
+func insertTTLItems(t *testing.T, store *badgerhold.Store) {
+	for i, category := range []string{"a", "a", "b", "a"} {
+		err := store.Insert(i, &TTLItem{ID: i, Category: category})
+		if err != nil {
+			t.Fatalf("Error inserting data: %s", err)
+		}
+	}
+}
+
+func TestRemoveExpired(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		insertTTLItems(t, store)
+		expireRecords(t, store, 1, 2)
+
+		// the index entries of the expired records are skipped
+		var result []TTLItem
+		err := store.Find(&result, badgerhold.Where("Category").Eq("a").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data with expired records: %s", err)
+		}
+		if len(result) != 2 || result[0].ID != 0 || result[1].ID != 3 {
+			t.Fatalf("Found %v with expired records, wanted the records 0 and 3", result)
+		}
+
+		// keys are only read from the index, which still has the entries of the expired records
+		keys, err := store.FindKeys(&TTLItem{}, badgerhold.Where("Category").Ge("a").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding keys with expired records: %s", err)
+		}
+		if len(keys) != 4 {
+			t.Fatalf("Found the keys %v with expired records, wanted 4", keys)
+		}
+
+		removed, err := store.RemoveExpired()
+		if err != nil {
+			t.Fatalf("Error removing expired index entries: %s", err)
+		}
+		if removed != 2 {
+			t.Fatalf("Removed %d expired index entries, wanted 2", removed)
+		}
+
+		keys, err = store.FindKeys(&TTLItem{}, badgerhold.Where("Category").Ge("a").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding keys: %s", err)
+		}
+		if len(keys) != 2 {
+			t.Fatalf("Found the keys %v after removing the expired entries, wanted 2", keys)
+		}
+
+		stats, err := store.IndexStats(&TTLItem{}, "Category")
+		if err != nil {
+			t.Fatalf("Error getting index stats: %s", err)
+		}
+		if stats.Entries != 2 || stats.Values != 1 {
+			t.Fatalf("The index has %d entries of %d values after removing expired ones, wanted 2 of 1",
+				stats.Entries, stats.Values)
+		}
+
+		removed, err = store.RemoveExpired()
+		if err != nil {
+			t.Fatalf("Error removing expired index entries: %s", err)
+		}
+		if removed != 0 {
+			t.Fatalf("Removed %d expired index entries a second time", removed)
+		}
+	})
+}
+
+func TestExpiredSweepInterval(t *testing.T) {
+	opt := testOptions()
+	opt.ExpiredSweepInterval = time.Hour
+	store, err := badgerhold.Open(opt)
+	if err != nil {
+		t.Fatalf("Error opening %s: %s", opt.Dir, err)
+	}
+	defer os.RemoveAll(opt.Dir)
+	defer store.Close()
+
+	insertTTLItems(t, store)
+	expireRecords(t, store, 0)
+
+	// coming across the expired record has the sweeper remove it without waiting for the interval
+	var result []TTLItem
+	err = store.Find(&result, badgerhold.Where("Category").Eq("a").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding data with expired records: %s", err)
+	}
+	if len(result) != 2 {
+		t.Fatalf("Found %v with an expired record, wanted 2 records", result)
+	}
+
+	// with the sweeper, keys read from the index are checked as well
+	keys, err := store.FindKeys(&TTLItem{}, badgerhold.Where("Category").Ge("a").Index("Category"))
+	if err != nil {
+		t.Fatalf("Error finding keys with an expired record: %s", err)
+	}
+	if len(keys) != 3 {
+		t.Fatalf("Found the keys %v with an expired record, wanted 3", keys)
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		stats, err := store.IndexStats(&TTLItem{}, "Category")
+		if err != nil {
+			t.Fatalf("Error getting index stats: %s", err)
+		}
+		if stats.Entries == 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("The index still has %d entries, the expired one wasn't removed", stats.Entries)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,74 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+// TypedStore is a Store for the records of a single type T, whose methods take and return T rather than interface{}
+// values, so a result of the wrong type is caught when compiling rather than when a query runs.  Each method is the
+// same as the Store method it calls
+type TypedStore[T any] struct {
+	store *Store
+}
+
+// NewTypedStore returns a TypedStore for the records of type T in the store
+func NewTypedStore[T any](store *Store) *TypedStore[T] {
+	return &TypedStore[T]{store: store}
+}
+
+// Store returns the Store the TypedStore reads and writes
+func (t *TypedStore[T]) Store() *Store {
+	return t.store
+}
+
+// Insert is the same as Store.Insert
+func (t *TypedStore[T]) Insert(key interface{}, data T) error {
+	return t.store.Insert(key, data)
+}
+
+// Update is the same as Store.Update
+func (t *TypedStore[T]) Update(key interface{}, data T) error {
+	return t.store.Update(key, data)
+}
+
+// Upsert is the same as Store.Upsert
+func (t *TypedStore[T]) Upsert(key interface{}, data T) error {
+	return t.store.Upsert(key, data)
+}
+
+// Delete is the same as Store.Delete
+func (t *TypedStore[T]) Delete(key interface{}) error {
+	var data T
+	return t.store.Delete(key, &data)
+}
+
+// Get is the same as Store.Get, and returns the record stored under the key
+func (t *TypedStore[T]) Get(key interface{}) (T, error) {
+	var result T
+	err := t.store.Get(key, &result)
+	return result, err
+}
+
+// Find is the same as Store.Find, and returns the records that match the query
+func (t *TypedStore[T]) Find(query *Query) ([]T, error) {
+	var result []T
+	err := t.store.Find(&result, query)
+	return result, err
+}
+
+// FindOne is the same as Store.FindOne, and returns the first record that matches the query
+func (t *TypedStore[T]) FindOne(query *Query) (T, error) {
+	var result T
+	err := t.store.FindOne(&result, query)
+	return result, err
+}
+
+// Count returns the number of records that match the query, from a Store.FindAggregate that isn't grouped
+func (t *TypedStore[T]) Count(query *Query) (int, error) {
+	var data T
+	result, err := t.store.FindAggregate(&data, query)
+	if err != nil {
+		return 0, err
+	}
+	return result[0].Count(), nil
+}
@@ -0,0 +1,89 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold_test
+
+import (
+	"testing"
+
+	"github.com/timshannon/badgerhold"
+)
+
+func TestTypedStore(t *testing.T) {
+	testWrap(t, func(store *badgerhold.Store, t *testing.T) {
+		items := badgerhold.NewTypedStore[ItemTest](store)
+		if items.Store() != store {
+			t.Fatalf("TypedStore returned a different store")
+		}
+
+		for i := range testData {
+			err := items.Insert(testData[i].Key, testData[i])
+			if err != nil {
+				t.Fatalf("Error inserting data: %s", err)
+			}
+		}
+
+		item, err := items.Get(testData[3].Key)
+		if err != nil {
+			t.Fatalf("Error getting data: %s", err)
+		}
+		if !item.equal(&testData[3]) {
+			t.Fatalf("Got %v wanted %v", item, testData[3])
+		}
+
+		result, err := items.Find(badgerhold.Where("Category").Eq("animal").Index("Category"))
+		if err != nil {
+			t.Fatalf("Error finding data: %s", err)
+		}
+		if len(result) != 7 {
+			t.Fatalf("Found %d animals wanted 7", len(result))
+		}
+
+		item, err = items.FindOne(badgerhold.Where("Name").Eq(testData[1].Name))
+		if err != nil {
+			t.Fatalf("Error finding one record: %s", err)
+		}
+		if item.Name != testData[1].Name {
+			t.Fatalf("Found %v wanted %s", item, testData[1].Name)
+		}
+
+		count, err := items.Count(badgerhold.Where("Category").Eq("animal"))
+		if err != nil {
+			t.Fatalf("Error counting data: %s", err)
+		}
+		if count != 7 {
+			t.Fatalf("Counted %d animals wanted 7", count)
+		}
+
+		item.Name = "Updated"
+		err = items.Update(testData[1].Key, item)
+		if err != nil {
+			t.Fatalf("Error updating data: %s", err)
+		}
+		item, err = items.Get(testData[1].Key)
+		if err != nil {
+			t.Fatalf("Error getting data: %s", err)
+		}
+		if item.Name != "Updated" {
+			t.Fatalf("Got %v after updating it", item)
+		}
+
+		err = items.Delete(testData[1].Key)
+		if err != nil {
+			t.Fatalf("Error deleting data: %s", err)
+		}
+		_, err = items.Get(testData[1].Key)
+		if err != badgerhold.ErrNotFound {
+			t.Fatalf("Getting a deleted record returned %v wanted ErrNotFound", err)
+		}
+
+		count, err = items.Count(nil)
+		if err != nil {
+			t.Fatalf("Error counting data: %s", err)
+		}
+		if count != len(testData)-1 {
+			t.Fatalf("Counted %d records wanted %d", count, len(testData)-1)
+		}
+	})
+}
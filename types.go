@@ -0,0 +1,263 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// Types returns the sorted names of all of the types that currently have records in the store.
+// Custom Storer type names can't contain a ':', as it separates the type name from the key
+func (s *Store) Types() ([]string, error) {
+	counts, err := s.TypeCounts()
+	if err != nil {
+		return nil, err
+	}
+
+	types := make([]string, 0, len(counts))
+	for typeName := range counts {
+		types = append(types, typeName)
+	}
+	sort.Strings(types)
+	return types, nil
+}
+
+// TypeCounts is the same as Types, but returns the number of records stored for each type.  Only the keys are read,
+// not the records themselves
+func (s *Store) TypeCounts() (map[string]int, error) {
+	counts := make(map[string]int)
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		return scanNames(tx, []byte(recordPrefix), func(name string) {
+			counts[name]++
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return counts, nil
+}
+
+// IndexesFor returns the sorted names of the indexes that currently have entries for the passed in type name.  Index
+// names added with AddIndex can't contain a ':', as it separates the index name from the indexed value
+func (s *Store) IndexesFor(typeName string) ([]string, error) {
+	found := make(map[string]bool)
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		return scanNames(tx, typeIndexPrefix(typeName), func(name string) {
+			found[name] = true
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	indexes := make([]string, 0, len(found))
+	for name := range found {
+		indexes = append(indexes, name)
+	}
+	sort.Strings(indexes)
+	return indexes, nil
+}
+
+// TypeSize is the approximate storage used by a type's records and its indexes
+type TypeSize struct {
+	Records     int
+	RecordBytes int64
+	IndexBytes  int64
+}
+
+// SizeOf returns the number of records stored for the passed in type, along with the approximate number of bytes their
+// keys and values, and the keys and values of the type's index entries, take up in Badger.  Only the keys are read,
+// the sizes are Badger's estimates, and don't include compression, or space that hasn't been garbage collected yet
+func (s *Store) SizeOf(dataType interface{}) (records int, recordBytes int64, indexBytes int64, err error) {
+	typeName := s.storer(dataType).Type()
+
+	err = s.Badger().View(func(tx *badger.Txn) error {
+		scanSizes(tx, typePrefix(typeName), func(_ string, size int64) {
+			records++
+			recordBytes += size
+		})
+		scanSizes(tx, typeIndexPrefix(typeName), func(_ string, size int64) {
+			indexBytes += size
+		})
+		return nil
+	})
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	return records, recordBytes, indexBytes, nil
+}
+
+// Sizes is the same as SizeOf, but for every type in the store, by type name
+func (s *Store) Sizes() (map[string]TypeSize, error) {
+	sizes := make(map[string]TypeSize)
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		scanSizes(tx, []byte(recordPrefix), func(name string, size int64) {
+			if name == "" {
+				return
+			}
+			typeSize := sizes[name]
+			typeSize.Records++
+			typeSize.RecordBytes += size
+			sizes[name] = typeSize
+		})
+		scanSizes(tx, []byte(indexPrefix+":"), func(name string, size int64) {
+			if name == "" {
+				return
+			}
+			typeSize := sizes[name]
+			typeSize.IndexBytes += size
+			sizes[name] = typeSize
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return sizes, nil
+}
+
+// ErrStopForEach can be returned by the callback of ForEachRecord, ForEachRecordProgress or ForEachDecoded to stop the
+// scan without an error
+var ErrStopForEach = errors.New("Stop iterating through the records")
+
+// ForEachRecord calls fn with the type name and encoded value of every record in the store, of every type, without
+// needing the types in advance.  Index entries and sequences aren't included.  Every record is read, so it's slow on
+// large stores, and it stops with ctx.Err() if ctx is cancelled, or with the error returned by fn.  If fn returns
+// ErrStopForEach, the scan stops early without an error
+func (s *Store) ForEachRecord(ctx context.Context, fn func(typeName string, raw []byte) error) error {
+	return s.ForEachRecordProgress(ctx, false, func(typeName string, raw []byte, _ Progress) error {
+		return fn(typeName, raw)
+	})
+}
+
+// Progress is how far a ForEachRecordProgress scan has got
+type Progress struct {
+	// Index is the number of records passed to the callback before this one
+	Index int
+	// Total is the number of records the scan will pass to the callback, or -1 if they weren't counted
+	Total int
+}
+
+// ForEachRecordProgress is the same as ForEachRecord, but also passes fn the progress of the scan, for reporting on
+// long running maintenance jobs.  If count is true, the records are counted before the scan starts so the Total is
+// known.  Counting only reads keys, but it's still a second pass over every record
+func (s *Store) ForEachRecordProgress(ctx context.Context, count bool,
+	fn func(typeName string, raw []byte, progress Progress) error) error {
+	prefix := []byte(recordPrefix)
+
+	return s.Badger().View(func(tx *badger.Txn) error {
+		progress := Progress{Total: -1}
+		if count {
+			progress.Total = 0
+			err := scanNames(tx, prefix, func(string) {
+				progress.Total++
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+			err := ctx.Err()
+			if err != nil {
+				return err
+			}
+
+			name := keyName(iter.Item().Key()[len(prefix):])
+			if name == "" {
+				continue
+			}
+
+			raw, err := iter.Item().ValueCopy(nil)
+			if err != nil {
+				return err
+			}
+
+			err = fn(name, raw, progress)
+			if errors.Is(err, ErrStopForEach) {
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			progress.Index++
+		}
+		return nil
+	})
+}
+
+// ForEachDecoded is the same as ForEachRecord, but decodes each record into the value returned by newRecord for its
+// type name, such as &Person{} for "Person".  Records with type names newRecord returns nil for are skipped.  Key
+// fields tagged `badgerhold:"key"` aren't set
+func (s *Store) ForEachDecoded(ctx context.Context, newRecord func(typeName string) interface{},
+	fn func(typeName string, record interface{}) error) error {
+	return s.ForEachRecord(ctx, func(typeName string, raw []byte) error {
+		record := newRecord(typeName)
+		if record == nil {
+			return nil
+		}
+
+		err := s.decode(raw, record)
+		if err != nil {
+			return err
+		}
+
+		return fn(typeName, record)
+	})
+}
+
+// scanNames calls fn with the name that follows prefix in every key that starts with prefix
+func scanNames(tx *badger.Txn, prefix []byte, fn func(name string)) error {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	iter := tx.NewIterator(opts)
+	defer iter.Close()
+
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		name := keyName(iter.Item().Key()[len(prefix):])
+		if name != "" {
+			fn(name)
+		}
+	}
+
+	return nil
+}
+
+// scanSizes calls fn with the name that follows prefix, and the estimated size, of every key that starts with prefix
+func scanSizes(tx *badger.Txn, prefix []byte, fn func(name string, size int64)) {
+	opts := badger.DefaultIteratorOptions
+	opts.PrefetchValues = false
+	iter := tx.NewIterator(opts)
+	defer iter.Close()
+
+	for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+		item := iter.Item()
+		fn(keyName(item.Key()[len(prefix):]), item.EstimatedSize())
+	}
+}
+
+// keyName returns the name at the start of key, before the separator that follows it, or "" if there's no separator
+func keyName(key []byte) string {
+	i := bytes.IndexByte(key, ':')
+	if i == -1 {
+		return ""
+	}
+
+	return string(key[:i])
+}
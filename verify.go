@@ -0,0 +1,211 @@
+// Copyright 2019 Tim Shannon. All rights reserved.
+// Use of this source code is governed by the MIT license
+// that can be found in the LICENSE file.
+
+package badgerhold
+
+import (
+	"bytes"
+	"reflect"
+	"sort"
+
+	"github.com/dgraph-io/badger"
+)
+
+// InconsistencyKind is the way an index disagrees with the records of its type
+type InconsistencyKind int
+
+const (
+	// CorruptIndexEntry is an index entry that isn't a valid index key, or doesn't refer to a record of the type
+	CorruptIndexEntry InconsistencyKind = iota
+	// MissingRecord is an index entry that refers to a record that doesn't exist
+	MissingRecord
+	// StaleIndexEntry is an index entry for a value the record it refers to no longer has
+	StaleIndexEntry
+	// MissingIndexEntry is an indexed value of a record that has no entry in the index
+	MissingIndexEntry
+)
+
+// Inconsistency is a disagreement between an index and the records of its type found by VerifyIndexes
+type Inconsistency struct {
+	Index string            // the name of the index
+	Kind  InconsistencyKind // the kind of disagreement
+	Key   []byte            // the badger key of the record, nil if a corrupt entry doesn't refer to one
+	Entry []byte            // the badger key of the index entry, or of the one that's missing
+}
+
+// VerifyIndexes cross-checks every index of dataType with its records, in both directions, without modifying
+// anything, and returns every Inconsistency found: index entries that are corrupt, refer to records that don't exist,
+// or to records that no longer have the entry's value, and indexed values of records that have no index entry.
+// Entries and records are read one at a time in a single read transaction, so memory use doesn't grow with the size
+// of the store, but every record is decoded once for each of its index entries.  Indexes with inconsistencies can be
+// rebuilt with ReIndex, and ErrIndexesStale is returned if the type's indexes are already waiting to be rebuilt
+func (s *Store) VerifyIndexes(dataType interface{}) ([]Inconsistency, error) {
+	storer := s.storer(dataType)
+	typeName := storer.Type()
+	indexes := storer.Indexes()
+
+	names := make([]string, 0, len(indexes))
+	for name := range indexes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	tp := reflect.TypeOf(dataType)
+	for tp.Kind() == reflect.Ptr {
+		tp = tp.Elem()
+	}
+
+	var found []Inconsistency
+
+	err := s.Badger().View(func(tx *badger.Txn) error {
+		if indexesStale(tx, typeName) {
+			return ErrIndexesStale
+		}
+
+		// decodeRecord reads the record with the badger key, and returns nil if it doesn't exist
+		decodeRecord := func(key []byte) (interface{}, error) {
+			item, err := tx.Get(key)
+			if err == badger.ErrKeyNotFound {
+				return nil, nil
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			value := reflect.New(tp)
+			err = item.Value(func(v []byte) error {
+				return s.decode(v, value.Interface())
+			})
+			if err != nil {
+				return nil, err
+			}
+			return value.Interface(), nil
+		}
+
+		opts := badger.DefaultIteratorOptions
+		opts.PrefetchValues = false
+
+		// entries to records
+		for _, name := range names {
+			err := func() error {
+				iter := tx.NewIterator(opts)
+				defer iter.Close()
+
+				prefix := indexKeyPrefix(typeName, name)
+				for iter.Seek(prefix); iter.ValidForPrefix(prefix); iter.Next() {
+					entry := iter.Item().KeyCopy(nil)
+					_, value, member, ok := splitIndexKey(prefix, entry)
+					if !ok || (len(member) != 0 && !bytes.HasPrefix(member, typePrefix(typeName))) {
+						found = append(found, Inconsistency{Index: name, Kind: CorruptIndexEntry, Entry: entry})
+						continue
+					}
+					if len(member) == 0 {
+						// the header of a unique value
+						continue
+					}
+
+					record, err := decodeRecord(member)
+					if err != nil {
+						return err
+					}
+					if record == nil {
+						found = append(found, Inconsistency{Index: name, Kind: MissingRecord, Key: member,
+							Entry: entry})
+						continue
+					}
+
+					values, err := indexes[name].values(name, record)
+					if err != nil {
+						return err
+					}
+					if !containsValue(values, value) {
+						found = append(found, Inconsistency{Index: name, Kind: StaleIndexEntry, Key: member,
+							Entry: entry})
+					}
+				}
+				return nil
+			}()
+			if err != nil {
+				return err
+			}
+		}
+
+		// records to entries
+		iter := tx.NewIterator(badger.DefaultIteratorOptions)
+		defer iter.Close()
+
+		tPrefix := typePrefix(typeName)
+		for iter.Seek(tPrefix); iter.ValidForPrefix(tPrefix); iter.Next() {
+			key := iter.Item().KeyCopy(nil)
+			value := reflect.New(tp)
+			err := iter.Item().Value(func(v []byte) error {
+				return s.decode(v, value.Interface())
+			})
+			if err != nil {
+				return err
+			}
+
+			for _, name := range names {
+				values, err := indexes[name].values(name, value.Interface())
+				if err != nil {
+					return err
+				}
+
+				prefix := indexKeyPrefix(typeName, name)
+				for i := range values {
+					if containsValue(values[:i], values[i]) {
+						continue
+					}
+
+					entry := append(indexValueKey(prefix, values[i]), key...)
+					_, err := tx.Get(entry)
+					if err == badger.ErrKeyNotFound {
+						found = append(found, Inconsistency{Index: name, Kind: MissingIndexEntry, Key: key,
+							Entry: entry})
+						continue
+					}
+					if err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}
+
+// RepairIndexes verifies every index of dataType like VerifyIndexes, then rebuilds each index with an inconsistency
+// from the records, the same as ReIndex, and returns the inconsistencies that were repaired.  Indexes that agree with
+// the records aren't rewritten
+func (s *Store) RepairIndexes(dataType interface{}) ([]Inconsistency, error) {
+	found, err := s.VerifyIndexes(dataType)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var names []string
+	for i := range found {
+		if !seen[found[i].Index] {
+			seen[found[i].Index] = true
+			names = append(names, found[i].Index)
+		}
+	}
+	sort.Strings(names)
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	err = s.ReIndex(dataType, names...)
+	if err != nil {
+		return nil, err
+	}
+
+	return found, nil
+}